@@ -45,6 +45,8 @@ var (
 	logLevel             string
 	formatJSON           bool
 	breakPoint           string
+	dumpStore            bool
+	debugTransformers    bool
 	fullSketches         bool
 	profileMemory        bool
 	profileMemoryDir     string
@@ -67,6 +69,8 @@ func setupCmd(cmd *cobra.Command) {
 	cmd.Flags().IntVarP(&checkDelay, "delay", "d", 100, "delay between running the check and grabbing the metrics in milliseconds")
 	cmd.Flags().BoolVarP(&formatJSON, "json", "", false, "format aggregator and check runner output as json")
 	cmd.Flags().StringVarP(&breakPoint, "breakpoint", "b", "", "set a breakpoint at a particular line number (Python checks only)")
+	cmd.Flags().BoolVar(&dumpStore, "dump-store", false, "dump the metric families held by the check stores (kubernetes_state-alpha check only)")
+	cmd.Flags().BoolVar(&debugTransformers, "debug-transformers", false, "print why metrics were dropped by the check transformers (kubernetes_state-alpha check only)")
 	cmd.Flags().BoolVarP(&profileMemory, "profile-memory", "m", false, "run the memory profiler (Python checks only)")
 	cmd.Flags().BoolVar(&fullSketches, "full-sketches", false, "output sketches with bins information")
 	config.Datadog.BindPFlag("cmd.check.fullsketches", cmd.Flags().Lookup("full-sketches")) //nolint:errcheck
@@ -250,6 +254,18 @@ func Check(loggerName config.LoggerName, confFilePath *string, flagNoColor *bool
 				}
 			}
 
+			if dumpStore {
+				if err := setInitConfigOption(allConfigs, checkName, "dump_store", true); err != nil {
+					return err
+				}
+			}
+
+			if debugTransformers {
+				if err := setInitConfigOption(allConfigs, checkName, "debug_transformers", true); err != nil {
+					return err
+				}
+			}
+
 			cs := collector.GetChecksByNameForConfigs(checkName, allConfigs)
 
 			// something happened while getting the check(s), display some info.
@@ -490,6 +506,33 @@ func singleCheckRun() bool {
 	return checkRate == false && checkTimes < 2
 }
 
+// setInitConfigOption sets an option in the init_config of the configs of the given check
+func setInitConfigOption(configs []integration.Config, checkName, key string, value interface{}) error {
+	for idx := range configs {
+		conf := &configs[idx]
+		if conf.Name != checkName {
+			continue
+		}
+
+		var data map[string]interface{}
+
+		err := yaml.Unmarshal(conf.InitConfig, &data)
+		if err != nil {
+			return err
+		}
+
+		if data == nil {
+			data = make(map[string]interface{})
+		}
+
+		data[key] = value
+
+		y, _ := yaml.Marshal(data)
+		conf.InitConfig = y
+	}
+	return nil
+}
+
 func createHiddenStringFlag(cmd *cobra.Command, p *string, name string, value string, usage string) {
 	cmd.Flags().StringVar(p, name, value, usage)
 	cmd.Flags().MarkHidden(name) //nolint:errcheck
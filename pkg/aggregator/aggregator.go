@@ -330,6 +330,11 @@ func (agg *BufferedAggregator) handleSenderSample(ss senderMetricSample) {
 	if checkSampler, ok := agg.checkSamplers[ss.id]; ok {
 		if ss.commit {
 			checkSampler.commit(timeNowNano())
+		} else if ss.batch != nil {
+			for _, metricSample := range ss.batch {
+				metricSample.Tags = util.SortUniqInPlace(metricSample.Tags)
+				checkSampler.addSample(metricSample)
+			}
 		} else {
 			ss.metricSample.Tags = util.SortUniqInPlace(ss.metricSample.Tags)
 			checkSampler.addSample(ss.metricSample)
@@ -653,8 +658,13 @@ func (agg *BufferedAggregator) run() {
 			addFlushTime("MainFlushTime", int64(time.Since(start)))
 			aggregatorNumberOfFlush.Add(1)
 		case checkMetric := <-agg.checkMetricIn:
-			aggregatorChecksMetricSample.Add(1)
-			tlmProcessed.Inc("metrics")
+			if checkMetric.batch != nil {
+				aggregatorChecksMetricSample.Add(int64(len(checkMetric.batch)))
+				tlmProcessed.Add(float64(len(checkMetric.batch)), "metrics")
+			} else {
+				aggregatorChecksMetricSample.Add(1)
+				tlmProcessed.Inc("metrics")
+			}
 			agg.handleSenderSample(checkMetric)
 		case checkHistogramBucket := <-agg.checkHistogramBucketIn:
 			aggregatorCheckHistogramBucketMetricSample.Add(1)
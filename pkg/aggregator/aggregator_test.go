@@ -8,6 +8,7 @@ package aggregator
 import (
 	// stdlib
 	"fmt"
+	"sort"
 	"testing"
 	"time"
 
@@ -58,6 +59,30 @@ func TestDeregisterCheckSampler(t *testing.T) {
 	assert.True(t, ok)
 }
 
+func TestHandleSenderSampleBatch(t *testing.T) {
+	resetAggregator()
+
+	agg := InitAggregator(nil, "")
+	agg.registerSender(checkID1)
+
+	agg.handleSenderSample(senderMetricSample{id: checkID1, batch: []*metrics.MetricSample{
+		{Name: "my.metric", Value: 1, Mtype: metrics.GaugeWithTimestampType, Tags: []string{"foo", "bar", "foo"}, SampleRate: 1, Timestamp: 1234},
+		{Name: "my.metric", Value: 2, Mtype: metrics.GaugeWithTimestampType, Tags: []string{"foo"}, SampleRate: 1, Timestamp: 1235},
+	}})
+	// Unknown sender
+	agg.handleSenderSample(senderMetricSample{id: checkID2, batch: []*metrics.MetricSample{
+		{Name: "my.metric", Value: 3, Mtype: metrics.GaugeWithTimestampType, SampleRate: 1, Timestamp: 1236},
+	}})
+
+	agg.checkSamplers[checkID1].commit(timeNowNano())
+	series, _ := agg.checkSamplers[checkID1].flush()
+	require.Len(t, series, 2)
+	sort.Slice(series, func(i, j int) bool { return series[i].Points[0].Value < series[j].Points[0].Value })
+	assert.ElementsMatch(t, []string{"bar", "foo"}, series[0].Tags)
+	assert.Equal(t, 1.0, series[0].Points[0].Value)
+	assert.Equal(t, 2.0, series[1].Points[0].Value)
+}
+
 func TestAddServiceCheckDefaultValues(t *testing.T) {
 	resetAggregator()
 	agg := InitAggregator(nil, "resolved-hostname")
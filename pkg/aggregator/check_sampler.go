@@ -7,6 +7,7 @@ package aggregator
 
 import (
 	"math"
+	"sort"
 	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/aggregator/ckey"
@@ -26,23 +27,31 @@ type CheckSampler struct {
 	lastBucketValue map[ckey.ContextKey]int64
 	lastSeenBucket  map[ckey.ContextKey]time.Time
 	bucketExpiry    time.Duration
+	// timestampedPoints contains the points submitted with their own timestamp per context until the next commit
+	timestampedPoints map[ckey.ContextKey][]metrics.Point
 }
 
 // newCheckSampler returns a newly initialized CheckSampler
 func newCheckSampler() *CheckSampler {
 	return &CheckSampler{
-		series:          make([]*metrics.Serie, 0),
-		sketches:        make([]metrics.SketchSeries, 0),
-		contextResolver: newContextResolver(),
-		metrics:         metrics.MakeContextMetrics(),
-		sketchMap:       make(sketchMap),
-		lastBucketValue: make(map[ckey.ContextKey]int64),
-		lastSeenBucket:  make(map[ckey.ContextKey]time.Time),
-		bucketExpiry:    1 * time.Minute,
+		series:            make([]*metrics.Serie, 0),
+		sketches:          make([]metrics.SketchSeries, 0),
+		contextResolver:   newContextResolver(),
+		metrics:           metrics.MakeContextMetrics(),
+		sketchMap:         make(sketchMap),
+		lastBucketValue:   make(map[ckey.ContextKey]int64),
+		lastSeenBucket:    make(map[ckey.ContextKey]time.Time),
+		bucketExpiry:      1 * time.Minute,
+		timestampedPoints: make(map[ckey.ContextKey][]metrics.Point),
 	}
 }
 
 func (cs *CheckSampler) addSample(metricSample *metrics.MetricSample) {
+	if metricSample.Mtype == metrics.GaugeWithTimestampType {
+		cs.addTimestampedSample(metricSample)
+		return
+	}
+
 	contextKey := cs.contextResolver.trackContext(metricSample, metricSample.Timestamp)
 
 	if err := cs.metrics.AddSample(contextKey, metricSample, metricSample.Timestamp, 1); err != nil {
@@ -50,6 +59,41 @@ func (cs *CheckSampler) addSample(metricSample *metrics.MetricSample) {
 	}
 }
 
+// addTimestampedSample adds a sample submitted with its own timestamp to the points of its context
+// These samples aren't aggregated: each of them is sent as a point at its timestamp instead of the flush time.
+// The context is tracked at the time the sample is received, so that it expires like the other contexts.
+func (cs *CheckSampler) addTimestampedSample(metricSample *metrics.MetricSample) {
+	contextKey := cs.contextResolver.trackContext(metricSample, timeNowNano())
+	cs.timestampedPoints[contextKey] = append(cs.timestampedPoints[contextKey], metrics.Point{Ts: metricSample.Timestamp, Value: metricSample.Value})
+}
+
+// commitTimestampedPoints adds the points submitted with their own timestamp to the series of their context,
+// the gauge series of the same context flushed by the commit are reused
+func (cs *CheckSampler) commitTimestampedPoints(gauges map[ckey.ContextKey]*metrics.Serie) {
+	for contextKey, points := range cs.timestampedPoints {
+		serie, found := gauges[contextKey]
+		if !found {
+			context, ok := cs.contextResolver.contextsByKey[contextKey]
+			if !ok {
+				log.Errorf("Ignoring all timestamped points on context key '%v': inconsistent context resolver state: the context is not tracked", contextKey)
+				continue
+			}
+			serie = &metrics.Serie{
+				Name:           context.Name,
+				Tags:           context.Tags,
+				Host:           context.Host,
+				MType:          metrics.APIGaugeType,
+				SourceTypeName: checksSourceTypeName,
+				ContextKey:     contextKey,
+			}
+			cs.series = append(cs.series, serie)
+		}
+		serie.Points = append(serie.Points, points...)
+		sort.SliceStable(serie.Points, func(i, j int) bool { return serie.Points[i].Ts < serie.Points[j].Ts })
+	}
+	cs.timestampedPoints = make(map[ckey.ContextKey][]metrics.Point)
+}
+
 func (cs *CheckSampler) newSketchSeries(ck ckey.ContextKey, points []metrics.SketchPoint) metrics.SketchSeries {
 	ctx := cs.contextResolver.contextsByKey[ck]
 	ss := metrics.SketchSeries{
@@ -129,6 +173,7 @@ func (cs *CheckSampler) commitSeries(timestamp float64) {
 		}
 		log.Infof("No value returned for check metric '%s' on host '%s' and tags '%s': %s", context.Name, context.Host, context.Tags, err)
 	}
+	gauges := make(map[ckey.ContextKey]*metrics.Serie)
 	for _, serie := range series {
 		// Resolve context and populate new []Serie
 		context, ok := cs.contextResolver.contextsByKey[serie.ContextKey]
@@ -142,7 +187,11 @@ func (cs *CheckSampler) commitSeries(timestamp float64) {
 		serie.SourceTypeName = checksSourceTypeName // this source type is required for metrics coming from the checks
 
 		cs.series = append(cs.series, serie)
+		if serie.MType == metrics.APIGaugeType && serie.NameSuffix == "" {
+			gauges[serie.ContextKey] = serie
+		}
 	}
+	cs.commitTimestampedPoints(gauges)
 }
 
 func (cs *CheckSampler) commitSketches(timestamp float64) {
@@ -9,6 +9,7 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/aggregator/ckey"
 	// stdlib
 	"math"
+	"sort"
 	"testing"
 	"time"
 
@@ -84,6 +85,91 @@ func TestCheckGaugeSampling(t *testing.T) {
 	metrics.AssertSeriesEqual(t, expectedSeries, series)
 }
 
+func TestCheckGaugeWithTimestampSampling(t *testing.T) {
+	checkSampler := newCheckSampler()
+
+	mSample1 := metrics.MetricSample{
+		Name:       "my.metric.name",
+		Value:      1,
+		Mtype:      metrics.GaugeWithTimestampType,
+		Tags:       []string{"foo", "bar"},
+		SampleRate: 1,
+		Timestamp:  12000.0,
+	}
+	mSample2 := metrics.MetricSample{
+		Name:       "my.metric.name",
+		Value:      2,
+		Mtype:      metrics.GaugeWithTimestampType,
+		Tags:       []string{"foo", "bar"},
+		SampleRate: 1,
+		Timestamp:  12300.0,
+	}
+
+	mSample3 := metrics.MetricSample{
+		Name:       "my.metric.name",
+		Value:      3,
+		Mtype:      metrics.GaugeWithTimestampType,
+		Tags:       []string{"foo"},
+		SampleRate: 1,
+		Timestamp:  12100.0,
+	}
+
+	checkSampler.addSample(&mSample2)
+	checkSampler.addSample(&mSample1)
+	checkSampler.addSample(&mSample3)
+
+	checkSampler.commit(timeNowNano())
+	series, _ := checkSampler.flush()
+
+	// The samples aren't aggregated, they're sent at their own timestamp in a single serie per context
+	require.Len(t, series, 2)
+	sort.Slice(series, func(i, j int) bool { return len(series[i].Tags) > len(series[j].Tags) })
+	assert.Equal(t, "my.metric.name", series[0].Name)
+	assert.ElementsMatch(t, []string{"foo", "bar"}, series[0].Tags)
+	assert.Equal(t, []metrics.Point{{Ts: 12000.0, Value: 1}, {Ts: 12300.0, Value: 2}}, series[0].Points)
+	assert.Equal(t, metrics.APIGaugeType, series[0].MType)
+	assert.Equal(t, checksSourceTypeName, series[0].SourceTypeName)
+	assert.Equal(t, generateContextKey(&mSample1), series[0].ContextKey)
+	assert.Equal(t, []string{"foo"}, series[1].Tags)
+	assert.Equal(t, []metrics.Point{{Ts: 12100.0, Value: 3}}, series[1].Points)
+
+	// The points are only sent once
+	checkSampler.commit(timeNowNano())
+	series, _ = checkSampler.flush()
+	assert.Len(t, series, 0)
+
+	// The contexts expire like the other contexts
+	checkSampler.commit(timeNowNano() + defaultExpiry + 1)
+	assert.Len(t, checkSampler.contextResolver.contextsByKey, 0)
+}
+
+func TestCheckGaugeWithTimestampSamplingMerged(t *testing.T) {
+	checkSampler := newCheckSampler()
+
+	gauge := metrics.MetricSample{
+		Name:       "my.metric.name",
+		Value:      2,
+		Mtype:      metrics.GaugeType,
+		Tags:       []string{"foo", "bar"},
+		SampleRate: 1,
+		Timestamp:  12345.0,
+	}
+	timestamped := gauge
+	timestamped.Value = 1
+	timestamped.Mtype = metrics.GaugeWithTimestampType
+	timestamped.Timestamp = 12000.0
+
+	checkSampler.addSample(&gauge)
+	checkSampler.addSample(&timestamped)
+	checkSampler.commit(12349.0)
+	series, _ := checkSampler.flush()
+
+	// The timestamped points are merged into the gauge serie of the same context
+	require.Len(t, series, 1)
+	assert.Equal(t, []metrics.Point{{Ts: 12000.0, Value: 1}, {Ts: 12349.0, Value: 2}}, series[0].Points)
+	assert.Equal(t, metrics.APIGaugeType, series[0].MType)
+}
+
 func TestCheckRateSampling(t *testing.T) {
 	checkSampler := newCheckSampler()
 
@@ -30,6 +30,12 @@ func (m *MockSender) AssertMetric(t *testing.T, method string, metric string, va
 	return m.Mock.AssertCalled(t, method, metric, value, hostname, MatchTagsContains(tags))
 }
 
+// AssertMetricWithTimestamp allows to assert a metric was emitted with given parameters and timestamp.
+// Additional tags over the ones specified don't make it fail
+func (m *MockSender) AssertMetricWithTimestamp(t *testing.T, method string, metric string, value float64, hostname string, tags []string, timestamp float64) bool {
+	return m.Mock.AssertCalled(t, method, metric, value, hostname, MatchTagsContains(tags), timestamp)
+}
+
 // AssertHistogramBucket allows to assert a histogram bucket was emitted with given parameters.
 // Additional tags over the ones specified don't make it fail
 func (m *MockSender) AssertHistogramBucket(t *testing.T, method string, metric string, value int64, lowerBound float64, upperBound float64, monotonic bool, hostname string, tags []string) bool {
@@ -9,82 +9,115 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/metrics"
 )
 
-//Rate adds a rate type to the mock calls.
+// Rate adds a rate type to the mock calls.
 func (m *MockSender) Rate(metric string, value float64, hostname string, tags []string) {
 	m.Called(metric, value, hostname, tags)
 }
 
-//Count adds a count type to the mock calls.
+// Count adds a count type to the mock calls.
 func (m *MockSender) Count(metric string, value float64, hostname string, tags []string) {
 	m.Called(metric, value, hostname, tags)
 }
 
-//MonotonicCount adds a monotonic count type to the mock calls.
+// MonotonicCount adds a monotonic count type to the mock calls.
 func (m *MockSender) MonotonicCount(metric string, value float64, hostname string, tags []string) {
 	m.Called(metric, value, hostname, tags)
 }
 
-//Counter adds a counter type to the mock calls.
+// Counter adds a counter type to the mock calls.
 func (m *MockSender) Counter(metric string, value float64, hostname string, tags []string) {
 	m.Called(metric, value, hostname, tags)
 }
 
-//Histogram adds a histogram type to the mock calls.
+// Histogram adds a histogram type to the mock calls.
 func (m *MockSender) Histogram(metric string, value float64, hostname string, tags []string) {
 	m.Called(metric, value, hostname, tags)
 }
 
-//Historate adds a historate type to the mock calls.
+// Historate adds a historate type to the mock calls.
 func (m *MockSender) Historate(metric string, value float64, hostname string, tags []string) {
 	m.Called(metric, value, hostname, tags)
 }
 
-//Gauge adds a gauge type to the mock calls.
+// Gauge adds a gauge type to the mock calls.
 func (m *MockSender) Gauge(metric string, value float64, hostname string, tags []string) {
 	m.Called(metric, value, hostname, tags)
 }
 
-//ServiceCheck enables the service check mock call.
+// GaugeWithTimestamp adds a timestamped gauge type to the mock calls.
+func (m *MockSender) GaugeWithTimestamp(metric string, value float64, hostname string, tags []string, timestamp float64) {
+	m.Called(metric, value, hostname, tags, timestamp)
+}
+
+// SubmitBatch adds the mock call of each sample's metric type, so they can be asserted like the other metrics.
+func (m *MockSender) SubmitBatch(samples []*metrics.MetricSample) {
+	for _, sample := range samples {
+		switch sample.Mtype {
+		case metrics.GaugeType:
+			m.Gauge(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.GaugeWithTimestampType:
+			if sample.Timestamp <= 0 {
+				m.Gauge(sample.Name, sample.Value, sample.Host, sample.Tags)
+			} else {
+				m.GaugeWithTimestamp(sample.Name, sample.Value, sample.Host, sample.Tags, sample.Timestamp)
+			}
+		case metrics.RateType:
+			m.Rate(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.CountType:
+			m.Count(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.MonotonicCountType:
+			m.MonotonicCount(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.CounterType:
+			m.Counter(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.HistogramType:
+			m.Histogram(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.HistorateType:
+			m.Historate(sample.Name, sample.Value, sample.Host, sample.Tags)
+		}
+	}
+}
+
+// ServiceCheck enables the service check mock call.
 func (m *MockSender) ServiceCheck(checkName string, status metrics.ServiceCheckStatus, hostname string, tags []string, message string) {
 	m.Called(checkName, status, hostname, tags, message)
 }
 
-//DisableDefaultHostname enables the hostname mock call.
+// DisableDefaultHostname enables the hostname mock call.
 func (m *MockSender) DisableDefaultHostname(d bool) {
 	m.Called(d)
 }
 
-//Event enables the event mock call.
+// Event enables the event mock call.
 func (m *MockSender) Event(e metrics.Event) {
 	m.Called(e)
 }
 
-//HistogramBucket enables the histogram bucket mock call.
+// HistogramBucket enables the histogram bucket mock call.
 func (m *MockSender) HistogramBucket(metric string, value int64, lowerBound, upperBound float64, monotonic bool, hostname string, tags []string) {
 	m.Called(metric, value, lowerBound, upperBound, monotonic, hostname, tags)
 }
 
-//Commit enables the commit mock call.
+// Commit enables the commit mock call.
 func (m *MockSender) Commit() {
 	m.Called()
 }
 
-//SetCheckCustomTags enables the set of check custom tags mock call.
+// SetCheckCustomTags enables the set of check custom tags mock call.
 func (m *MockSender) SetCheckCustomTags(tags []string) {
 	m.Called(tags)
 }
 
-//SetCheckService enables the setting of check service mock call.
+// SetCheckService enables the setting of check service mock call.
 func (m *MockSender) SetCheckService(service string) {
 	m.Called(service)
 }
 
-//FinalizeCheckServiceTag enables the sending of check service tag mock call.
+// FinalizeCheckServiceTag enables the sending of check service tag mock call.
 func (m *MockSender) FinalizeCheckServiceTag() {
 	m.Called()
 }
 
-//GetMetricStats enables the get metric stats mock call.
+// GetMetricStats enables the get metric stats mock call.
 func (m *MockSender) GetMetricStats() map[string]int64 {
 	m.Called()
 	return make(map[string]int64)
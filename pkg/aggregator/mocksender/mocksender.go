@@ -30,7 +30,7 @@ func SetSender(sender *MockSender, id check.ID) {
 	aggregator.SetSender(sender, id) //nolint:errcheck
 }
 
-//MockSender allows mocking of the checks sender for unit testing
+// MockSender allows mocking of the checks sender for unit testing
 type MockSender struct {
 	mock.Mock
 }
@@ -46,6 +46,13 @@ func (m *MockSender) SetupAcceptAll() {
 			mock.AnythingOfType("[]string"), // Tags
 		).Return()
 	}
+	m.On("GaugeWithTimestamp",
+		mock.AnythingOfType("string"),   // Metric
+		mock.AnythingOfType("float64"),  // Value
+		mock.AnythingOfType("string"),   // Hostname
+		mock.AnythingOfType("[]string"), // Tags
+		mock.AnythingOfType("float64"),  // Timestamp
+	).Return()
 	m.On("ServiceCheck",
 		mock.AnythingOfType("string"),                     // checkName (e.g: docker.exit)
 		mock.AnythingOfType("metrics.ServiceCheckStatus"), // (e.g: metrics.ServiceCheckOK)
@@ -25,6 +25,7 @@ var senderPool *checkSenderPool
 type Sender interface {
 	Commit()
 	Gauge(metric string, value float64, hostname string, tags []string)
+	GaugeWithTimestamp(metric string, value float64, hostname string, tags []string, timestamp float64)
 	Rate(metric string, value float64, hostname string, tags []string)
 	Count(metric string, value float64, hostname string, tags []string)
 	MonotonicCount(metric string, value float64, hostname string, tags []string)
@@ -33,6 +34,7 @@ type Sender interface {
 	Historate(metric string, value float64, hostname string, tags []string)
 	ServiceCheck(checkName string, status metrics.ServiceCheckStatus, hostname string, tags []string, message string)
 	HistogramBucket(metric string, value int64, lowerBound, upperBound float64, monotonic bool, hostname string, tags []string)
+	SubmitBatch(samples []*metrics.MetricSample)
 	Event(e metrics.Event)
 	GetMetricStats() map[string]int64
 	DisableDefaultHostname(disable bool)
@@ -75,6 +77,8 @@ type senderMetricSample struct {
 	id           check.ID
 	metricSample *metrics.MetricSample
 	commit       bool
+	// batch contains the samples submitted together by SubmitBatch, metricSample is nil when it's set
+	batch []*metrics.MetricSample
 }
 
 type senderHistogramBucket struct {
@@ -189,7 +193,7 @@ func (s *checkSender) FinalizeCheckServiceTag() {
 // Should be called at the end of every check run
 func (s *checkSender) Commit() {
 	// we use a metric sample to commit both for metrics & sketches
-	s.smsOut <- senderMetricSample{s.id, &metrics.MetricSample{}, true}
+	s.smsOut <- senderMetricSample{id: s.id, metricSample: &metrics.MetricSample{}, commit: true}
 	s.cyclemetricStats()
 }
 
@@ -224,10 +228,14 @@ func (s *checkSender) cyclemetricStats() {
 // SendRawMetricSample sends the raw sample
 // Useful for testing - submitting precomputed samples.
 func (s *checkSender) SendRawMetricSample(sample *metrics.MetricSample) {
-	s.smsOut <- senderMetricSample{s.id, sample, false}
+	s.smsOut <- senderMetricSample{id: s.id, metricSample: sample}
 }
 
 func (s *checkSender) sendMetricSample(metric string, value float64, hostname string, tags []string, mType metrics.MetricType) {
+	s.sendMetricSampleWithTimestamp(metric, value, hostname, tags, mType, timeNowNano())
+}
+
+func (s *checkSender) sendMetricSampleWithTimestamp(metric string, value float64, hostname string, tags []string, mType metrics.MetricType, timestamp float64) {
 	tags = append(tags, s.checkTags...)
 
 	log.Trace(mType.String(), " sample: ", metric, ": ", value, " for hostname: ", hostname, " tags: ", tags)
@@ -239,14 +247,14 @@ func (s *checkSender) sendMetricSample(metric string, value float64, hostname st
 		Tags:       tags,
 		Host:       hostname,
 		SampleRate: 1,
-		Timestamp:  timeNowNano(),
+		Timestamp:  timestamp,
 	}
 
 	if hostname == "" && !s.defaultHostnameDisabled {
 		metricSample.Host = s.defaultHostname
 	}
 
-	s.smsOut <- senderMetricSample{s.id, metricSample, false}
+	s.smsOut <- senderMetricSample{id: s.id, metricSample: metricSample}
 
 	s.metricStats.Lock.Lock()
 	s.metricStats.MetricSamples++
@@ -258,6 +266,17 @@ func (s *checkSender) Gauge(metric string, value float64, hostname string, tags
 	s.sendMetricSample(metric, value, hostname, tags, metrics.GaugeType)
 }
 
+// GaugeWithTimestamp should be used to send a gauge value observed at the given Unix timestamp, in seconds.
+// Unlike Gauge, the values aren't aggregated: each of them is sent at its own timestamp instead of the commit time.
+// The value is sent at the current time if the timestamp isn't valid.
+func (s *checkSender) GaugeWithTimestamp(metric string, value float64, hostname string, tags []string, timestamp float64) {
+	if timestamp <= 0 {
+		s.Gauge(metric, value, hostname, tags)
+		return
+	}
+	s.sendMetricSampleWithTimestamp(metric, value, hostname, tags, metrics.GaugeWithTimestampType, timestamp)
+}
+
 // Rate should be used to track the rate of a metric over each check run
 func (s *checkSender) Rate(metric string, value float64, hostname string, tags []string) {
 	s.sendMetricSample(metric, value, hostname, tags, metrics.RateType)
@@ -286,6 +305,46 @@ func (s *checkSender) Histogram(metric string, value float64, hostname string, t
 	s.sendMetricSample(metric, value, hostname, tags, metrics.HistogramType)
 }
 
+// SubmitBatch sends several metric samples to the aggregator at once, in a single message on the sender channel.
+// It should be used by the checks submitting a lot of points per run instead of the methods per metric type.
+// The Name, Value, Mtype, Host and Tags of the samples must be set, they're sent at the current time if they don't have
+// a valid Timestamp. The samples must not be modified by the caller once submitted, the tags of the samples are
+// copied before the check tags are added, so their slices can be shared with the caller.
+func (s *checkSender) SubmitBatch(samples []*metrics.MetricSample) {
+	if len(samples) == 0 {
+		return
+	}
+
+	now := timeNowNano()
+	for _, sample := range samples {
+		if len(s.checkTags) > 0 {
+			tags := make([]string, 0, len(sample.Tags)+len(s.checkTags))
+			tags = append(tags, sample.Tags...)
+			sample.Tags = append(tags, s.checkTags...)
+		}
+		if sample.Host == "" && !s.defaultHostnameDisabled {
+			sample.Host = s.defaultHostname
+		}
+		if sample.SampleRate == 0 {
+			sample.SampleRate = 1
+		}
+		if sample.Timestamp <= 0 {
+			if sample.Mtype == metrics.GaugeWithTimestampType {
+				sample.Mtype = metrics.GaugeType
+			}
+			sample.Timestamp = now
+		}
+	}
+
+	log.Tracef("Batch of %d samples submitted", len(samples))
+
+	s.smsOut <- senderMetricSample{id: s.id, batch: samples}
+
+	s.metricStats.Lock.Lock()
+	s.metricStats.MetricSamples += int64(len(samples))
+	s.metricStats.Lock.Unlock()
+}
+
 // HistogramBucket should be called to directly send raw buckets to be submitted as distribution metrics
 func (s *checkSender) HistogramBucket(metric string, value int64, lowerBound, upperBound float64, monotonic bool, hostname string, tags []string) {
 	tags = append(tags, s.checkTags...)
@@ -470,6 +470,82 @@ func TestCheckSenderInterface(t *testing.T) {
 	assert.Equal(t, []string{"foo", "bar"}, histogramBucket.bucket.Tags)
 }
 
+func TestCheckSenderGaugeWithTimestamp(t *testing.T) {
+	senderMetricSampleChan := make(chan senderMetricSample, 10)
+	serviceCheckChan := make(chan metrics.ServiceCheck, 10)
+	eventChan := make(chan metrics.Event, 10)
+	bucketChan := make(chan senderHistogramBucket, 10)
+	checkSender := newCheckSender(checkID1, "default-hostname", senderMetricSampleChan, serviceCheckChan, eventChan, bucketChan)
+	checkSender.SetCheckCustomTags([]string{"custom:tag"})
+
+	checkSender.GaugeWithTimestamp("my.metric", 1.0, "", []string{"foo"}, 1234.0)
+	checkSender.GaugeWithTimestamp("my.metric", 2.0, "my-hostname", []string{"foo"}, 0)
+
+	timestampedSample := <-senderMetricSampleChan
+	assert.Equal(t, metrics.GaugeWithTimestampType, timestampedSample.metricSample.Mtype)
+	assert.Equal(t, 1234.0, timestampedSample.metricSample.Timestamp)
+	assert.Equal(t, "default-hostname", timestampedSample.metricSample.Host)
+	assert.Equal(t, []string{"foo", "custom:tag"}, timestampedSample.metricSample.Tags)
+
+	// Invalid timestamps fall back to a gauge sent at the current time
+	fallbackSample := <-senderMetricSampleChan
+	assert.Equal(t, metrics.GaugeType, fallbackSample.metricSample.Mtype)
+	assert.NotEqual(t, 0.0, fallbackSample.metricSample.Timestamp)
+	assert.Equal(t, "my-hostname", fallbackSample.metricSample.Host)
+}
+
+func TestCheckSenderSubmitBatch(t *testing.T) {
+	senderMetricSampleChan := make(chan senderMetricSample, 10)
+	serviceCheckChan := make(chan metrics.ServiceCheck, 10)
+	eventChan := make(chan metrics.Event, 10)
+	bucketChan := make(chan senderHistogramBucket, 10)
+	checkSender := newCheckSender(checkID1, "default-hostname", senderMetricSampleChan, serviceCheckChan, eventChan, bucketChan)
+	checkSender.SetCheckCustomTags([]string{"custom:tag"})
+
+	checkSender.SubmitBatch(nil)
+	checkSender.SubmitBatch([]*metrics.MetricSample{
+		{Name: "my.metric", Value: 1.0, Mtype: metrics.GaugeType, Tags: []string{"foo"}},
+		{Name: "my.count_metric", Value: 2.0, Mtype: metrics.CountType, Host: "my-hostname", Tags: []string{"foo"}},
+		{Name: "my.timestamped_metric", Value: 3.0, Mtype: metrics.GaugeWithTimestampType, Tags: []string{"foo"}, Timestamp: 1234.0},
+		{Name: "my.timestamped_metric", Value: 4.0, Mtype: metrics.GaugeWithTimestampType, Tags: []string{"foo"}},
+	})
+
+	// The empty batch isn't sent
+	require.Len(t, senderMetricSampleChan, 1)
+	batchSenderSample := <-senderMetricSampleChan
+	assert.EqualValues(t, checkID1, batchSenderSample.id)
+	assert.Nil(t, batchSenderSample.metricSample)
+	assert.Equal(t, false, batchSenderSample.commit)
+	require.Len(t, batchSenderSample.batch, 4)
+
+	gauge := batchSenderSample.batch[0]
+	assert.Equal(t, "default-hostname", gauge.Host)
+	assert.Equal(t, []string{"foo", "custom:tag"}, gauge.Tags)
+	assert.Equal(t, 1.0, gauge.SampleRate)
+	assert.NotEqual(t, 0.0, gauge.Timestamp)
+	assert.Equal(t, "my-hostname", batchSenderSample.batch[1].Host)
+	assert.Equal(t, metrics.GaugeWithTimestampType, batchSenderSample.batch[2].Mtype)
+	assert.Equal(t, 1234.0, batchSenderSample.batch[2].Timestamp)
+	// Invalid timestamps fall back to a gauge sent at the current time
+	assert.Equal(t, metrics.GaugeType, batchSenderSample.batch[3].Mtype)
+	assert.NotEqual(t, 0.0, batchSenderSample.batch[3].Timestamp)
+
+	// The check tags aren't written in the backing array of the tags of the caller
+	shared := make([]string, 1, 2)
+	shared[0] = "foo"
+	checkSender.SubmitBatch([]*metrics.MetricSample{
+		{Name: "my.metric", Value: 1.0, Mtype: metrics.GaugeType, Tags: shared},
+		{Name: "my.other_metric", Value: 2.0, Mtype: metrics.GaugeType, Tags: shared},
+	})
+	batchSenderSample = <-senderMetricSampleChan
+	assert.Equal(t, []string{"foo", "custom:tag"}, batchSenderSample.batch[0].Tags)
+	assert.Equal(t, []string{"foo", "custom:tag"}, batchSenderSample.batch[1].Tags)
+	assert.Equal(t, []string{"foo", ""}, shared[:2])
+
+	checkSender.Commit()
+	assert.Equal(t, int64(6), checkSender.GetMetricStats()["MetricSamples"])
+}
+
 func TestCheckSenderHostname(t *testing.T) {
 	defaultHostname := "default-host"
 
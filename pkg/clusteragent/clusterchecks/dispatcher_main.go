@@ -31,6 +31,7 @@ type dispatcher struct {
 	extraTags             []string
 	clcRunnersClient      clusteragent.CLCRunnerClientInterface
 	advancedDispatching   bool
+	splitInstances        bool
 }
 
 func newDispatcher() *dispatcher {
@@ -39,6 +40,7 @@ func newDispatcher() *dispatcher {
 	}
 	d.nodeExpirationSeconds = config.Datadog.GetInt64("cluster_checks.node_expiration_timeout")
 	d.extraTags = config.Datadog.GetStringSlice("cluster_checks.extra_tags")
+	d.splitInstances = config.Datadog.GetBool("cluster_checks.split_instances")
 
 	clusterTagValue := clustername.GetClusterName()
 	clusterTagName := config.Datadog.GetString("cluster_checks.cluster_tag_name")
@@ -71,6 +73,9 @@ func (d *dispatcher) Stop() {
 
 // Schedule implements the scheduler.Scheduler interface
 func (d *dispatcher) Schedule(configs []integration.Config) {
+	if d.splitInstances {
+		configs = splitInstances(configs)
+	}
 	for _, c := range configs {
 		if !c.ClusterCheck {
 			continue // Ignore non cluster-check configs
@@ -96,6 +101,9 @@ func (d *dispatcher) Schedule(configs []integration.Config) {
 
 // Unschedule implements the scheduler.Scheduler interface
 func (d *dispatcher) Unschedule(configs []integration.Config) {
+	if d.splitInstances {
+		configs = splitInstances(configs)
+	}
 	for _, c := range configs {
 		if !c.ClusterCheck {
 			continue // Ignore non cluster-check configs
@@ -78,6 +78,35 @@ func TestScheduleUnschedule(t *testing.T) {
 	requireNotLocked(t, dispatcher.store)
 }
 
+func TestScheduleUnscheduleSplitInstances(t *testing.T) {
+	mockConfig := config.Mock()
+	mockConfig.Set("cluster_checks.split_instances", true)
+	defer mockConfig.Set("cluster_checks.split_instances", false)
+
+	dispatcher := newDispatcher()
+	config1 := integration.Config{
+		Name:         "cluster-check",
+		ClusterCheck: true,
+		Instances:    []integration.Data{integration.Data("collectors: [pods]"), integration.Data("collectors: [nodes]")},
+	}
+
+	dispatcher.Schedule([]integration.Config{config1})
+	stored, err := dispatcher.getAllConfigs()
+	assert.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Len(t, stored[0].Instances, 1)
+	assert.Len(t, stored[1].Instances, 1)
+	assert.Equal(t, 2, len(dispatcher.store.danglingConfigs))
+
+	dispatcher.Unschedule([]integration.Config{config1})
+	stored, err = dispatcher.getAllConfigs()
+	assert.NoError(t, err)
+	assert.Len(t, stored, 0)
+	assert.Equal(t, 0, len(dispatcher.store.danglingConfigs))
+
+	requireNotLocked(t, dispatcher.store)
+}
+
 func TestScheduleUnscheduleEndpoints(t *testing.T) {
 	dispatcher := newDispatcher()
 
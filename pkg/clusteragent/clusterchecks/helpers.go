@@ -31,6 +31,24 @@ func makeConfigArray(configMap map[string]integration.Config) []integration.Conf
 	return configSlice
 }
 
+// splitInstances returns one config per instance for the cluster-check configs with several instances,
+// so that they can be dispatched to different nodes. Endpoints checks are kept as is.
+func splitInstances(configs []integration.Config) []integration.Config {
+	splitConfigs := make([]integration.Config, 0, len(configs))
+	for _, c := range configs {
+		if !c.ClusterCheck || c.NodeName != "" || len(c.Instances) < 2 {
+			splitConfigs = append(splitConfigs, c)
+			continue
+		}
+		for _, instance := range c.Instances {
+			split := c
+			split.Instances = []integration.Data{instance}
+			splitConfigs = append(splitConfigs, split)
+		}
+	}
+	return splitConfigs
+}
+
 // timestampNow provides a consistent way to keep a seconds timestamp
 func timestampNow() int64 {
 	return time.Now().Unix()
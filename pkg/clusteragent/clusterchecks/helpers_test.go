@@ -10,6 +10,10 @@ package clusterchecks
 import (
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
 	"github.com/DataDog/datadog-agent/pkg/clusteragent/clusterchecks/types"
 )
 
@@ -100,3 +104,42 @@ func Test_calculateBusyness(t *testing.T) {
 		})
 	}
 }
+
+func Test_splitInstances(t *testing.T) {
+	clusterCheck := integration.Config{
+		Name:         "kubernetes_state",
+		ClusterCheck: true,
+		InitConfig:   integration.Data("{}"),
+		Instances:    []integration.Data{integration.Data("collectors: [pods]"), integration.Data("collectors: [nodes, deployments]")},
+	}
+	endpointsCheck := integration.Config{
+		Name:         "http_check",
+		ClusterCheck: true,
+		NodeName:     "node1",
+		Instances:    []integration.Data{integration.Data("foo: bar"), integration.Data("bar: foo")},
+	}
+	singleInstance := integration.Config{
+		Name:         "mysql",
+		ClusterCheck: true,
+		Instances:    []integration.Data{integration.Data("foo: bar")},
+	}
+	nodeCheck := integration.Config{
+		Name:      "redis",
+		Instances: []integration.Data{integration.Data("foo: bar"), integration.Data("bar: foo")},
+	}
+
+	split := splitInstances([]integration.Config{clusterCheck, endpointsCheck, singleInstance, nodeCheck})
+	require.Len(t, split, 5)
+
+	assert.Equal(t, "kubernetes_state", split[0].Name)
+	assert.Equal(t, []integration.Data{integration.Data("collectors: [pods]")}, split[0].Instances)
+	assert.Equal(t, integration.Data("{}"), split[0].InitConfig)
+	assert.Equal(t, "kubernetes_state", split[1].Name)
+	assert.Equal(t, []integration.Data{integration.Data("collectors: [nodes, deployments]")}, split[1].Instances)
+	assert.NotEqual(t, split[0].Digest(), split[1].Digest())
+	assert.Len(t, clusterCheck.Instances, 2)
+
+	assert.Equal(t, endpointsCheck, split[2])
+	assert.Equal(t, singleInstance, split[3])
+	assert.Equal(t, nodeCheck, split[4])
+}
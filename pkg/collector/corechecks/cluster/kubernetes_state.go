@@ -9,16 +9,27 @@ package cluster
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/aggregator"
 	"github.com/DataDog/datadog-agent/pkg/autodiscovery/integration"
 	"github.com/DataDog/datadog-agent/pkg/collector/check"
 	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/DataDog/datadog-agent/pkg/config"
 	kubestatemetrics "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/builder"
+	"github.com/DataDog/datadog-agent/pkg/kubestatemetrics/scraper"
 	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/util/containers"
 	"github.com/DataDog/datadog-agent/pkg/util/kubernetes/apiserver"
+	"github.com/DataDog/datadog-agent/pkg/util/kubernetes/apiserver/leaderelection"
+	"github.com/DataDog/datadog-agent/pkg/util/kubernetes/clustername"
+	"github.com/DataDog/datadog-agent/pkg/util/kubernetes/kubelet"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 
 	"gopkg.in/yaml.v2"
@@ -31,10 +42,28 @@ const (
 	// TODO rename correctly once we deprecate the python check
 	kubeStateMetricsCheckName = "kubernetes_state-alpha"
 	defaultResyncPeriod       = 30
+	defaultScrapeTimeout      = 10 * time.Second
+	// maxTimestampAge bounds how far honor_timestamps backdates a point, older points are dropped by the intake
+	maxTimestampAge = 10 * time.Minute
 )
 
 // KSMConfig contains the check config parameters
+// See the conf.yaml.example of the check for the details of the options
 type KSMConfig struct {
+	// KubeStateURL is the metrics endpoint of an existing kube-state-metrics deployment, scraped instead of watching the resources.
+	// Example: Scrape the kube-state-metrics service of the kube-system namespace.
+	// kube_state_url: http://kube-state-metrics.kube-system:8080/metrics
+	KubeStateURL string `yaml:"kube_state_url"`
+
+	// Kubeconfig, KubeContext and ClusterName make the check monitor another cluster with the credentials of a kubeconfig context.
+	// Example: Monitor the prod context of a kubeconfig mounted from a secret.
+	// kubeconfig: /etc/datadog-agent/kubeconfigs/prod.yaml
+	// kube_context: prod
+	// cluster_name: prod-eu
+	Kubeconfig  string `yaml:"kubeconfig"`
+	KubeContext string `yaml:"kube_context"`
+	ClusterName string `yaml:"cluster_name"`
+
 	// Collectors defines the resource type collectors.
 	// Example: Enable pods and nodes collectors.
 	// collectors:
@@ -60,6 +89,13 @@ type KSMConfig struct {
 	//   namespace: kube_namespace
 	LabelsMapper map[string]string `yaml:"labels_mapper"`
 
+	// LabelToTagMapping is an alias of LabelsMapper, mappings defined in LabelsMapper are prioritized.
+	// Example: Use the tag names of the legacy KSM check.
+	// label_to_tag_mapping:
+	//   pod: pod_name
+	//   container: kube_container_name
+	LabelToTagMapping map[string]string `yaml:"label_to_tag_mapping"`
+
 	// Namespaces contains the namespaces from which we collect metrics
 	// Example: Enable metric collection for objects in prod and kube-system namespaces.
 	// namespaces:
@@ -69,6 +105,139 @@ type KSMConfig struct {
 
 	// ResyncPeriod is the frequency of resync'ing the metrics cache in seconds, default 30.
 	ResyncPeriod int `yaml:"resync_period"`
+
+	// ListPageSize is the number of objects requested per page by the list calls to the API server, default 500.
+	ListPageSize int `yaml:"list_page_size"`
+
+	// DeletedObjectsTTL is the duration in seconds the metrics of the deleted objects are kept and reported for, default 0.
+	DeletedObjectsTTL int `yaml:"deleted_objects_ttl"`
+
+	// DisableNodeHostname disables attaching the metrics of the nodes (kube_node_*) to the corresponding hosts.
+	DisableNodeHostname bool `yaml:"disable_node_hostname"`
+
+	// MetricPrefix overrides the namespace of the metrics sent by the check, default kubernetes_state.
+	// Example: Use kube_state as metric namespace.
+	// metric_prefix: kube_state
+	MetricPrefix string `yaml:"metric_prefix"`
+
+	// WaitingReasons and TerminatedReasons add or remove the container waiting and terminated reasons reported by the check.
+	// Example: Report the CreateContainerConfigError waiting reason and stop reporting the ContainerCreating one.
+	// waiting_reasons:
+	//   add:
+	//     - CreateContainerConfigError
+	//   remove:
+	//     - ContainerCreating
+	WaitingReasons    ReasonsConfig `yaml:"waiting_reasons"`
+	TerminatedReasons ReasonsConfig `yaml:"terminated_reasons"`
+
+	// ReportAllWaitingReasons reports all the container waiting reasons instead of the allowed ones only.
+	ReportAllWaitingReasons bool `yaml:"report_all_waiting_reasons"`
+
+	// HistogramMetrics contains the metrics (without the metric prefix) submitted as histograms instead of gauges.
+	// Example: Submit the container restarts as a histogram.
+	// histogram_metrics:
+	//   - container.restarts
+	HistogramMetrics []string `yaml:"histogram_metrics"`
+
+	// ChangedSeriesMetrics contains the metrics (without the metric prefix) whose series are only submitted when their value
+	// changed, or after ChangedSeriesMaxAge seconds, default 300.
+	// Example: Only submit the node and deployment conditions when they change.
+	// changed_series_metrics:
+	//   - node.by_condition
+	//   - deployment.condition
+	ChangedSeriesMetrics []string `yaml:"changed_series_metrics"`
+	ChangedSeriesMaxAge  int      `yaml:"changed_series_max_age"`
+
+	// DisableConfigMapSecretCounts disables the configmap.count and secret.count metrics.
+	DisableConfigMapSecretCounts bool `yaml:"disable_configmap_secret_counts"`
+
+	// CustomResources allows generating metrics from the fields of custom resources objects.
+	// Example: Submit the replicas of the Foo objects as customresource.foo.replicas, tagged with their app label.
+	// custom_resources:
+	//   - group: example.com
+	//     version: v1
+	//     kind: Foo
+	//     resource: foos
+	//     labels_from_path:
+	//       app: metadata.labels.app
+	//     metrics:
+	//       - name: replicas
+	//         path: spec.replicas
+	CustomResources []CustomResourceConfig `yaml:"custom_resources"`
+
+	// RolloutStuckTimeout is the duration in seconds after which a rollout that didn't progress is reported as stuck, default 600.
+	RolloutStuckTimeout int `yaml:"rollout_stuck_timeout"`
+
+	// DaemonSetUnavailableThreshold is the number of unavailable daemons above which the daemonset.scheduling service check is CRITICAL, default 0.
+	DaemonSetUnavailableThreshold int `yaml:"daemonset_unavailable_threshold"`
+
+	// DaemonSetMisscheduledRuns is the number of consecutive runs with misscheduled daemons after which
+	// the daemonset.misscheduling service check is WARNING, default 3.
+	DaemonSetMisscheduledRuns int `yaml:"daemonset_misscheduled_runs"`
+
+	// ReplicaMismatchGracePeriod is the duration in seconds a deployment can have less available replicas than desired
+	// before the deployment.available service check fails, default 300.
+	ReplicaMismatchGracePeriod int `yaml:"replica_mismatch_grace_period"`
+
+	// NamespaceTerminatingTimeout is the duration in seconds after which a terminating namespace is reported as stuck, default 600.
+	NamespaceTerminatingTimeout int `yaml:"namespace_terminating_timeout"`
+
+	// PersistentVolumePendingTimeout is the duration in seconds after which a pending persistent volume
+	// makes the persistentvolume.status service check WARNING, default 600.
+	PersistentVolumePendingTimeout int `yaml:"persistentvolume_pending_timeout"`
+
+	// ResourceQuotaWarningThreshold and ResourceQuotaCriticalThreshold are the utilization ratios (used/limit)
+	// from which the resourcequota.utilization service check is WARNING and CRITICAL, default 0.9 and 0.95.
+	// Example: Alert when 80% of a quota is used, and critically when it's exhausted.
+	// resourcequota_warning_threshold: 0.8
+	// resourcequota_critical_threshold: 1
+	ResourceQuotaWarningThreshold  float64 `yaml:"resourcequota_warning_threshold"`
+	ResourceQuotaCriticalThreshold float64 `yaml:"resourcequota_critical_threshold"`
+
+	// MaxContextsPerMetric limits the number of unique tag sets submitted per KSM metric family, disabled by default.
+	// Example: Limit each metric family to 10000 series.
+	// max_contexts_per_metric: 10000
+	MaxContextsPerMetric int `yaml:"max_contexts_per_metric"`
+
+	// LeaderElection makes only the leader of the agents submit the metrics, it requires the agent leader_election option.
+	LeaderElection bool `yaml:"leader_election"`
+
+	// MaxEventsPerRun is the number of events the check can send per run, default 50, and EventDedupWindow
+	// the duration in seconds during which the same event isn't sent again for an object, default 300.
+	MaxEventsPerRun  int `yaml:"max_events_per_run"`
+	EventDedupWindow int `yaml:"event_dedup_window"`
+
+	// RestartBurstThreshold and RestartBurstWindow send an event when the containers of a pod restarted
+	// at least RestartBurstThreshold times during the last RestartBurstWindow seconds, default 3 restarts in 300 seconds.
+	// Example: Send an event when the containers of a pod restarted 5 times in 10 minutes.
+	// restart_burst_threshold: 5
+	// restart_burst_window: 600
+	RestartBurstThreshold int `yaml:"restart_burst_threshold"`
+	RestartBurstWindow    int `yaml:"restart_burst_window"`
+
+	// DryRun runs the check without submitting anything, what it would submit is logged instead, or appended to DryRunFile if set.
+	// Example: Write what the check would submit to a file.
+	// dry_run: true
+	// dry_run_file: /tmp/kubernetes_state.out
+	DryRun     bool   `yaml:"dry_run"`
+	DryRunFile string `yaml:"dry_run_file"`
+
+	// StaggerCollectors spreads the processing of the resource collectors over the check interval, disabled by default.
+	// Example: Spread the processing of the pods, nodes and deployments over the interval.
+	// stagger_collectors: true
+	StaggerCollectors bool `yaml:"stagger_collectors"`
+
+	// HonorTimestamps submits the first point of the condition metrics after a transition at the transition time, disabled by default.
+	HonorTimestamps bool `yaml:"honor_timestamps"`
+
+	// TaggerTags adds the tags of the agent tagger to the metrics of the pods and containers, disabled by default.
+	TaggerTags bool `yaml:"tagger_tags"`
+
+	// ExperimentalMetrics enables groups of KSM metric families disabled by default, see experimentalMetricGroups.
+	// Example: Collect the specification details of the cronjobs, jobs and services.
+	// experimental_metrics:
+	//   - spec
+	ExperimentalMetrics []string `yaml:"experimental_metrics"`
 }
 
 // KSMCheck wraps the config and the metric stores needed to run the check
@@ -76,6 +245,167 @@ type KSMCheck struct {
 	core.CheckBase
 	instance *KSMConfig
 	store    []cache.Store
+
+	// dryRunOutput receives the output of the dry_run option, it's logged if nil
+	// The dry_run_file is opened once by Configure and closed by Stop
+	dryRunOutput io.Writer
+
+	// storeDumpOutput receives the content of the stores at each run when it's set, see `agent check --dump-store`
+	storeDumpOutput io.Writer
+
+	// droppedMetricsOutput receives the summary of the metrics dropped during each run when it's set, see `agent check --debug-transformers`
+	droppedMetricsOutput io.Writer
+
+	// collectors contains the enabled resource collectors, it's empty when the scraper is used
+	collectors []string
+	// storesStarted is when the stores were started, their collectors are reported as failed
+	// if they didn't receive the list of their objects collectorSyncTimeout after
+	storesStarted time.Time
+
+	// stopOnce closes the dry_run_file once when the check is stopped
+	stopOnce sync.Once
+
+	// run is the check run in progress, it's nil between the runs
+	run *checkRun
+
+	// ksmVersion is the version of the embedded kube-state-metrics library
+	ksmVersion string
+
+	// scraper collects the metrics of the kube_state_url endpoint, the stores aren't used when it's set
+	scraper *scraper.Scraper
+
+	// pushedStores contains the metrics pushed by each store during the previous run
+	pushedStores []*pushedStore
+
+	// clusterName is used to build the hostname of the node metrics and the kube_cluster_name tag
+	clusterName string
+
+	// customTags contains the instance tags and the kube_cluster_name tag, the sender appends them to everything submitted
+	customTags []string
+
+	// nodeConditions keeps the status of each condition per node seen during the previous run
+	// it's used to send events on node condition transitions
+	nodeConditions map[string]map[string]string
+	// currentNodeConditions is filled during the current run and replaces nodeConditions at the end of the run
+	// this way deleted nodes are forgotten
+	currentNodeConditions map[string]map[string]string
+
+	// jobFailures keeps the failure value per job for the job failure metrics seen during the previous run
+	// it's used to send events on new job failures
+	jobFailures map[string]float64
+	// currentJobFailures is filled during the current run and replaces jobFailures at the end of the run
+	// this way deleted jobs are forgotten
+	currentJobFailures map[string]float64
+
+	// timestamps contains the timestamps submitted with honor_timestamps per series during the previous run
+	// it's used to only backdate the first point after a transition, see submitGaugeWithTimestamp
+	timestamps map[string]float64
+	// currentTimestamps is filled during the current run and replaces timestamps at the end of the run
+	currentTimestamps map[string]float64
+
+	// oomKilledContainers contains the containers seen OOMKilled during the previous run
+	// it's used to deduplicate OOMKilled events
+	oomKilledContainers map[string]struct{}
+	// currentOOMKilledContainers is filled during the current run and replaces oomKilledContainers at the end of the run
+	currentOOMKilledContainers map[string]struct{}
+
+	// evictedPods contains the pods seen Evicted during the previous run
+	// it's used to deduplicate the Evicted events
+	evictedPods map[string]struct{}
+	// currentEvictedPods is filled during the current run and replaces evictedPods at the end of the run
+	currentEvictedPods map[string]struct{}
+
+	// podRestarts contains the container restart counts and the recent restarts of the pods seen during the previous run
+	// it's used to detect the restart bursts
+	podRestarts map[string]*podRestartState
+	// currentPodRestarts is filled during the current run and replaces podRestarts at the end of the run
+	currentPodRestarts map[string]*podRestartState
+
+	// sentEvents contains the last time an event was sent per event key, it's used to deduplicate the events
+	sentEvents map[string]time.Time
+	// eventsSent is the number of events sent during the run
+	eventsSent int
+	// droppedEvents counts the events dropped during the run per reason
+	droppedEvents map[string]float64
+
+	// sentServiceChecks contains the service checks sent during the run, see submitServiceCheck
+	sentServiceChecks map[string]struct{}
+
+	// hasRun is true once the check completed its first run
+	hasRun bool
+
+	// unprocessedMetrics counts the metrics that couldn't be processed as expected during the run
+	unprocessedMetrics map[unprocessedMetric]float64
+
+	// droppedMetrics counts the metrics dropped by the transformers during the run per reason and detail
+	// it's only filled when droppedMetricsOutput is set
+	droppedMetrics map[droppedMetric]int
+
+	// allowedWaitingReasons and allowedTerminatedReasons contain the container reasons reported by the check
+	allowedWaitingReasons    map[string]struct{}
+	allowedTerminatedReasons map[string]struct{}
+
+	// histogramMetrics contains the Datadog metric names submitted as histograms
+	histogramMetrics map[string]struct{}
+
+	// changedSeriesMetrics contains the Datadog metric names submitted only when their value changed
+	changedSeriesMetrics map[string]struct{}
+	// submittedSeries contains the series of the changedSeriesMetrics seen during the previous run, see changedSeriesSender
+	submittedSeries map[string]submittedSeries
+
+	// customResourceMetricNames translates the custom resource metric names to Datadog metric names
+	customResourceMetricNames map[string]string
+
+	// workloads keeps the state of the workloads per kind and namespace/name
+	// it's used to compute metrics and service checks from several KSM metrics, and to track rollouts between runs
+	workloads map[string]map[string]*workloadState
+
+	// cronJobs contains the schedule and the last successful job of the cronjobs seen during the run
+	cronJobs map[string]*cronJobState
+
+	// namespaces keeps the state of the namespaces, it's used to detect the namespaces stuck terminating
+	namespaces map[string]*namespaceState
+
+	// persistentVolumes keeps the state of the persistent volumes, it's used to detect the volumes pending for too long
+	persistentVolumes map[string]*persistentVolumeState
+
+	// nodesUnderPressure contains the number of nodes under each pressure condition seen during the run
+	nodesUnderPressure map[string]float64
+
+	// resourceQuotas contains the used and limit values of the resource quotas per namespace/quota/resource seen during the run
+	resourceQuotas map[string]*resourceQuotaUsage
+
+	// familyDurations contains the processing time of each metric family during the run
+	familyDurations map[string]time.Duration
+
+	// familyContexts contains the contexts admitted per KSM metric family, it's used to enforce max_contexts_per_metric
+	// A context is kept while it's submitted, the value tells whether it was submitted during the run
+	familyContexts map[string]map[string]bool
+	// overflowValues contains the summed values of the contexts over the limit per metric,
+	// overflowContexts contains the contexts over the limit per KSM metric family during the run
+	overflowValues   map[string]float64
+	overflowContexts map[string]map[string]struct{}
+}
+
+// ReasonsConfig contains the reasons to add to or remove from the default container reasons, case insensitive
+type ReasonsConfig struct {
+	Add    []string `yaml:"add"`
+	Remove []string `yaml:"remove"`
+}
+
+// allowedReasons returns the default reasons updated with the configured ones
+func (rc ReasonsConfig) allowedReasons(defaults map[string]struct{}) map[string]struct{} {
+	reasons := make(map[string]struct{}, len(defaults)+len(rc.Add))
+	for reason := range defaults {
+		reasons[reason] = struct{}{}
+	}
+	for _, reason := range rc.Add {
+		reasons[strings.ToLower(reason)] = struct{}{}
+	}
+	for _, reason := range rc.Remove {
+		delete(reasons, strings.ToLower(reason))
+	}
+	return reasons
 }
 
 // JoinsConfig contains the config parameters for label joins
@@ -89,6 +419,18 @@ type JoinsConfig struct {
 
 	// GetAllLabels replaces LabelsToGet if enabled
 	GetAllLabels bool `yaml:"get_all_labels"`
+
+	// tagKeys replaces the labels of the targeted metric by other labels before they're converted into tags,
+	// it's used by the default label joins whose labels have another meaning elsewhere (e.g. phase is mapped to pod_phase)
+	tagKeys map[string]string
+}
+
+// joinedLabel returns the label a label of the targeted metric is joined as
+func (jc *JoinsConfig) joinedLabel(label string) string {
+	if key, found := jc.tagKeys[label]; found {
+		return key
+	}
+	return label
 }
 
 func (jc *JoinsConfig) setupGetAllLabels() {
@@ -110,6 +452,9 @@ func init() {
 
 // Configure prepares the configuration of the KSM check instance
 func (k *KSMCheck) Configure(config, initConfig integration.Data, source string) error {
+	// Several instances can run with different collectors and min_collection_interval
+	k.BuildID(config, initConfig)
+
 	err := k.CommonConfigure(config, source)
 	if err != nil {
 		return err
@@ -120,6 +465,50 @@ func (k *KSMCheck) Configure(config, initConfig integration.Data, source string)
 		return err
 	}
 
+	initConf := &ksmInitConfig{}
+	if err = initConf.parse(initConfig); err != nil {
+		return err
+	}
+	if initConf.DumpStore {
+		k.storeDumpOutput = os.Stdout
+	}
+	if initConf.DebugTransformers {
+		k.droppedMetricsOutput = os.Stdout
+	}
+
+	// Prepare the allowed container reasons
+	k.allowedWaitingReasons = k.instance.WaitingReasons.allowedReasons(defaultWaitingReasons)
+	k.allowedTerminatedReasons = k.instance.TerminatedReasons.allowedReasons(defaultTerminatedReasons)
+
+	// Prepare the metric prefix
+	if k.instance.MetricPrefix == "" {
+		k.instance.MetricPrefix = ksmMetricPrefix
+	} else if !strings.HasSuffix(k.instance.MetricPrefix, ".") {
+		k.instance.MetricPrefix += "."
+	}
+
+	// Prepare the histogram metrics
+	for _, name := range k.instance.HistogramMetrics {
+		k.histogramMetrics[k.metricName(name)] = struct{}{}
+	}
+
+	// Prepare the metrics submitted only when they change
+	for _, name := range k.instance.ChangedSeriesMetrics {
+		k.changedSeriesMetrics[k.metricName(name)] = struct{}{}
+	}
+
+	k.clusterName = k.instance.ClusterName
+	if k.clusterName == "" && !k.outOfCluster() {
+		k.clusterName = clustername.GetClusterName()
+	}
+	if k.outOfCluster() && k.instance.TaggerTags {
+		log.Warn("The tagger_tags option is ignored with a kubeconfig, the tagger only knows the entities of the cluster of the agent")
+		k.instance.TaggerTags = false
+	}
+	if err := k.setCustomTags(config); err != nil {
+		return err
+	}
+
 	// Prepare label joins
 	for _, joinConf := range k.instance.LabelJoins {
 		joinConf.setupGetAllLabels()
@@ -128,8 +517,40 @@ func (k *KSMCheck) Configure(config, initConfig integration.Data, source string)
 	k.mergeLabelJoins(defaultLabelJoins)
 
 	// Prepare labels mapper
+	k.mergeLabelsMapper(k.instance.LabelToTagMapping)
 	k.mergeLabelsMapper(defaultLabelsMapper)
 
+	denied, err := deniedMetricsWithExperimental(k.instance.ExperimentalMetrics)
+	if err != nil {
+		return err
+	}
+
+	allowDenyList, err := allowdenylist.New(options.MetricSet{}, denied)
+	if err != nil {
+		return err
+	}
+
+	if err := allowDenyList.Parse(); err != nil {
+		return err
+	}
+
+	if k.instance.DryRun && k.instance.DryRunFile != "" && k.dryRunOutput == nil {
+		out, err := openDryRunFile(k.instance.DryRunFile)
+		if err != nil {
+			return err
+		}
+		k.dryRunOutput = out
+	}
+
+	if k.instance.KubeStateURL != "" {
+		k.scraper = scraper.New(k.instance.KubeStateURL, defaultScrapeTimeout)
+		k.scraper.WithFamilyFilter(func(name string) bool {
+			name = translatedFamilyName(name)
+			return allowDenyList.IsIncluded(name) && k.storeFamilyFilter(name)
+		})
+		return nil
+	}
+
 	builder := kubestatemetrics.New()
 
 	// Prepare the collectors for the resources specified in the configuration file.
@@ -143,6 +564,7 @@ func (k *KSMCheck) Configure(config, initConfig integration.Data, source string)
 	if err := builder.WithEnabledResources(collectors); err != nil {
 		return err
 	}
+	k.collectors = collectors
 
 	// Prepare watched namespaces
 	namespaces := k.instance.Namespaces
@@ -154,23 +576,33 @@ func (k *KSMCheck) Configure(config, initConfig integration.Data, source string)
 
 	builder.WithNamespaces(namespaces)
 
-	allowDenyList, err := allowdenylist.New(options.MetricSet{}, deniedMetrics)
-	if err != nil {
-		return err
-	}
-
-	if err := allowDenyList.Parse(); err != nil {
-		return err
-	}
-
 	builder.WithAllowDenyList(allowDenyList)
 
-	c, err := apiserver.GetAPIClient()
+	kubeClient, dynamicClient, err := k.apiClients()
 	if err != nil {
 		return err
 	}
 
-	builder.WithKubeClient(c.Cl)
+	builder.WithKubeClient(kubeClient)
+
+	// Prepare the custom resources stores
+	if len(k.instance.CustomResources) > 0 {
+		if dynamicClient == nil {
+			return errors.New("custom resources require the apiserver dynamic client")
+		}
+		builder.WithDynamicClient(dynamicClient)
+	}
+	for i := range k.instance.CustomResources {
+		cr := &k.instance.CustomResources[i]
+		if err := cr.validate(); err != nil {
+			return err
+		}
+		for _, m := range cr.Metrics {
+			name, ddName := customResourceMetricName(cr.Kind, m.Name)
+			k.customResourceMetricNames[name] = ddName
+		}
+		builder.WithCustomResource(cr.groupVersionResource(), cr.metricFamilies())
+	}
 	builder.WithContext(context.Background())
 
 	resyncPeriod := k.instance.ResyncPeriod
@@ -180,19 +612,70 @@ func (k *KSMCheck) Configure(config, initConfig integration.Data, source string)
 
 	builder.WithResync(time.Duration(resyncPeriod) * time.Second)
 
+	if k.instance.ListPageSize > 0 {
+		builder.WithListPageSize(int64(k.instance.ListPageSize))
+	}
+
+	builder.WithDeletedObjectsTTL(time.Duration(k.instance.DeletedObjectsTTL) * time.Second)
+	if k.instance.HonorTimestamps {
+		builder.WithConditionTimestamps()
+	}
+
+	builder.WithFamilyFilter(func(name string) bool {
+		return k.storeFamilyFilter(translatedFamilyName(name))
+	})
+
 	builder.WithGenerateStoreFunc(builder.GenerateStore)
 
 	// Start the collection process
 	k.store = builder.Build()
+	k.storesStarted = time.Now()
 
 	return nil
 }
 
+// setCustomTags keeps the custom tags of the check, the instance tags and the kube_cluster_name tag.
+// The sender appends them to every metric, event and service check, CommonConfigure already gave it the instance tags,
+// the kube_cluster_name tag is added to them. The dry run sender appends them the same way.
+func (k *KSMCheck) setCustomTags(config integration.Data) error {
+	commonOptions := integration.CommonInstanceConfig{}
+	if err := yaml.Unmarshal(config, &commonOptions); err != nil {
+		return err
+	}
+
+	k.customTags = commonOptions.Tags
+	if k.clusterName == "" {
+		return nil
+	}
+	k.customTags = append(k.customTags, "kube_cluster_name:"+k.clusterName)
+
+	sender, err := aggregator.GetSender(k.ID())
+	if err != nil {
+		return err
+	}
+
+	sender.SetCheckCustomTags(k.customTags)
+	return nil
+}
+
 func (c *KSMConfig) parse(data []byte) error {
 	return yaml.Unmarshal(data, c)
 }
 
+// ksmInitConfig contains the init_config options of the check
+type ksmInitConfig struct {
+	// DumpStore is set by `agent check --dump-store` to write the content of the stores at each run
+	DumpStore bool `yaml:"dump_store"`
+	// DebugTransformers is set by `agent check --debug-transformers` to write why metrics were dropped at each run
+	DebugTransformers bool `yaml:"debug_transformers"`
+}
+
+func (c *ksmInitConfig) parse(data []byte) error {
+	return yaml.Unmarshal(data, c)
+}
+
 // Run runs the KSM check
+// With stagger_collectors a run of the check is split over several calls, see staggerSlots
 func (k *KSMCheck) Run() error {
 	sender, err := aggregator.GetSender(k.ID())
 	if err != nil {
@@ -201,49 +684,394 @@ func (k *KSMCheck) Run() error {
 
 	defer sender.Commit()
 
+	callStart := time.Now()
+	run := k.run
+	if run == nil {
+		if run, err = k.startRun(sender, callStart); run == nil {
+			return err
+		}
+		k.run = run
+	}
+
+	// The stores are pushed when they're processed, so the staggered ones aren't processed with the metrics of the start of the run
+	last := len(run.pushed)
+	if slots := k.staggerSlots(); slots > 1 {
+		run.slot++
+		last = run.slot * len(run.pushed) / slots
+	}
+	if k.scraper == nil {
+		for i := run.next; i < last; i++ {
+			run.pushed[i] = k.pushStore(i)
+		}
+	}
+	metricsToGet := k.joinedMetrics(run.pushed)
+	for ; run.next < last; run.next++ {
+		p := run.pushed[run.next]
+		if err := k.processStore(run.counter, p, metricsToGet); err != nil {
+			log.Warnf("Failed to process the metrics of the %s collector: %v", p.collector, err)
+			run.failed[p.collector] = collectorProcessingPanic
+		}
+	}
+	if run.next < len(run.pushed) {
+		run.batcher.flush()
+		// The time between the calls of a staggered run isn't reported as run duration
+		run.duration += time.Since(callStart)
+		return nil
+	}
+
+	k.run = nil
+	k.endStores(run, callStart)
+	return nil
+}
+
+// startRun starts a check run, it returns nil if the check mustn't run
+func (k *KSMCheck) startRun(sender aggregator.Sender, start time.Time) (*checkRun, error) {
+	// The runs returning early don't call endRun, the service checks they sent mustn't be deduplicated with the next run
+	k.sentServiceChecks = make(map[string]struct{})
+
+	if k.instance.LeaderElection {
+		if err := k.runLeaderElection(); err != nil {
+			if err == apiserver.ErrNotLeader {
+				// The events are only sent by the leader, they must not be sent for the state
+				// seen before taking over
+				k.hasRun = false
+				return nil, nil
+			}
+			return nil, err
+		}
+	}
+
+	// With dry_run nothing is submitted, including the service check of a failed scrape
+	if k.instance.DryRun {
+		dryRun := newDryRunSender(sender, k.dryRunOutput, k.customTags)
+		dryRun.writeHeader(start)
+		sender = dryRun
+	}
+
+	var pushed []*pushedStore
+	if k.scraper != nil {
+		p, err := k.scrape()
+		if err != nil {
+			k.sendCollectorStatus(sender, map[string]string{scraperCollector: collectorScrapeFailed}, 1)
+			return nil, err
+		}
+		pushed = []*pushedStore{p}
+	} else {
+		pushed = make([]*pushedStore, len(k.store))
+		if k.storeDumpOutput != nil {
+			dumpStores(k.storeDumpOutput, k.store)
+		}
+	}
+
+	// The points submitted by the check are counted for the telemetry and sent to the aggregator by batches
+	// The unchanged series of the changed_series_metrics are skipped
+	run := &checkRun{
+		start:   start,
+		sender:  sender,
+		batcher: newBatchingSender(sender),
+		pushed:  pushed,
+		failed:  make(map[string]string),
+	}
+	var submitter aggregator.Sender = run.batcher
+	if len(k.changedSeriesMetrics) > 0 {
+		run.changed = k.newChangedSeriesSender(run.batcher, start)
+		submitter = run.changed
+	}
+	run.counter = &countingSender{Sender: submitter}
+	return run, nil
+}
+
+// endStores runs the processors using the metrics of all the stores and sends the telemetry once all the stores were processed
+// callStart is the start of the last call of Run of the run
+func (k *KSMCheck) endStores(run *checkRun, callStart time.Time) {
+	if k.scraper == nil {
+		k.addFailedCollectors(run.failed, run.start)
+	}
+
+	k.processResourceQuotas(run.counter)
+	k.processNodePressure(run.counter)
+	k.processWorkloads(run.counter)
+	k.processCronJobs(run.counter)
+	k.processNamespaces(run.counter)
+	k.processPersistentVolumes(run.counter)
+	k.submitOverflow(run.counter)
+	run.batcher.flush()
+	k.sendTelemetry(run.sender)
+	k.sendStoreTelemetry(run.sender)
+	k.sendVersionMetadata(run.sender)
+	k.sendCollectorStatus(run.sender, run.failed, len(run.pushed))
+	k.writeDroppedMetrics()
+	if run.changed != nil {
+		k.endChangedSeries(run.sender, run.changed)
+	}
+	k.sendRunTelemetry(run.sender, run.counter.points, run.duration+time.Since(callStart))
+	k.endRun()
+}
+
+// checkRun contains the state of a check run, with stagger_collectors it spans several calls of Run
+type checkRun struct {
+	start   time.Time
+	sender  aggregator.Sender
+	batcher *batchingSender
+	changed *changedSeriesSender
+	counter *countingSender
+	// pushed contains the metrics of the stores, the ones not processed yet are nil
+	pushed []*pushedStore
+	// next is the index of the next store to process, and slot the number of calls of Run of the staggered run
+	next int
+	slot int
+	// failed contains the failed collectors, a collector failing doesn't prevent the others from being processed
+	// and they're reported by the collectors.status service check
+	failed map[string]string
+	// duration is the time spent in the previous calls of Run
+	duration time.Duration
+}
+
+// pushedStore contains the metrics pushed by a store and the store generation they correspond to
+type pushedStore struct {
+	// collector is the name of the collector of the store, e.g. v1.Pod
+	collector    string
+	generation   uint64
+	metrics      map[string][]ksmstore.DDMetricsFam
+	metricsToGet []ksmstore.DDMetricsFam
+}
+
+// pushStores returns the metrics of the stores, and the metrics used by the label joins
+func (k *KSMCheck) pushStores() []*pushedStore {
+	for i := range k.store {
+		k.pushStore(i)
+	}
+	return k.pushedStores
+}
+
+// pushStore returns the metrics of the store at the given index, and the metrics used by the label joins
+// The metrics pushed during the previous run are reused if the store didn't change since
+func (k *KSMCheck) pushStore(i int) *pushedStore {
+	if len(k.pushedStores) != len(k.store) {
+		k.pushedStores = make([]*pushedStore, len(k.store))
+	}
+	metricsStore := k.store[i].(*ksmstore.MetricsStore)
+	if p := k.pushedStores[i]; p != nil && p.generation == metricsStore.Generation() {
+		return p
+	}
+
+	// The metrics and the label joins metrics are read from the same snapshot to be consistent
+	snapshot := metricsStore.Snapshot()
+	metrics := translateFamilies(snapshot.Push(ksmstore.GetAllFamilies, ksmstore.GetAllMetrics))
+	k.pushedStores[i] = &pushedStore{
+		collector:    storeCollector(metricsStore),
+		generation:   snapshot.Generation(),
+		metrics:      metrics,
+		metricsToGet: k.labelJoinMetrics(metrics),
+	}
+	return k.pushedStores[i]
+}
+
+// joinedMetrics returns the metrics used by the label joins of the given stores
+// The stores not processed yet during a staggered run are joined with the metrics they pushed last,
+// they're only pushed if they never were
+func (k *KSMCheck) joinedMetrics(pushed []*pushedStore) []ksmstore.DDMetricsFam {
 	metricsToGet := []ksmstore.DDMetricsFam{}
-	for _, store := range k.store {
-		metrics := store.(*ksmstore.MetricsStore).Push(k.familyFilter, k.metricFilter)
-		for _, m := range metrics {
-			metricsToGet = append(metricsToGet, m...)
+	for i, p := range pushed {
+		if p == nil {
+			if p = k.pushedStores[i]; p == nil {
+				p = k.pushStore(i)
+			}
 		}
+		metricsToGet = append(metricsToGet, p.metricsToGet...)
 	}
+	return metricsToGet
+}
+
+// scrape returns the metrics of the kube_state_url endpoint, and the metrics used by the label joins
+func (k *KSMCheck) scrape() (*pushedStore, error) {
+	metrics, err := k.scraper.Scrape()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics = translateFamilies(metrics)
+	return &pushedStore{collector: scraperCollector, metrics: metrics, metricsToGet: k.labelJoinMetrics(metrics)}, nil
+}
+
+// labelJoinMetrics returns the metrics used by the label joins
+func (k *KSMCheck) labelJoinMetrics(metrics map[string][]ksmstore.DDMetricsFam) []ksmstore.DDMetricsFam {
+	var metricsToGet []ksmstore.DDMetricsFam
+	for _, families := range metrics {
+		for _, f := range families {
+			if !k.familyFilter(f) {
+				continue
+			}
+			joined := ksmstore.DDMetricsFam{Type: f.Type, Name: f.Name}
+			for _, m := range f.ListMetrics {
+				if k.metricFilter(m) {
+					joined.ListMetrics = append(joined.ListMetrics, m)
+				}
+			}
+			metricsToGet = append(metricsToGet, joined)
+		}
+	}
+	return metricsToGet
+}
 
-	for _, store := range k.store {
-		metrics := store.(*ksmstore.MetricsStore).Push(ksmstore.GetAllFamilies, ksmstore.GetAllMetrics)
-		k.processMetrics(sender, metrics, metricsToGet)
+// runLeaderElection returns apiserver.ErrNotLeader if the agent isn't the leader
+func (k *KSMCheck) runLeaderElection() error {
+	if !config.Datadog.GetBool("leader_election") {
+		return log.Error("Leader Election not enabled. The agent leader_election option is required by the leader_election option of the check.")
 	}
 
+	leaderEngine, err := leaderelection.GetLeaderEngine()
+	if err != nil {
+		k.Warn("Failed to instantiate the Leader Elector. Not running the kubernetes_state check.") //nolint:errcheck
+		return err
+	}
+
+	err = leaderEngine.EnsureLeaderElectionRuns()
+	if err != nil {
+		k.Warn("Leader Election process failed to start") //nolint:errcheck
+		return err
+	}
+
+	if !leaderEngine.IsLeader() {
+		log.Debugf("Leader is %q. %s will not submit the kubernetes_state metrics", leaderEngine.GetLeader(), leaderEngine.HolderIdentity)
+		return apiserver.ErrNotLeader
+	}
 	return nil
 }
 
+// endRun rotates the state kept between check runs
+func (k *KSMCheck) endRun() {
+	k.nodeConditions = k.currentNodeConditions
+	k.currentNodeConditions = make(map[string]map[string]string)
+	k.jobFailures = k.currentJobFailures
+	k.currentJobFailures = make(map[string]float64)
+	k.timestamps = k.currentTimestamps
+	k.currentTimestamps = make(map[string]float64)
+	k.oomKilledContainers = k.currentOOMKilledContainers
+	k.currentOOMKilledContainers = make(map[string]struct{})
+	k.evictedPods = k.currentEvictedPods
+	k.currentEvictedPods = make(map[string]struct{})
+	k.podRestarts = k.currentPodRestarts
+	k.currentPodRestarts = make(map[string]*podRestartState)
+	k.resetEventBudget(time.Now())
+	k.sentServiceChecks = make(map[string]struct{})
+	k.hasRun = true
+}
+
 // processMetrics attaches tags and forwards metrics to the aggregator
+// The processing time of each metric family is kept for the telemetry
 func (k *KSMCheck) processMetrics(sender aggregator.Sender, metrics map[string][]ksmstore.DDMetricsFam, metricsToGet []ksmstore.DDMetricsFam) {
 	for _, metricsList := range metrics {
 		for _, metricFamily := range metricsList {
-			if metadataMetricsRegex.MatchString(metricFamily.Name) {
-				// metadata metrics are only used by the check for label joins
-				// they shouldn't be forwarded to Datadog
-				continue
-			}
-			if transform, found := metricTransformers[metricFamily.Name]; found {
-				for _, m := range metricFamily.ListMetrics {
-					// TODO: implement metric transformer functions
-					transform(sender, metricFamily.Name, m, k.joinLabels(m.Labels, metricsToGet))
-				}
-				continue
-			}
-			for _, m := range metricFamily.ListMetrics {
-				sender.Gauge(formatMetricName(metricFamily.Name), m.Val, "", k.joinLabels(m.Labels, metricsToGet))
-			}
+			start := time.Now()
+			k.processFamily(sender, metricFamily, metricsToGet)
+			k.familyDurations[metricFamily.Name] += time.Since(start)
+		}
+	}
+}
+
+// processFamily attaches tags and forwards the metrics of a family to the aggregator
+func (k *KSMCheck) processFamily(sender aggregator.Sender, metricFamily ksmstore.DDMetricsFam, metricsToGet []ksmstore.DDMetricsFam) {
+	// metadata metrics can have a transformer to generate dedicated metrics
+	if transform, found := metricTransformers[metricFamily.Name]; found {
+		for _, m := range metricFamily.ListMetrics {
+			transform(k, sender, metricFamily.Name, m, k.hostname(metricFamily.Name, m.Labels), k.joinLabels(m.Labels, metricsToGet))
+		}
+		return
+	}
+	if metadataMetricsRegex.MatchString(metricFamily.Name) {
+		// metadata metrics are only used by the check for label joins
+		// they shouldn't be forwarded to Datadog
+		return
+	}
+	_, mapped := metricNamesMapper[metricFamily.Name]
+	if !mapped {
+		_, mapped = k.customResourceMetricNames[metricFamily.Name]
+	}
+	if !mapped {
+		_, mapped = experimentalMetricNames[metricFamily.Name]
+	}
+	for _, m := range metricFamily.ListMetrics {
+		if !mapped {
+			k.unprocessed(metricFamily.Name, unprocessedUnmapped, "")
 		}
+		k.submitGuardedGauge(sender, metricFamily.Name, k.formatMetricName(metricFamily.Name), m.Val, m.Timestamp, k.hostname(metricFamily.Name, m.Labels), k.joinLabels(m.Labels, metricsToGet))
+	}
+}
+
+// highCardinalityLabels contains the pod-level labels removed from the metrics submitted as histograms
+var highCardinalityLabels = []string{"pod", "uid", "container_id", "pod_ip"}
+
+// submitGauge submits a gauge, or a histogram without the pod-level tags if configured in histogram_metrics
+func (k *KSMCheck) submitGauge(sender aggregator.Sender, name string, value float64, hostname string, tags []string) {
+	if _, found := k.histogramMetrics[name]; !found {
+		sender.Gauge(name, value, hostname, tags)
+		return
+	}
+	for _, label := range highCardinalityLabels {
+		tags = removeTag(tags, k.tagKey(label))
+	}
+	sender.Histogram(name, value, hostname, tags)
+}
+
+// submitGaugeWithTimestamp submits a gauge at the given timestamp if honor_timestamps is enabled
+// Only the first point after a transition is backdated, no further than maxTimestampAge,
+// the next points of the series are submitted at the collection time.
+// The gauges without timestamp and the histograms are submitted at the collection time
+func (k *KSMCheck) submitGaugeWithTimestamp(sender aggregator.Sender, name string, value, timestamp float64, hostname string, tags []string) {
+	if _, found := k.histogramMetrics[name]; found || !k.instance.HonorTimestamps || timestamp <= 0 {
+		k.submitGauge(sender, name, value, hostname, tags)
+		return
+	}
+
+	key := name + "|" + contextKey(hostname, tags)
+	k.currentTimestamps[key] = timestamp
+	if last, found := k.timestamps[key]; found && last == timestamp {
+		k.submitGauge(sender, name, value, hostname, tags)
+		return
+	}
+	if oldest := float64(time.Now().Add(-maxTimestampAge).Unix()); timestamp < oldest {
+		timestamp = oldest
 	}
+	sender.GaugeWithTimestamp(name, value, hostname, tags, timestamp)
+}
+
+// hostname returns the hostname to use to submit a metric
+// Node metrics are attached to the corresponding host, unless disabled in the configuration
+func (k *KSMCheck) hostname(name string, labels map[string]string) string {
+	if k.instance.DisableNodeHostname || !strings.HasPrefix(name, "kube_node_") {
+		return ""
+	}
+	node, found := labels["node"]
+	if !found {
+		return ""
+	}
+	return k.nodeHostname(node)
+}
+
+// nodeHostname returns the hostname of a node, or an empty string if node hostnames are disabled or the node is unknown
+func (k *KSMCheck) nodeHostname(node string) string {
+	if k.instance.DisableNodeHostname || node == "" {
+		return ""
+	}
+	if k.clusterName != "" {
+		// Adding the clusterName to the node name, consistently with the agent hostname
+		return node + "-" + k.clusterName
+	}
+	return node
 }
 
 // joinLabels converts metric labels into datatog tags and applies the label joins config
+// The metrics joined with kube_node_labels also get the kube_node_role, os and arch tags of the node,
+// and the metrics of the pods and containers get their tagger tags if tagger_tags is enabled
 func (k *KSMCheck) joinLabels(labels map[string]string, metricsToGet []ksmstore.DDMetricsFam) (tags []string) {
 	for key, value := range labels {
-		tags = append(tags, k.buildTag(key, value))
+		tags = append(tags, k.buildTags(key, value)...)
+	}
+
+	entity := ""
+	if k.instance.TaggerTags {
+		entity = taggerEntity(labels)
 	}
 
 	// apply label joins
@@ -253,12 +1081,24 @@ func (k *KSMCheck) joinLabels(labels map[string]string, metricsToGet []ksmstore.
 			continue
 		}
 		for _, m := range mFamily.ListMetrics {
-			if isMatching(config, labels, m.Labels) {
-				tags = append(tags, k.getJoinedTags(config, m.Labels)...)
+			if !isMatching(config, labels, m.Labels) {
+				continue
+			}
+			tags = append(tags, k.getJoinedTags(config, m.Labels)...)
+			if mFamily.Name == nodeLabelsFamily {
+				tags = append(tags, nodeLabelTags(m.Labels)...)
+			}
+			if k.instance.TaggerTags && entity == "" && mFamily.Name == podInfoFamily {
+				// The uid of the pods is only reported by kube_pod_info
+				entity = kubelet.PodUIDToTaggerEntityName(m.Labels["uid"])
 			}
 		}
 	}
 
+	if entity != "" {
+		tags = append(tags, k.taggerTags(entity)...)
+	}
+
 	return tags
 }
 
@@ -270,6 +1110,20 @@ func (k *KSMCheck) familyFilter(f ksmstore.DDMetricsFam) bool {
 	return found
 }
 
+// storeFamilyFilter is a metric families filter used by the stores
+// It drops the metadata metrics that are neither used by a label join nor by a transformer,
+// the check would skip them anyway
+func (k *KSMCheck) storeFamilyFilter(name string) bool {
+	if !metadataMetricsRegex.MatchString(name) {
+		return true
+	}
+	if _, found := metricTransformers[name]; found {
+		return true
+	}
+	_, found := k.instance.LabelJoins[name]
+	return found
+}
+
 // metricFilter is a metrics filter for label joins
 // It ensures that we only get metadata-only metrics for label joins
 // metadata-only metrics that are used for label joins are always equal to 1
@@ -307,20 +1161,65 @@ func (k *KSMCheck) buildTag(key, value string) string {
 	return fmt.Sprintf("%s:%s", key, value)
 }
 
+// tagKey returns the tag key of a label according to the LabelsMapper config
+func (k *KSMCheck) tagKey(label string) string {
+	if key, found := k.instance.LabelsMapper[label]; found {
+		return key
+	}
+	return label
+}
+
+// buildTags returns the tags of a label using buildTag
+// The jobs created by a cronjob are reported with the cronjob name and an additional cronjob tag,
+// the container images are split into image name, short image and image tag
+func (k *KSMCheck) buildTags(key, value string) []string {
+	switch key {
+	case "job_name":
+		if cronjob, isCronJob := normalizeJobName(value); isCronJob {
+			return []string{k.buildTag(key, cronjob), k.buildTag("cronjob", cronjob)}
+		}
+	case "image":
+		if tags, ok := k.imageTags(value); ok {
+			return tags
+		}
+	}
+	return []string{k.buildTag(key, value)}
+}
+
+// imageTags returns the image_name, short_image and image_tag tags of a container image,
+// consistently with the tags of the container metrics set by the tagger
+func (k *KSMCheck) imageTags(image string) ([]string, bool) {
+	imageName, shortImage, imageTag, err := containers.SplitImageName(image)
+	if err != nil {
+		log.Debugf("Cannot split %s: %s", image, err)
+		return nil, false
+	}
+	if imageTag == "" {
+		// k8s default to latest if tag is omitted
+		imageTag = "latest"
+	}
+	return []string{k.buildTag("image", imageName), "short_image:" + shortImage, "image_tag:" + imageTag}, true
+}
+
 // getJoinedTags applies the label joins config, it gets labels from a targeted metric labels
 func (k *KSMCheck) getJoinedTags(config *JoinsConfig, srcLabels map[string]string) []string {
 	tags := []string{}
 	if config.GetAllLabels {
 		for key, value := range srcLabels {
-			tags = append(tags, k.buildTag(key, value))
+			tags = append(tags, k.buildTags(config.joinedLabel(key), value)...)
 		}
-		return tags
+		return append(tags, k.ownerTags(srcLabels)...)
 	}
+	getOwner := false
 	for _, key := range config.LabelsToGet {
 		if value, found := srcLabels[key]; found {
-			tags = append(tags, k.buildTag(key, value))
+			tags = append(tags, k.buildTags(config.joinedLabel(key), value)...)
+			getOwner = getOwner || key == "created_by_kind" || key == "owner_kind"
 		}
 	}
+	if getOwner {
+		tags = append(tags, k.ownerTags(srcLabels)...)
+	}
 	return tags
 }
 
@@ -355,17 +1254,101 @@ func KubeStateMetricsFactory() check.Check {
 }
 
 func newKSMCheck(base core.CheckBase, instance *KSMConfig) *KSMCheck {
+	if instance.MetricPrefix == "" {
+		instance.MetricPrefix = ksmMetricPrefix
+	}
+	if instance.RolloutStuckTimeout == 0 {
+		instance.RolloutStuckTimeout = defaultRolloutStuckTimeout
+	}
+	if instance.DaemonSetMisscheduledRuns == 0 {
+		instance.DaemonSetMisscheduledRuns = defaultDaemonSetMisscheduledRuns
+	}
+	if instance.ReplicaMismatchGracePeriod == 0 {
+		instance.ReplicaMismatchGracePeriod = defaultReplicaMismatchGracePeriod
+	}
+	if instance.NamespaceTerminatingTimeout == 0 {
+		instance.NamespaceTerminatingTimeout = defaultNamespaceTerminatingTimeout
+	}
+	if instance.PersistentVolumePendingTimeout == 0 {
+		instance.PersistentVolumePendingTimeout = defaultPersistentVolumePendingTimeout
+	}
+	if instance.MaxEventsPerRun == 0 {
+		instance.MaxEventsPerRun = defaultMaxEventsPerRun
+	}
+	if instance.EventDedupWindow == 0 {
+		instance.EventDedupWindow = defaultEventDedupWindow
+	}
+	if instance.ChangedSeriesMaxAge == 0 {
+		instance.ChangedSeriesMaxAge = defaultChangedSeriesMaxAge
+	}
+	if instance.RestartBurstThreshold == 0 {
+		instance.RestartBurstThreshold = defaultRestartBurstThreshold
+	}
+	if instance.RestartBurstWindow == 0 {
+		instance.RestartBurstWindow = defaultRestartBurstWindow
+	}
+	if instance.ResourceQuotaWarningThreshold == 0 {
+		instance.ResourceQuotaWarningThreshold = defaultResourceQuotaWarningThreshold
+	}
+	if instance.ResourceQuotaCriticalThreshold == 0 {
+		instance.ResourceQuotaCriticalThreshold = defaultResourceQuotaCriticalThreshold
+	}
 	return &KSMCheck{
-		CheckBase: base,
-		instance:  instance,
+		CheckBase:                  base,
+		instance:                   instance,
+		nodeConditions:             make(map[string]map[string]string),
+		currentNodeConditions:      make(map[string]map[string]string),
+		jobFailures:                make(map[string]float64),
+		currentJobFailures:         make(map[string]float64),
+		timestamps:                 make(map[string]float64),
+		currentTimestamps:          make(map[string]float64),
+		oomKilledContainers:        make(map[string]struct{}),
+		currentOOMKilledContainers: make(map[string]struct{}),
+		evictedPods:                make(map[string]struct{}),
+		currentEvictedPods:         make(map[string]struct{}),
+		podRestarts:                make(map[string]*podRestartState),
+		currentPodRestarts:         make(map[string]*podRestartState),
+		sentEvents:                 make(map[string]time.Time),
+		droppedEvents:              make(map[string]float64),
+		sentServiceChecks:          make(map[string]struct{}),
+		unprocessedMetrics:         make(map[unprocessedMetric]float64),
+		ksmVersion:                 ksmVersion(),
+		droppedMetrics:             make(map[droppedMetric]int),
+		customResourceMetricNames:  make(map[string]string),
+		histogramMetrics:           make(map[string]struct{}),
+		changedSeriesMetrics:       make(map[string]struct{}),
+		submittedSeries:            make(map[string]submittedSeries),
+		workloads:                  make(map[string]map[string]*workloadState),
+		cronJobs:                   make(map[string]*cronJobState),
+		namespaces:                 make(map[string]*namespaceState),
+		persistentVolumes:          make(map[string]*persistentVolumeState),
+		nodesUnderPressure:         make(map[string]float64),
+		resourceQuotas:             make(map[string]*resourceQuotaUsage),
+		familyContexts:             make(map[string]map[string]bool),
+		overflowValues:             make(map[string]float64),
+		overflowContexts:           make(map[string]map[string]struct{}),
+		familyDurations:            make(map[string]time.Duration),
+		allowedWaitingReasons:      instance.WaitingReasons.allowedReasons(defaultWaitingReasons),
+		allowedTerminatedReasons:   instance.TerminatedReasons.allowedReasons(defaultTerminatedReasons),
 	}
 }
 
 // formatMetricName converts the default KSM metric names into Datadog metric names
-func formatMetricName(name string) string {
+func (k *KSMCheck) formatMetricName(name string) string {
 	if ddName, found := metricNamesMapper[name]; found {
-		return ksmMetricPrefix + ddName
+		return k.metricName(ddName)
+	}
+	if ddName, found := k.customResourceMetricNames[name]; found {
+		return k.metricName(ddName)
+	}
+	if ddName, found := experimentalMetricNames[name]; found {
+		return k.metricName(ddName)
 	}
 	log.Tracef("KSM metric '%s' is not found in the metric names mapper", name)
-	return ksmMetricPrefix + name
+	return k.metricName(name)
+}
+
+// metricName prepends the configured metric prefix to a Datadog metric name
+func (k *KSMCheck) metricName(name string) string {
+	return k.instance.MetricPrefix + name
 }
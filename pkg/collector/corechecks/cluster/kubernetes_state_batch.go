@@ -0,0 +1,91 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+)
+
+// metricBatchSize is the number of metric points submitted to the aggregator at once
+const metricBatchSize = 1000
+
+// batchingSender buffers the metric points submitted through it and submits them by batches with SubmitBatch,
+// so the aggregator lock isn't taken for each point on large clusters.
+// The service checks and events are sent directly, flush must be called before committing the points.
+type batchingSender struct {
+	aggregator.Sender
+	batch []*metrics.MetricSample
+}
+
+// newBatchingSender returns a batching sender submitting the points to the given sender
+func newBatchingSender(sender aggregator.Sender) *batchingSender {
+	return &batchingSender{Sender: sender, batch: make([]*metrics.MetricSample, 0, metricBatchSize)}
+}
+
+func (s *batchingSender) add(metric string, value float64, hostname string, tags []string, mType metrics.MetricType, timestamp float64) {
+	s.batch = append(s.batch, &metrics.MetricSample{
+		Name:       metric,
+		Value:      value,
+		Mtype:      mType,
+		Tags:       tags,
+		Host:       hostname,
+		SampleRate: 1,
+		Timestamp:  timestamp,
+	})
+	if len(s.batch) >= metricBatchSize {
+		s.flush()
+	}
+}
+
+// flush submits the buffered points
+func (s *batchingSender) flush() {
+	if len(s.batch) == 0 {
+		return
+	}
+	s.Sender.SubmitBatch(s.batch)
+	// The submitted samples belong to the aggregator now, the slice can't be reused
+	s.batch = make([]*metrics.MetricSample, 0, metricBatchSize)
+}
+
+func (s *batchingSender) Gauge(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.GaugeType, 0)
+}
+
+func (s *batchingSender) GaugeWithTimestamp(metric string, value float64, hostname string, tags []string, timestamp float64) {
+	s.add(metric, value, hostname, tags, metrics.GaugeWithTimestampType, timestamp)
+}
+
+func (s *batchingSender) Rate(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.RateType, 0)
+}
+
+func (s *batchingSender) Count(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.CountType, 0)
+}
+
+func (s *batchingSender) MonotonicCount(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.MonotonicCountType, 0)
+}
+
+func (s *batchingSender) Counter(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.CounterType, 0)
+}
+
+func (s *batchingSender) Histogram(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.HistogramType, 0)
+}
+
+func (s *batchingSender) Historate(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.HistorateType, 0)
+}
+
+func (s *batchingSender) SubmitBatch(samples []*metrics.MetricSample) {
+	s.flush()
+	s.Sender.SubmitBatch(samples)
+}
@@ -0,0 +1,70 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// batchRecorder records the batches submitted through it
+type batchRecorder struct {
+	aggregator.Sender
+	batches [][]*metrics.MetricSample
+}
+
+func (s *batchRecorder) SubmitBatch(samples []*metrics.MetricSample) {
+	s.batches = append(s.batches, samples)
+	s.Sender.SubmitBatch(samples)
+}
+
+func TestBatchingSender(t *testing.T) {
+	s := mocksender.NewMockSender("ksm")
+	s.SetupAcceptAll()
+	recorder := &batchRecorder{Sender: s}
+	batcher := newBatchingSender(recorder)
+
+	tags := []string{"kube_namespace:default"}
+	batcher.Gauge("kubernetes_state.foo", 1, "", tags)
+	batcher.GaugeWithTimestamp("kubernetes_state.bar", 2, "", tags, 1600000000)
+	batcher.Count("kubernetes_state.baz", 3, "node1", tags)
+	batcher.ServiceCheck("kubernetes_state.check", metrics.ServiceCheckOK, "", tags, "")
+
+	// The service checks are sent directly, the points are buffered until flushed
+	s.AssertNumberOfCalls(t, "ServiceCheck", 1)
+	s.AssertNumberOfCalls(t, "Gauge", 0)
+	assert.Empty(t, recorder.batches)
+
+	batcher.flush()
+	require.Len(t, recorder.batches, 1)
+	assert.Len(t, recorder.batches[0], 3)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.foo", 1, "", tags)
+	s.AssertMetricWithTimestamp(t, "GaugeWithTimestamp", "kubernetes_state.bar", 2, "", tags, 1600000000)
+	s.AssertMetric(t, "Count", "kubernetes_state.baz", 3, "node1", tags)
+
+	// Nothing left to submit
+	batcher.flush()
+	assert.Len(t, recorder.batches, 1)
+
+	// Full batches are submitted without waiting for the flush
+	for i := 0; i < metricBatchSize+1; i++ {
+		batcher.Gauge("kubernetes_state.foo", float64(i), "", tags)
+	}
+	require.Len(t, recorder.batches, 2)
+	assert.Len(t, recorder.batches[1], metricBatchSize)
+	batcher.flush()
+	require.Len(t, recorder.batches, 3)
+	assert.Len(t, recorder.batches[2], 1)
+	s.AssertNumberOfCalls(t, "Gauge", metricBatchSize+2)
+}
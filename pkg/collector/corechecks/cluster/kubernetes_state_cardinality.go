@@ -0,0 +1,88 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/telemetry"
+)
+
+// overflowTag is the tag of the series aggregating the contexts over max_contexts_per_metric
+const overflowTag = "overflow:true"
+
+var tlmOverflowContexts = telemetry.NewCounter("kubernetes_state", "overflow_contexts",
+	[]string{"metric_name"}, "Number of KSM metric contexts aggregated into the overflow series")
+
+// submitGuardedGauge submits a gauge of a KSM metric family unless the family already reached max_contexts_per_metric
+// unique contexts. The contexts of all the metrics submitted for a family are counted together, and the admitted contexts
+// are kept between runs until they stop being submitted, so that the same series are submitted from one run to the next.
+// The values of the contexts over the limit are summed into a single series per metric tagged overflow:true, sent at the end of the run
+func (k *KSMCheck) submitGuardedGauge(s aggregator.Sender, family, name string, val, timestamp float64, hostname string, tags []string) {
+	if k.instance.MaxContextsPerMetric <= 0 {
+		k.submitGaugeWithTimestamp(s, name, val, timestamp, hostname, tags)
+		return
+	}
+
+	contexts, found := k.familyContexts[family]
+	if !found {
+		contexts = make(map[string]bool)
+		k.familyContexts[family] = contexts
+	}
+	key := name + "|" + contextKey(hostname, tags)
+	if _, admitted := contexts[key]; !admitted && len(contexts) >= k.instance.MaxContextsPerMetric {
+		overflowed, found := k.overflowContexts[family]
+		if !found {
+			overflowed = make(map[string]struct{})
+			k.overflowContexts[family] = overflowed
+		}
+		if _, found := overflowed[key]; !found {
+			overflowed[key] = struct{}{}
+			tlmOverflowContexts.Inc(family)
+		}
+		k.overflowValues[name] += val
+		return
+	}
+	contexts[key] = true
+	k.submitGaugeWithTimestamp(s, name, val, timestamp, hostname, tags)
+}
+
+// submitOverflow sends the overflow series and the overflow telemetry of the run
+// The admitted contexts that weren't submitted during the run are forgotten, freeing their place for new contexts
+func (k *KSMCheck) submitOverflow(s aggregator.Sender) {
+	for name, val := range k.overflowValues {
+		k.submitGauge(s, name, val, "", []string{overflowTag})
+	}
+	for family, overflowed := range k.overflowContexts {
+		s.Count(k.metricName("telemetry.overflow_contexts"), float64(len(overflowed)), "", []string{"metric_name:" + family})
+	}
+	for family, contexts := range k.familyContexts {
+		for key, seen := range contexts {
+			if !seen {
+				delete(contexts, key)
+				continue
+			}
+			contexts[key] = false
+		}
+		if len(contexts) == 0 {
+			delete(k.familyContexts, family)
+		}
+	}
+	k.overflowValues = make(map[string]float64)
+	k.overflowContexts = make(map[string]map[string]struct{})
+}
+
+// contextKey returns a key identifying the series of a metric, independent of the tags order
+func contextKey(hostname string, tags []string) string {
+	sorted := make([]string, len(tags))
+	copy(sorted, tags)
+	sort.Strings(sorted)
+	return hostname + "|" + strings.Join(sorted, ",")
+}
@@ -0,0 +1,185 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKSMCheck_submitGuardedGauge(t *testing.T) {
+	type gauge struct {
+		family string
+		name   string
+		val    float64
+		tags   []string
+	}
+	tests := []struct {
+		name              string
+		config            *KSMConfig
+		gauges            []gauge
+		expected          []gauge
+		expectedOverflow  []gauge
+		expectedTelemetry map[string]float64
+	}{
+		{
+			name:   "disabled",
+			config: &KSMConfig{},
+			gauges: []gauge{
+				{"kube_foo", "kubernetes_state.foo", 1, []string{"pod:a"}},
+				{"kube_foo", "kubernetes_state.foo", 2, []string{"pod:b"}},
+				{"kube_foo", "kubernetes_state.foo", 3, []string{"pod:c"}},
+			},
+			expected: []gauge{
+				{"kube_foo", "kubernetes_state.foo", 1, []string{"pod:a"}},
+				{"kube_foo", "kubernetes_state.foo", 2, []string{"pod:b"}},
+				{"kube_foo", "kubernetes_state.foo", 3, []string{"pod:c"}},
+			},
+		},
+		{
+			name:   "under the limit",
+			config: &KSMConfig{MaxContextsPerMetric: 3},
+			gauges: []gauge{
+				{"kube_foo", "kubernetes_state.foo", 1, []string{"pod:a"}},
+				{"kube_foo", "kubernetes_state.foo", 2, []string{"pod:b"}},
+				{"kube_foo", "kubernetes_state.foo", 3, []string{"pod:c"}},
+			},
+			expected: []gauge{
+				{"kube_foo", "kubernetes_state.foo", 1, []string{"pod:a"}},
+				{"kube_foo", "kubernetes_state.foo", 2, []string{"pod:b"}},
+				{"kube_foo", "kubernetes_state.foo", 3, []string{"pod:c"}},
+			},
+		},
+		{
+			name:   "over the limit",
+			config: &KSMConfig{MaxContextsPerMetric: 1},
+			gauges: []gauge{
+				{"kube_foo", "kubernetes_state.foo", 1, []string{"pod:a", "ns:x"}},
+				{"kube_foo", "kubernetes_state.foo", 2, []string{"pod:b"}},
+				{"kube_foo", "kubernetes_state.foo", 3, []string{"pod:c"}},
+				{"kube_foo", "kubernetes_state.foo", 4, []string{"ns:x", "pod:a"}},
+			},
+			expected: []gauge{
+				{"kube_foo", "kubernetes_state.foo", 1, []string{"pod:a", "ns:x"}},
+				{"kube_foo", "kubernetes_state.foo", 4, []string{"ns:x", "pod:a"}},
+			},
+			expectedOverflow:  []gauge{{"kube_foo", "kubernetes_state.foo", 5, []string{overflowTag}}},
+			expectedTelemetry: map[string]float64{"kube_foo": 2},
+		},
+		{
+			name:   "several points of an overflowed context",
+			config: &KSMConfig{MaxContextsPerMetric: 1},
+			gauges: []gauge{
+				{"kube_foo", "kubernetes_state.foo", 1, []string{"pod:a"}},
+				{"kube_foo", "kubernetes_state.foo", 2, []string{"pod:b"}},
+				{"kube_foo", "kubernetes_state.foo", 3, []string{"pod:b"}},
+			},
+			expected:          []gauge{{"kube_foo", "kubernetes_state.foo", 1, []string{"pod:a"}}},
+			expectedOverflow:  []gauge{{"kube_foo", "kubernetes_state.foo", 5, []string{overflowTag}}},
+			expectedTelemetry: map[string]float64{"kube_foo": 1},
+		},
+		{
+			name:   "metrics of the same family",
+			config: &KSMConfig{MaxContextsPerMetric: 2},
+			gauges: []gauge{
+				{"kube_foo", "kubernetes_state.foo.a", 1, []string{"pod:a"}},
+				{"kube_foo", "kubernetes_state.foo.b", 2, []string{"pod:a"}},
+				{"kube_foo", "kubernetes_state.foo.a", 3, []string{"pod:b"}},
+				{"kube_foo", "kubernetes_state.foo.b", 4, []string{"pod:b"}},
+			},
+			expected: []gauge{
+				{"kube_foo", "kubernetes_state.foo.a", 1, []string{"pod:a"}},
+				{"kube_foo", "kubernetes_state.foo.b", 2, []string{"pod:a"}},
+			},
+			expectedOverflow: []gauge{
+				{"kube_foo", "kubernetes_state.foo.a", 3, []string{overflowTag}},
+				{"kube_foo", "kubernetes_state.foo.b", 4, []string{overflowTag}},
+			},
+			expectedTelemetry: map[string]float64{"kube_foo": 2},
+		},
+		{
+			name:   "metric shared by several families",
+			config: &KSMConfig{MaxContextsPerMetric: 1},
+			gauges: []gauge{
+				{"kube_foo", "kubernetes_state.foo", 1, []string{"pod:a"}},
+				{"kube_bar", "kubernetes_state.foo", 2, []string{"pod:b"}},
+			},
+			expected: []gauge{
+				{"kube_foo", "kubernetes_state.foo", 1, []string{"pod:a"}},
+				{"kube_bar", "kubernetes_state.foo", 2, []string{"pod:b"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), tt.config)
+			s := mocksender.NewMockSender(k.ID())
+			s.SetupAcceptAll()
+
+			for _, g := range tt.gauges {
+				k.submitGuardedGauge(s, g.family, g.name, g.val, 0, "", g.tags)
+			}
+			k.submitOverflow(s)
+
+			for _, g := range append(tt.expected, tt.expectedOverflow...) {
+				s.AssertMetric(t, "Gauge", g.name, g.val, "", g.tags)
+			}
+			for family, contexts := range tt.expectedTelemetry {
+				s.AssertMetric(t, "Count", "kubernetes_state.telemetry.overflow_contexts", contexts, "", []string{"metric_name:" + family})
+			}
+			s.AssertNumberOfCalls(t, "Gauge", len(tt.expected)+len(tt.expectedOverflow))
+			s.AssertNumberOfCalls(t, "Count", len(tt.expectedTelemetry))
+			assert.Len(t, k.overflowValues, 0)
+			assert.Len(t, k.overflowContexts, 0)
+		})
+	}
+}
+
+func TestKSMCheck_submitGuardedGaugeRuns(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{MaxContextsPerMetric: 2})
+	run := func(pods ...string) *mocksender.MockSender {
+		s := mocksender.NewMockSender(k.ID())
+		s.SetupAcceptAll()
+		for _, pod := range pods {
+			k.submitGuardedGauge(s, "kube_foo", "kubernetes_state.foo", 1, 0, "", []string{"pod:" + pod})
+		}
+		k.submitOverflow(s)
+		return s
+	}
+
+	run("a", "b")
+
+	// The contexts admitted during the previous runs are kept, whatever the submission order
+	s := run("c", "b", "a")
+	s.AssertMetric(t, "Gauge", "kubernetes_state.foo", 1, "", []string{"pod:a"})
+	s.AssertMetric(t, "Gauge", "kubernetes_state.foo", 1, "", []string{"pod:b"})
+	s.AssertMetric(t, "Gauge", "kubernetes_state.foo", 1, "", []string{overflowTag})
+	s.AssertNumberOfCalls(t, "Gauge", 3)
+
+	// The contexts that aren't submitted anymore are forgotten at the end of the run
+	run("b", "c")
+	assert.Equal(t, map[string]bool{"kubernetes_state.foo|" + contextKey("", []string{"pod:b"}): false}, k.familyContexts["kube_foo"])
+	s = run("d", "c", "b")
+	s.AssertMetric(t, "Gauge", "kubernetes_state.foo", 1, "", []string{"pod:b"})
+	s.AssertMetric(t, "Gauge", "kubernetes_state.foo", 1, "", []string{"pod:d"})
+	s.AssertMetric(t, "Gauge", "kubernetes_state.foo", 1, "", []string{overflowTag})
+	s.AssertNumberOfCalls(t, "Gauge", 3)
+
+	// The families without contexts are forgotten
+	run()
+	assert.Empty(t, k.familyContexts)
+}
+
+func Test_contextKey(t *testing.T) {
+	assert.Equal(t, contextKey("host", []string{"b:2", "a:1"}), contextKey("host", []string{"a:1", "b:2"}))
+	assert.NotEqual(t, contextKey("host", []string{"a:1"}), contextKey("", []string{"a:1"}))
+}
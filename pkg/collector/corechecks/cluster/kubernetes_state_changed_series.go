@@ -0,0 +1,82 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+)
+
+// defaultChangedSeriesMaxAge is the default duration after which an unchanged series is submitted again, in seconds
+const defaultChangedSeriesMaxAge = 300
+
+// submittedSeries is the last value submitted for a series of the changed_series_metrics and when it was submitted
+type submittedSeries struct {
+	value float64
+	sent  time.Time
+}
+
+// changedSeriesSender skips the gauges of the changed_series_metrics whose value didn't change since they were submitted
+// The unchanged series are submitted again after changed_series_max_age, the other metrics are submitted as is
+type changedSeriesSender struct {
+	aggregator.Sender
+	k   *KSMCheck
+	now time.Time
+	// current contains the series seen during the run, it replaces the submitted series of the check at the end of the run
+	current map[string]submittedSeries
+	// skipped is the number of points skipped during the run
+	skipped int
+}
+
+// newChangedSeriesSender returns a sender skipping the unchanged series of the run starting at now
+func (k *KSMCheck) newChangedSeriesSender(sender aggregator.Sender, now time.Time) *changedSeriesSender {
+	return &changedSeriesSender{
+		Sender:  sender,
+		k:       k,
+		now:     now,
+		current: make(map[string]submittedSeries, len(k.submittedSeries)),
+	}
+}
+
+// skip returns whether the point can be skipped, and keeps the series for the next run
+func (s *changedSeriesSender) skip(metric string, value float64, hostname string, tags []string) bool {
+	if _, found := s.k.changedSeriesMetrics[metric]; !found {
+		return false
+	}
+	key := metric + "|" + contextKey(hostname, tags)
+	last, found := s.k.submittedSeries[key]
+	if found && last.value == value && s.now.Sub(last.sent) < time.Duration(s.k.instance.ChangedSeriesMaxAge)*time.Second {
+		s.current[key] = last
+		s.skipped++
+		return true
+	}
+	s.current[key] = submittedSeries{value: value, sent: s.now}
+	return false
+}
+
+func (s *changedSeriesSender) Gauge(metric string, value float64, hostname string, tags []string) {
+	if s.skip(metric, value, hostname, tags) {
+		return
+	}
+	s.Sender.Gauge(metric, value, hostname, tags)
+}
+
+func (s *changedSeriesSender) GaugeWithTimestamp(metric string, value float64, hostname string, tags []string, timestamp float64) {
+	if s.skip(metric, value, hostname, tags) {
+		return
+	}
+	s.Sender.GaugeWithTimestamp(metric, value, hostname, tags, timestamp)
+}
+
+// endChangedSeries keeps the series seen during the run, the deleted series are forgotten,
+// and sends the number of points skipped
+func (k *KSMCheck) endChangedSeries(s aggregator.Sender, changed *changedSeriesSender) {
+	k.submittedSeries = changed.current
+	s.Gauge(k.metricName("telemetry.points_unchanged"), float64(changed.skipped), "", nil)
+}
@@ -0,0 +1,61 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangedSeriesSender(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{ChangedSeriesMaxAge: 60})
+	k.changedSeriesMetrics["kubernetes_state.node.by_condition"] = struct{}{}
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	ready := []string{"node:foo", "condition:Ready", "status:true"}
+	run := func(now time.Time, ready float64, tags []string) *changedSeriesSender {
+		changed := k.newChangedSeriesSender(s, now)
+		changed.Gauge("kubernetes_state.node.by_condition", ready, "", tags)
+		changed.Gauge("kubernetes_state.node.count", 1, "", nil)
+		k.endChangedSeries(s, changed)
+		return changed
+	}
+	start := time.Now()
+
+	// The series are submitted during the first run
+	run(start, 1, ready)
+	s.AssertNumberOfCalls(t, "Gauge", 3)
+
+	// The unchanged series are skipped, the other metrics are submitted
+	changed := run(start.Add(15*time.Second), 1, []string{"status:true", "condition:Ready", "node:foo"})
+	assert.Equal(t, 1, changed.skipped)
+	s.AssertNumberOfCalls(t, "Gauge", 5)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.telemetry.points_unchanged", 1, "", nil)
+
+	// The changed series are submitted
+	run(start.Add(30*time.Second), 0, ready)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.node.by_condition", 0, "", ready)
+	s.AssertNumberOfCalls(t, "Gauge", 8)
+
+	// The unchanged series are submitted again after the max age
+	run(start.Add(45*time.Second), 0, ready)
+	s.AssertNumberOfCalls(t, "Gauge", 10)
+	run(start.Add(95*time.Second), 0, ready)
+	s.AssertNumberOfCalls(t, "Gauge", 13)
+
+	// The series not seen during a run are forgotten
+	run(start.Add(100*time.Second), 0, []string{"node:bar", "condition:Ready", "status:true"})
+	assert.Len(t, k.submittedSeries, 1)
+	assert.NotContains(t, k.submittedSeries, "kubernetes_state.node.by_condition|"+contextKey("", ready))
+}
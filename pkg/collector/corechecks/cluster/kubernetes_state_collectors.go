@@ -0,0 +1,170 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// collectorSyncTimeout is the duration after which a store that never received the list of its objects is reported as failed
+// The list is retried by the reflector meanwhile, e.g. while the check isn't allowed to list a custom resource
+const collectorSyncTimeout = time.Minute
+
+// The reasons a collector failed during a run
+const (
+	collectorNotSynced       = "not_synced"
+	collectorListWatchFailed = "list_watch_failed"
+	collectorProcessingPanic = "processing_panic"
+	collectorScrapeFailed    = "scrape_failed"
+)
+
+// scraperCollector is the collector name reported when the kube_state_url endpoint can't be scraped
+const scraperCollector = "kube_state_url"
+
+// storeCollector returns the name of the collector of a store, e.g. v1.Pod
+func storeCollector(metricsStore *ksmstore.MetricsStore) string {
+	return strings.TrimPrefix(metricsStore.MetricsType, "*")
+}
+
+// processStore processes the metrics pushed by a store
+// A panic is recovered and returned as an error so that the other stores are still processed,
+// the points already submitted for the store are dropped
+func (k *KSMCheck) processStore(sender aggregator.Sender, p *pushedStore, metricsToGet []ksmstore.DDMetricsFam) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing the metrics: %v", r)
+		}
+	}()
+	buffered := &bufferedSender{Sender: sender}
+	k.processMetrics(buffered, p.metrics, metricsToGet)
+	buffered.commit()
+	return nil
+}
+
+// bufferedSender keeps the metric points submitted through it until commit is called,
+// so that the points of a store are only submitted once all its metrics are processed.
+// The service checks and events are sent directly.
+type bufferedSender struct {
+	aggregator.Sender
+	samples []*metrics.MetricSample
+}
+
+func (s *bufferedSender) add(metric string, value float64, hostname string, tags []string, mType metrics.MetricType, timestamp float64) {
+	s.samples = append(s.samples, &metrics.MetricSample{Name: metric, Value: value, Mtype: mType, Tags: tags, Host: hostname, Timestamp: timestamp})
+}
+
+// commit submits the buffered points one by one, so that the senders wrapped by the buffered sender see each of them
+func (s *bufferedSender) commit() {
+	for _, sample := range s.samples {
+		switch sample.Mtype {
+		case metrics.GaugeType:
+			s.Sender.Gauge(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.GaugeWithTimestampType:
+			s.Sender.GaugeWithTimestamp(sample.Name, sample.Value, sample.Host, sample.Tags, sample.Timestamp)
+		case metrics.RateType:
+			s.Sender.Rate(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.CountType:
+			s.Sender.Count(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.MonotonicCountType:
+			s.Sender.MonotonicCount(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.CounterType:
+			s.Sender.Counter(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.HistogramType:
+			s.Sender.Histogram(sample.Name, sample.Value, sample.Host, sample.Tags)
+		case metrics.HistorateType:
+			s.Sender.Historate(sample.Name, sample.Value, sample.Host, sample.Tags)
+		}
+	}
+	s.samples = nil
+}
+
+func (s *bufferedSender) Gauge(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.GaugeType, 0)
+}
+
+func (s *bufferedSender) GaugeWithTimestamp(metric string, value float64, hostname string, tags []string, timestamp float64) {
+	s.add(metric, value, hostname, tags, metrics.GaugeWithTimestampType, timestamp)
+}
+
+func (s *bufferedSender) Rate(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.RateType, 0)
+}
+
+func (s *bufferedSender) Count(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.CountType, 0)
+}
+
+func (s *bufferedSender) MonotonicCount(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.MonotonicCountType, 0)
+}
+
+func (s *bufferedSender) Counter(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.CounterType, 0)
+}
+
+func (s *bufferedSender) Histogram(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.HistogramType, 0)
+}
+
+func (s *bufferedSender) Historate(metric string, value float64, hostname string, tags []string) {
+	s.add(metric, value, hostname, tags, metrics.HistorateType, 0)
+}
+
+func (s *bufferedSender) SubmitBatch(samples []*metrics.MetricSample) {
+	s.samples = append(s.samples, samples...)
+}
+
+// addFailedCollectors adds the collectors whose store still didn't receive the list of its objects
+// collectorSyncTimeout after the stores were started to the failed collectors, and the collectors whose
+// list or watch calls fail since their store was synced: the store keeps the metrics of the last list meanwhile
+func (k *KSMCheck) addFailedCollectors(failed map[string]string, now time.Time) {
+	for _, store := range k.store {
+		metricsStore := store.(*ksmstore.MetricsStore)
+		switch {
+		case !metricsStore.Synced():
+			if now.Sub(k.storesStarted) >= collectorSyncTimeout {
+				failed[storeCollector(metricsStore)] = collectorNotSynced
+			}
+		case metricsStore.ListWatchError() != nil:
+			log.Debugf("The %s collector failed to list or watch its objects: %v", storeCollector(metricsStore), metricsStore.ListWatchError())
+			failed[storeCollector(metricsStore)] = collectorListWatchFailed
+		}
+	}
+}
+
+// sendCollectorStatus sends the collectors.status service check and the errors of the failed collectors
+// The service check is WARNING when some collectors failed and the others were processed, CRITICAL when they all failed
+func (k *KSMCheck) sendCollectorStatus(s aggregator.Sender, failed map[string]string, collectors int) {
+	for collector, reason := range failed {
+		s.Gauge(k.metricName("telemetry.collector.errors"), 1, "", []string{"resource_type:" + collector, "reason:" + reason})
+	}
+
+	if len(failed) == 0 {
+		k.submitServiceCheck(s, "collectors.status", metrics.ServiceCheckOK, "", nil, "")
+		return
+	}
+
+	status := metrics.ServiceCheckWarning
+	if len(failed) >= collectors {
+		status = metrics.ServiceCheckCritical
+	}
+	errors := make([]string, 0, len(failed))
+	for collector, reason := range failed {
+		errors = append(errors, fmt.Sprintf("%s (%s)", collector, reason))
+	}
+	sort.Strings(errors)
+	k.submitServiceCheck(s, "collectors.status", status, "", nil, fmt.Sprintf("%d/%d collectors failed: %s", len(failed), collectors, strings.Join(errors, ", ")))
+}
@@ -0,0 +1,182 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/DataDog/datadog-agent/pkg/kubestatemetrics/scraper"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kube-state-metrics/pkg/metric"
+)
+
+func TestKSMCheck_processStore(t *testing.T) {
+	metricTransformers["kube_test_panic"] = func(*KSMCheck, aggregator.Sender, string, ksmstore.DDMetric, string, []string) {
+		panic("unexpected metric")
+	}
+	defer delete(metricTransformers, "kube_test_panic")
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	// The points submitted for the store before the panic are dropped
+	failing := &pushedStore{collector: "v1.Foo", metrics: map[string][]ksmstore.DDMetricsFam{
+		"uid": {
+			{Name: "kube_daemonset_status_number_ready", ListMetrics: []ksmstore.DDMetric{{Val: 1, Labels: map[string]string{"daemonset": "bar"}}}},
+			{Name: "kube_test_panic", ListMetrics: []ksmstore.DDMetric{{Val: 1, Labels: map[string]string{}}}},
+		},
+	}}
+	err := k.processStore(s, failing, nil)
+	assert.EqualError(t, err, "panic while processing the metrics: unexpected metric")
+	s.AssertNotCalled(t, "Gauge", "kubernetes_state.daemonset.ready", mock.Anything, mock.Anything, mock.Anything)
+
+	working := &pushedStore{collector: "v1.DaemonSet", metrics: map[string][]ksmstore.DDMetricsFam{
+		"uid": {{Name: "kube_daemonset_status_number_ready", ListMetrics: []ksmstore.DDMetric{{Val: 1, Labels: map[string]string{"daemonset": "foo"}}}}},
+	}}
+	assert.NoError(t, k.processStore(s, working, nil))
+	s.AssertMetric(t, "Gauge", "kubernetes_state.daemonset.ready", 1, "", []string{"daemonset:foo"})
+	s.AssertNumberOfCalls(t, "Gauge", 1)
+}
+
+func TestBufferedSender(t *testing.T) {
+	s := mocksender.NewMockSender("buffered")
+	s.SetupAcceptAll()
+	buffered := &bufferedSender{Sender: s}
+
+	buffered.Gauge("foo", 1, "host", []string{"a:b"})
+	buffered.GaugeWithTimestamp("foo", 2, "", nil, 1600000000)
+	buffered.Count("bar", 3, "", nil)
+	buffered.Histogram("baz", 4, "", nil)
+	buffered.SubmitBatch([]*metrics.MetricSample{{Name: "qux", Value: 5, Mtype: metrics.GaugeType}})
+	s.AssertNotCalled(t, "Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// The points are submitted one by one on commit
+	buffered.commit()
+	s.AssertMetric(t, "Gauge", "foo", 1, "host", []string{"a:b"})
+	s.AssertCalled(t, "GaugeWithTimestamp", "foo", 2.0, "", []string(nil), 1600000000.0)
+	s.AssertMetric(t, "Count", "bar", 3, "", nil)
+	s.AssertMetric(t, "Histogram", "baz", 4, "", nil)
+	s.AssertMetric(t, "Gauge", "qux", 5, "", nil)
+	s.AssertNotCalled(t, "SubmitBatch", mock.Anything)
+
+	buffered.commit()
+	s.AssertNumberOfCalls(t, "Gauge", 2)
+}
+
+func TestKSMCheck_addFailedCollectors(t *testing.T) {
+	genFunc := func(interface{}) []metric.FamilyInterface { return nil }
+	nodes := ksmstore.NewMetricsStore(genFunc, "*v1.Node")
+	assert.NoError(t, nodes.Replace(nil, ""))
+	foos := ksmstore.NewMetricsStore(genFunc, "*v1.Foo")
+	pods := ksmstore.NewMetricsStore(genFunc, "*v1.Pod")
+	assert.NoError(t, pods.Replace(nil, ""))
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	k.store = []cache.Store{nodes, foos, pods}
+	k.storesStarted = time.Now()
+
+	// The stores can still be syncing
+	failed := map[string]string{}
+	k.addFailedCollectors(failed, k.storesStarted.Add(30*time.Second))
+	assert.Empty(t, failed)
+
+	failed = map[string]string{"v1.Pod": collectorProcessingPanic}
+	k.addFailedCollectors(failed, k.storesStarted.Add(collectorSyncTimeout))
+	assert.Equal(t, map[string]string{"v1.Pod": collectorProcessingPanic, "v1.Foo": collectorNotSynced}, failed)
+
+	// The synced stores whose list or watch calls fail keep serving their metrics, they're reported as failed
+	nodes.SetListWatchError("", errors.New("forbidden"))
+	failed = map[string]string{}
+	k.addFailedCollectors(failed, k.storesStarted.Add(30*time.Second))
+	assert.Equal(t, map[string]string{"v1.Node": collectorListWatchFailed}, failed)
+
+	nodes.SetListWatchError("", nil)
+	failed = map[string]string{}
+	k.addFailedCollectors(failed, k.storesStarted.Add(30*time.Second))
+	assert.Empty(t, failed)
+}
+
+func TestKSMCheck_sendCollectorStatus(t *testing.T) {
+	tests := []struct {
+		name            string
+		failed          map[string]string
+		collectors      int
+		expectedStatus  metrics.ServiceCheckStatus
+		expectedMessage string
+	}{
+		{
+			name:            "no failure",
+			failed:          map[string]string{},
+			collectors:      3,
+			expectedStatus:  metrics.ServiceCheckOK,
+			expectedMessage: "",
+		},
+		{
+			name:            "partial failure",
+			failed:          map[string]string{"v1.Pod": collectorProcessingPanic, "v1.Foo": collectorNotSynced},
+			collectors:      3,
+			expectedStatus:  metrics.ServiceCheckWarning,
+			expectedMessage: "2/3 collectors failed: v1.Foo (not_synced), v1.Pod (processing_panic)",
+		},
+		{
+			name:            "scrape failure",
+			failed:          map[string]string{scraperCollector: collectorScrapeFailed},
+			collectors:      1,
+			expectedStatus:  metrics.ServiceCheckCritical,
+			expectedMessage: "1/1 collectors failed: kube_state_url (scrape_failed)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+			s := mocksender.NewMockSender(k.ID())
+			s.SetupAcceptAll()
+
+			k.sendCollectorStatus(s, tt.failed, tt.collectors)
+			s.AssertServiceCheck(t, "kubernetes_state.collectors.status", tt.expectedStatus, "", nil, tt.expectedMessage)
+			s.AssertNumberOfCalls(t, "Gauge", len(tt.failed))
+			for collector, reason := range tt.failed {
+				s.AssertMetric(t, "Gauge", "kubernetes_state.telemetry.collector.errors", 1, "", []string{"resource_type:" + collector, "reason:" + reason})
+			}
+		})
+	}
+}
+
+func TestKSMCheck_RunDryRunScrapeFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{DryRun: true})
+	out := &bytes.Buffer{}
+	k.dryRunOutput = out
+	k.scraper = scraper.New(server.URL, time.Second)
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	// The service check of the failed scrape is written instead of being submitted
+	assert.Error(t, k.Run())
+	assert.Contains(t, out.String(), "service_check kubernetes_state.collectors.status 2 host: tags:\n")
+	assert.Contains(t, out.String(), "gauge kubernetes_state.telemetry.collector.errors 1 host: tags:reason:scrape_failed,resource_type:kube_state_url\n")
+	s.AssertNotCalled(t, "ServiceCheck", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	s.AssertNotCalled(t, "Gauge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
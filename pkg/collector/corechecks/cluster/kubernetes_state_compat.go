@@ -0,0 +1,104 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+)
+
+// familyRename translates a KSM metric family generated by another kube-state-metrics version
+// to the family generated by the embedded library
+type familyRename struct {
+	// name is the name of the family in the embedded library
+	name string
+	// labels contains the renamed labels, old name to new name
+	labels map[string]string
+	// extraLabels contains the labels added to the metrics, e.g. the resource of the families split per resource
+	extraLabels map[string]string
+}
+
+// hpaLabels contains the labels of the HPA families renamed by kube-state-metrics v2
+var hpaLabels = map[string]string{"hpa": "horizontalpodautoscaler"}
+
+// familyRenames contains the families renamed or removed between kube-state-metrics versions
+// The metric names mapper, the label joins and the transformers use the families of the embedded library,
+// the families of the other versions (e.g. scraped with the kube_state_url option) are translated first.
+// The per-resource families of v1 (e.g. kube_node_status_capacity_cpu_cores) aren't translated:
+// v1 generates the generic families (e.g. kube_node_status_capacity) alongside them.
+var familyRenames = map[string]familyRename{
+	// kube-state-metrics v1
+	"kube_hpa_labels":                  {name: "kube_horizontalpodautoscaler_labels", labels: hpaLabels},
+	"kube_hpa_metadata_generation":     {name: "kube_horizontalpodautoscaler_metadata_generation", labels: hpaLabels},
+	"kube_hpa_spec_max_replicas":       {name: "kube_horizontalpodautoscaler_spec_max_replicas", labels: hpaLabels},
+	"kube_hpa_spec_min_replicas":       {name: "kube_horizontalpodautoscaler_spec_min_replicas", labels: hpaLabels},
+	"kube_hpa_spec_target_metric":      {name: "kube_horizontalpodautoscaler_spec_target_metric", labels: hpaLabels},
+	"kube_hpa_status_condition":        {name: "kube_horizontalpodautoscaler_status_condition", labels: hpaLabels},
+	"kube_hpa_status_current_replicas": {name: "kube_horizontalpodautoscaler_status_current_replicas", labels: hpaLabels},
+	"kube_hpa_status_desired_replicas": {name: "kube_horizontalpodautoscaler_status_desired_replicas", labels: hpaLabels},
+
+	// kube-state-metrics v2
+	"kube_daemonset_status_updated_number_scheduled": {name: "kube_daemonset_updated_number_scheduled"},
+	"kube_pod_overhead_cpu_cores":                    {name: "kube_pod_overhead", extraLabels: map[string]string{"resource": "cpu", "unit": "core"}},
+	"kube_pod_overhead_memory_bytes":                 {name: "kube_pod_overhead", extraLabels: map[string]string{"resource": "memory", "unit": "byte"}},
+}
+
+// translatedFamilyName returns the name of a KSM family in the embedded library
+func translatedFamilyName(name string) string {
+	if rename, found := familyRenames[name]; found {
+		return rename.name
+	}
+	return name
+}
+
+// translateFamilies translates the families generated by another kube-state-metrics version
+// The renamed families are merged with the families of the same name, metrics is returned as is if nothing is renamed
+func translateFamilies(metrics map[string][]ksmstore.DDMetricsFam) map[string][]ksmstore.DDMetricsFam {
+	renamed := false
+	for name := range metrics {
+		if _, found := familyRenames[name]; found {
+			renamed = true
+			break
+		}
+	}
+	if !renamed {
+		return metrics
+	}
+
+	translated := make(map[string][]ksmstore.DDMetricsFam, len(metrics))
+	for name, families := range metrics {
+		rename, found := familyRenames[name]
+		if !found {
+			translated[name] = append(translated[name], families...)
+			continue
+		}
+		for _, f := range families {
+			t := ksmstore.DDMetricsFam{Type: f.Type, Name: rename.name, ListMetrics: make([]ksmstore.DDMetric, 0, len(f.ListMetrics))}
+			for _, m := range f.ListMetrics {
+				t.ListMetrics = append(t.ListMetrics, rename.translate(m))
+			}
+			translated[rename.name] = append(translated[rename.name], t)
+		}
+	}
+	return translated
+}
+
+// translate returns a copy of the metric with the renamed and extra labels
+func (r familyRename) translate(m ksmstore.DDMetric) ksmstore.DDMetric {
+	labels := make(map[string]string, len(m.Labels)+len(r.extraLabels))
+	for key, value := range m.Labels {
+		if newKey, found := r.labels[key]; found {
+			key = newKey
+		}
+		labels[key] = value
+	}
+	for key, value := range r.extraLabels {
+		labels[key] = value
+	}
+	m.Labels = labels
+	return m
+}
@@ -0,0 +1,94 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_translateFamilies(t *testing.T) {
+	tests := []struct {
+		name     string
+		metrics  map[string][]ksmstore.DDMetricsFam
+		expected map[string][]ksmstore.DDMetricsFam
+	}{
+		{
+			name: "families of the embedded library",
+			metrics: map[string][]ksmstore.DDMetricsFam{
+				"kube_daemonset_updated_number_scheduled": {{Type: "*v1.DaemonSet", Name: "kube_daemonset_updated_number_scheduled", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"daemonset": "foo"}, Val: 3}}}},
+			},
+			expected: map[string][]ksmstore.DDMetricsFam{
+				"kube_daemonset_updated_number_scheduled": {{Type: "*v1.DaemonSet", Name: "kube_daemonset_updated_number_scheduled", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"daemonset": "foo"}, Val: 3}}}},
+			},
+		},
+		{
+			name: "v1 HPA family and label",
+			metrics: map[string][]ksmstore.DDMetricsFam{
+				"kube_hpa_spec_min_replicas": {{Name: "kube_hpa_spec_min_replicas", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"namespace": "default", "hpa": "foo"}, Val: 2}}}},
+				"kube_pod_info":              {{Name: "kube_pod_info", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"pod": "bar"}, Val: 1}}}},
+			},
+			expected: map[string][]ksmstore.DDMetricsFam{
+				"kube_horizontalpodautoscaler_spec_min_replicas": {{Name: "kube_horizontalpodautoscaler_spec_min_replicas", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"namespace": "default", "horizontalpodautoscaler": "foo"}, Val: 2}}}},
+				"kube_pod_info": {{Name: "kube_pod_info", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"pod": "bar"}, Val: 1}}}},
+			},
+		},
+		{
+			name: "v2 per-resource families are merged",
+			metrics: map[string][]ksmstore.DDMetricsFam{
+				"kube_pod_overhead_cpu_cores":    {{Name: "kube_pod_overhead_cpu_cores", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"pod": "foo"}, Val: 0.25}}}},
+				"kube_pod_overhead_memory_bytes": {{Name: "kube_pod_overhead_memory_bytes", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"pod": "foo"}, Val: 1024}}}},
+			},
+			expected: map[string][]ksmstore.DDMetricsFam{
+				"kube_pod_overhead": {
+					{Name: "kube_pod_overhead", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"pod": "foo", "resource": "cpu", "unit": "core"}, Val: 0.25}}},
+					{Name: "kube_pod_overhead", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"pod": "foo", "resource": "memory", "unit": "byte"}, Val: 1024}}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			translated := translateFamilies(tt.metrics)
+			assert.Len(t, translated, len(tt.expected))
+			for name, families := range tt.expected {
+				assert.ElementsMatch(t, families, translated[name])
+			}
+		})
+	}
+}
+
+func Test_translateFamiliesLabels(t *testing.T) {
+	// The labels of the original metrics aren't modified
+	labels := map[string]string{"namespace": "default", "hpa": "foo"}
+	translateFamilies(map[string][]ksmstore.DDMetricsFam{
+		"kube_hpa_status_current_replicas": {{Name: "kube_hpa_status_current_replicas", ListMetrics: []ksmstore.DDMetric{{Labels: labels, Val: 1}}}},
+	})
+	assert.Equal(t, map[string]string{"namespace": "default", "hpa": "foo"}, labels)
+}
+
+func TestKSMCheck_translatedFamilies(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	// Families scraped from a kube-state-metrics v2 endpoint are processed like the ones of the embedded library
+	metrics := translateFamilies(map[string][]ksmstore.DDMetricsFam{
+		"kube_pod_overhead_cpu_cores":                    {{Name: "kube_pod_overhead_cpu_cores", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"namespace": "default", "pod": "foo"}, Val: 0.25}}}},
+		"kube_daemonset_status_updated_number_scheduled": {{Name: "kube_daemonset_status_updated_number_scheduled", ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"namespace": "default", "daemonset": "bar"}, Val: 3}}}},
+	})
+	k.processMetrics(s, metrics, k.labelJoinMetrics(metrics))
+
+	s.AssertMetric(t, "Gauge", "kubernetes_state.pod.cpu_overhead", 0.25, "", []string{"kube_namespace:default", "pod_name:foo", "resource:cpu", "unit:core"})
+	s.AssertMetric(t, "Gauge", "kubernetes_state.daemonset.updated", 3, "", []string{"kube_namespace:default", "kube_daemon_set:bar"})
+}
@@ -0,0 +1,111 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronJobState contains the schedule of a cronjob and the completion time of its last successful job,
+// collected from several KSM metrics during a check run
+type cronJobState struct {
+	tags     []string
+	schedule string
+	// lastSuccess is the completion time of the last successful job of the cronjob, zero if unknown
+	lastSuccess time.Time
+	// seen is true when the cronjob was seen during the current run
+	seen bool
+}
+
+// cronJobInfoTransformer keeps the schedule of the cronjobs based on kube_cronjob_info for the end of the run
+// kube_cronjob_info is a metadata metric, it's not submitted
+func cronJobInfoTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	cronjob, found := metric.Labels["cronjob"]
+	if !found {
+		k.missingLabel(name, "cronjob")
+		return
+	}
+	schedule, found := metric.Labels["schedule"]
+	if !found {
+		k.missingLabel(name, "schedule")
+		return
+	}
+	state := k.cronJobState(metric.Labels["namespace"], cronjob)
+	state.tags = removeTag(removeTag(tags, k.tagKey("schedule")), k.tagKey("concurrency_policy"))
+	state.schedule = schedule
+	state.seen = true
+}
+
+// jobCompletionTimeTransformer keeps the completion time of the last successful job of the cronjobs for the end of the run
+// The completion time is only set for the jobs that succeeded, the timestamp itself isn't submitted
+func jobCompletionTimeTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	job, found := metric.Labels["job_name"]
+	if !found {
+		k.missingLabel(name, "job_name")
+		return
+	}
+	cronjob, isCronJob := normalizeJobName(job)
+	if !isCronJob {
+		return
+	}
+	state := k.cronJobState(metric.Labels["namespace"], cronjob)
+	if completion := time.Unix(int64(metric.Val), 0); completion.After(state.lastSuccess) {
+		state.lastSuccess = completion
+	}
+}
+
+// cronJobState returns the state of a cronjob, it's created if needed
+func (k *KSMCheck) cronJobState(namespace, name string) *cronJobState {
+	key := fmt.Sprintf("%s/%s", namespace, name)
+	state, found := k.cronJobs[key]
+	if !found {
+		state = &cronJobState{}
+		k.cronJobs[key] = state
+	}
+	return state
+}
+
+// processCronJobs submits the cronjob.lateness metric of the cronjobs seen during the run
+// The cronjobs without known successful job aren't reported, the states are reset for the next run
+func (k *KSMCheck) processCronJobs(s aggregator.Sender) {
+	now := time.Now()
+	for key, state := range k.cronJobs {
+		if state.seen && !state.lastSuccess.IsZero() {
+			lateness, err := cronJobLateness(state.schedule, state.lastSuccess, now)
+			if err != nil {
+				log.Debugf("Cannot compute the lateness of cronjob %s: %s", key, err)
+			} else {
+				s.Gauge(k.metricName("cronjob.lateness"), lateness.Seconds(), "", state.tags)
+			}
+		}
+		delete(k.cronJobs, key)
+	}
+}
+
+// cronJobLateness returns for how long a cronjob has been due to succeed again: the time elapsed since the first
+// scheduled run after the last successful job, or zero if it didn't happen yet.
+// The lateness increases during the execution of the jobs, until they succeed.
+// The schedule is evaluated in UTC like the cronjob controller does, whatever the local timezone of the agent.
+func cronJobLateness(schedule string, lastSuccess, now time.Time) (time.Duration, error) {
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return 0, err
+	}
+	due := sched.Next(lastSuccess.UTC())
+	if !now.After(due) {
+		return 0, nil
+	}
+	return now.Sub(due), nil
+}
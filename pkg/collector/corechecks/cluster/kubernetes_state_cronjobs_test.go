@@ -0,0 +1,125 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/kube-state-metrics/pkg/allowdenylist"
+)
+
+func Test_cronJobLateness(t *testing.T) {
+	lastSuccess := time.Date(2020, 9, 1, 10, 2, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		schedule string
+		now      time.Time
+		want     time.Duration
+		wantErr  bool
+	}{
+		{
+			name:     "next run not due yet",
+			schedule: "0 * * * *",
+			now:      time.Date(2020, 9, 1, 10, 59, 0, 0, time.UTC),
+			want:     0,
+		},
+		{
+			name:     "next run due",
+			schedule: "0 * * * *",
+			now:      time.Date(2020, 9, 1, 11, 5, 0, 0, time.UTC),
+			want:     5 * time.Minute,
+		},
+		{
+			name:     "several runs missed",
+			schedule: "@hourly",
+			now:      time.Date(2020, 9, 1, 14, 0, 0, 0, time.UTC),
+			want:     3 * time.Hour,
+		},
+		{
+			name:     "invalid schedule",
+			schedule: "every hour",
+			now:      time.Date(2020, 9, 1, 14, 0, 0, 0, time.UTC),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cronJobLateness(tt.schedule, lastSuccess, tt.now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_cronJobLatenessLocalTimezone(t *testing.T) {
+	local := time.Local
+	defer func() { time.Local = local }()
+	time.Local = time.FixedZone("IST", 5*3600+1800)
+
+	// The completion times are parsed in the local timezone
+	lastSuccess := time.Unix(time.Date(2020, 9, 1, 10, 2, 0, 0, time.UTC).Unix(), 0)
+	got, err := cronJobLateness("0 10 * * *", lastSuccess, time.Date(2020, 9, 2, 10, 5, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, got)
+}
+
+func TestKSMCheck_processCronJobs(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	completion := float64(time.Now().Add(-2 * time.Hour).Unix())
+	cronJobInfoTransformer(k, s, "kube_cronjob_info",
+		ksmstore.DDMetric{Val: 1, Labels: map[string]string{"namespace": "default", "cronjob": "foo", "schedule": "@hourly", "concurrency_policy": "Forbid"}}, "",
+		[]string{"kube_namespace:default", "kube_cronjob:foo", "schedule:@hourly", "concurrency_policy:Forbid"})
+	jobCompletionTimeTransformer(k, s, "kube_job_status_completion_time",
+		ksmstore.DDMetric{Val: completion - 3600, Labels: map[string]string{"namespace": "default", "job_name": "foo-1599000000"}}, "",
+		[]string{"kube_namespace:default", "kube_job:foo", "kube_cronjob:foo"})
+	jobCompletionTimeTransformer(k, s, "kube_job_status_completion_time",
+		ksmstore.DDMetric{Val: completion, Labels: map[string]string{"namespace": "default", "job_name": "foo-1599003600"}}, "",
+		[]string{"kube_namespace:default", "kube_job:foo", "kube_cronjob:foo"})
+	// Not created by a cronjob
+	jobCompletionTimeTransformer(k, s, "kube_job_status_completion_time",
+		ksmstore.DDMetric{Val: completion, Labels: map[string]string{"namespace": "default", "job_name": "bar"}}, "",
+		[]string{"kube_namespace:default", "kube_job:bar"})
+	// Deleted cronjob, its jobs are still there
+	jobCompletionTimeTransformer(k, s, "kube_job_status_completion_time",
+		ksmstore.DDMetric{Val: completion, Labels: map[string]string{"namespace": "default", "job_name": "baz-1599003600"}}, "",
+		[]string{"kube_namespace:default", "kube_job:baz", "kube_cronjob:baz"})
+
+	s.AssertNumberOfCalls(t, "Gauge", 0)
+
+	k.processCronJobs(s)
+
+	s.AssertNumberOfCalls(t, "Gauge", 1)
+	s.AssertCalled(t, "Gauge", "kubernetes_state.cronjob.lateness", mock.MatchedBy(func(v float64) bool { return v >= 3600 && v <= 7200 }), "", []string{"kube_namespace:default", "kube_cronjob:foo"})
+	assert.Empty(t, k.cronJobs)
+}
+
+func Test_cronJobFamiliesNotDenied(t *testing.T) {
+	denyList, err := allowdenylist.New(nil, deniedMetrics)
+	assert.NoError(t, err)
+	assert.NoError(t, denyList.Parse())
+
+	assert.True(t, denyList.IsIncluded("kube_cronjob_info"))
+	assert.True(t, denyList.IsIncluded("kube_job_status_completion_time"))
+	assert.True(t, denyList.IsExcluded("kube_job_status_start_time"))
+	assert.True(t, denyList.IsExcluded("kube_pod_completion_time"))
+	assert.True(t, denyList.IsExcluded("kube_cronjob_status_last_schedule_time"))
+}
@@ -0,0 +1,171 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-state-metrics/pkg/metric"
+	generator "k8s.io/kube-state-metrics/pkg/metric_generator"
+)
+
+// CustomResourceConfig contains the config parameters to generate metrics from the objects of a custom resource
+type CustomResourceConfig struct {
+	// Group, Version and Kind identify the custom resource
+	Group   string `yaml:"group"`
+	Version string `yaml:"version"`
+	Kind    string `yaml:"kind"`
+
+	// Resource is the plural name of the custom resource used by the API, defaults to the lowercased kind followed by 's'
+	Resource string `yaml:"resource"`
+
+	// LabelsFromPath contains the labels added to all the metrics of the custom resource
+	// and the dot-separated paths of the object fields to get their values from
+	LabelsFromPath map[string]string `yaml:"labels_from_path"`
+
+	// Metrics contains the metrics to generate for each object of the custom resource
+	Metrics []CustomResourceMetricConfig `yaml:"metrics"`
+}
+
+// CustomResourceMetricConfig contains the config parameters of a custom resource metric
+type CustomResourceMetricConfig struct {
+	// Name is the metric name, it's submitted as customresource.<kind>.<name>
+	Name string `yaml:"name"`
+
+	// Path is the dot-separated path of the object field to get the metric value from
+	// Numeric, boolean and numeric string fields are supported
+	Path string `yaml:"path"`
+
+	// LabelsFromPath contains the labels added to the metric
+	// and the dot-separated paths of the object fields to get their values from
+	LabelsFromPath map[string]string `yaml:"labels_from_path"`
+}
+
+// validate checks the custom resource config and sets the default resource name
+func (c *CustomResourceConfig) validate() error {
+	if c.Version == "" || c.Kind == "" {
+		return errors.New("custom resources require a version and a kind")
+	}
+	if len(c.Metrics) == 0 {
+		return fmt.Errorf("no metrics configured for custom resource %s", c.Kind)
+	}
+	for _, m := range c.Metrics {
+		if m.Name == "" || m.Path == "" {
+			return fmt.Errorf("metrics of custom resource %s require a name and a path", c.Kind)
+		}
+	}
+	if c.Resource == "" {
+		c.Resource = strings.ToLower(c.Kind) + "s"
+	}
+	return nil
+}
+
+// groupVersionResource returns the custom resource identifier used by the dynamic client
+func (c *CustomResourceConfig) groupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: c.Group, Version: c.Version, Resource: c.Resource}
+}
+
+// customResourceMetricName returns the KSM style metric name and the Datadog metric name of a custom resource metric
+func customResourceMetricName(kind, name string) (string, string) {
+	kind = strings.ToLower(kind)
+	return fmt.Sprintf("kube_customresource_%s_%s", kind, name), fmt.Sprintf("customresource.%s.%s", kind, name)
+}
+
+// metricFamilies returns the metric family generators of the custom resource
+func (c *CustomResourceConfig) metricFamilies() []generator.FamilyGenerator {
+	families := make([]generator.FamilyGenerator, 0, len(c.Metrics))
+	for _, m := range c.Metrics {
+		m := m
+		name, _ := customResourceMetricName(c.Kind, m.Name)
+		families = append(families, generator.FamilyGenerator{
+			Name: name,
+			Type: metric.Gauge,
+			Help: fmt.Sprintf("Value of the field %s of the custom resource %s", m.Path, c.Kind),
+			GenerateFunc: func(obj interface{}) *metric.Family {
+				return c.generateMetric(obj, m)
+			},
+		})
+	}
+	return families
+}
+
+// generateMetric generates the metric of a custom resource object
+// The family is empty if the value can't be found or parsed
+func (c *CustomResourceConfig) generateMetric(obj interface{}, m CustomResourceMetricConfig) *metric.Family {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return &metric.Family{}
+	}
+
+	field, found, err := unstructured.NestedFieldNoCopy(u.Object, strings.Split(m.Path, ".")...)
+	if err != nil || !found {
+		log.Tracef("Couldn't find field %s of %s %s/%s: %v", m.Path, c.Kind, u.GetNamespace(), u.GetName(), err)
+		return &metric.Family{}
+	}
+	value, ok := customResourceValue(field)
+	if !ok {
+		log.Debugf("Unsupported value for field %s of %s %s/%s: %v", m.Path, c.Kind, u.GetNamespace(), u.GetName(), field)
+		return &metric.Family{}
+	}
+
+	labelKeys := []string{"customresource_group", "customresource_version", "customresource_kind", "customresource_name"}
+	labelValues := []string{c.Group, c.Version, c.Kind, u.GetName()}
+	if namespace := u.GetNamespace(); namespace != "" {
+		labelKeys = append(labelKeys, "namespace")
+		labelValues = append(labelValues, namespace)
+	}
+	for _, labelsFromPath := range []map[string]string{c.LabelsFromPath, m.LabelsFromPath} {
+		for label, path := range labelsFromPath {
+			field, found, err := unstructured.NestedFieldNoCopy(u.Object, strings.Split(path, ".")...)
+			if err != nil || !found {
+				continue
+			}
+			labelKeys = append(labelKeys, label)
+			labelValues = append(labelValues, fmt.Sprintf("%v", field))
+		}
+	}
+
+	return &metric.Family{
+		Metrics: []*metric.Metric{
+			{
+				LabelKeys:   labelKeys,
+				LabelValues: labelValues,
+				Value:       value,
+			},
+		},
+	}
+}
+
+// customResourceValue converts a custom resource field into a metric value
+func customResourceValue(field interface{}) (float64, bool) {
+	switch v := field.(type) {
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		value, err := strconv.ParseFloat(v, 64)
+		return value, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,164 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/kube-state-metrics/pkg/metric"
+)
+
+func TestCustomResourceConfig_validate(t *testing.T) {
+	tests := []struct {
+		name             string
+		config           CustomResourceConfig
+		wantErr          bool
+		expectedResource string
+	}{
+		{
+			name:             "nominal case, default resource",
+			config:           CustomResourceConfig{Group: "example.com", Version: "v1", Kind: "Foo", Metrics: []CustomResourceMetricConfig{{Name: "replicas", Path: "spec.replicas"}}},
+			expectedResource: "foos",
+		},
+		{
+			name:             "custom resource",
+			config:           CustomResourceConfig{Group: "example.com", Version: "v1", Kind: "Policy", Resource: "policies", Metrics: []CustomResourceMetricConfig{{Name: "rules", Path: "status.rules"}}},
+			expectedResource: "policies",
+		},
+		{
+			name:    "no kind",
+			config:  CustomResourceConfig{Group: "example.com", Version: "v1", Metrics: []CustomResourceMetricConfig{{Name: "replicas", Path: "spec.replicas"}}},
+			wantErr: true,
+		},
+		{
+			name:    "no metrics",
+			config:  CustomResourceConfig{Group: "example.com", Version: "v1", Kind: "Foo"},
+			wantErr: true,
+		},
+		{
+			name:    "no metric path",
+			config:  CustomResourceConfig{Group: "example.com", Version: "v1", Kind: "Foo", Metrics: []CustomResourceMetricConfig{{Name: "replicas"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedResource, tt.config.Resource)
+		})
+	}
+}
+
+func TestCustomResourceConfig_metricFamilies(t *testing.T) {
+	config := CustomResourceConfig{
+		Group:          "example.com",
+		Version:        "v1",
+		Kind:           "Foo",
+		LabelsFromPath: map[string]string{"app": "metadata.labels.app"},
+		Metrics: []CustomResourceMetricConfig{
+			{Name: "replicas", Path: "spec.replicas"},
+			{Name: "ready", Path: "status.ready", LabelsFromPath: map[string]string{"phase": "status.phase"}},
+			{Name: "missing", Path: "status.missing"},
+		},
+	}
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Foo",
+		"metadata": map[string]interface{}{
+			"name":      "bar",
+			"namespace": "default",
+			"labels":    map[string]interface{}{"app": "baz"},
+		},
+		"spec":   map[string]interface{}{"replicas": int64(3)},
+		"status": map[string]interface{}{"ready": true, "phase": "Running"},
+	}}
+	commonKeys := []string{"customresource_group", "customresource_version", "customresource_kind", "customresource_name", "namespace"}
+	commonValues := []string{"example.com", "v1", "Foo", "bar", "default"}
+
+	families := config.metricFamilies()
+	assert.Len(t, families, 3)
+
+	replicas := families[0].Generate(obj)
+	assert.Equal(t, "kube_customresource_foo_replicas", replicas.Name)
+	assert.Equal(t, []*metric.Metric{{
+		LabelKeys:   append(commonKeys, "app"),
+		LabelValues: append(commonValues, "baz"),
+		Value:       3,
+	}}, replicas.Metrics)
+
+	ready := families[1].Generate(obj)
+	assert.Equal(t, "kube_customresource_foo_ready", ready.Name)
+	assert.Equal(t, []*metric.Metric{{
+		LabelKeys:   append(commonKeys, "app", "phase"),
+		LabelValues: append(commonValues, "baz", "Running"),
+		Value:       1,
+	}}, ready.Metrics)
+
+	missing := families[2].Generate(obj)
+	assert.Equal(t, "kube_customresource_foo_missing", missing.Name)
+	assert.Len(t, missing.Metrics, 0)
+}
+
+func Test_customResourceValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		field interface{}
+		want  float64
+		ok    bool
+	}{
+		{name: "int", field: int64(2), want: 2, ok: true},
+		{name: "float", field: 1.5, want: 1.5, ok: true},
+		{name: "true", field: true, want: 1, ok: true},
+		{name: "false", field: false, want: 0, ok: true},
+		{name: "numeric string", field: "0.25", want: 0.25, ok: true},
+		{name: "string", field: "foo", ok: false},
+		{name: "map", field: map[string]interface{}{}, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := customResourceValue(tt.field)
+			assert.Equal(t, tt.ok, ok)
+			if tt.ok {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestKSMCheck_processCustomResourceMetrics(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper})
+	name, ddName := customResourceMetricName("Foo", "replicas")
+	k.customResourceMetricNames[name] = ddName
+
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+	k.processMetrics(s, map[string][]ksmstore.DDMetricsFam{
+		name: {
+			{
+				Type: "example.com/v1, Resource=foos",
+				Name: name,
+				ListMetrics: []ksmstore.DDMetric{
+					{Val: 3, Labels: map[string]string{"customresource_kind": "Foo", "customresource_name": "bar", "namespace": "default"}},
+				},
+			},
+		},
+	}, nil)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.customresource.foo.replicas", 3, "", []string{"customresource_kind:Foo", "customresource_name:bar", "kube_namespace:default"})
+	assert.Len(t, k.unprocessedMetrics, 0)
+}
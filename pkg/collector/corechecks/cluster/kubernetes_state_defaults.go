@@ -13,7 +13,7 @@ import (
 	"k8s.io/kube-state-metrics/pkg/options"
 )
 
-// ksmMetricPrefix defines the KSM metrics namespace
+// ksmMetricPrefix defines the default KSM metrics namespace
 const ksmMetricPrefix = "kubernetes_state."
 
 var (
@@ -21,23 +21,36 @@ var (
 	defaultLabelsMapper = map[string]string{
 		"namespace":                        "kube_namespace",
 		"job":                              "kube_job",
+		"job_name":                         "kube_job",
 		"cronjob":                          "kube_cronjob",
 		"pod":                              "pod_name",
 		"phase":                            "pod_phase",
 		"daemonset":                        "kube_daemon_set",
 		"replicationcontroller":            "kube_replication_controller",
 		"replicaset":                       "kube_replica_set",
-		"statefulset ":                     "kube_stateful_set",
+		"statefulset":                      "kube_stateful_set",
 		"deployment":                       "kube_deployment",
 		"container":                        "kube_container_name",
 		"container_id":                     "container_id",
 		"image":                            "image_name",
+		"runtimeclass_name":                "runtime_class",
 		"node":                             "host",
 		"label_tags_datadoghq_com_env":     "env",
 		"label_tags_datadoghq_com_service": "service",
 		"label_tags_datadoghq_com_version": "version",
 	}
 
+	// ownerKindTags translates the kind of the owners (created_by_kind label of kube_pod_info,
+	// owner_kind label of kube_replicaset_owner) to the tag names used by the tagger for the owner name
+	ownerKindTags = map[string]string{
+		"Deployment":            "kube_deployment",
+		"DaemonSet":             "kube_daemon_set",
+		"ReplicationController": "kube_replication_controller",
+		"ReplicaSet":            "kube_replica_set",
+		"StatefulSet":           "kube_stateful_set",
+		"Job":                   "kube_job",
+	}
+
 	// metricNamesMapper translates KSM metric names to Datadog metric names
 	metricNamesMapper = map[string]string{
 		"kube_daemonset_status_current_number_scheduled":                                           "daemonset.scheduled",
@@ -56,31 +69,22 @@ var (
 		"kube_deployment_status_condition":                                                         "deployment.condition",
 		"kube_daemonset_status_number_unavailable":                                                 "daemonset.daemons_unavailable",
 		"kube_daemonset_status_number_available":                                                   "daemonset.daemons_available",
-		"kube_endpoint_address_available":                                                          "endpoint.address_available",
-		"kube_endpoint_address_not_ready":                                                          "endpoint.address_not_ready",
 		"kube_node_info":                                                                           "node.count",
-		"kube_node_status_allocatable":                                                             "node.allocatable", // TODO: Investigate adding a transformer to generate one metric per resource?
-		"kube_node_status_capacity":                                                                "node.capacity",    // TODO: Investigate adding need a transformer to generate one metric per resource?
 		"kube_pod_container_status_terminated":                                                     "container.terminated",
 		"kube_pod_container_status_waiting":                                                        "container.waiting",
-		"kube_persistentvolumeclaim_status_phase":                                                  "persistentvolumeclaim.status",
 		"kube_persistentvolumeclaim_access_mode":                                                   "persistentvolumeclaim.access_mode",
 		"kube_persistentvolumeclaim_resource_requests_storage_bytes":                               "persistentvolumeclaim.request_storage",
 		"kube_persistentvolume_capacity_bytes":                                                     "persistentvolume.capacity",
 		"kube_pod_container_resource_limits":                                                       "container.resource_limits",   // TODO: Investigate adding a transformer to generate one metric per resource?
 		"kube_pod_container_resource_requests":                                                     "container.resource_requests", // TODO: Investigate adding a transformer to generate one metric per resource?
 		"kube_pod_container_status_ready":                                                          "container.ready",
-		"kube_pod_container_status_restarts_total":                                                 "container.restarts",
 		"kube_pod_container_status_running":                                                        "container.running",
-		"kube_pod_status_ready":                                                                    "pod.ready",
-		"kube_pod_status_scheduled":                                                                "pod.scheduled",
 		"kube_pod_spec_volumes_persistentvolumeclaims_readonly":                                    "pod.volumes.persistentvolumeclaims_readonly",
 		"kube_pod_status_unschedulable":                                                            "pod.unschedulable",
 		"kube_poddisruptionbudget_status_current_healthy":                                          "pdb.pods_healthy",
 		"kube_poddisruptionbudget_status_desired_healthy":                                          "pdb.pods_desired",
 		"kube_poddisruptionbudget_status_pod_disruptions_allowed":                                  "pdb.disruptions_allowed",
 		"kube_poddisruptionbudget_status_expected_pods":                                            "pdb.pods_total",
-		"kube_secret_type":                                                                         "secret.type",
 		"kube_replicaset_spec_replicas":                                                            "replicaset.replicas_desired",
 		"kube_replicaset_status_fully_labeled_replicas":                                            "replicaset.fully_labeled_replicas",
 		"kube_replicaset_status_ready_replicas":                                                    "replicaset.replicas_ready",
@@ -108,36 +112,26 @@ var (
 		"kube_verticalpodautoscaler_spec_updatepolicy_updatemode":                                  "vpa.update_mode",
 		"kube_verticalpodautoscaler_spec_resourcepolicy_container_policies_minallowed":             "vpa.spec_container_minallowed",
 		"kube_verticalpodautoscaler_spec_resourcepolicy_container_policies_maxallowed":             "vpa.spec_container_maxallowed",
+		"kube_cronjob_spec_suspend":                                                                "cronjob.spec_suspend",
+		"kube_cronjob_status_active":                                                               "cronjob.status_active",
 	}
 
 	// metadata metrics are useful for label joins
 	// but shouldn't be submitted to Datadog
-	metadataMetricsRegex = regexp.MustCompile(".*_(info|labels)")
+	metadataMetricsRegex = regexp.MustCompile(".*_(info|labels|owner)")
 
 	// deniedMetrics used to configure the KSM store to ignore these metrics by KSM engine
+	// The families of the experimental metric groups are also denied unless their group is enabled
 	deniedMetrics = options.MetricSet{
-		".*_created":                                       {},
-		".*_owner":                                         {},
-		".*_time":                                          {},
-		".*_generation":                                    {},
-		".*_metadata_resource_version":                     {},
-		"kube_pod_status_reason":                           {},
-		"kube_pod_restart_policy":                          {},
-		"kube_cronjob_status_active":                       {},
-		"kube_namespace_status_phase":                      {},
-		"kube_node_status_phase":                           {},
-		"kube_cronjob_spec_suspend":                        {},
-		"kube_cronjob_spec_starting_deadline_seconds":      {},
-		"kube_job_spec_active_dealine_seconds":             {},
-		"kube_job_spec_completions":                        {},
-		"kube_job_spec_parallelism":                        {},
-		"kube_job_status_active":                           {},
-		"kube_service_spec_external_ip":                    {},
-		"kube_service_status_load_balancer_ingress":        {},
-		"kube_ingress_path":                                {},
-		"kube_statefulset_status_current_revision":         {},
-		"kube_statefulset_status_update_revision":          {},
-		"kube_pod_container_status_last_terminated_reason": {},
+		".*_created":                               {},
+		"kube_pod_owner":                           {},
+		"kube_job_owner":                           {},
+		"kube_replicationcontroller_owner":         {},
+		"kube_lease_owner":                         {},
+		".*_(start|renew|schedule|scheduled)_time": {}, // kube_job_status_completion_time is used for the cronjob lateness
+		"kube_pod_completion_time":                 {},
+		".*_generation":                            {},
+		".*_metadata_resource_version":             {},
 	}
 
 	// defaultLabelJoins contains the default label joins configuration
@@ -146,14 +140,32 @@ var (
 			LabelsToMatch: []string{"pod", "namespace"},
 			LabelsToGet:   []string{"phase"},
 		},
+		"kube_pod_status_qos_class": {
+			LabelsToMatch: []string{"pod", "namespace"},
+			LabelsToGet:   []string{"qos_class"},
+		},
+		"kube_pod_runtimeclass_name_info": {
+			LabelsToMatch: []string{"pod", "namespace"},
+			LabelsToGet:   []string{"runtimeclass_name"},
+		},
 		"kube_pod_info": {
 			LabelsToMatch: []string{"pod", "namespace"},
-			LabelsToGet:   []string{"node"},
+			LabelsToGet:   []string{"node", "created_by_kind", "created_by_name", "host_ip"},
+		},
+		"kube_replicaset_owner": {
+			LabelsToMatch: []string{"replicaset", "namespace"},
+			LabelsToGet:   []string{"owner_kind", "owner_name"},
 		},
 		"kube_persistentvolume_info": {
 			LabelsToMatch: []string{"persistentvolume"}, // persistent volumes are not namespaced
 			LabelsToGet:   []string{"storageclass"},
 		},
+		"kube_persistentvolumeclaim_status_phase": {
+			LabelsToMatch: []string{"persistentvolumeclaim", "namespace"},
+			LabelsToGet:   []string{"phase"},
+			// phase is mapped to pod_phase, the claims are tagged with pvc_phase instead
+			tagKeys: map[string]string{"phase": pvcPhaseLabel},
+		},
 		"kube_persistentvolumeclaim_info": {
 			LabelsToMatch: []string{"persistentvolumeclaim", "namespace"},
 			LabelsToGet:   []string{"storageclass"},
@@ -178,6 +190,9 @@ var (
 			LabelsToMatch: []string{"statefulset", "namespace"},
 			GetAllLabels:  true,
 		},
+		"kube_node_labels": {
+			LabelsToMatch: []string{"node"}, // only used to add the kube_node_role, os and arch tags
+		},
 		"kube_job_labels": {
 			LabelsToMatch: []string{"job_name", "namespace"},
 			GetAllLabels:  true,
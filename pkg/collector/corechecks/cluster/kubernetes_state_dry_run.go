@@ -0,0 +1,146 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// dryRunSender writes the metrics, service checks and events submitted through it instead of sending them
+// Each of them is written on a line with sorted tags, so the outputs of two runs or checks can be diffed
+// The custom tags of the check are appended to the tags, as the wrapped sender would do it
+type dryRunSender struct {
+	aggregator.Sender
+	out        io.Writer
+	customTags []string
+}
+
+// newDryRunSender returns a sender writing to out, or logging if out is nil
+func newDryRunSender(sender aggregator.Sender, out io.Writer, customTags []string) *dryRunSender {
+	if out == nil {
+		out = dryRunLogger{}
+	}
+	return &dryRunSender{Sender: sender, out: out, customTags: customTags}
+}
+
+// openDryRunFile opens the dry_run_file, the lines are appended to the existing content
+func openDryRunFile(path string) (io.WriteCloser, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// dryRunLogger logs the lines written by a dryRunSender
+type dryRunLogger struct{}
+
+func (dryRunLogger) Write(p []byte) (int, error) {
+	log.Infof("kubernetes_state dry run: %s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// writeHeader writes the start time of the run, so the output of each run can be told apart in the dry_run_file
+func (s *dryRunSender) writeHeader(start time.Time) {
+	fmt.Fprintf(s.out, "# run %s\n", start.Format(time.RFC3339)) //nolint:errcheck
+}
+
+// closeDryRunFile closes the dry_run_file if it was opened
+func (k *KSMCheck) closeDryRunFile() {
+	closer, ok := k.dryRunOutput.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		log.Debugf("Cannot close the dry_run_file: %s", err)
+	}
+}
+
+func (s *dryRunSender) write(kind, name string, value float64, hostname string, tags []string) {
+	sorted := make([]string, 0, len(tags)+len(s.customTags))
+	sorted = append(sorted, tags...)
+	sorted = append(sorted, s.customTags...)
+	sort.Strings(sorted)
+	fmt.Fprintf(s.out, "%s %s %s host:%s tags:%s\n", kind, name, strconv.FormatFloat(value, 'f', -1, 64), hostname, strings.Join(sorted, ",")) //nolint:errcheck
+}
+
+func (s *dryRunSender) Gauge(metric string, value float64, hostname string, tags []string) {
+	s.write("gauge", metric, value, hostname, tags)
+}
+
+func (s *dryRunSender) GaugeWithTimestamp(metric string, value float64, hostname string, tags []string, timestamp float64) {
+	s.write("gauge", metric, value, hostname, tags)
+}
+
+func (s *dryRunSender) Rate(metric string, value float64, hostname string, tags []string) {
+	s.write("rate", metric, value, hostname, tags)
+}
+
+func (s *dryRunSender) Count(metric string, value float64, hostname string, tags []string) {
+	s.write("count", metric, value, hostname, tags)
+}
+
+func (s *dryRunSender) MonotonicCount(metric string, value float64, hostname string, tags []string) {
+	s.write("monotonic_count", metric, value, hostname, tags)
+}
+
+func (s *dryRunSender) Counter(metric string, value float64, hostname string, tags []string) {
+	s.write("counter", metric, value, hostname, tags)
+}
+
+func (s *dryRunSender) Histogram(metric string, value float64, hostname string, tags []string) {
+	s.write("histogram", metric, value, hostname, tags)
+}
+
+func (s *dryRunSender) Historate(metric string, value float64, hostname string, tags []string) {
+	s.write("historate", metric, value, hostname, tags)
+}
+
+func (s *dryRunSender) HistogramBucket(metric string, value int64, lowerBound, upperBound float64, monotonic bool, hostname string, tags []string) {
+	s.write("histogram_bucket", metric, float64(value), hostname, tags)
+}
+
+// dryRunMetricKinds contains the kind written for the metric samples submitted by batch
+var dryRunMetricKinds = map[metrics.MetricType]string{
+	metrics.GaugeType:              "gauge",
+	metrics.GaugeWithTimestampType: "gauge",
+	metrics.RateType:               "rate",
+	metrics.CountType:              "count",
+	metrics.MonotonicCountType:     "monotonic_count",
+	metrics.CounterType:            "counter",
+	metrics.HistogramType:          "histogram",
+	metrics.HistorateType:          "historate",
+}
+
+func (s *dryRunSender) SubmitBatch(samples []*metrics.MetricSample) {
+	for _, sample := range samples {
+		s.write(dryRunMetricKinds[sample.Mtype], sample.Name, sample.Value, sample.Host, sample.Tags)
+	}
+}
+
+func (s *dryRunSender) ServiceCheck(checkName string, status metrics.ServiceCheckStatus, hostname string, tags []string, message string) {
+	s.write("service_check", checkName, float64(status), hostname, tags)
+}
+
+func (s *dryRunSender) Event(e metrics.Event) {
+	tags := make([]string, 0, len(e.Tags)+len(s.customTags))
+	tags = append(tags, e.Tags...)
+	tags = append(tags, s.customTags...)
+	sort.Strings(tags)
+	fmt.Fprintf(s.out, "event %q host:%s tags:%s\n", e.Title, e.Host, strings.Join(tags, ",")) //nolint:errcheck
+}
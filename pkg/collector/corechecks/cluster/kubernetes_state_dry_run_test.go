@@ -0,0 +1,113 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRunSender(t *testing.T) {
+	s := mocksender.NewMockSender("dry_run")
+	s.SetupAcceptAll()
+	out := &bytes.Buffer{}
+	sender := newDryRunSender(s, out, nil)
+
+	tags := []string{"kube_namespace:default", "kube_deployment:foo"}
+	sender.Gauge("kubernetes_state.deployment.replicas", 3, "", tags)
+	sender.Count("kubernetes_state.pod.count", 1.5, "bar", nil)
+	sender.GaugeWithTimestamp("kubernetes_state.deployment.condition", 1, "", tags, 1600000000)
+	sender.SubmitBatch([]*metrics.MetricSample{
+		{Name: "kubernetes_state.pod.ready", Value: 1, Mtype: metrics.GaugeType, Tags: []string{"pod_name:foo"}},
+		{Name: "kubernetes_state.container.restarts", Value: 2, Mtype: metrics.HistogramType, Host: "bar"},
+	})
+	sender.ServiceCheck("kubernetes_state.deployment.available", metrics.ServiceCheckWarning, "", tags, "1/3 replicas available")
+	sender.Event(metrics.Event{Title: "Node bar is NotReady", Host: "bar", Tags: []string{"host:bar", "condition:Ready"}})
+
+	assert.Equal(t, `gauge kubernetes_state.deployment.replicas 3 host: tags:kube_deployment:foo,kube_namespace:default
+count kubernetes_state.pod.count 1.5 host:bar tags:
+gauge kubernetes_state.deployment.condition 1 host: tags:kube_deployment:foo,kube_namespace:default
+gauge kubernetes_state.pod.ready 1 host: tags:pod_name:foo
+histogram kubernetes_state.container.restarts 2 host:bar tags:
+service_check kubernetes_state.deployment.available 1 host: tags:kube_deployment:foo,kube_namespace:default
+event "Node bar is NotReady" host:bar tags:condition:Ready,host:bar
+`, out.String())
+
+	// The tags of the caller aren't reordered
+	assert.Equal(t, []string{"kube_namespace:default", "kube_deployment:foo"}, tags)
+
+	for _, method := range []string{"Gauge", "GaugeWithTimestamp", "Count", "Histogram", "ServiceCheck", "Event"} {
+		s.AssertNumberOfCalls(t, method, 0)
+	}
+}
+
+func TestDryRunSenderCustomTags(t *testing.T) {
+	s := mocksender.NewMockSender("dry_run_custom_tags")
+	s.SetupAcceptAll()
+	out := &bytes.Buffer{}
+	sender := newDryRunSender(s, out, []string{"env:prod", "kube_cluster_name:foo"})
+
+	tags := []string{"kube_namespace:default"}
+	sender.Gauge("kubernetes_state.deployment.replicas", 3, "", tags)
+	sender.ServiceCheck("kubernetes_state.deployment.available", metrics.ServiceCheckOK, "", nil, "")
+	sender.Event(metrics.Event{Title: "Node bar is NotReady", Host: "bar", Tags: []string{"host:bar"}})
+
+	assert.Equal(t, `gauge kubernetes_state.deployment.replicas 3 host: tags:env:prod,kube_cluster_name:foo,kube_namespace:default
+service_check kubernetes_state.deployment.available 0 host: tags:env:prod,kube_cluster_name:foo
+event "Node bar is NotReady" host:bar tags:env:prod,host:bar,kube_cluster_name:foo
+`, out.String())
+
+	// The tags of the caller aren't modified
+	assert.Equal(t, []string{"kube_namespace:default"}, tags)
+}
+
+func TestDryRunSenderHeader(t *testing.T) {
+	s := mocksender.NewMockSender("dry_run_header")
+	out := &bytes.Buffer{}
+	sender := newDryRunSender(s, out, nil)
+
+	sender.writeHeader(time.Date(2020, 9, 1, 10, 2, 0, 0, time.UTC))
+	sender.Gauge("kubernetes_state.deployment.replicas", 3, "", nil)
+
+	assert.Equal(t, `# run 2020-09-01T10:02:00Z
+gauge kubernetes_state.deployment.replicas 3 host: tags:
+`, out.String())
+}
+
+func TestKSMCheck_closeDryRunFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dry_run")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubernetes_state.out")
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{DryRun: true, DryRunFile: path})
+	k.dryRunOutput, err = openDryRunFile(path)
+	require.NoError(t, err)
+	newDryRunSender(nil, k.dryRunOutput, nil).Gauge("kubernetes_state.deployment.replicas", 3, "", nil)
+
+	k.Stop()
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "gauge kubernetes_state.deployment.replicas 3 host: tags:\n", string(content))
+	_, err = k.dryRunOutput.Write([]byte("closed\n"))
+	assert.Error(t, err)
+
+	// Stopping again doesn't fail
+	k.Stop()
+}
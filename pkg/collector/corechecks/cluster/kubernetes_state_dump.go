@@ -0,0 +1,53 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"k8s.io/client-go/tools/cache"
+)
+
+// dumpStores writes the metric families held by each store, one metric per line with sorted labels
+// It's used to find out why a metric is missing or mistagged: the raw KSM metrics are written before any transformation
+func dumpStores(out io.Writer, stores []cache.Store) {
+	for _, store := range stores {
+		metricsStore := store.(*ksmstore.MetricsStore)
+		fmt.Fprintf(out, "# store %s\n", strings.TrimPrefix(metricsStore.MetricsType, "*")) //nolint:errcheck
+		for _, family := range metricsStore.Dump() {
+			lines := make([]string, 0, len(family.ListMetrics))
+			for _, m := range family.ListMetrics {
+				lines = append(lines, family.Name+formatDumpLabels(m.Labels)+" "+strconv.FormatFloat(m.Val, 'f', -1, 64))
+			}
+			sort.Strings(lines)
+			for _, line := range lines {
+				fmt.Fprintln(out, line) //nolint:errcheck
+			}
+		}
+	}
+}
+
+// formatDumpLabels formats the labels of a metric like in the Prometheus exposition format
+func formatDumpLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", key, labels[key]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,44 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"bytes"
+	"testing"
+
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kube-state-metrics/pkg/metric"
+)
+
+func Test_dumpStores(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		o, _ := meta.Accessor(obj)
+		return []metric.FamilyInterface{
+			&metric.Family{Name: "kube_node_spec_unschedulable", Metrics: []*metric.Metric{{LabelKeys: []string{"node"}, LabelValues: []string{o.GetName()}, Value: 0}}},
+			&metric.Family{Name: "kube_node_info", Metrics: []*metric.Metric{{LabelKeys: []string{"node", "kernel_version"}, LabelValues: []string{o.GetName(), "5.4"}, Value: 1}}},
+		}
+	}
+	nodes := ksmstore.NewMetricsStore(genFunc, "*v1.Node")
+	assert.NoError(t, nodes.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{UID: "456", Name: "foo"}}))
+	assert.NoError(t, nodes.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{UID: "123", Name: "bar"}}))
+
+	out := &bytes.Buffer{}
+	dumpStores(out, []cache.Store{nodes})
+	assert.Equal(t, `# store v1.Node
+kube_node_info{kernel_version="5.4",node="bar",uid="123"} 1
+kube_node_info{kernel_version="5.4",node="foo",uid="456"} 1
+kube_node_spec_unschedulable{node="bar",uid="123"} 0
+kube_node_spec_unschedulable{node="foo",uid="456"} 0
+`, out.String())
+}
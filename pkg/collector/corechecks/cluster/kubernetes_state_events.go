@@ -0,0 +1,327 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// defaultMaxEventsPerRun is the default number of events the transformers can send per run
+const defaultMaxEventsPerRun = 50
+
+// defaultEventDedupWindow is the default duration during which an event isn't sent again for the same object, in seconds
+const defaultEventDedupWindow = 300
+
+// Reasons for which an event generated by a transformer isn't sent
+const (
+	eventDroppedDuplicate = "duplicate"
+	eventDroppedBudget    = "budget"
+)
+
+// sendEvent sends an event generated by a transformer, the key identifies the object and the kind of event.
+// The event is dropped if an event with the same key was sent during the last event_dedup_window
+// or if max_events_per_run events were already sent during the run, so a cluster-wide incident can't flood the intake.
+func (k *KSMCheck) sendEvent(s aggregator.Sender, key string, event metrics.Event) {
+	now := time.Now()
+	if sent, found := k.sentEvents[key]; found && now.Sub(sent) < time.Duration(k.instance.EventDedupWindow)*time.Second {
+		k.droppedEvents[eventDroppedDuplicate]++
+		return
+	}
+	if k.eventsSent >= k.instance.MaxEventsPerRun {
+		if k.droppedEvents[eventDroppedBudget] == 0 {
+			log.Warnf("The kubernetes_state check sent %d events during the run, the next ones are dropped", k.eventsSent)
+		}
+		k.droppedEvents[eventDroppedBudget]++
+		return
+	}
+	k.eventsSent++
+	k.sentEvents[key] = now
+	s.Event(event)
+}
+
+// resetEventBudget resets the number of events sent during the run
+// and forgets the events sent before the last event_dedup_window
+func (k *KSMCheck) resetEventBudget(now time.Time) {
+	k.eventsSent = 0
+	for key, sent := range k.sentEvents {
+		if now.Sub(sent) >= time.Duration(k.instance.EventDedupWindow)*time.Second {
+			delete(k.sentEvents, key)
+		}
+	}
+}
+
+// nodePressureConditions contains the node conditions that generate an event when they become true
+var nodePressureConditions = map[string]struct{}{
+	"OutOfDisk":          {},
+	"DiskPressure":       {},
+	"MemoryPressure":     {},
+	"PIDPressure":        {},
+	"NetworkUnavailable": {},
+}
+
+// nodeConditionEvent keeps track of the last seen status of the node conditions
+// and sends an event when a node stops being Ready or gains a pressure condition.
+// No event is sent the first time a node condition is seen, as we can't know whether it's a transition.
+func (k *KSMCheck) nodeConditionEvent(s aggregator.Sender, node, condition, status, hostname string, tags []string) {
+	conditions, found := k.currentNodeConditions[node]
+	if !found {
+		conditions = make(map[string]string)
+		k.currentNodeConditions[node] = conditions
+	}
+	conditions[condition] = status
+	previous, seen := k.nodeConditions[node][condition]
+	if !seen || previous == status {
+		return
+	}
+
+	var title string
+	var alertType metrics.EventAlertType
+	if condition == "Ready" {
+		if previous != "true" {
+			return
+		}
+		title = fmt.Sprintf("Node %s is NotReady", node)
+		alertType = metrics.EventAlertTypeError
+	} else {
+		if _, found := nodePressureConditions[condition]; !found || status != "true" {
+			return
+		}
+		title = fmt.Sprintf("Node %s has %s", node, condition)
+		alertType = metrics.EventAlertTypeWarning
+	}
+
+	k.sendEvent(s, fmt.Sprintf("node:%s/%s", node, condition), metrics.Event{
+		Title:          title,
+		Text:           fmt.Sprintf("Condition %s of node %s changed from %s to %s", condition, node, previous, status),
+		Ts:             time.Now().Unix(),
+		Priority:       metrics.EventPriorityNormal,
+		Host:           hostname,
+		Tags:           removeTag(tags, k.tagKey("status")),
+		AlertType:      alertType,
+		AggregationKey: fmt.Sprintf("%s:node:%s", kubeStateMetricsCheckName, node),
+		SourceTypeName: "kubernetes",
+		EventType:      kubeStateMetricsCheckName,
+	})
+}
+
+// jobFailureEvent keeps track of the last seen failure value of the jobs for kube_job_failed and kube_job_status_failed
+// and sends an event when it increases, which means the job or some of its pods failed since the last run.
+// Jobs seen during the first run don't generate events, to avoid sending events for old failures when the check starts.
+// The events of both families are deduplicated per job: a job failure is reported once.
+func (k *KSMCheck) jobFailureEvent(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {
+	job, found := metric.Labels["job_name"]
+	if !found {
+		k.missingLabel(name, "job_name")
+		return
+	}
+	namespace := metric.Labels["namespace"]
+	key := fmt.Sprintf("%s/%s/%s", name, namespace, job)
+
+	previous, seen := k.jobFailures[key]
+	k.currentJobFailures[key] = metric.Val
+	if !seen && !k.hasRun {
+		return
+	}
+	if metric.Val <= previous {
+		return
+	}
+
+	// Events of the jobs created by the same cronjob are aggregated together
+	jobKey, _ := normalizeJobName(job)
+
+	var title string
+	if name == "kube_job_failed" {
+		title = fmt.Sprintf("Job %s/%s failed", namespace, job)
+	} else {
+		title = fmt.Sprintf("Job %s/%s has %d new failed pod(s)", namespace, job, int(metric.Val-previous))
+	}
+
+	text := fmt.Sprintf("Job %s in namespace %s reported a failure", job, namespace)
+	if reasons := failureReasons(metric.Labels); len(reasons) > 0 {
+		text += fmt.Sprintf(" (%s)", strings.Join(reasons, ", "))
+	}
+
+	k.sendEvent(s, fmt.Sprintf("job:%s/%s", namespace, job), metrics.Event{
+		Title:          title,
+		Text:           text,
+		Ts:             time.Now().Unix(),
+		Priority:       metrics.EventPriorityNormal,
+		Tags:           tags,
+		AlertType:      metrics.EventAlertTypeError,
+		AggregationKey: fmt.Sprintf("%s:job:%s/%s", kubeStateMetricsCheckName, namespace, jobKey),
+		SourceTypeName: "kubernetes",
+		EventType:      kubeStateMetricsCheckName,
+	})
+}
+
+// failureReasons returns the reason labels of a metric formatted as key: value, sorted by key
+func failureReasons(labels map[string]string) []string {
+	reasons := []string{}
+	for key, value := range labels {
+		if strings.Contains(key, "reason") {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", key, value))
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// podEvictedEvent sends an event when a pod is seen Evicted, including the node it was evicted from.
+// Events are deduplicated per pod: a pod that was already seen Evicted during the previous run doesn't generate another event.
+// The evicted pods are kept until they're deleted, the ones seen during the first run don't generate events.
+func (k *KSMCheck) podEvictedEvent(s aggregator.Sender, metric ksmstore.DDMetric, tags []string) {
+	namespace := metric.Labels["namespace"]
+	pod := metric.Labels["pod"]
+	key := fmt.Sprintf("%s/%s", namespace, pod)
+
+	_, seen := k.evictedPods[key]
+	k.currentEvictedPods[key] = struct{}{}
+	if seen || !k.hasRun {
+		return
+	}
+
+	text := fmt.Sprintf("Pod %s in namespace %s was evicted", pod, namespace)
+	host := ""
+	if node, found := tagValue(tags, k.tagKey("node")); found {
+		text += fmt.Sprintf(" from node %s", node)
+		host = k.nodeHostname(node)
+	}
+
+	k.sendEvent(s, "pod:"+key, metrics.Event{
+		Title:          fmt.Sprintf("Pod %s/%s was Evicted", namespace, pod),
+		Text:           text,
+		Ts:             time.Now().Unix(),
+		Priority:       metrics.EventPriorityNormal,
+		Host:           host,
+		Tags:           removeTag(tags, k.tagKey("reason")),
+		AlertType:      metrics.EventAlertTypeWarning,
+		AggregationKey: fmt.Sprintf("%s:pod:%s", kubeStateMetricsCheckName, key),
+		SourceTypeName: "kubernetes",
+		EventType:      kubeStateMetricsCheckName,
+	})
+}
+
+// oomKilledEvent sends an event when a container is seen OOMKilled.
+// Events are deduplicated per container: a container that was already seen OOMKilled
+// during the previous run or earlier in the current run doesn't generate another event.
+func (k *KSMCheck) oomKilledEvent(s aggregator.Sender, metric ksmstore.DDMetric, tags []string) {
+	namespace := metric.Labels["namespace"]
+	pod := metric.Labels["pod"]
+	container := metric.Labels["container"]
+	key := fmt.Sprintf("%s/%s/%s", namespace, pod, container)
+
+	_, seen := k.oomKilledContainers[key]
+	_, sent := k.currentOOMKilledContainers[key]
+	k.currentOOMKilledContainers[key] = struct{}{}
+	if seen || sent || !k.hasRun {
+		return
+	}
+
+	k.sendEvent(s, "container:"+key, metrics.Event{
+		Title:          fmt.Sprintf("Container %s of pod %s/%s was OOMKilled", container, namespace, pod),
+		Text:           fmt.Sprintf("Container %s of pod %s in namespace %s was terminated because it ran out of memory", container, pod, namespace),
+		Ts:             time.Now().Unix(),
+		Priority:       metrics.EventPriorityNormal,
+		Tags:           tags,
+		AlertType:      metrics.EventAlertTypeError,
+		AggregationKey: fmt.Sprintf("%s:container:%s", kubeStateMetricsCheckName, key),
+		SourceTypeName: "kubernetes",
+		EventType:      kubeStateMetricsCheckName,
+	})
+}
+
+// Default number of container restarts of a pod during the restart burst window from which an event is sent
+// and default duration of the window, in seconds
+const (
+	defaultRestartBurstThreshold = 3
+	defaultRestartBurstWindow    = 300
+)
+
+// podRestartState contains the last seen restart count of the containers of a pod
+// and the restarts seen during the restart_burst_window
+type podRestartState struct {
+	containers map[string]float64
+	restarts   []podRestarts
+}
+
+// podRestarts is a number of container restarts seen during a run
+type podRestarts struct {
+	time  time.Time
+	count float64
+}
+
+// restartBurstEvent keeps track of the container restart counts for kube_pod_container_status_restarts_total
+// and sends an event when the containers of a pod restarted restart_burst_threshold times during the restart_burst_window.
+// The restarts are the increases of the counts between two runs, the counts seen for the first time aren't restarts.
+// The events are deduplicated per pod during the event_dedup_window.
+func (k *KSMCheck) restartBurstEvent(s aggregator.Sender, metric ksmstore.DDMetric, tags []string, now time.Time) {
+	if k.instance.RestartBurstThreshold < 0 {
+		return
+	}
+	namespace := metric.Labels["namespace"]
+	pod := metric.Labels["pod"]
+	container := metric.Labels["container"]
+	key := fmt.Sprintf("%s/%s", namespace, pod)
+
+	state, found := k.currentPodRestarts[key]
+	if !found {
+		if state, found = k.podRestarts[key]; !found {
+			state = &podRestartState{containers: make(map[string]float64)}
+		}
+		k.currentPodRestarts[key] = state
+	}
+
+	previous, seen := state.containers[container]
+	state.containers[container] = metric.Val
+	if !seen || metric.Val <= previous {
+		return
+	}
+	state.restarts = append(state.restarts, podRestarts{time: now, count: metric.Val - previous})
+
+	window := time.Duration(k.instance.RestartBurstWindow) * time.Second
+	restarts := 0.0
+	recent := state.restarts[:0]
+	for _, r := range state.restarts {
+		if now.Sub(r.time) < window {
+			recent = append(recent, r)
+			restarts += r.count
+		}
+	}
+	state.restarts = recent
+	if restarts < float64(k.instance.RestartBurstThreshold) {
+		return
+	}
+
+	host := ""
+	if node, found := tagValue(tags, k.tagKey("node")); found {
+		host = k.nodeHostname(node)
+	}
+	tags = removeTag(tags, k.tagKey("container"))
+	tags = removeTag(tags, k.tagKey("container_id"))
+
+	k.sendEvent(s, "restarts:"+key, metrics.Event{
+		Title:          fmt.Sprintf("Pod %s/%s restarted %d times in %s", namespace, pod, int(restarts), window),
+		Text:           fmt.Sprintf("The containers of pod %s in namespace %s restarted %d times in %s, container %s restarted last", pod, namespace, int(restarts), window, container),
+		Ts:             now.Unix(),
+		Priority:       metrics.EventPriorityNormal,
+		Host:           host,
+		Tags:           tags,
+		AlertType:      metrics.EventAlertTypeWarning,
+		AggregationKey: fmt.Sprintf("%s:pod:%s", kubeStateMetricsCheckName, key),
+		SourceTypeName: "kubernetes",
+		EventType:      kubeStateMetricsCheckName,
+	})
+}
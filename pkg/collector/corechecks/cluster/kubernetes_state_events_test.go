@@ -0,0 +1,167 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestKSMCheck_sendEvent(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{MaxEventsPerRun: 2, EventDedupWindow: 60})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	k.sendEvent(s, "node:foo/Ready", metrics.Event{Title: "Node foo is NotReady"})
+	k.sendEvent(s, "node:foo/Ready", metrics.Event{Title: "Node foo is NotReady"})
+	k.sendEvent(s, "node:bar/Ready", metrics.Event{Title: "Node bar is NotReady"})
+	k.sendEvent(s, "node:baz/Ready", metrics.Event{Title: "Node baz is NotReady"})
+
+	s.AssertNumberOfCalls(t, "Event", 2)
+	assert.Equal(t, map[string]float64{eventDroppedDuplicate: 1, eventDroppedBudget: 1}, k.droppedEvents)
+
+	k.sendTelemetry(s)
+	s.AssertMetric(t, "Count", "kubernetes_state.telemetry.events_dropped", 1, "", []string{"reason:duplicate"})
+	s.AssertMetric(t, "Count", "kubernetes_state.telemetry.events_dropped", 1, "", []string{"reason:budget"})
+	assert.Empty(t, k.droppedEvents)
+
+	// The budget is reset at the end of the run, the events sent before the dedup window are forgotten
+	k.sentEvents["node:foo/Ready"] = time.Now().Add(-2 * time.Minute)
+	k.resetEventBudget(time.Now())
+	assert.Equal(t, 0, k.eventsSent)
+	assert.NotContains(t, k.sentEvents, "node:foo/Ready")
+	assert.Contains(t, k.sentEvents, "node:bar/Ready")
+
+	k.sendEvent(s, "node:foo/Ready", metrics.Event{Title: "Node foo is NotReady"})
+	k.sendEvent(s, "node:bar/Ready", metrics.Event{Title: "Node bar is NotReady"})
+	s.AssertNumberOfCalls(t, "Event", 3)
+}
+
+func TestKSMCheck_restartBurstEvent(t *testing.T) {
+	restarts := func(container string, count float64) ksmstore.DDMetric {
+		return ksmstore.DDMetric{Val: count, Labels: map[string]string{"namespace": "default", "pod": "foo", "container": container}}
+	}
+	tags := []string{"kube_namespace:default", "pod_name:foo", "kube_container_name:bar"}
+	start := time.Now()
+
+	tests := []struct {
+		name     string
+		config   *KSMConfig
+		node     string
+		runs     [][]ksmstore.DDMetric
+		expected bool
+	}{
+		{
+			name:     "restarts seen during the first run",
+			runs:     [][]ksmstore.DDMetric{{restarts("bar", 10)}},
+			expected: false,
+		},
+		{
+			name:     "restarts under the threshold",
+			runs:     [][]ksmstore.DDMetric{{restarts("bar", 0)}, {restarts("bar", 1)}, {restarts("bar", 2)}},
+			expected: false,
+		},
+		{
+			name:     "restart burst",
+			runs:     [][]ksmstore.DDMetric{{restarts("bar", 0)}, {restarts("bar", 1)}, {restarts("bar", 3)}},
+			expected: true,
+		},
+		{
+			name:     "restart burst on a node",
+			node:     "bar",
+			runs:     [][]ksmstore.DDMetric{{restarts("bar", 0)}, {restarts("bar", 3)}},
+			expected: true,
+		},
+		{
+			name:     "restarts of several containers",
+			runs:     [][]ksmstore.DDMetric{{restarts("bar", 0), restarts("baz", 4)}, {restarts("bar", 1), restarts("baz", 6)}},
+			expected: true,
+		},
+		{
+			name:     "restarts out of the window",
+			config:   &KSMConfig{RestartBurstWindow: 60},
+			runs:     [][]ksmstore.DDMetric{{restarts("bar", 0)}, {restarts("bar", 2)}, {restarts("bar", 2)}, {restarts("bar", 3)}},
+			expected: false,
+		},
+		{
+			name:     "custom threshold",
+			config:   &KSMConfig{RestartBurstThreshold: 5},
+			runs:     [][]ksmstore.DDMetric{{restarts("bar", 0)}, {restarts("bar", 4)}},
+			expected: false,
+		},
+		{
+			name:     "disabled",
+			config:   &KSMConfig{RestartBurstThreshold: -1},
+			runs:     [][]ksmstore.DDMetric{{restarts("bar", 0)}, {restarts("bar", 10)}},
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			if config == nil {
+				config = &KSMConfig{}
+			}
+			config.LabelsMapper = defaultLabelsMapper
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), config)
+			k.clusterName = "prod"
+			s := mocksender.NewMockSender(k.ID())
+			s.SetupAcceptAll()
+
+			// The events of the pods without node have no host
+			podTags := tags[:2:2]
+			expectedHost := ""
+			if tt.node != "" {
+				podTags = append(podTags, "host:"+tt.node)
+				expectedHost = tt.node + "-prod"
+			}
+
+			// The runs are 30 seconds apart
+			for i, run := range tt.runs {
+				for _, metric := range run {
+					k.restartBurstEvent(s, metric, append(podTags[:len(podTags):len(podTags)], "kube_container_name:"+metric.Labels["container"]), start.Add(time.Duration(i)*30*time.Second))
+				}
+				k.endRun()
+			}
+			if tt.expected {
+				s.AssertCalled(t, "Event", mock.MatchedBy(func(e metrics.Event) bool {
+					return e.Title == "Pod default/foo restarted 3 times in 5m0s" &&
+						e.AggregationKey == "kubernetes_state-alpha:pod:default/foo" &&
+						e.Host == expectedHost &&
+						assert.ObjectsAreEqual(podTags, e.Tags)
+				}))
+				s.AssertNumberOfCalls(t, "Event", 1)
+			} else {
+				s.AssertNotCalled(t, "Event")
+			}
+		})
+	}
+}
+
+func TestKSMCheck_restartBurstEventDeletedPods(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	metric := ksmstore.DDMetric{Val: 1, Labels: map[string]string{"namespace": "default", "pod": "foo", "container": "bar"}}
+	k.restartBurstEvent(s, metric, nil, time.Now())
+	k.endRun()
+	assert.Contains(t, k.podRestarts, "default/foo")
+
+	// The pods not seen during a run are forgotten
+	k.endRun()
+	assert.Empty(t, k.podRestarts)
+}
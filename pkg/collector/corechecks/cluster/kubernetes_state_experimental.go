@@ -0,0 +1,85 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+
+	"k8s.io/kube-state-metrics/pkg/options"
+)
+
+// experimentalMetricGroup is a group of KSM metric families disabled by default
+// They're collected when the group is listed in the experimental_metrics option
+type experimentalMetricGroup struct {
+	// description documents what the group enables
+	description string
+	// families maps the KSM metric families of the group to their Datadog metric names
+	families map[string]string
+}
+
+// experimentalMetricGroups is the registry of the groups of the experimental_metrics option
+// The annotations families aren't generated by the kube-state-metrics version in use, they can't be enabled yet
+var experimentalMetricGroups = map[string]experimentalMetricGroup{
+	"spec": {
+		description: "Specification details of the cronjobs, jobs and services: deadlines, completions, parallelism and external IPs",
+		families: map[string]string{
+			"kube_cronjob_spec_starting_deadline_seconds": "cronjob.spec_starting_deadline_seconds",
+			"kube_job_spec_active_deadline_seconds":       "job.spec_active_deadline_seconds",
+			"kube_job_spec_completions":                   "job.spec_completions",
+			"kube_job_spec_parallelism":                   "job.spec_parallelism",
+			"kube_service_spec_external_ip":               "service.spec_external_ip",
+		},
+	},
+	"verbose_status": {
+		description: "Detailed statuses: pod restart policies, active job pods, node phases, " +
+			"load balancer ingresses and ingress paths",
+		families: map[string]string{
+			"kube_pod_restart_policy":                   "pod.restart_policy",
+			"kube_job_status_active":                    "job.status_active",
+			"kube_node_status_phase":                    "node.status_phase",
+			"kube_service_status_load_balancer_ingress": "service.status_load_balancer_ingress",
+			"kube_ingress_path":                         "ingress.path",
+		},
+	},
+}
+
+// experimentalMetricNames translates the experimental metric families to Datadog metric names
+var experimentalMetricNames = func() map[string]string {
+	names := make(map[string]string)
+	for _, group := range experimentalMetricGroups {
+		for family, ddName := range group.families {
+			names[family] = ddName
+		}
+	}
+	return names
+}()
+
+// deniedMetricsWithExperimental returns the default denied metrics and the families of the experimental groups not enabled
+func deniedMetricsWithExperimental(enabled []string) (options.MetricSet, error) {
+	enabledGroups := make(map[string]struct{}, len(enabled))
+	for _, name := range enabled {
+		if _, found := experimentalMetricGroups[name]; !found {
+			return nil, fmt.Errorf("unknown experimental metric group %q", name)
+		}
+		enabledGroups[name] = struct{}{}
+	}
+
+	denied := make(options.MetricSet, len(deniedMetrics)+len(experimentalMetricNames))
+	for family := range deniedMetrics {
+		denied[family] = struct{}{}
+	}
+	for name, group := range experimentalMetricGroups {
+		if _, found := enabledGroups[name]; found {
+			continue
+		}
+		for family := range group.families {
+			denied[family] = struct{}{}
+		}
+	}
+	return denied, nil
+}
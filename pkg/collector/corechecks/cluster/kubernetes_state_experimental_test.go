@@ -0,0 +1,83 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+)
+
+func Test_deniedMetricsWithExperimental(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     []string
+		denied      []string
+		allowed     []string
+		expectedErr bool
+	}{
+		{
+			name:    "no experimental group",
+			denied:  []string{".*_created", "kube_job_spec_completions", "kube_pod_restart_policy"},
+			allowed: []string{"kube_cronjob_spec_suspend", "kube_cronjob_status_active", "kube_pod_status_reason"},
+		},
+		{
+			name:    "spec group",
+			enabled: []string{"spec"},
+			denied:  []string{".*_created", "kube_pod_restart_policy"},
+			allowed: []string{"kube_job_spec_completions", "kube_cronjob_spec_starting_deadline_seconds"},
+		},
+		{
+			name:    "all groups",
+			enabled: []string{"spec", "verbose_status"},
+			denied:  []string{".*_created"},
+			allowed: []string{"kube_job_spec_completions", "kube_pod_restart_policy"},
+		},
+		{
+			name:        "unknown group",
+			enabled:     []string{"annotations"},
+			expectedErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			denied, err := deniedMetricsWithExperimental(tt.enabled)
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			for _, family := range tt.denied {
+				assert.Contains(t, denied, family)
+			}
+			for _, family := range tt.allowed {
+				assert.NotContains(t, denied, family)
+			}
+		})
+	}
+
+	// The default denied metrics aren't modified
+	assert.NotContains(t, deniedMetrics, "kube_job_spec_completions")
+}
+
+func TestExperimentalMetricGroups(t *testing.T) {
+	for name, group := range experimentalMetricGroups {
+		assert.NotEmpty(t, group.description, "group %s", name)
+		for family := range group.families {
+			_, found := metricNamesMapper[family]
+			assert.False(t, found, "experimental family %s is also in the metric names mapper", family)
+			_, found = metricTransformers[family]
+			assert.False(t, found, "experimental family %s has a transformer", family)
+		}
+	}
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{MetricPrefix: ksmMetricPrefix})
+	assert.Equal(t, "kubernetes_state.job.spec_completions", k.formatMetricName("kube_job_spec_completions"))
+}
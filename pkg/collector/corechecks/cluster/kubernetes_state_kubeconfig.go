@@ -0,0 +1,71 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/util/kubernetes/apiserver"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// outOfCluster returns whether the check watches another cluster than the one of the agent, through a kubeconfig
+func (k *KSMCheck) outOfCluster() bool {
+	return k.instance.Kubeconfig != "" || k.instance.KubeContext != ""
+}
+
+// apiClients returns the clients used by the stores, the dynamic client can be nil
+// They're the clients of the agent unless the instance has a kubeconfig
+func (k *KSMCheck) apiClients() (kubernetes.Interface, dynamic.Interface, error) {
+	if !k.outOfCluster() {
+		c, err := apiserver.GetAPIClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.Cl, c.DynamicCl, nil
+	}
+
+	clientConfig, err := k.kubeconfigClientConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	clientConfig.Timeout = time.Duration(config.Datadog.GetInt64("kubernetes_apiserver_client_timeout")) * time.Second
+
+	// The dynamic client only supports JSON, the content type is set after it's created
+	dynamicClient, err := dynamic.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if config.Datadog.GetBool("kubernetes_apiserver_use_protobuf") {
+		clientConfig.ContentType = "application/vnd.kubernetes.protobuf"
+	}
+	kubeClient, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return kubeClient, dynamicClient, nil
+}
+
+// kubeconfigClientConfig returns the client config of the kubeconfig context of the instance
+func (k *KSMCheck) kubeconfigClientConfig() (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = k.instance.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: k.instance.KubeContext}
+
+	clientConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cannot load the context %q of the kubeconfig %q: %v", k.instance.KubeContext, k.instance.Kubeconfig, err)
+	}
+	return clientConfig, nil
+}
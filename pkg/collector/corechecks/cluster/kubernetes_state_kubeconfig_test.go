@@ -0,0 +1,103 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: staging
+clusters:
+- name: staging
+  cluster:
+    server: https://staging.example.com
+- name: prod
+  cluster:
+    server: https://prod.example.com
+users:
+- name: staging
+  user:
+    token: staging-token
+- name: prod
+  user:
+    token: prod-token
+contexts:
+- name: staging
+  context:
+    cluster: staging
+    user: staging
+- name: prod
+  context:
+    cluster: prod
+    user: prod
+`
+
+func TestKSMCheck_kubeconfigClientConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kubeconfig")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testKubeconfig), 0600))
+
+	tests := []struct {
+		name          string
+		context       string
+		expectedHost  string
+		expectedToken string
+		expectedErr   bool
+	}{
+		{
+			name:          "current context",
+			expectedHost:  "https://staging.example.com",
+			expectedToken: "staging-token",
+		},
+		{
+			name:          "explicit context",
+			context:       "prod",
+			expectedHost:  "https://prod.example.com",
+			expectedToken: "prod-token",
+		},
+		{
+			name:        "unknown context",
+			context:     "dev",
+			expectedErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{Kubeconfig: path, KubeContext: tt.context})
+			assert.True(t, k.outOfCluster())
+
+			clientConfig, err := k.kubeconfigClientConfig()
+			if tt.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedHost, clientConfig.Host)
+			assert.Equal(t, tt.expectedToken, clientConfig.BearerToken)
+		})
+	}
+}
+
+func TestKSMCheck_outOfCluster(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	assert.False(t, k.outOfCluster())
+
+	k = newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{KubeContext: "prod"})
+	assert.True(t, k.outOfCluster())
+}
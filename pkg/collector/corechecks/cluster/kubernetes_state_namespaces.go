@@ -0,0 +1,93 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+)
+
+// defaultNamespaceTerminatingTimeout is the default duration after which a terminating namespace is reported as stuck, in seconds
+const defaultNamespaceTerminatingTimeout = 600
+
+// namespaceState contains the phase of a namespace seen during a check run
+// and the time it was first seen terminating, kept between check runs
+type namespaceState struct {
+	tags        []string
+	terminating bool
+	// seen is true when the namespace was seen during the current run
+	seen bool
+	// terminatingSince is the time the namespace was first seen terminating, zero otherwise
+	terminatingSince time.Time
+}
+
+// namespacePhaseTransformer submits the namespace.count metric tagged by phase based on the metric kube_namespace_status_phase
+// and keeps the phase of the namespaces to detect the namespaces stuck terminating at the end of the run
+// KSM generates one metric per phase (active, terminating), only the current one is equal to 1
+func namespacePhaseTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	phase, found := metric.Labels["phase"]
+	if !found {
+		k.missingLabel(name, "phase")
+		return
+	}
+	s.Count(k.metricName("namespace.count"), metric.Val, hostname, []string{"phase:" + strings.ToLower(phase)})
+
+	namespace, found := metric.Labels["namespace"]
+	if !found {
+		k.missingLabel(name, "namespace")
+		return
+	}
+	if metric.Val != 1.0 {
+		return
+	}
+	state, found := k.namespaces[namespace]
+	if !found {
+		state = &namespaceState{}
+		k.namespaces[namespace] = state
+	}
+	state.tags = removeTag(tags, k.tagKey("phase"))
+	state.terminating = strings.ToLower(phase) == "terminating"
+	state.seen = true
+}
+
+// processNamespaces submits the namespace.terminating service check of the namespaces seen during the run
+// It's WARNING when a namespace is terminating for more than namespace_terminating_timeout,
+// which usually means a finalizer or a resource of the namespace blocks the deletion
+func (k *KSMCheck) processNamespaces(s aggregator.Sender) {
+	now := time.Now()
+	for namespace, state := range k.namespaces {
+		if !state.seen {
+			// The namespace is deleted
+			delete(k.namespaces, namespace)
+			continue
+		}
+		state.seen = false
+
+		if !state.terminating {
+			state.terminatingSince = time.Time{}
+			k.submitServiceCheck(s, "namespace.terminating", metrics.ServiceCheckOK, "", state.tags, "")
+			continue
+		}
+
+		if state.terminatingSince.IsZero() {
+			state.terminatingSince = now
+		}
+		terminating := now.Sub(state.terminatingSince)
+		if terminating <= time.Duration(k.instance.NamespaceTerminatingTimeout)*time.Second {
+			k.submitServiceCheck(s, "namespace.terminating", metrics.ServiceCheckOK, "", state.tags, "")
+			continue
+		}
+		message := fmt.Sprintf("Namespace %s stuck Terminating for %s", namespace, terminating.Round(time.Second))
+		k.submitServiceCheck(s, "namespace.terminating", metrics.ServiceCheckWarning, "", state.tags, message)
+	}
+}
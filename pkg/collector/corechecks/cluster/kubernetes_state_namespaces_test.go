@@ -0,0 +1,97 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_namespacePhaseTransformer(t *testing.T) {
+	RunTransformerTests(t, namespacePhaseTransformer, []TransformerTestCase{
+		{
+			Name:       "active",
+			MetricName: "kube_namespace_status_phase",
+			Metric: ksmstore.DDMetric{
+				Labels: map[string]string{"namespace": "default", "phase": "Active"},
+				Val:    1,
+			},
+			Tags: []string{"kube_namespace:default", "phase:Active"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.namespace.count", Value: 1, Tags: []string{"phase:active"}},
+			},
+		},
+		{
+			Name:       "inactive phase",
+			MetricName: "kube_namespace_status_phase",
+			Metric: ksmstore.DDMetric{
+				Labels: map[string]string{"namespace": "default", "phase": "Terminating"},
+				Val:    0,
+			},
+			Tags: []string{"kube_namespace:default", "phase:Terminating"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.namespace.count", Value: 0, Tags: []string{"phase:terminating"}},
+			},
+		},
+		{
+			Name:       "missing phase",
+			MetricName: "kube_namespace_status_phase",
+			Metric: ksmstore.DDMetric{
+				Labels: map[string]string{"namespace": "default"},
+				Val:    1,
+			},
+			Tags: []string{"kube_namespace:default"},
+		},
+	})
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+	tags := []string{"kube_namespace:foo", "phase:Terminating"}
+	namespacePhaseTransformer(k, s, "kube_namespace_status_phase", ksmstore.DDMetric{Val: 0, Labels: map[string]string{"namespace": "foo", "phase": "Active"}}, "", tags)
+	namespacePhaseTransformer(k, s, "kube_namespace_status_phase", ksmstore.DDMetric{Val: 1, Labels: map[string]string{"namespace": "foo", "phase": "Terminating"}}, "", tags)
+
+	state := k.namespaces["foo"]
+	assert.NotNil(t, state)
+	assert.True(t, state.seen)
+	assert.True(t, state.terminating)
+	assert.Equal(t, []string{"kube_namespace:foo"}, state.tags)
+}
+
+func TestKSMCheck_processNamespaces(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	k.namespaces = map[string]*namespaceState{
+		"active":      {tags: []string{"kube_namespace:active"}, seen: true, terminatingSince: time.Now().Add(-time.Hour)},
+		"terminating": {tags: []string{"kube_namespace:terminating"}, seen: true, terminating: true},
+		"stuck":       {tags: []string{"kube_namespace:stuck"}, seen: true, terminating: true, terminatingSince: time.Now().Add(-15 * time.Minute)},
+		"deleted":     {tags: []string{"kube_namespace:deleted"}, terminating: true},
+	}
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	k.processNamespaces(s)
+
+	s.AssertServiceCheck(t, "kubernetes_state.namespace.terminating", metrics.ServiceCheckOK, "", []string{"kube_namespace:active"}, "")
+	s.AssertServiceCheck(t, "kubernetes_state.namespace.terminating", metrics.ServiceCheckOK, "", []string{"kube_namespace:terminating"}, "")
+	s.AssertServiceCheck(t, "kubernetes_state.namespace.terminating", metrics.ServiceCheckWarning, "", []string{"kube_namespace:stuck"}, "Namespace stuck stuck Terminating for 15m0s")
+	s.AssertNumberOfCalls(t, "ServiceCheck", 3)
+
+	assert.NotContains(t, k.namespaces, "deleted")
+	assert.True(t, k.namespaces["active"].terminatingSince.IsZero())
+	assert.False(t, k.namespaces["terminating"].terminatingSince.IsZero())
+	for _, state := range k.namespaces {
+		assert.False(t, state.seen)
+	}
+}
@@ -0,0 +1,91 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// nodeLabelsFamily is the KSM metric family the node roles and platform are derived from
+	nodeLabelsFamily = "kube_node_labels"
+
+	// nodeRoleLabelPrefix is the KSM label prefix of the node-role.kubernetes.io/<role> node labels
+	nodeRoleLabelPrefix = "label_node_role_kubernetes_io_"
+	// legacyNodeRoleLabel is the KSM label of the kubernetes.io/role=<role> node label
+	legacyNodeRoleLabel = "label_kubernetes_io_role"
+
+	nodeRoleControlPlane = "control-plane"
+	nodeRoleWorker       = "worker"
+)
+
+// nodePlatformLabels contains the KSM labels of the node OS and architecture labels per tag,
+// the beta labels are used by the clusters older than Kubernetes 1.14
+var nodePlatformLabels = []struct {
+	tag    string
+	labels []string
+}{
+	{tag: "os", labels: []string{"label_kubernetes_io_os", "label_beta_kubernetes_io_os"}},
+	{tag: "arch", labels: []string{"label_kubernetes_io_arch", "label_beta_kubernetes_io_arch"}},
+}
+
+// nodeLabelTags returns the tags derived from the labels of kube_node_labels: the node roles, OS and architecture
+func nodeLabelTags(labels map[string]string) []string {
+	return append(nodeRoleTags(labels), nodePlatformTags(labels)...)
+}
+
+// nodePlatformTags returns the os and arch tags based on the labels of kube_node_labels
+// The kubelet sets the labels on its node, they're missing if the node is registered by another way
+func nodePlatformTags(labels map[string]string) []string {
+	tags := []string{}
+	for _, platform := range nodePlatformLabels {
+		for _, label := range platform.labels {
+			if value := labels[label]; value != "" {
+				tags = append(tags, platform.tag+":"+value)
+				break
+			}
+		}
+	}
+	return tags
+}
+
+// nodeRoleTags returns the kube_node_role tags based on the labels of kube_node_labels.
+// The roles are taken from the node-role.kubernetes.io/<role> and kubernetes.io/role=<role> node labels,
+// the master role is reported as control-plane and the nodes without a role label are reported as worker.
+func nodeRoleTags(labels map[string]string) []string {
+	roles := make(map[string]struct{})
+	for key, value := range labels {
+		var role string
+		switch {
+		case strings.HasPrefix(key, nodeRoleLabelPrefix):
+			// KSM sanitizes the label names, the dashes of the role are turned into underscores
+			role = strings.Replace(strings.TrimPrefix(key, nodeRoleLabelPrefix), "_", "-", -1)
+		case key == legacyNodeRoleLabel:
+			role = value
+		}
+		if role == "" {
+			continue
+		}
+		if role == "master" {
+			role = nodeRoleControlPlane
+		}
+		roles[role] = struct{}{}
+	}
+
+	if len(roles) == 0 {
+		return []string{"kube_node_role:" + nodeRoleWorker}
+	}
+
+	tags := make([]string, 0, len(roles))
+	for role := range roles {
+		tags = append(tags, "kube_node_role:"+role)
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,87 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_nodeRoleTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []string
+	}{
+		{
+			name:   "control plane",
+			labels: map[string]string{"node": "foo", "label_node_role_kubernetes_io_control_plane": ""},
+			want:   []string{"kube_node_role:control-plane"},
+		},
+		{
+			name:   "master and control plane",
+			labels: map[string]string{"node": "foo", "label_node_role_kubernetes_io_master": "", "label_node_role_kubernetes_io_control_plane": ""},
+			want:   []string{"kube_node_role:control-plane"},
+		},
+		{
+			name:   "legacy role label",
+			labels: map[string]string{"node": "foo", "label_kubernetes_io_role": "master"},
+			want:   []string{"kube_node_role:control-plane"},
+		},
+		{
+			name:   "custom roles",
+			labels: map[string]string{"node": "foo", "label_node_role_kubernetes_io_ingress": "true", "label_node_role_kubernetes_io_gpu_worker": ""},
+			want:   []string{"kube_node_role:gpu-worker", "kube_node_role:ingress"},
+		},
+		{
+			name:   "no role",
+			labels: map[string]string{"node": "foo", "label_kubernetes_io_os": "linux"},
+			want:   []string{"kube_node_role:worker"},
+		},
+		{
+			name:   "empty legacy role",
+			labels: map[string]string{"node": "foo", "label_kubernetes_io_role": ""},
+			want:   []string{"kube_node_role:worker"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, nodeRoleTags(tt.labels))
+		})
+	}
+}
+
+func Test_nodePlatformTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []string
+	}{
+		{
+			name:   "os and arch",
+			labels: map[string]string{"node": "foo", "label_kubernetes_io_os": "windows", "label_kubernetes_io_arch": "amd64", "label_beta_kubernetes_io_os": "windows"},
+			want:   []string{"os:windows", "arch:amd64"},
+		},
+		{
+			name:   "beta labels",
+			labels: map[string]string{"node": "foo", "label_beta_kubernetes_io_os": "linux", "label_beta_kubernetes_io_arch": "arm64"},
+			want:   []string{"os:linux", "arch:arm64"},
+		},
+		{
+			name:   "no platform labels",
+			labels: map[string]string{"node": "foo"},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, nodePlatformTags(tt.labels))
+		})
+	}
+}
@@ -0,0 +1,58 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/util/kubernetes"
+)
+
+// ownerTags returns the owner workload tags (e.g. kube_daemon_set:foo) based on
+// the created_by_kind and created_by_name labels of kube_pod_info
+// or the owner_kind and owner_name labels of the owner metrics.
+// A kube_deployment tag is added for the replicasets created by a deployment,
+// the jobs created by a cronjob are reported with the cronjob name.
+func (k *KSMCheck) ownerTags(labels map[string]string) []string {
+	tags := []string{}
+	for _, keys := range [][2]string{{"created_by_kind", "created_by_name"}, {"owner_kind", "owner_name"}} {
+		kind, found := labels[keys[0]]
+		if !found {
+			continue
+		}
+		name, found := labels[keys[1]]
+		if !found || name == "" {
+			continue
+		}
+		tagName, found := ownerKindTags[kind]
+		if !found {
+			continue
+		}
+		if kind == "Job" {
+			if cronjob, isCronJob := normalizeJobName(name); isCronJob {
+				tags = append(tags, k.buildTag("job_name", cronjob), k.buildTag("cronjob", cronjob))
+				continue
+			}
+		}
+		tags = append(tags, tagName+":"+name)
+		if kind == "ReplicaSet" {
+			if deployment := kubernetes.ParseDeploymentForReplicaset(name); deployment != "" {
+				tags = append(tags, "kube_deployment:"+deployment)
+			}
+		}
+	}
+	return tags
+}
+
+// normalizeJobName strips the scheduled time suffix from the name of the jobs created by a cronjob,
+// so the job metrics don't get a new context on every scheduled run.
+// It returns the cronjob name and true, or the unchanged job name and false.
+func normalizeJobName(name string) (string, bool) {
+	if cronjob := kubernetes.ParseCronJobForJob(name); cronjob != "" {
+		return cronjob, true
+	}
+	return name, false
+}
@@ -0,0 +1,105 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ownerTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *KSMConfig
+		labels map[string]string
+		want   []string
+	}{
+		{
+			name:   "daemonset pod",
+			labels: map[string]string{"created_by_kind": "DaemonSet", "created_by_name": "foo"},
+			want:   []string{"kube_daemon_set:foo"},
+		},
+		{
+			name:   "deployment pod",
+			labels: map[string]string{"created_by_kind": "ReplicaSet", "created_by_name": "foo-5d8b9c7f4"},
+			want:   []string{"kube_replica_set:foo-5d8b9c7f4", "kube_deployment:foo"},
+		},
+		{
+			name:   "standalone replicaset pod",
+			labels: map[string]string{"created_by_kind": "ReplicaSet", "created_by_name": "foo"},
+			want:   []string{"kube_replica_set:foo"},
+		},
+		{
+			name:   "replicaset owner",
+			labels: map[string]string{"owner_kind": "Deployment", "owner_name": "foo", "owner_is_controller": "true"},
+			want:   []string{"kube_deployment:foo"},
+		},
+		{
+			name:   "job pod",
+			labels: map[string]string{"created_by_kind": "Job", "created_by_name": "foo"},
+			want:   []string{"kube_job:foo"},
+		},
+		{
+			name:   "cronjob pod",
+			labels: map[string]string{"created_by_kind": "Job", "created_by_name": "foo-1600000000"},
+			want:   []string{"kube_job:foo", "kube_cronjob:foo"},
+		},
+		{
+			name:   "cronjob pod, remapped tags",
+			config: &KSMConfig{LabelsMapper: map[string]string{"job_name": "job", "cronjob": "cron"}},
+			labels: map[string]string{"created_by_kind": "Job", "created_by_name": "foo-1600000000"},
+			want:   []string{"job:foo", "cron:foo"},
+		},
+		{
+			name:   "no owner",
+			labels: map[string]string{"owner_kind": "<none>", "owner_name": "<none>", "owner_is_controller": "<none>"},
+			want:   []string{},
+		},
+		{
+			name:   "missing name",
+			labels: map[string]string{"created_by_kind": "Job"},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			if config == nil {
+				config = &KSMConfig{LabelsMapper: defaultLabelsMapper}
+			}
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), config)
+			assert.ElementsMatch(t, tt.want, k.ownerTags(tt.labels))
+		})
+	}
+}
+
+func Test_normalizeJobName(t *testing.T) {
+	tests := []struct {
+		name       string
+		job        string
+		want       string
+		isFromCron bool
+	}{
+		{name: "cronjob job", job: "hello-1562319360", want: "hello", isFromCron: true},
+		{name: "cronjob job with dashes", job: "hello-world-1562319360", want: "hello-world", isFromCron: true},
+		{name: "manual job", job: "hello-world", want: "hello-world", isFromCron: false},
+		{name: "short suffix", job: "hello-12", want: "hello-12", isFromCron: false},
+		{name: "no dash", job: "hello1562319360", want: "hello1562319360", isFromCron: false},
+		{name: "leading dash", job: "-1562319360", want: "-1562319360", isFromCron: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, isFromCron := normalizeJobName(tt.job)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.isFromCron, isFromCron)
+		})
+	}
+}
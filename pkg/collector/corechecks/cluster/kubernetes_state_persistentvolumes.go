@@ -0,0 +1,105 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+)
+
+// defaultPersistentVolumePendingTimeout is the default duration after which a pending persistent volume is reported, in seconds
+const defaultPersistentVolumePendingTimeout = 600
+
+// persistentVolumeState contains the phase of a persistent volume seen during a check run
+// and the time it was first seen pending, kept between check runs
+type persistentVolumeState struct {
+	tags  []string
+	phase string
+	// seen is true when the persistent volume was seen during the current run
+	seen bool
+	// pendingSince is the time the persistent volume was first seen pending, zero otherwise
+	pendingSince time.Time
+}
+
+// pvStatusPhaseTransformer counts the persistent volumes per storage class and phase in persistentvolumes.by_phase
+// based on kube_persistentvolume_status_phase, the storage class is joined from kube_persistentvolume_info.
+// It keeps the phase of the persistent volumes for the persistentvolume.status service check submitted at the end of the run.
+// KSM generates one metric per phase (pending, available, bound, released, failed), only the current one is equal to 1
+func pvStatusPhaseTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	phase, found := metric.Labels["phase"]
+	if !found {
+		k.missingLabel(name, "phase")
+		return
+	}
+	phase = strings.ToLower(phase)
+	countTags := []string{"phase:" + phase}
+	if storageClass, found := tagValue(tags, "storageclass"); found {
+		countTags = append(countTags, "storageclass:"+storageClass)
+	}
+	s.Count(k.metricName("persistentvolumes.by_phase"), metric.Val, hostname, countTags)
+
+	volume, found := metric.Labels["persistentvolume"]
+	if !found {
+		k.missingLabel(name, "persistentvolume")
+		return
+	}
+	if metric.Val != 1.0 {
+		return
+	}
+	state, found := k.persistentVolumes[volume]
+	if !found {
+		state = &persistentVolumeState{}
+		k.persistentVolumes[volume] = state
+	}
+	state.tags = removeTag(tags, k.tagKey("phase"))
+	state.phase = phase
+	state.seen = true
+}
+
+// processPersistentVolumes submits the persistentvolume.status service check of the persistent volumes seen during the run
+// It's CRITICAL for the failed volumes, which failed their automatic reclamation,
+// and WARNING for the volumes pending for more than persistentvolume_pending_timeout
+func (k *KSMCheck) processPersistentVolumes(s aggregator.Sender) {
+	now := time.Now()
+	for volume, state := range k.persistentVolumes {
+		if !state.seen {
+			// The persistent volume is deleted
+			delete(k.persistentVolumes, volume)
+			continue
+		}
+		state.seen = false
+
+		if state.phase != "pending" {
+			state.pendingSince = time.Time{}
+		}
+
+		switch state.phase {
+		case "failed":
+			message := fmt.Sprintf("Persistent volume %s is Failed", volume)
+			k.submitServiceCheck(s, "persistentvolume.status", metrics.ServiceCheckCritical, "", state.tags, message)
+		case "pending":
+			if state.pendingSince.IsZero() {
+				state.pendingSince = now
+			}
+			pending := now.Sub(state.pendingSince)
+			if pending <= time.Duration(k.instance.PersistentVolumePendingTimeout)*time.Second {
+				k.submitServiceCheck(s, "persistentvolume.status", metrics.ServiceCheckOK, "", state.tags, "")
+				continue
+			}
+			message := fmt.Sprintf("Persistent volume %s Pending for %s", volume, pending.Round(time.Second))
+			k.submitServiceCheck(s, "persistentvolume.status", metrics.ServiceCheckWarning, "", state.tags, message)
+		default:
+			k.submitServiceCheck(s, "persistentvolume.status", metrics.ServiceCheckOK, "", state.tags, "")
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_pvStatusPhaseTransformer(t *testing.T) {
+	RunTransformerTests(t, pvStatusPhaseTransformer, []TransformerTestCase{
+		{
+			Name:       "bound",
+			MetricName: "kube_persistentvolume_status_phase",
+			Metric: ksmstore.DDMetric{
+				Labels: map[string]string{"persistentvolume": "foo", "phase": "Bound"},
+				Val:    1,
+			},
+			Tags: []string{"persistentvolume:foo", "phase:Bound", "storageclass:standard"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.persistentvolumes.by_phase", Value: 1, Tags: []string{"phase:bound", "storageclass:standard"}},
+			},
+		},
+		{
+			Name:       "inactive phase without storage class",
+			MetricName: "kube_persistentvolume_status_phase",
+			Metric: ksmstore.DDMetric{
+				Labels: map[string]string{"persistentvolume": "foo", "phase": "Failed"},
+				Val:    0,
+			},
+			Tags: []string{"persistentvolume:foo", "phase:Failed"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.persistentvolumes.by_phase", Value: 0, Tags: []string{"phase:failed"}},
+			},
+		},
+		{
+			Name:       "missing phase",
+			MetricName: "kube_persistentvolume_status_phase",
+			Metric: ksmstore.DDMetric{
+				Labels: map[string]string{"persistentvolume": "foo"},
+				Val:    1,
+			},
+			Tags: []string{"persistentvolume:foo"},
+		},
+	})
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+	tags := []string{"persistentvolume:foo", "phase:Failed", "storageclass:standard"}
+	pvStatusPhaseTransformer(k, s, "kube_persistentvolume_status_phase", ksmstore.DDMetric{Val: 0, Labels: map[string]string{"persistentvolume": "foo", "phase": "Bound"}}, "", tags)
+	pvStatusPhaseTransformer(k, s, "kube_persistentvolume_status_phase", ksmstore.DDMetric{Val: 1, Labels: map[string]string{"persistentvolume": "foo", "phase": "Failed"}}, "", tags)
+
+	state := k.persistentVolumes["foo"]
+	assert.NotNil(t, state)
+	assert.True(t, state.seen)
+	assert.Equal(t, "failed", state.phase)
+	assert.Equal(t, []string{"persistentvolume:foo", "storageclass:standard"}, state.tags)
+}
+
+func TestKSMCheck_processPersistentVolumes(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	k.persistentVolumes = map[string]*persistentVolumeState{
+		"bound":   {tags: []string{"persistentvolume:bound"}, phase: "bound", seen: true, pendingSince: time.Now().Add(-time.Hour)},
+		"pending": {tags: []string{"persistentvolume:pending"}, phase: "pending", seen: true},
+		"stuck":   {tags: []string{"persistentvolume:stuck"}, phase: "pending", seen: true, pendingSince: time.Now().Add(-15 * time.Minute)},
+		"failed":  {tags: []string{"persistentvolume:failed"}, phase: "failed", seen: true},
+		"deleted": {tags: []string{"persistentvolume:deleted"}, phase: "failed"},
+	}
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	k.processPersistentVolumes(s)
+
+	s.AssertServiceCheck(t, "kubernetes_state.persistentvolume.status", metrics.ServiceCheckOK, "", []string{"persistentvolume:bound"}, "")
+	s.AssertServiceCheck(t, "kubernetes_state.persistentvolume.status", metrics.ServiceCheckOK, "", []string{"persistentvolume:pending"}, "")
+	s.AssertServiceCheck(t, "kubernetes_state.persistentvolume.status", metrics.ServiceCheckWarning, "", []string{"persistentvolume:stuck"}, "Persistent volume stuck Pending for 15m0s")
+	s.AssertServiceCheck(t, "kubernetes_state.persistentvolume.status", metrics.ServiceCheckCritical, "", []string{"persistentvolume:failed"}, "Persistent volume failed is Failed")
+	s.AssertNumberOfCalls(t, "ServiceCheck", 4)
+
+	assert.NotContains(t, k.persistentVolumes, "deleted")
+	assert.True(t, k.persistentVolumes["bound"].pendingSince.IsZero())
+	assert.False(t, k.persistentVolumes["pending"].pendingSince.IsZero())
+	for _, state := range k.persistentVolumes {
+		assert.False(t, state.seen)
+	}
+}
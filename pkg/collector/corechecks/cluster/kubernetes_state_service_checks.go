@@ -0,0 +1,69 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"regexp"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+)
+
+// droppedDuplicateServiceCheck is recorded when a service check already sent during the run
+const droppedDuplicateServiceCheck = "duplicate_service_check"
+
+// transformerServiceCheck describes a service check sent by the transformers
+type transformerServiceCheck struct {
+	// name is the name of the service check, without the metric prefix
+	name string
+	// statusLabels contains the labels describing the status itself (e.g. condition, phase)
+	// their tags are removed to keep a single context per object
+	statusLabels []string
+	// message is sent with the statuses other than OK, the {label} placeholders are replaced by the metric labels,
+	// e.g. "Persistent volume claim {namespace}/{persistentvolumeclaim} is {phase}"
+	message string
+}
+
+// messagePlaceholderRegex matches the {label} placeholders of the service check messages
+var messagePlaceholderRegex = regexp.MustCompile(`{[a-z_]+}`)
+
+// formatMessage replaces the placeholders of the check message by the labels of the metric
+// The placeholders of missing labels are replaced by an empty string
+func (c transformerServiceCheck) formatMessage(labels map[string]string) string {
+	return messagePlaceholderRegex.ReplaceAllStringFunc(c.message, func(placeholder string) string {
+		return labels[placeholder[1:len(placeholder)-1]]
+	})
+}
+
+// sendServiceCheck sends a service check generated by a transformer from a metric, see submitServiceCheck
+func (k *KSMCheck) sendServiceCheck(s aggregator.Sender, c transformerServiceCheck, metric ksmstore.DDMetric, status metrics.ServiceCheckStatus, hostname string, tags []string) {
+	for _, label := range c.statusLabels {
+		tags = removeTag(tags, k.tagKey(label))
+	}
+
+	message := ""
+	if status != metrics.ServiceCheckOK {
+		message = c.formatMessage(metric.Labels)
+	}
+	k.submitServiceCheck(s, c.name, status, hostname, tags, message)
+}
+
+// submitServiceCheck sends a service check, the name is given without the metric prefix
+// A service check is sent once per run for a given hostname and tags, the next ones are recorded as dropped:
+// several metrics, families or processors can describe the same object
+func (k *KSMCheck) submitServiceCheck(s aggregator.Sender, name string, status metrics.ServiceCheckStatus, hostname string, tags []string, message string) {
+	key := k.metricName(name) + "|" + contextKey(hostname, tags)
+	if _, sent := k.sentServiceChecks[key]; sent {
+		k.dropped(name, droppedDuplicateServiceCheck, hostname)
+		return
+	}
+	k.sentServiceChecks[key] = struct{}{}
+
+	s.ServiceCheck(k.metricName(name), status, hostname, tags, message)
+}
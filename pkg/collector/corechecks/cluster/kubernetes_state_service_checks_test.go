@@ -0,0 +1,109 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_transformerServiceCheck_formatMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		labels   map[string]string
+		expected string
+	}{
+		{
+			name:     "no placeholder",
+			message:  "Job failed",
+			labels:   map[string]string{"job_name": "foo"},
+			expected: "Job failed",
+		},
+		{
+			name:     "placeholders",
+			message:  "Job {namespace}/{job_name} failed",
+			labels:   map[string]string{"job_name": "foo", "namespace": "default"},
+			expected: "Job default/foo failed",
+		},
+		{
+			name:     "missing label",
+			message:  "Job {namespace}/{job_name} failed",
+			labels:   map[string]string{"job_name": "foo"},
+			expected: "Job /foo failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, transformerServiceCheck{message: tt.message}.formatMessage(tt.labels))
+		})
+	}
+}
+
+func TestKSMCheck_sendServiceCheck(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper})
+	k.droppedMetricsOutput = &bytes.Buffer{}
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	c := pvcStatusServiceCheck
+	metric := ksmstore.DDMetric{Val: 1, Labels: map[string]string{"persistentvolumeclaim": "data", "namespace": "default", "phase": "Pending"}}
+
+	// The status tags are removed, the message is only sent with the statuses other than OK
+	k.sendServiceCheck(s, c, metric, metrics.ServiceCheckWarning, "", []string{"persistentvolumeclaim:data", "kube_namespace:default", "pvc_phase:Pending"})
+	s.AssertServiceCheck(t, "kubernetes_state.persistentvolumeclaim.status", metrics.ServiceCheckWarning, "", []string{"persistentvolumeclaim:data", "kube_namespace:default"}, "Persistent volume claim default/data is Pending")
+	s.AssertNotCalled(t, "ServiceCheck", "kubernetes_state.persistentvolumeclaim.status", metrics.ServiceCheckWarning, "", mocksender.MatchTagsContains([]string{"pvc_phase:Pending"}), "Persistent volume claim default/data is Pending")
+
+	// The same context is sent once per run, whatever the order of the tags
+	k.sendServiceCheck(s, c, metric, metrics.ServiceCheckOK, "", []string{"kube_namespace:default", "persistentvolumeclaim:data"})
+	s.AssertNumberOfCalls(t, "ServiceCheck", 1)
+	assert.Equal(t, 1, k.droppedMetrics[droppedMetric{name: "persistentvolumeclaim.status", reason: droppedDuplicateServiceCheck}])
+
+	// Another object is sent
+	other := ksmstore.DDMetric{Val: 1, Labels: map[string]string{"persistentvolumeclaim": "logs", "namespace": "default", "phase": "Bound"}}
+	k.sendServiceCheck(s, c, other, metrics.ServiceCheckOK, "", []string{"persistentvolumeclaim:logs", "kube_namespace:default", "pvc_phase:Bound"})
+	s.AssertServiceCheck(t, "kubernetes_state.persistentvolumeclaim.status", metrics.ServiceCheckOK, "", []string{"persistentvolumeclaim:logs", "kube_namespace:default"}, "")
+	s.AssertNumberOfCalls(t, "ServiceCheck", 2)
+
+	// The contexts are sent again during the next run
+	k.endRun()
+	k.sendServiceCheck(s, c, metric, metrics.ServiceCheckOK, "", []string{"persistentvolumeclaim:data", "kube_namespace:default", "pvc_phase:Bound"})
+	s.AssertNumberOfCalls(t, "ServiceCheck", 3)
+}
+
+func TestKSMCheck_submitServiceCheck(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	k.droppedMetricsOutput = &bytes.Buffer{}
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	// The service checks of the processors are deduplicated like the ones of the transformers
+	tags := []string{"kube_namespace:default", "kube_deployment:foo"}
+	k.submitServiceCheck(s, "deployment.available", metrics.ServiceCheckWarning, "", tags, "2/3 replicas available for 6m0s")
+	k.submitServiceCheck(s, "deployment.available", metrics.ServiceCheckOK, "", []string{"kube_deployment:foo", "kube_namespace:default"}, "")
+	s.AssertServiceCheck(t, "kubernetes_state.deployment.available", metrics.ServiceCheckWarning, "", tags, "2/3 replicas available for 6m0s")
+	s.AssertNumberOfCalls(t, "ServiceCheck", 1)
+	assert.Equal(t, 1, k.droppedMetrics[droppedMetric{name: "deployment.available", reason: droppedDuplicateServiceCheck}])
+
+	// Another service check of the same object is sent
+	k.submitServiceCheck(s, "deployment.rollout", metrics.ServiceCheckOK, "", tags, "")
+	s.AssertServiceCheck(t, "kubernetes_state.deployment.rollout", metrics.ServiceCheckOK, "", tags, "")
+	s.AssertNumberOfCalls(t, "ServiceCheck", 2)
+
+	// The contexts are sent again during the next run
+	k.endRun()
+	k.submitServiceCheck(s, "deployment.available", metrics.ServiceCheckOK, "", tags, "")
+	s.AssertNumberOfCalls(t, "ServiceCheck", 3)
+}
@@ -0,0 +1,43 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"time"
+)
+
+// minStaggerInterval is the minimum interval between the calls of Run of a staggered run, the scheduler rejects shorter intervals
+const minStaggerInterval = time.Second
+
+// staggerSlots returns the number of calls of Run a check run is split into with stagger_collectors
+// Each call processes the next stores and returns, so the runner worker isn't blocked between the stores.
+// It's 1 when stagger_collectors isn't enabled or when there's a single store, e.g. with kube_state_url
+func (k *KSMCheck) staggerSlots() int {
+	if !k.instance.StaggerCollectors || k.scraper != nil || len(k.store) < 2 {
+		return 1
+	}
+	slots := int(k.CheckBase.Interval() / minStaggerInterval)
+	if slots > len(k.store) {
+		slots = len(k.store)
+	}
+	if slots < 1 {
+		return 1
+	}
+	return slots
+}
+
+// Interval returns the interval between the calls of Run, it's shortened with stagger_collectors
+// so that a run spread over several calls still completes within the interval of the check
+func (k *KSMCheck) Interval() time.Duration {
+	return k.CheckBase.Interval() / time.Duration(k.staggerSlots())
+}
+
+// Stop closes the dry_run_file
+func (k *KSMCheck) Stop() {
+	k.stopOnce.Do(k.closeDryRunFile)
+}
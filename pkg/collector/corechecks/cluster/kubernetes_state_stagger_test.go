@@ -0,0 +1,122 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	"github.com/DataDog/datadog-agent/pkg/collector/check/defaults"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kube-state-metrics/pkg/metric"
+)
+
+func TestKSMCheck_staggerSlots(t *testing.T) {
+	tests := []struct {
+		name             string
+		stagger          bool
+		stores           int
+		expectedSlots    int
+		expectedInterval time.Duration
+	}{
+		{
+			name:             "disabled",
+			stagger:          false,
+			stores:           4,
+			expectedSlots:    1,
+			expectedInterval: defaults.DefaultCheckInterval,
+		},
+		{
+			name:             "single store",
+			stagger:          true,
+			stores:           1,
+			expectedSlots:    1,
+			expectedInterval: defaults.DefaultCheckInterval,
+		},
+		{
+			name:             "four stores",
+			stagger:          true,
+			stores:           4,
+			expectedSlots:    4,
+			expectedInterval: defaults.DefaultCheckInterval / 4,
+		},
+		{
+			name:             "more stores than seconds in the interval",
+			stagger:          true,
+			stores:           20,
+			expectedSlots:    int(defaults.DefaultCheckInterval / time.Second),
+			expectedInterval: time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{StaggerCollectors: tt.stagger})
+			k.store = make([]cache.Store, tt.stores)
+			assert.Equal(t, tt.expectedSlots, k.staggerSlots())
+			assert.Equal(t, tt.expectedInterval, k.Interval())
+		})
+	}
+}
+
+func TestKSMCheck_RunStaggered(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		o, _ := meta.Accessor(obj)
+		return []metric.FamilyInterface{&metric.Family{
+			Name:    "kube_deployment_spec_paused",
+			Metrics: []*metric.Metric{{LabelKeys: []string{"deployment"}, LabelValues: []string{o.GetName()}, Value: 1}},
+		}}
+	}
+	deployment := func(uid, name, resourceVersion string) *appsv1.Deployment {
+		return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), Name: name, ResourceVersion: resourceVersion}}
+	}
+	first := ksmstore.NewMetricsStore(genFunc, "*v1.Deployment")
+	second := ksmstore.NewMetricsStore(genFunc, "*v1.Deployment")
+	assert.NoError(t, first.Replace([]interface{}{deployment("123", "foo", "1")}, ""))
+	assert.NoError(t, second.Replace([]interface{}{deployment("456", "bar", "1")}, ""))
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{StaggerCollectors: true})
+	k.store = []cache.Store{first, second}
+	k.storesStarted = time.Now()
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+	paused := func(deployment string) interface{} {
+		return mock.MatchedBy(func(tags []string) bool {
+			for _, tag := range tags {
+				if tag == "deployment:"+deployment {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	// The first call only processes the first store, the run isn't complete yet
+	assert.NoError(t, k.Run())
+	s.AssertCalled(t, "Gauge", "kubernetes_state.deployment.paused", 1.0, "", paused("foo"))
+	s.AssertNotCalled(t, "Gauge", "kubernetes_state.deployment.paused", 1.0, "", paused("bar"))
+	s.AssertNotCalled(t, "Gauge", "kubernetes_state.telemetry.run_duration", mock.Anything, "", mock.Anything)
+	assert.NotNil(t, k.run)
+
+	// The second store is pushed when it's processed
+	assert.NoError(t, second.Update(deployment("456", "baz", "2")))
+	assert.NoError(t, k.Run())
+	s.AssertCalled(t, "Gauge", "kubernetes_state.deployment.paused", 1.0, "", paused("baz"))
+	s.AssertNotCalled(t, "Gauge", "kubernetes_state.deployment.paused", 1.0, "", paused("bar"))
+	s.AssertCalled(t, "Gauge", "kubernetes_state.telemetry.run_duration", mock.Anything, "", mock.Anything)
+	assert.Nil(t, k.run)
+}
@@ -0,0 +1,43 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"github.com/DataDog/datadog-agent/pkg/tagger"
+	"github.com/DataDog/datadog-agent/pkg/util/containers"
+	"github.com/DataDog/datadog-agent/pkg/util/kubernetes/kubelet"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// podInfoFamily is the KSM family joined to get the uid of the pods for the tagger
+const podInfoFamily = "kube_pod_info"
+
+// taggerTagsFunc is used to query the tagger, it's overridden in tests
+var taggerTagsFunc = tagger.Tag
+
+// taggerEntity returns the tagger entity of the container or the pod a KSM metric is about based on its labels,
+// or an empty string if the metric isn't about a container nor a pod
+func taggerEntity(labels map[string]string) string {
+	if containerID, found := labels["container_id"]; found {
+		// KSM reports the container IDs with their runtime (e.g. docker://<id>)
+		if _, id := containers.SplitEntityName(containerID); id != "" {
+			return containers.BuildTaggerEntityName(id)
+		}
+	}
+	return kubelet.PodUIDToTaggerEntityName(labels["uid"])
+}
+
+// taggerTags returns the tags of a tagger entity at the checks_tag_cardinality of the agent
+func (k *KSMCheck) taggerTags(entity string) []string {
+	tags, err := taggerTagsFunc(entity, tagger.ChecksCardinality)
+	if err != nil {
+		log.Debugf("Cannot get the tags of %s from the tagger: %s", entity, err)
+		return nil
+	}
+	return tags
+}
@@ -0,0 +1,117 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/tagger"
+	"github.com/DataDog/datadog-agent/pkg/tagger/collectors"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_taggerEntity(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{
+			name:   "container",
+			labels: map[string]string{"pod": "foo", "uid": "1234", "container_id": "docker://5678"},
+			want:   "container_id://5678",
+		},
+		{
+			name:   "invalid container id",
+			labels: map[string]string{"pod": "foo", "uid": "1234", "container_id": "5678"},
+			want:   "kubernetes_pod_uid://1234",
+		},
+		{
+			name:   "pod",
+			labels: map[string]string{"pod": "foo", "uid": "1234"},
+			want:   "kubernetes_pod_uid://1234",
+		},
+		{
+			name:   "not a pod",
+			labels: map[string]string{"deployment": "foo"},
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, taggerEntity(tt.labels))
+		})
+	}
+}
+
+func TestKSMCheck_joinLabels_taggerTags(t *testing.T) {
+	taggerTagsFunc = func(entity string, cardinality collectors.TagCardinality) ([]string, error) {
+		switch entity {
+		case "kubernetes_pod_uid://1234":
+			return []string{"kube_deployment:foo", "env:prod"}, nil
+		case "container_id://5678":
+			return []string{"kube_deployment:foo", "env:prod", "image_tag:1.0"}, nil
+		}
+		return nil, fmt.Errorf("unknown entity %s", entity)
+	}
+	defer func() { taggerTagsFunc = tagger.Tag }()
+
+	podInfo := []ksmstore.DDMetricsFam{
+		{
+			Name: "kube_pod_info",
+			ListMetrics: []ksmstore.DDMetric{
+				{Labels: map[string]string{"pod": "foo-abcde", "namespace": "default", "uid": "1234", "node": "node1"}},
+				{Labels: map[string]string{"pod": "bar", "namespace": "default", "uid": "9999", "node": "node1"}},
+			},
+		},
+	}
+	tests := []struct {
+		name       string
+		taggerTags bool
+		labels     map[string]string
+		wantTags   []string
+	}{
+		{
+			name:       "pod metric joined with kube_pod_info",
+			taggerTags: true,
+			labels:     map[string]string{"pod": "foo-abcde", "namespace": "default"},
+			wantTags:   []string{"pod_name:foo-abcde", "kube_namespace:default", "host:node1", "kube_deployment:foo", "env:prod"},
+		},
+		{
+			name:       "container metric",
+			taggerTags: true,
+			labels:     map[string]string{"pod": "foo-abcde", "namespace": "default", "container_id": "docker://5678"},
+			wantTags:   []string{"pod_name:foo-abcde", "kube_namespace:default", "container_id:docker://5678", "host:node1", "kube_deployment:foo", "env:prod", "image_tag:1.0"},
+		},
+		{
+			name:       "unknown pod",
+			taggerTags: true,
+			labels:     map[string]string{"pod": "bar", "namespace": "default"},
+			wantTags:   []string{"pod_name:bar", "kube_namespace:default", "host:node1"},
+		},
+		{
+			name:     "disabled",
+			labels:   map[string]string{"pod": "foo-abcde", "namespace": "default"},
+			wantTags: []string{"pod_name:foo-abcde", "kube_namespace:default", "host:node1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{
+				LabelsMapper: defaultLabelsMapper,
+				LabelJoins:   map[string]*JoinsConfig{"kube_pod_info": defaultLabelJoins["kube_pod_info"]},
+				TaggerTags:   tt.taggerTags,
+			})
+			assert.ElementsMatch(t, tt.wantTags, k.joinLabels(tt.labels, podInfo))
+		})
+	}
+}
@@ -0,0 +1,205 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/DataDog/datadog-agent/pkg/telemetry"
+	"github.com/DataDog/datadog-agent/pkg/util/log"
+)
+
+// Reasons for which a KSM metric isn't processed as expected by the check
+const (
+	// unprocessedUnmapped is used for metrics without name mapping nor transformer, they're sent with their KSM name
+	unprocessedUnmapped = "unmapped"
+	// unprocessedFiltered is used for metrics dropped by a transformer because of a label value (e.g. unsupported resource)
+	unprocessedFiltered = "filtered"
+	// unprocessedMissingLabel is used for metrics dropped by a transformer because an expected label is missing
+	unprocessedMissingLabel = "missing_label"
+)
+
+// droppedZeroValue is used for metrics ignored by a transformer because they're not active (e.g. a condition not met)
+// They're expected and not accounted for as unprocessed, they're only reported by droppedMetricsOutput
+const droppedZeroValue = "zero_value"
+
+var tlmUnprocessedMetrics = telemetry.NewCounter("kubernetes_state", "unprocessed_metrics",
+	[]string{"metric_name", "reason"}, "Number of KSM metrics not processed as expected by the check")
+
+// unprocessedMetric is the context of the unprocessed metrics count
+type unprocessedMetric struct {
+	name   string
+	reason string
+}
+
+// droppedMetric is the context of the dropped metrics count, detail is the missing label or the filtered value
+type droppedMetric struct {
+	name   string
+	reason string
+	detail string
+}
+
+// unprocessed accounts for a KSM metric that couldn't be processed as expected
+// The counts are sent with the check metrics at the end of the run and to the agent telemetry
+func (k *KSMCheck) unprocessed(name, reason, detail string) {
+	log.Tracef("Ignoring metric '%s': %s %s", name, reason, detail)
+	tlmUnprocessedMetrics.Inc(name, reason)
+	k.unprocessedMetrics[unprocessedMetric{name: name, reason: reason}]++
+	k.dropped(name, reason, detail)
+}
+
+// missingLabel accounts for a KSM metric dropped because the given label is missing
+func (k *KSMCheck) missingLabel(name, label string) {
+	k.unprocessed(name, unprocessedMissingLabel, label)
+}
+
+// dropped records why a transformer dropped a KSM metric for the summary written at the end of the run
+func (k *KSMCheck) dropped(name, reason, detail string) {
+	if k.droppedMetricsOutput == nil {
+		return
+	}
+	k.droppedMetrics[droppedMetric{name: name, reason: reason, detail: detail}]++
+}
+
+// writeDroppedMetrics writes the summary of the metrics dropped during the run if enabled and resets it
+func (k *KSMCheck) writeDroppedMetrics() {
+	if k.droppedMetricsOutput == nil {
+		return
+	}
+
+	dropped := make([]droppedMetric, 0, len(k.droppedMetrics))
+	for m := range k.droppedMetrics {
+		dropped = append(dropped, m)
+	}
+	sort.Slice(dropped, func(i, j int) bool {
+		if dropped[i].name != dropped[j].name {
+			return dropped[i].name < dropped[j].name
+		}
+		if dropped[i].reason != dropped[j].reason {
+			return dropped[i].reason < dropped[j].reason
+		}
+		return dropped[i].detail < dropped[j].detail
+	})
+
+	w := tabwriter.NewWriter(k.droppedMetricsOutput, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METRIC\tREASON\tDETAIL\tCOUNT") //nolint:errcheck
+	for _, m := range dropped {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", m.name, m.reason, m.detail, k.droppedMetrics[m]) //nolint:errcheck
+	}
+	w.Flush() //nolint:errcheck
+
+	k.droppedMetrics = make(map[droppedMetric]int)
+}
+
+// sendTelemetry sends the check telemetry collected during the run and resets it
+func (k *KSMCheck) sendTelemetry(s aggregator.Sender) {
+	for m, count := range k.unprocessedMetrics {
+		s.Count(k.metricName("telemetry.unprocessed_metrics"), count, "", []string{"metric_name:" + m.name, "reason:" + m.reason})
+	}
+	k.unprocessedMetrics = make(map[unprocessedMetric]float64)
+
+	for reason, count := range k.droppedEvents {
+		s.Count(k.metricName("telemetry.events_dropped"), count, "", []string{"reason:" + reason})
+	}
+	k.droppedEvents = make(map[string]float64)
+}
+
+// sendStoreTelemetry sends the number of objects and metrics held by each store
+func (k *KSMCheck) sendStoreTelemetry(s aggregator.Sender) {
+	for _, store := range k.store {
+		metricsStore := store.(*ksmstore.MetricsStore)
+		objects, metrics := metricsStore.Size()
+		tags := []string{"resource_type:" + storeCollector(metricsStore)}
+		s.Gauge(k.metricName("telemetry.store.objects"), float64(objects), "", tags)
+		s.Gauge(k.metricName("telemetry.store.metrics"), float64(metrics), "", tags)
+	}
+}
+
+// telemetryTopFamilies is the number of metric families whose processing time is reported at the end of each run
+const telemetryTopFamilies = 10
+
+// countingSender counts the metric points submitted through it
+type countingSender struct {
+	aggregator.Sender
+	points int
+}
+
+func (s *countingSender) Gauge(metric string, value float64, hostname string, tags []string) {
+	s.points++
+	s.Sender.Gauge(metric, value, hostname, tags)
+}
+
+func (s *countingSender) GaugeWithTimestamp(metric string, value float64, hostname string, tags []string, timestamp float64) {
+	s.points++
+	s.Sender.GaugeWithTimestamp(metric, value, hostname, tags, timestamp)
+}
+
+func (s *countingSender) Rate(metric string, value float64, hostname string, tags []string) {
+	s.points++
+	s.Sender.Rate(metric, value, hostname, tags)
+}
+
+func (s *countingSender) Count(metric string, value float64, hostname string, tags []string) {
+	s.points++
+	s.Sender.Count(metric, value, hostname, tags)
+}
+
+func (s *countingSender) MonotonicCount(metric string, value float64, hostname string, tags []string) {
+	s.points++
+	s.Sender.MonotonicCount(metric, value, hostname, tags)
+}
+
+func (s *countingSender) Counter(metric string, value float64, hostname string, tags []string) {
+	s.points++
+	s.Sender.Counter(metric, value, hostname, tags)
+}
+
+func (s *countingSender) Histogram(metric string, value float64, hostname string, tags []string) {
+	s.points++
+	s.Sender.Histogram(metric, value, hostname, tags)
+}
+
+func (s *countingSender) Historate(metric string, value float64, hostname string, tags []string) {
+	s.points++
+	s.Sender.Historate(metric, value, hostname, tags)
+}
+
+func (s *countingSender) SubmitBatch(samples []*metrics.MetricSample) {
+	s.points += len(samples)
+	s.Sender.SubmitBatch(samples)
+}
+
+// sendRunTelemetry sends the duration of the run, the number of metric families processed and of points submitted,
+// and the processing time of the slowest metric families, then resets the processing times
+func (k *KSMCheck) sendRunTelemetry(s aggregator.Sender, points int, duration time.Duration) {
+	s.Gauge(k.metricName("telemetry.run_duration"), duration.Seconds(), "", nil)
+	s.Gauge(k.metricName("telemetry.families_processed"), float64(len(k.familyDurations)), "", nil)
+	s.Gauge(k.metricName("telemetry.points_submitted"), float64(points), "", nil)
+
+	families := make([]string, 0, len(k.familyDurations))
+	for name := range k.familyDurations {
+		families = append(families, name)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return k.familyDurations[families[i]] > k.familyDurations[families[j]]
+	})
+	if len(families) > telemetryTopFamilies {
+		families = families[:telemetryTopFamilies]
+	}
+	for _, name := range families {
+		s.Gauge(k.metricName("telemetry.family_processing_time"), k.familyDurations[name].Seconds(), "", []string{"metric_name:" + name})
+	}
+
+	k.familyDurations = make(map[string]time.Duration)
+}
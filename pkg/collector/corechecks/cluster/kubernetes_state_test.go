@@ -8,14 +8,30 @@
 package cluster
 
 import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/aggregator"
 	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	"github.com/DataDog/datadog-agent/pkg/collector/check/defaults"
 	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/DataDog/datadog-agent/pkg/config"
+	"github.com/DataDog/datadog-agent/pkg/kubestatemetrics/scraper"
 	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kube-state-metrics/pkg/metric"
 )
 
 type metricsExpected struct {
@@ -103,7 +119,7 @@ func TestProcessMetrics(t *testing.T) {
 				{
 					name: "kubernetes_state.container.running",
 					val:  1,
-					tags: []string{"kube_container_name:kube-state-metrics", "kube_namespace:default", "pod_name:kube-state-metrics-b7fbc487d-4phhj", "host:minikube"},
+					tags: []string{"kube_container_name:kube-state-metrics", "kube_namespace:default", "pod_name:kube-state-metrics-b7fbc487d-4phhj", "host:minikube", "created_by_kind:ReplicaSet", "created_by_name:kube-state-metrics-b7fbc487d", "kube_replica_set:kube-state-metrics-b7fbc487d", "kube_deployment:kube-state-metrics", "host_ip:192.168.99.100"},
 				},
 			},
 		},
@@ -160,6 +176,50 @@ func TestProcessMetrics(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:   "custom metric prefix",
+			config: &KSMConfig{LabelsMapper: defaultLabelsMapper, MetricPrefix: "kube_state."},
+			metricsToProcess: map[string][]ksmstore.DDMetricsFam{
+				"kube_pod_container_status_running": {
+					{
+						Type: "*v1.Pod",
+						Name: "kube_pod_container_status_running",
+						ListMetrics: []ksmstore.DDMetric{
+							{
+								Labels: map[string]string{"container": "kube-state-metrics", "namespace": "default", "pod": "kube-state-metrics-b7fbc487d-4phhj"},
+								Val:    1,
+							},
+						},
+					},
+				},
+				"kube_resourcequota": {
+					{
+						Type: "*v1.ResourceQuota",
+						Name: "kube_resourcequota",
+						ListMetrics: []ksmstore.DDMetric{
+							{
+								Labels: map[string]string{"namespace": "default", "resource": "pods", "type": "hard", "resourcequota": "gke-resource-quotas"},
+								Val:    15000,
+							},
+						},
+					},
+				},
+			},
+			metricsToGet:       []ksmstore.DDMetricsFam{},
+			metricTransformers: metricTransformers,
+			expected: []metricsExpected{
+				{
+					name: "kube_state.container.running",
+					val:  1,
+					tags: []string{"kube_container_name:kube-state-metrics", "kube_namespace:default", "pod_name:kube-state-metrics-b7fbc487d-4phhj"},
+				},
+				{
+					name: "kube_state.resourcequota.pods.limit",
+					val:  15000,
+					tags: []string{"kube_namespace:default", "resourcequota:gke-resource-quotas"},
+				},
+			},
+		},
 		{
 			name:   "honour metric transformers",
 			config: &KSMConfig{LabelsMapper: defaultLabelsMapper},
@@ -179,7 +239,7 @@ func TestProcessMetrics(t *testing.T) {
 			},
 			metricsToGet: []ksmstore.DDMetricsFam{},
 			metricTransformers: map[string]metricTransformerFunc{
-				"kube_pod_status_phase": func(s aggregator.Sender, n string, m ksmstore.DDMetric, t []string) {
+				"kube_pod_status_phase": func(k *KSMCheck, s aggregator.Sender, n string, m ksmstore.DDMetric, h string, t []string) {
 					s.Gauge("kube_pod_status_phase_transformed", 1, "", []string{"transformed:tag"})
 				},
 			},
@@ -192,6 +252,8 @@ func TestProcessMetrics(t *testing.T) {
 			},
 		},
 	}
+	defaultMetricTransformers := metricTransformers
+	defer func() { metricTransformers = defaultMetricTransformers }()
 	for _, test := range tests {
 		kubeStateMetricsSCheck := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), test.config)
 		mocked := mocksender.NewMockSender(kubeStateMetricsSCheck.ID())
@@ -384,6 +446,98 @@ func TestKSMCheck_joinLabels(t *testing.T) {
 			},
 			wantTags: []string{"foo_label:foo_value", "foo_label:foo_value", "bar_label:bar_value", "baz_label:baz_value"},
 		},
+		{
+			name:       "pod owner via kube_pod_info",
+			labelJoins: defaultLabelJoins,
+			args: args{
+				labels: map[string]string{"pod": "foo-abcde", "namespace": "default"},
+				metricsToGet: []ksmstore.DDMetricsFam{
+					{
+						Name:        "kube_pod_info",
+						ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"pod": "foo-abcde", "namespace": "default", "node": "bar", "host_ip": "10.0.0.1", "pod_ip": "10.1.0.1", "created_by_kind": "DaemonSet", "created_by_name": "foo"}}},
+					},
+				},
+			},
+			wantTags: []string{"pod:foo-abcde", "namespace:default", "node:bar", "host_ip:10.0.0.1", "created_by_kind:DaemonSet", "created_by_name:foo", "kube_daemon_set:foo"},
+		},
+		{
+			name:       "pod owner via kube_pod_info, unknown kind",
+			labelJoins: defaultLabelJoins,
+			args: args{
+				labels: map[string]string{"pod": "foo", "namespace": "default"},
+				metricsToGet: []ksmstore.DDMetricsFam{
+					{
+						Name:        "kube_pod_info",
+						ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"pod": "foo", "namespace": "default", "node": "bar", "created_by_kind": "<none>", "created_by_name": "<none>"}}},
+					},
+				},
+			},
+			wantTags: []string{"pod:foo", "namespace:default", "node:bar", "created_by_kind:<none>", "created_by_name:<none>"},
+		},
+		{
+			name:       "deployment via kube_replicaset_owner",
+			labelJoins: defaultLabelJoins,
+			args: args{
+				labels: map[string]string{"replicaset": "foo-5d8b9c7f4", "namespace": "default"},
+				metricsToGet: []ksmstore.DDMetricsFam{
+					{
+						Name:        "kube_replicaset_owner",
+						ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"replicaset": "foo-5d8b9c7f4", "namespace": "default", "owner_kind": "Deployment", "owner_name": "foo", "owner_is_controller": "true"}}},
+					},
+				},
+			},
+			wantTags: []string{"replicaset:foo-5d8b9c7f4", "namespace:default", "owner_kind:Deployment", "owner_name:foo", "kube_deployment:foo"},
+		},
+		{
+			name:       "claim phase via kube_persistentvolumeclaim_status_phase",
+			labelJoins: defaultLabelJoins,
+			args: args{
+				labels: map[string]string{"persistentvolumeclaim": "data", "namespace": "default"},
+				metricsToGet: []ksmstore.DDMetricsFam{
+					{
+						Name:        "kube_persistentvolumeclaim_status_phase",
+						ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"persistentvolumeclaim": "data", "namespace": "default", "phase": "Bound"}}},
+					},
+				},
+			},
+			wantTags: []string{"persistentvolumeclaim:data", "namespace:default", "pvc_phase:Bound"},
+		},
+		{
+			name:       "node roles via kube_node_labels",
+			labelJoins: defaultLabelJoins,
+			args: args{
+				labels: map[string]string{"node": "foo", "condition": "Ready", "status": "true"},
+				metricsToGet: []ksmstore.DDMetricsFam{
+					{
+						Name: "kube_node_labels",
+						ListMetrics: []ksmstore.DDMetric{
+							{Labels: map[string]string{"node": "foo", "label_node_role_kubernetes_io_control_plane": "", "label_kubernetes_io_os": "linux", "label_kubernetes_io_arch": "arm64"}},
+							{Labels: map[string]string{"node": "bar"}},
+						},
+					},
+				},
+			},
+			wantTags: []string{"node:foo", "condition:Ready", "status:true", "kube_node_role:control-plane", "os:linux", "arch:arm64"},
+		},
+		{
+			name: "owner not requested",
+			labelJoins: map[string]*JoinsConfig{
+				"kube_pod_info": {
+					LabelsToMatch: []string{"pod", "namespace"},
+					LabelsToGet:   []string{"node"},
+				},
+			},
+			args: args{
+				labels: map[string]string{"pod": "foo", "namespace": "default"},
+				metricsToGet: []ksmstore.DDMetricsFam{
+					{
+						Name:        "kube_pod_info",
+						ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"pod": "foo", "namespace": "default", "node": "bar", "created_by_kind": "Job", "created_by_name": "baz"}}},
+					},
+				},
+			},
+			wantTags: []string{"pod:foo", "namespace:default", "node:bar"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -451,6 +605,7 @@ func TestMetadataMetricsRegex(t *testing.T) {
 		"kube_service_labels",
 		"kube_statefulset_labels",
 		"kube_verticalpodautoscaler_labels",
+		"kube_replicaset_owner",
 	}
 	for _, m := range metadataMetrics {
 		assert.True(t, metadataMetricsRegex.MatchString(m))
@@ -466,3 +621,552 @@ func lenMetrics(metricsToProcess map[string][]ksmstore.DDMetricsFam) int {
 	}
 	return count
 }
+
+func TestKSMCheck_sendTelemetry(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	metrics := map[string][]ksmstore.DDMetricsFam{
+		"kube_foo_unknown": {
+			{
+				Type:        "*v1.Pod",
+				Name:        "kube_foo_unknown",
+				ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"namespace": "default"}, Val: 1}, {Labels: map[string]string{"namespace": "kube-system"}, Val: 1}},
+			},
+		},
+		"kube_resourcequota": {
+			{
+				Type:        "*v1.ResourceQuota",
+				Name:        "kube_resourcequota",
+				ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"namespace": "default", "type": "hard"}, Val: 1}},
+			},
+		},
+	}
+	k.processMetrics(s, metrics, []ksmstore.DDMetricsFam{})
+	k.sendTelemetry(s)
+
+	s.AssertMetric(t, "Gauge", "kubernetes_state.kube_foo_unknown", 1, "", []string{"kube_namespace:default"})
+	s.AssertMetric(t, "Count", "kubernetes_state.telemetry.unprocessed_metrics", 2, "", []string{"metric_name:kube_foo_unknown", "reason:unmapped"})
+	s.AssertMetric(t, "Count", "kubernetes_state.telemetry.unprocessed_metrics", 1, "", []string{"metric_name:kube_resourcequota", "reason:missing_label"})
+	s.AssertNumberOfCalls(t, "Count", 2)
+	assert.Len(t, k.unprocessedMetrics, 0)
+}
+
+func TestKSMCheck_writeDroppedMetrics(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	// Nothing is recorded unless the summary is enabled
+	k.missingLabel("kube_resourcequota", "resource")
+	assert.Len(t, k.droppedMetrics, 0)
+
+	out := &bytes.Buffer{}
+	k.droppedMetricsOutput = out
+	metrics := map[string][]ksmstore.DDMetricsFam{
+		"kube_resourcequota": {
+			{
+				Type:        "*v1.ResourceQuota",
+				Name:        "kube_resourcequota",
+				ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"namespace": "default", "type": "hard"}, Val: 1}},
+			},
+		},
+		"kube_node_status_condition": {
+			{
+				Type: "*v1.Node",
+				Name: "kube_node_status_condition",
+				ListMetrics: []ksmstore.DDMetric{
+					{Labels: map[string]string{"node": "foo", "condition": "Ready", "status": "false"}, Val: 0},
+					{Labels: map[string]string{"node": "foo", "condition": "Ready", "status": "unknown"}, Val: 0},
+				},
+			},
+		},
+		"kube_pod_container_status_terminated_reason": {
+			{
+				Type:        "*v1.Pod",
+				Name:        "kube_pod_container_status_terminated_reason",
+				ListMetrics: []ksmstore.DDMetric{{Labels: map[string]string{"namespace": "default", "reason": "Completed"}, Val: 1}},
+			},
+		},
+	}
+	k.processMetrics(s, metrics, []ksmstore.DDMetricsFam{})
+	k.writeDroppedMetrics()
+
+	assert.Equal(t, `METRIC                                       REASON         DETAIL     COUNT
+kube_node_status_condition                   zero_value                2
+kube_pod_container_status_terminated_reason  filtered       completed  1
+kube_resourcequota                           missing_label  resource   1
+`, out.String())
+	assert.Len(t, k.droppedMetrics, 0)
+
+	// The zero values aren't accounted for as unprocessed
+	assert.Len(t, k.unprocessedMetrics, 2)
+}
+
+func Test_ksmInitConfig_parse(t *testing.T) {
+	tests := []struct {
+		name       string
+		initConfig string
+		dump       bool
+		debug      bool
+	}{
+		{
+			name:       "empty init_config",
+			initConfig: "",
+			dump:       false,
+		},
+		{
+			name:       "dump_store set by the check command",
+			initConfig: "dump_store: true",
+			dump:       true,
+		},
+		{
+			name:       "debug_transformers set by the check command",
+			initConfig: "debug_transformers: true",
+			debug:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initConf := &ksmInitConfig{}
+			assert.NoError(t, initConf.parse([]byte(tt.initConfig)))
+			assert.Equal(t, tt.dump, initConf.DumpStore)
+			assert.Equal(t, tt.debug, initConf.DebugTransformers)
+		})
+	}
+}
+
+func TestKSMCheck_ConfigureInstances(t *testing.T) {
+	pods := KubeStateMetricsFactory().(*KSMCheck)
+	assert.NoError(t, pods.Configure([]byte("kube_state_url: http://localhost:8080/metrics\ncollectors: [pods]\nmin_collection_interval: 60"), nil, "test"))
+	workloads := KubeStateMetricsFactory().(*KSMCheck)
+	assert.NoError(t, workloads.Configure([]byte("kube_state_url: http://localhost:8080/metrics\ncollectors: [deployments, statefulsets]"), nil, "test"))
+
+	// The instances can be scheduled side by side with their own interval
+	assert.NotEqual(t, pods.ID(), workloads.ID())
+	assert.Equal(t, 60*time.Second, pods.Interval())
+	assert.Equal(t, defaults.DefaultCheckInterval, workloads.Interval())
+}
+
+func TestKSMCheck_hostname(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *KSMConfig
+		clusterName string
+		metricName  string
+		labels      map[string]string
+		expected    string
+	}{
+		{
+			name:       "node metric",
+			config:     &KSMConfig{},
+			metricName: "kube_node_status_capacity",
+			labels:     map[string]string{"node": "foo", "resource": "cpu"},
+			expected:   "foo",
+		},
+		{
+			name:        "node metric with cluster name",
+			config:      &KSMConfig{},
+			clusterName: "bar",
+			metricName:  "kube_node_status_capacity",
+			labels:      map[string]string{"node": "foo", "resource": "cpu"},
+			expected:    "foo-bar",
+		},
+		{
+			name:       "node metric, disabled",
+			config:     &KSMConfig{DisableNodeHostname: true},
+			metricName: "kube_node_status_capacity",
+			labels:     map[string]string{"node": "foo", "resource": "cpu"},
+			expected:   "",
+		},
+		{
+			name:       "pod metric",
+			config:     &KSMConfig{},
+			metricName: "kube_pod_container_status_running",
+			labels:     map[string]string{"node": "foo", "pod": "bar"},
+			expected:   "",
+		},
+		{
+			name:       "no node label",
+			config:     &KSMConfig{},
+			metricName: "kube_node_status_capacity",
+			labels:     map[string]string{"resource": "cpu"},
+			expected:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), tt.config)
+			k.clusterName = tt.clusterName
+			assert.Equal(t, tt.expected, k.hostname(tt.metricName, tt.labels))
+		})
+	}
+}
+
+func TestKSMCheck_setCustomTags(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		clusterName string
+		expected    []string
+	}{
+		{
+			name:        "no instance tags",
+			config:      "",
+			clusterName: "foo",
+			expected:    []string{"kube_cluster_name:foo"},
+		},
+		{
+			name:        "instance tags are kept",
+			config:      "tags:\n  - env:prod\n  - team:containers\n",
+			clusterName: "foo",
+			expected:    []string{"env:prod", "team:containers", "kube_cluster_name:foo"},
+		},
+		{
+			name:     "no cluster name",
+			config:   "tags:\n  - env:prod\n",
+			expected: []string{"env:prod"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+			k.clusterName = tt.clusterName
+			s := mocksender.NewMockSender(k.ID())
+			s.On("SetCheckCustomTags", tt.expected).Return()
+			assert.NoError(t, k.setCustomTags([]byte(tt.config)))
+			assert.Equal(t, tt.expected, k.customTags)
+			if tt.clusterName == "" {
+				// The sender already got the instance tags from CommonConfigure
+				s.AssertNotCalled(t, "SetCheckCustomTags", tt.expected)
+			} else {
+				s.AssertCalled(t, "SetCheckCustomTags", tt.expected)
+			}
+		})
+	}
+}
+
+func TestKSMCheck_buildTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		want  []string
+	}{
+		{name: "regular label", key: "namespace", value: "default", want: []string{"kube_namespace:default"}},
+		{name: "job", key: "job_name", value: "foo", want: []string{"kube_job:foo"}},
+		{name: "cronjob job", key: "job_name", value: "foo-1600000000", want: []string{"kube_job:foo", "kube_cronjob:foo"}},
+		{name: "image", key: "image", value: "gcr.io/foo/bar:1.2.3", want: []string{"image_name:gcr.io/foo/bar", "short_image:bar", "image_tag:1.2.3"}},
+		{name: "image without tag", key: "image", value: "redis", want: []string{"image_name:redis", "short_image:redis", "image_tag:latest"}},
+		{name: "sha256 image", key: "image", value: "sha256:0123456789abcdef", want: []string{"image_name:sha256:0123456789abcdef"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper})
+			assert.Equal(t, tt.want, k.buildTags(tt.key, tt.value))
+		})
+	}
+}
+
+func TestReasonsConfig_allowedReasons(t *testing.T) {
+	defaults := map[string]struct{}{"foo": {}, "bar": {}}
+	tests := []struct {
+		name   string
+		config ReasonsConfig
+		want   map[string]struct{}
+	}{
+		{
+			name:   "defaults",
+			config: ReasonsConfig{},
+			want:   map[string]struct{}{"foo": {}, "bar": {}},
+		},
+		{
+			name:   "add and remove, case insensitive",
+			config: ReasonsConfig{Add: []string{"Baz"}, Remove: []string{"BAR"}},
+			want:   map[string]struct{}{"foo": {}, "baz": {}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.config.allowedReasons(defaults))
+			assert.Len(t, defaults, 2)
+		})
+	}
+}
+
+func TestKSMCheck_submitGauge(t *testing.T) {
+	tags := []string{"kube_namespace:default", "pod_name:foo-abcde", "uid:1234", "kube_container_name:bar", "container_id:5678"}
+	tests := []struct {
+		name         string
+		metricName   string
+		expectedCall string
+		expectedTags []string
+		unwantedTags []string
+	}{
+		{
+			name:         "gauge",
+			metricName:   "kubernetes_state.container.running",
+			expectedCall: "Gauge",
+			expectedTags: tags,
+		},
+		{
+			name:         "histogram",
+			metricName:   "kubernetes_state.container.restarts",
+			expectedCall: "Histogram",
+			expectedTags: []string{"kube_namespace:default", "kube_container_name:bar"},
+			unwantedTags: []string{"pod_name:foo-abcde", "uid:1234", "container_id:5678"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper})
+			k.histogramMetrics["kubernetes_state.container.restarts"] = struct{}{}
+			s := mocksender.NewMockSender(k.ID())
+			s.SetupAcceptAll()
+			k.submitGauge(s, tt.metricName, 2, "", tags)
+			s.AssertMetric(t, tt.expectedCall, tt.metricName, 2, "", tt.expectedTags)
+			s.AssertNumberOfCalls(t, tt.expectedCall, 1)
+			for _, tag := range tt.unwantedTags {
+				s.AssertMetricNotTaggedWith(t, tt.expectedCall, tt.metricName, []string{tag})
+			}
+		})
+	}
+}
+
+func TestKSMCheck_submitGaugeWithTimestamp(t *testing.T) {
+	tags := []string{"kube_deployment:foo", "condition:Available"}
+	transition := float64(time.Now().Add(-time.Minute).Unix())
+	tests := []struct {
+		name              string
+		honorTimestamps   bool
+		metricName        string
+		timestamp         float64
+		expectedCall      string
+		expectedTimestamp float64
+	}{
+		{
+			name:              "timestamp honored",
+			honorTimestamps:   true,
+			metricName:        "kubernetes_state.deployment.condition",
+			timestamp:         transition,
+			expectedCall:      "GaugeWithTimestamp",
+			expectedTimestamp: transition,
+		},
+		{
+			name:         "timestamps disabled",
+			metricName:   "kubernetes_state.deployment.condition",
+			timestamp:    transition,
+			expectedCall: "Gauge",
+		},
+		{
+			name:            "no timestamp",
+			honorTimestamps: true,
+			metricName:      "kubernetes_state.deployment.condition",
+			expectedCall:    "Gauge",
+		},
+		{
+			name:            "histogram",
+			honorTimestamps: true,
+			metricName:      "kubernetes_state.container.restarts",
+			timestamp:       transition,
+			expectedCall:    "Histogram",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{HonorTimestamps: tt.honorTimestamps})
+			k.histogramMetrics["kubernetes_state.container.restarts"] = struct{}{}
+			s := mocksender.NewMockSender(k.ID())
+			s.SetupAcceptAll()
+			k.submitGaugeWithTimestamp(s, tt.metricName, 1, tt.timestamp, "", tags)
+			if tt.expectedCall == "GaugeWithTimestamp" {
+				s.AssertMetricWithTimestamp(t, tt.expectedCall, tt.metricName, 1, "", tags, tt.expectedTimestamp)
+			} else {
+				s.AssertMetric(t, tt.expectedCall, tt.metricName, 1, "", tags)
+			}
+			s.AssertNumberOfCalls(t, tt.expectedCall, 1)
+		})
+	}
+}
+
+func TestKSMCheck_submitGaugeWithTimestampRuns(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{HonorTimestamps: true})
+	tags := []string{"kube_deployment:foo", "condition:Available"}
+	name := "kubernetes_state.deployment.condition"
+	run := func(timestamp float64) *mocksender.MockSender {
+		s := mocksender.NewMockSender(k.ID())
+		s.SetupAcceptAll()
+		k.submitGaugeWithTimestamp(s, name, 1, timestamp, "", tags)
+		k.endRun()
+		return s
+	}
+
+	// The conditions older than maxTimestampAge are backdated by maxTimestampAge only
+	before := float64(time.Now().Add(-maxTimestampAge).Unix())
+	s := run(1600000000)
+	s.AssertCalled(t, "GaugeWithTimestamp", name, 1.0, "", tags, mock.MatchedBy(func(timestamp float64) bool {
+		return timestamp >= before && timestamp <= float64(time.Now().Add(-maxTimestampAge).Unix())
+	}))
+
+	// The next points of the same transition are submitted at the collection time
+	s = run(1600000000)
+	s.AssertMetric(t, "Gauge", name, 1, "", tags)
+	s.AssertNotCalled(t, "GaugeWithTimestamp", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// A new transition is backdated
+	transition := float64(time.Now().Add(-time.Minute).Unix())
+	s = run(transition)
+	s.AssertMetricWithTimestamp(t, "GaugeWithTimestamp", name, 1, "", tags, transition)
+	s = run(transition)
+	s.AssertMetric(t, "Gauge", name, 1, "", tags)
+}
+
+func TestKSMCheck_runLeaderElectionDisabled(t *testing.T) {
+	mockConfig := config.Mock()
+	mockConfig.Set("leader_election", false)
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LeaderElection: true})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	assert.Error(t, k.Run())
+	s.AssertNumberOfCalls(t, "Gauge", 0)
+}
+
+func TestKSMCheck_pushStores(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		o, _ := meta.Accessor(obj)
+		return []metric.FamilyInterface{&metric.Family{
+			Name:    "kube_node_info",
+			Metrics: []*metric.Metric{{LabelKeys: []string{"node"}, LabelValues: []string{o.GetName()}, Value: 1}},
+		}}
+	}
+	node := func(uid, name, resourceVersion string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), Name: name, ResourceVersion: resourceVersion}}
+	}
+	nodes := ksmstore.NewMetricsStore(genFunc, "*v1.Node")
+	others := ksmstore.NewMetricsStore(genFunc, "*v1.Node")
+	assert.NoError(t, nodes.Add(node("123", "foo", "1")))
+	assert.NoError(t, others.Add(node("456", "bar", "1")))
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelJoins: map[string]*JoinsConfig{
+		"kube_node_info": {LabelsToMatch: []string{"node"}},
+	}})
+	k.store = []cache.Store{nodes, others}
+
+	first := k.pushStores()
+	assert.Len(t, first, 2)
+	assert.Len(t, first[0].metrics["kube_node_info"], 1)
+	assert.Len(t, first[0].metricsToGet, 1)
+	firstNodes, firstOthers := first[0], first[1]
+
+	// Nothing changed, the pushed metrics are reused
+	second := k.pushStores()
+	assert.True(t, firstNodes == second[0])
+	assert.True(t, firstOthers == second[1])
+
+	// Only the changed store is pushed again
+	assert.NoError(t, nodes.Update(node("123", "baz", "2")))
+	third := k.pushStores()
+	assert.False(t, firstNodes == third[0])
+	assert.True(t, firstOthers == third[1])
+	assert.Equal(t, "baz", third[0].metrics["kube_node_info"][0].ListMetrics[0].Labels["node"])
+}
+
+func TestKSMCheck_scrape(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `kube_node_info{node="foo",kernel_version="5.4"} 1`)
+		fmt.Fprintln(w, `kube_node_info{node="bar",kernel_version="5.4"} 0`)
+		fmt.Fprintln(w, `kube_node_spec_unschedulable{node="foo"} 0`)
+	}))
+	defer server.Close()
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelJoins: map[string]*JoinsConfig{
+		"kube_node_info": {LabelsToMatch: []string{"node"}, LabelsToGet: []string{"kernel_version"}},
+	}})
+	k.scraper = scraper.New(server.URL, time.Second)
+
+	p, err := k.scrape()
+	assert.NoError(t, err)
+	assert.Len(t, p.metrics, 2)
+	assert.Len(t, p.metrics["kube_node_spec_unschedulable"], 1)
+
+	// Only the metrics equal to 1 of the label joins families are used for the label joins
+	assert.Len(t, p.metricsToGet, 1)
+	assert.Equal(t, "kube_node_info", p.metricsToGet[0].Name)
+	assert.Len(t, p.metricsToGet[0].ListMetrics, 1)
+	assert.Equal(t, "foo", p.metricsToGet[0].ListMetrics[0].Labels["node"])
+
+	server.Close()
+	_, err = k.scrape()
+	assert.Error(t, err)
+}
+
+func TestKSMCheck_sendStoreTelemetry(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		return []metric.FamilyInterface{&metric.Family{Name: "kube_node_info", Metrics: []*metric.Metric{{Value: 1}}}}
+	}
+	nodes := ksmstore.NewMetricsStore(genFunc, "*v1.Node")
+	assert.NoError(t, nodes.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{UID: "123"}}))
+	assert.NoError(t, nodes.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{UID: "456"}}))
+
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	k.store = []cache.Store{nodes}
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	k.sendStoreTelemetry(s)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.telemetry.store.objects", 2, "", []string{"resource_type:v1.Node"})
+	s.AssertMetric(t, "Gauge", "kubernetes_state.telemetry.store.metrics", 2, "", []string{"resource_type:v1.Node"})
+}
+
+func TestKSMCheck_storeFamilyFilter(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelJoins: map[string]*JoinsConfig{
+		"kube_deployment_labels": {LabelsToMatch: []string{"deployment", "namespace"}},
+	}})
+
+	assert.True(t, k.storeFamilyFilter("kube_pod_container_status_restarts_total"))
+	assert.True(t, k.storeFamilyFilter("kube_deployment_labels"))
+	assert.True(t, k.storeFamilyFilter("kube_storageclass_info"))
+	assert.False(t, k.storeFamilyFilter("kube_daemonset_labels"))
+	assert.False(t, k.storeFamilyFilter("kube_service_info"))
+}
+
+func TestKSMCheck_sendRunTelemetry(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{MetricPrefix: ksmMetricPrefix})
+	for i := 0; i < telemetryTopFamilies+2; i++ {
+		k.familyDurations[fmt.Sprintf("kube_family_%02d", i)] = time.Duration(i) * time.Millisecond
+	}
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	k.sendRunTelemetry(s, 42, 2*time.Second)
+
+	s.AssertMetric(t, "Gauge", "kubernetes_state.telemetry.run_duration", 2, "", nil)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.telemetry.families_processed", float64(telemetryTopFamilies+2), "", nil)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.telemetry.points_submitted", 42, "", nil)
+	// Only the slowest families are reported
+	s.AssertMetric(t, "Gauge", "kubernetes_state.telemetry.family_processing_time", 0.011, "", []string{"metric_name:kube_family_11"})
+	s.AssertMetric(t, "Gauge", "kubernetes_state.telemetry.family_processing_time", 0.002, "", []string{"metric_name:kube_family_02"})
+	s.AssertMetricNotTaggedWith(t, "Gauge", "kubernetes_state.telemetry.family_processing_time", []string{"metric_name:kube_family_01"})
+	s.AssertNumberOfCalls(t, "Gauge", 3+telemetryTopFamilies)
+	assert.Len(t, k.familyDurations, 0)
+}
+
+func TestCountingSender(t *testing.T) {
+	s := mocksender.NewMockSender("counting")
+	s.SetupAcceptAll()
+	counter := &countingSender{Sender: s}
+
+	counter.Gauge("foo", 1, "", nil)
+	counter.GaugeWithTimestamp("foo", 1, "", nil, 1600000000)
+	counter.Count("bar", 1, "", nil)
+	counter.Histogram("baz", 1, "", nil)
+	counter.SubmitBatch([]*metrics.MetricSample{{Name: "foo", Value: 1, Mtype: metrics.GaugeType}, {Name: "bar", Value: 1, Mtype: metrics.CountType}})
+	counter.ServiceCheck("foo.check", metrics.ServiceCheckOK, "", nil, "")
+
+	assert.Equal(t, 6, counter.points)
+	s.AssertNumberOfCalls(t, "Gauge", 2)
+	s.AssertNumberOfCalls(t, "ServiceCheck", 1)
+}
@@ -0,0 +1,101 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	"github.com/DataDog/datadog-agent/pkg/collector/check"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+)
+
+// TransformerTestCase describes a KSM metric given to a metric transformer
+// and the metrics and service checks the transformer is expected to submit
+type TransformerTestCase struct {
+	// Name is the name of the test case
+	Name string
+	// Config is the check instance config, an empty config is used if nil
+	Config *KSMConfig
+	// MetricName is the name of the KSM metric (e.g. kube_pod_status_ready)
+	MetricName string
+	// Metric is the KSM metric given to the transformer
+	Metric ksmstore.DDMetric
+	// Hostname and Tags are the hostname and tags given to the transformer
+	Hostname string
+	Tags     []string
+	// ExpectedMetrics contains all the metrics the transformer is expected to submit
+	ExpectedMetrics []ExpectedMetric
+	// ExpectedServiceChecks contains all the service checks the transformer is expected to submit
+	ExpectedServiceChecks []ExpectedServiceCheck
+}
+
+// ExpectedMetric describes a metric expected to be submitted by a transformer
+// Additional tags over the ones specified don't make the test fail
+type ExpectedMetric struct {
+	// Method is the sender method used to submit the metric (e.g. Gauge, Count, GaugeWithTimestamp)
+	Method   string
+	Name     string
+	Value    float64
+	Hostname string
+	Tags     []string
+	// Timestamp is only checked for the GaugeWithTimestamp method
+	Timestamp float64
+}
+
+// ExpectedServiceCheck describes a service check expected to be submitted by a transformer
+// Additional tags over the ones specified don't make the test fail
+type ExpectedServiceCheck struct {
+	Name     string
+	Status   metrics.ServiceCheckStatus
+	Hostname string
+	Tags     []string
+	Message  string
+}
+
+// transformerTestMethods contains the sender methods checked by RunTransformerTests
+var transformerTestMethods = []string{"Gauge", "GaugeWithTimestamp", "Rate", "Count", "MonotonicCount", "Histogram"}
+
+// RunTransformerTests runs the test cases against a metric transformer using a mock sender.
+// It asserts the expected metrics and service checks are submitted, and that nothing else is submitted.
+func RunTransformerTests(t *testing.T, transformer func(*KSMCheck, aggregator.Sender, string, ksmstore.DDMetric, string, []string), tests []TransformerTestCase) {
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			config := tt.Config
+			if config == nil {
+				config = &KSMConfig{}
+			}
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), config)
+			s := mocksender.NewMockSender(check.ID(tt.Name))
+			s.SetupAcceptAll()
+
+			transformer(k, s, tt.MetricName, tt.Metric, tt.Hostname, tt.Tags)
+
+			expectedCalls := make(map[string]int)
+			for _, m := range tt.ExpectedMetrics {
+				if m.Method == "GaugeWithTimestamp" {
+					s.AssertMetricWithTimestamp(t, m.Method, m.Name, m.Value, m.Hostname, m.Tags, m.Timestamp)
+				} else {
+					s.AssertMetric(t, m.Method, m.Name, m.Value, m.Hostname, m.Tags)
+				}
+				expectedCalls[m.Method]++
+			}
+			for _, method := range transformerTestMethods {
+				s.AssertNumberOfCalls(t, method, expectedCalls[method])
+			}
+
+			for _, sc := range tt.ExpectedServiceChecks {
+				s.AssertServiceCheck(t, sc.Name, sc.Status, sc.Hostname, sc.Tags, sc.Message)
+			}
+			s.AssertNumberOfCalls(t, "ServiceCheck", len(tt.ExpectedServiceChecks))
+		})
+	}
+}
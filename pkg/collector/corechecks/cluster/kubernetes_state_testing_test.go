@@ -0,0 +1,52 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+)
+
+func TestRunTransformerTests(t *testing.T) {
+	// A custom transformer submitting a metric and a service check, using the check config
+	customTransformer := func(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+		s.Gauge(k.metricName("foo.bar"), metric.Val, hostname, tags)
+		if metric.Val > 0 {
+			s.ServiceCheck(k.metricName("foo.ok"), metrics.ServiceCheckOK, hostname, tags, "")
+		}
+	}
+
+	RunTransformerTests(t, customTransformer, []TransformerTestCase{
+		{
+			Name:       "metric and service check",
+			MetricName: "kube_foo_bar",
+			Metric:     ksmstore.DDMetric{Val: 2, Labels: map[string]string{"foo": "bar"}},
+			Hostname:   "baz",
+			Tags:       []string{"foo:bar", "kube_namespace:default"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.foo.bar", Value: 2, Hostname: "baz", Tags: []string{"foo:bar"}},
+			},
+			ExpectedServiceChecks: []ExpectedServiceCheck{
+				{Name: "kubernetes_state.foo.ok", Status: metrics.ServiceCheckOK, Hostname: "baz", Tags: []string{"foo:bar"}},
+			},
+		},
+		{
+			Name:       "custom metric prefix",
+			Config:     &KSMConfig{MetricPrefix: "kube_state."},
+			MetricName: "kube_foo_bar",
+			Metric:     ksmstore.DDMetric{Val: 0, Labels: map[string]string{"foo": "bar"}},
+			Tags:       []string{"foo:bar"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kube_state.foo.bar", Value: 0, Tags: []string{"foo:bar"}},
+			},
+		},
+	})
+}
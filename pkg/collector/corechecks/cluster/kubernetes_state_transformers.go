@@ -9,15 +9,19 @@ package cluster
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/aggregator"
 	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 )
 
 // metricTransformerFunc is used to tweak or generate new metrics from a given KSM metric
 // For name translation only please use metricNamesMapper instead
-type metricTransformerFunc = func(aggregator.Sender, string, ksmstore.DDMetric, []string)
+type metricTransformerFunc = func(*KSMCheck, aggregator.Sender, string, ksmstore.DDMetric, string, []string)
 
 var (
 	// metricTransformers contains KSM metric names and their corresponding transformer functions
@@ -25,38 +29,764 @@ var (
 	// TODO: implement the metric transformers of these metrics and unit test them
 	// For reference see METRIC_TRANSFORMERS in KSM check V1
 	metricTransformers = map[string]metricTransformerFunc{
-		"kube_pod_status_phase":                       func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_pod_container_status_waiting_reason":    func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_pod_container_status_terminated_reason": func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_cronjob_next_schedule_time":             func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_job_complete":                           func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_job_failed":                             func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_job_status_failed":                      func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_job_status_succeeded":                   func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_node_status_condition":                  func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_node_spec_unschedulable":                func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_resourcequota":                          resourcequotaTransformer,
-		"kube_limitrange":                             func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_persistentvolume_status_phase":          func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
-		"kube_service_spec_type":                      func(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {},
+		"kube_pod_status_phase": func(_ *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+		},
+		"kube_pod_status_ready":                            podReadyTransformer,
+		"kube_pod_status_scheduled":                        podScheduledTransformer,
+		"kube_pod_status_qos_class":                        podQOSClassTransformer,
+		"kube_pod_status_reason":                           podStatusReasonTransformer,
+		"kube_pod_container_status_waiting_reason":         containerWaitingReasonTransformer,
+		"kube_pod_container_status_terminated_reason":      containerTerminatedReasonTransformer,
+		"kube_pod_container_status_last_terminated_reason": containerLastTerminatedReasonTransformer,
+		"kube_pod_container_status_restarts_total":         containerRestartsTransformer,
+		"kube_cronjob_next_schedule_time": func(_ *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+		},
+		"kube_cronjob_info":               cronJobInfoTransformer,
+		"kube_job_status_completion_time": jobCompletionTimeTransformer,
+		"kube_job_complete":               jobCompleteTransformer,
+		"kube_job_failed":                 jobFailedTransformer,
+		"kube_job_status_failed":          jobStatusFailedTransformer,
+		"kube_job_status_succeeded": func(_ *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+		},
+		"kube_node_status_condition": nodeConditionTransformer,
+		"kube_node_spec_unschedulable": func(_ *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+		},
+		"kube_node_status_allocatable":                   nodeAllocatableTransformer,
+		"kube_node_status_capacity":                      nodeCapacityTransformer,
+		"kube_pod_overhead":                              podOverheadTransformer,
+		"kube_resourcequota":                             resourcequotaTransformer,
+		"kube_endpoint_address_available":                endpointAddressAvailableTransformer,
+		"kube_endpoint_address_not_ready":                endpointAddressNotReadyTransformer,
+		"kube_storageclass_info":                         storageClassInfoTransformer,
+		"kube_persistentvolumeclaim_status_phase":        pvcStatusPhaseTransformer,
+		"kube_configmap_info":                            configMapInfoTransformer,
+		"kube_pod_info":                                  podInfoTransformer,
+		"kube_secret_type":                               secretTypeTransformer,
+		"kube_mutatingwebhookconfiguration_info":         webhookConfigurationInfoTransformer("mutating"),
+		"kube_validatingwebhookconfiguration_info":       webhookConfigurationInfoTransformer("validating"),
+		"kube_deployment_spec_replicas":                  workloadReplicasTransformer("deployment", replicasDesired),
+		"kube_deployment_status_replicas_updated":        workloadReplicasTransformer("deployment", replicasUpdated),
+		"kube_deployment_status_replicas_available":      workloadReplicasTransformer("deployment", replicasAvailable),
+		"kube_statefulset_replicas":                      workloadReplicasTransformer("statefulset", replicasDesired),
+		"kube_statefulset_status_replicas_ready":         workloadReplicasTransformer("statefulset", replicasReady),
+		"kube_statefulset_status_replicas_updated":       workloadReplicasTransformer("statefulset", replicasUpdated),
+		"kube_statefulset_status_current_revision":       statefulSetRevisionTransformer(revisionCurrent),
+		"kube_daemonset_status_desired_number_scheduled": workloadReplicasTransformer("daemonset", replicasDesired),
+		"kube_daemonset_status_number_ready":             workloadReplicasTransformer("daemonset", replicasReady),
+		"kube_daemonset_status_number_misscheduled":      workloadReplicasTransformer("daemonset", replicasMisscheduled),
+		"kube_daemonset_status_number_unavailable":       workloadReplicasTransformer("daemonset", replicasUnavailable),
+		"kube_statefulset_status_update_revision":        statefulSetRevisionTransformer(revisionUpdate),
+		"kube_namespace_status_phase":                    namespacePhaseTransformer,
+		"kube_persistentvolume_status_phase":             pvStatusPhaseTransformer,
+		"kube_limitrange": func(_ *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+		},
+		"kube_service_spec_type": func(_ *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+		},
 	}
 )
 
 // resourcequotaTransformer generates dedicated metrics per resource per type from the kube_resourcequota metric
-func resourcequotaTransformer(s aggregator.Sender, name string, metric ksmstore.DDMetric, tags []string) {
+func resourcequotaTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
 	resource, found := metric.Labels["resource"]
 	if !found {
-		log.Debugf("Couldn't find 'resource' label, ignoring metric '%s'", name)
+		k.missingLabel(name, "resource")
 		return
 	}
 	quotaType, found := metric.Labels["type"]
 	if !found {
-		log.Debugf("Couldn't find 'type' label, ignoring metric '%s'", name)
+		k.missingLabel(name, "type")
 		return
 	}
 	if quotaType == "hard" {
 		quotaType = "limit"
 	}
-	metricName := ksmMetricPrefix + fmt.Sprintf("resourcequota.%s.%s", resource, quotaType)
-	s.Gauge(metricName, metric.Val, "", tags)
+	ddResource := resourceQuotaMetricResource(resource)
+	metricName := k.metricName(fmt.Sprintf("resourcequota.%s.%s", ddResource, quotaType))
+	s.Gauge(metricName, metric.Val, hostname, tags)
+
+	// Keep the used and limit values to compute the utilization at the end of the run
+	key := fmt.Sprintf("%s/%s/%s", metric.Labels["namespace"], metric.Labels["resourcequota"], resource)
+	usage, found := k.resourceQuotas[key]
+	if !found {
+		usage = &resourceQuotaUsage{resource: resource, ddResource: ddResource, tags: removeTag(tags, k.tagKey("type"))}
+		k.resourceQuotas[key] = usage
+	}
+	switch quotaType {
+	case "used":
+		usage.used = metric.Val
+		usage.hasUsed = true
+	case "limit":
+		usage.limit = metric.Val
+		usage.hasLimit = true
+	}
+}
+
+var (
+	// invalidMetricNameCharsRegex matches the characters not allowed in the Datadog metric names
+	invalidMetricNameCharsRegex = regexp.MustCompile(`[^a-zA-Z0-9_.]`)
+	// invalidMetricNamePartCharsRegex also matches the dots, it's used to keep a name in a single metric name part
+	invalidMetricNamePartCharsRegex = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// resourceQuotaMetricResource returns the part of the resource quota metric names identifying a quota resource
+// The compute resources (e.g. requests.cpu) and the legacy object counts (e.g. services.loadbalancers) are valid as is.
+// The object count quotas (e.g. count/pods, count/deployments.apps) are reported as count.pods and count.deployments_apps,
+// the extended resources (e.g. requests.nvidia.com/gpu) as requests.nvidia_com_gpu.
+// The other invalid characters (e.g. of the storage class quotas) are replaced by underscores.
+func resourceQuotaMetricResource(resource string) string {
+	if object := strings.TrimPrefix(resource, "count/"); object != resource {
+		return "count." + invalidMetricNamePartCharsRegex.ReplaceAllString(object, "_")
+	}
+	for _, prefix := range []string{"requests.", "limits."} {
+		if extended := strings.TrimPrefix(resource, prefix); extended != resource && strings.Contains(extended, "/") {
+			return prefix + invalidMetricNamePartCharsRegex.ReplaceAllString(extended, "_")
+		}
+	}
+	return invalidMetricNameCharsRegex.ReplaceAllString(resource, "_")
+}
+
+// Default utilization ratios from which the resourcequota.utilization service check is WARNING and CRITICAL
+const (
+	defaultResourceQuotaWarningThreshold  = 0.9
+	defaultResourceQuotaCriticalThreshold = 0.95
+)
+
+// resourceQuotaUsage contains the used and limit values of a resource quota for a resource
+type resourceQuotaUsage struct {
+	// resource is the quota resource, it's used in the service check message
+	resource string
+	// ddResource identifies the resource in the metric names, see resourceQuotaMetricResource
+	ddResource string
+	tags       []string
+	used       float64
+	hasUsed    bool
+	limit      float64
+	hasLimit   bool
+}
+
+// processResourceQuotas submits the resourcequota.<resource>.utilization metrics and the resourcequota.utilization service checks
+// from the used and limit values collected by resourcequotaTransformer during the run, and resets them
+// The service check is WARNING or CRITICAL when the utilization reaches the configured thresholds
+func (k *KSMCheck) processResourceQuotas(s aggregator.Sender) {
+	for _, usage := range k.resourceQuotas {
+		if !usage.hasUsed || !usage.hasLimit || usage.limit == 0 {
+			continue
+		}
+		utilization := usage.used / usage.limit
+		s.Gauge(k.metricName(fmt.Sprintf("resourcequota.%s.utilization", usage.ddResource)), utilization, "", usage.tags)
+
+		status := metrics.ServiceCheckOK
+		switch {
+		case utilization >= k.instance.ResourceQuotaCriticalThreshold:
+			status = metrics.ServiceCheckCritical
+		case utilization >= k.instance.ResourceQuotaWarningThreshold:
+			status = metrics.ServiceCheckWarning
+		}
+		message := ""
+		if status != metrics.ServiceCheckOK {
+			message = fmt.Sprintf("%.0f%% of the %s quota is used", utilization*100, usage.resource)
+		}
+		k.submitServiceCheck(s, "resourcequota.utilization", status, "", usage.tags, message)
+	}
+	k.resourceQuotas = make(map[string]*resourceQuotaUsage)
+}
+
+// nodeAllocatableTransformer transforms the generic ksm node allocatable metrics into resource-specific metrics
+func nodeAllocatableTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	submitNodeResourceMetric(k, s, name, metric, hostname, tags, "allocatable")
+}
+
+// nodeCapacityTransformer transforms the generic ksm node capacity metrics into resource-specific metrics
+func nodeCapacityTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	submitNodeResourceMetric(k, s, name, metric, hostname, tags, "capacity")
+}
+
+// podOverheadResources contains the supported pod overhead resources and their Datadog metric names
+var podOverheadResources = map[string]string{
+	"cpu":    "pod.cpu_overhead",
+	"memory": "pod.memory_overhead",
+}
+
+// podOverheadTransformer transforms the generic ksm pod overhead metric into resource-specific metrics
+// The overhead is set on the pods using a runtime class with a sandbox (e.g. kata, gVisor),
+// it's accounted for in addition to the container requests when the pods are scheduled
+func podOverheadTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	resource, found := metric.Labels["resource"]
+	if !found {
+		k.missingLabel(name, "resource")
+		return
+	}
+	ddName, allowed := podOverheadResources[resource]
+	if !allowed {
+		k.unprocessed(name, unprocessedFiltered, resource)
+		return
+	}
+	s.Gauge(k.metricName(ddName), metric.Val, hostname, tags)
+}
+
+// nodeResources contains the supported native node resources and their Datadog metric names
+// The resource label is sanitized by KSM (e.g. ephemeral-storage becomes ephemeral_storage)
+var nodeResources = map[string]string{
+	"cpu":               "cpu",
+	"memory":            "memory",
+	"pods":              "pods",
+	"ephemeral_storage": "ephemeral_storage",
+}
+
+// extendedResourceSuffix returns the Datadog metric name of an extended resource (e.g. exposed by a device plugin)
+// KSM reports the extended resources with the integer unit and their sanitized name, e.g. nvidia_com_gpu for nvidia.com/gpu.
+// The domain can't be told apart from the name anymore, the last part of the name is used: nvidia_com_gpu and amd_com_gpu are gpu.
+// The other resources not supported (e.g. hugepages_2Mi, attachable_volumes_aws_ebs) are reported in bytes by KSM.
+func extendedResourceSuffix(resource, unit string) (string, bool) {
+	if unit != "integer" {
+		return "", false
+	}
+	suffix := strings.ToLower(resource[strings.LastIndex(resource, "_")+1:])
+	return suffix, suffix != ""
+}
+
+// submitNodeResourceMetric can be called by the generic ksm node resource (allocatable, capacity) metric transformers
+// KSM already scales the values according to the unit label (cores for cpu, bytes for memory and storage)
+// The extended resources are tagged with their resource name, several of them can share a metric name
+func submitNodeResourceMetric(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string, metricSuffix string) {
+	resource, found := metric.Labels["resource"]
+	if !found {
+		k.missingLabel(name, "resource")
+		return
+	}
+	ddResource, allowed := nodeResources[resource]
+	if !allowed {
+		if ddResource, allowed = extendedResourceSuffix(resource, metric.Labels["unit"]); !allowed {
+			k.unprocessed(name, unprocessedFiltered, resource)
+			return
+		}
+		resourceKey := k.tagKey("resource")
+		tags = append(removeTag(tags, resourceKey), resourceKey+":"+resource)
+	}
+	s.Gauge(k.metricName(fmt.Sprintf("node.%s_%s", ddResource, metricSuffix)), metric.Val, hostname, tags)
+}
+
+// podReadyServiceCheck is sent by podReadyTransformer
+var podReadyServiceCheck = transformerServiceCheck{
+	name:         "pod.ready",
+	statusLabels: []string{"condition"},
+	message:      "Pod {namespace}/{pod} readiness is {condition}",
+}
+
+// podReadyTransformer submits the pod.ready metric and the pod.ready service check based on kube_pod_status_ready
+// KSM generates one metric per condition (true, false, unknown), only the active one is equal to 1
+func podReadyTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	condition, found := metric.Labels["condition"]
+	if !found {
+		k.missingLabel(name, "condition")
+		return
+	}
+	k.submitGaugeWithTimestamp(s, k.metricName("pod.ready"), metric.Val, metric.Timestamp, hostname, tags)
+	if metric.Val != 1.0 {
+		// Only the active condition is used for the service check
+		return
+	}
+	k.sendServiceCheck(s, podReadyServiceCheck, metric, statusForCondition(condition, true), hostname, tags)
+}
+
+// statusForCondition returns the service check status corresponding to a Kubernetes condition status
+// positive is true when the condition being true is the expected state (e.g. Ready)
+// and false when it means something is wrong (e.g. MemoryPressure)
+func statusForCondition(condition string, positive bool) metrics.ServiceCheckStatus {
+	switch strings.ToLower(condition) {
+	case "true":
+		if positive {
+			return metrics.ServiceCheckOK
+		}
+		return metrics.ServiceCheckCritical
+	case "false":
+		if positive {
+			return metrics.ServiceCheckCritical
+		}
+		return metrics.ServiceCheckOK
+	default:
+		return metrics.ServiceCheckUnknown
+	}
+}
+
+// removeTag returns a copy of the tags without the tags having the given key
+// It's used to keep service check contexts stable when a label describes the status itself
+func removeTag(tags []string, key string) []string {
+	res := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, key+":") {
+			continue
+		}
+		res = append(res, tag)
+	}
+	return res
+}
+
+// tagValue returns the value of the first tag having the given key
+func tagValue(tags []string, key string) (string, bool) {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, key+":") {
+			return tag[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// podInfoTransformer counts the running and pending pods per node in node.pods_running and node.pods_pending
+// based on kube_pod_info, the pod phase is joined from kube_pod_status_phase.
+// It also counts the pods per namespace and priority class in pod.priority_class.
+// The counts are aggregated by the aggregator for all the pods of a given node during the check run
+func podInfoTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	podPriorityClassCount(k, s, metric)
+
+	node, found := metric.Labels["node"]
+	if !found || node == "" {
+		// Pods not scheduled yet don't have a node
+		return
+	}
+	phase, found := tagValue(tags, k.tagKey("phase"))
+	if !found {
+		// The phase is joined from kube_pod_status_phase
+		k.dropped(name, unprocessedMissingLabel, "phase")
+		return
+	}
+	var metricName string
+	switch strings.ToLower(phase) {
+	case "running":
+		metricName = "node.pods_running"
+	case "pending":
+		metricName = "node.pods_pending"
+	default:
+		return
+	}
+	s.Count(k.metricName(metricName), metric.Val, k.nodeHostname(node), []string{k.buildTag("node", node)})
+}
+
+// podPriorityClassCount counts the pods per namespace and priority class based on the priority_class label of kube_pod_info
+// The pods without a priority class aren't counted
+func podPriorityClassCount(k *KSMCheck, s aggregator.Sender, metric ksmstore.DDMetric) {
+	priorityClass := metric.Labels["priority_class"]
+	if priorityClass == "" {
+		return
+	}
+	namespace, found := metric.Labels["namespace"]
+	if !found {
+		return
+	}
+	s.Count(k.metricName("pod.priority_class"), metric.Val, "", []string{k.buildTag("namespace", namespace), "priority_class:" + priorityClass})
+}
+
+// podQOSClassTransformer counts the pods per namespace and QoS class in pod.qos_class based on kube_pod_status_qos_class
+// KSM generates one metric per QoS class (BestEffort, Burstable, Guaranteed), only the current one is equal to 1.
+// The count is aggregated by the aggregator for all the pods of a given namespace during the check run
+func podQOSClassTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	namespace, found := metric.Labels["namespace"]
+	if !found {
+		k.missingLabel(name, "namespace")
+		return
+	}
+	qosClass, found := metric.Labels["qos_class"]
+	if !found {
+		k.missingLabel(name, "qos_class")
+		return
+	}
+	s.Count(k.metricName("pod.qos_class"), metric.Val, "", []string{k.buildTag("namespace", namespace), "qos_class:" + strings.ToLower(qosClass)})
+}
+
+// podStatusReasonTransformer counts the pods per namespace and status reason (e.g. Evicted, NodeLost) in pod.status_reason
+// based on kube_pod_status_reason. KSM generates one metric per reason, only the current one is equal to 1.
+// It also sends an event when a pod is Evicted
+func podStatusReasonTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	namespace, found := metric.Labels["namespace"]
+	if !found {
+		k.missingLabel(name, "namespace")
+		return
+	}
+	reason, found := metric.Labels["reason"]
+	if !found {
+		k.missingLabel(name, "reason")
+		return
+	}
+	s.Count(k.metricName("pod.status_reason"), metric.Val, "", []string{k.buildTag("namespace", namespace), "reason:" + strings.ToLower(reason)})
+	if metric.Val == 1.0 && reason == "Evicted" {
+		k.podEvictedEvent(s, metric, tags)
+	}
+}
+
+// podScheduledTransformer submits the pod.scheduled metric based on kube_pod_status_scheduled
+// It also counts the pods pending scheduling per namespace in pod.pending_scheduling,
+// the count is aggregated by the aggregator for all the pods of a given namespace during the check run
+func podScheduledTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	condition, found := metric.Labels["condition"]
+	if !found {
+		k.missingLabel(name, "condition")
+		return
+	}
+	k.submitGaugeWithTimestamp(s, k.metricName("pod.scheduled"), metric.Val, metric.Timestamp, hostname, tags)
+	if metric.Val != 1.0 || strings.ToLower(condition) != "false" {
+		return
+	}
+	namespace, found := metric.Labels["namespace"]
+	if !found {
+		k.dropped(name, unprocessedMissingLabel, "namespace")
+		return
+	}
+	s.Count(k.metricName("pod.pending_scheduling"), 1, "", []string{k.buildTag("namespace", namespace)})
+}
+
+// nodeConditionServiceChecks contains the supported node conditions and their service check names
+var nodeConditionServiceChecks = map[string]string{
+	"Ready":              "node.ready",
+	"OutOfDisk":          "node.out_of_disk",
+	"DiskPressure":       "node.disk_pressure",
+	"MemoryPressure":     "node.memory_pressure",
+	"PIDPressure":        "node.pid_pressure",
+	"NetworkUnavailable": "node.network_unavailable",
+}
+
+// nodePressureMetrics contains the node conditions whose nodes are counted at the end of the run and their metric names
+var nodePressureMetrics = map[string]string{
+	"MemoryPressure": "nodes.memory_pressure",
+	"DiskPressure":   "nodes.disk_pressure",
+	"PIDPressure":    "nodes.pid_pressure",
+}
+
+// nodeConditionTransformer generates service checks based on the metric kube_node_status_condition
+// It also submits the metric node.by_condition, sends an event when a node condition becomes unhealthy
+// and counts the nodes under pressure for processNodePressure
+func nodeConditionTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	if metric.Val != 1.0 {
+		// Only consider active metrics
+		k.dropped(name, droppedZeroValue, "")
+		return
+	}
+	node, found := metric.Labels["node"]
+	if !found {
+		k.missingLabel(name, "node")
+		return
+	}
+	condition, found := metric.Labels["condition"]
+	if !found {
+		k.missingLabel(name, "condition")
+		return
+	}
+	status, found := metric.Labels["status"]
+	if !found {
+		k.missingLabel(name, "status")
+		return
+	}
+
+	k.submitGaugeWithTimestamp(s, k.metricName("node.by_condition"), metric.Val, metric.Timestamp, hostname, tags)
+
+	k.nodeConditionEvent(s, node, condition, strings.ToLower(status), hostname, tags)
+
+	if _, found := nodePressureMetrics[condition]; found {
+		k.nodesUnderPressure[condition] += boolToFloat(strings.ToLower(status) == "true")
+	}
+
+	serviceCheckName, found := nodeConditionServiceChecks[condition]
+	if !found {
+		log.Tracef("Unsupported node condition '%s', not sending service check for metric '%s'", condition, name)
+		return
+	}
+	k.sendServiceCheck(s, transformerServiceCheck{
+		name:         serviceCheckName,
+		statusLabels: []string{"status"},
+		message:      "Node {node} condition {condition} is {status}",
+	}, metric, statusForCondition(status, condition == "Ready"), hostname, tags)
+}
+
+// processNodePressure submits the nodes.memory_pressure, nodes.disk_pressure and nodes.pid_pressure metrics
+// from the nodes counted by nodeConditionTransformer during the run, and resets them
+// The metrics are only submitted for the conditions seen during the run, zero if no node is under pressure
+func (k *KSMCheck) processNodePressure(s aggregator.Sender) {
+	for condition, count := range k.nodesUnderPressure {
+		s.Gauge(k.metricName(nodePressureMetrics[condition]), count, "", nil)
+	}
+	k.nodesUnderPressure = make(map[string]float64)
+}
+
+// jobCompleteServiceCheck is sent by jobCompleteTransformer and jobFailedTransformer
+var jobCompleteServiceCheck = transformerServiceCheck{
+	name:         "job.complete",
+	statusLabels: []string{"condition"},
+	message:      "Job {namespace}/{job_name} failed",
+}
+
+// jobCompleteTransformer sends the job.complete service check based on kube_job_complete
+func jobCompleteTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	if metric.Val != 1.0 {
+		// Only consider active metrics
+		k.dropped(name, droppedZeroValue, "")
+		return
+	}
+	if strings.ToLower(metric.Labels["condition"]) != "true" {
+		return
+	}
+	k.sendServiceCheck(s, jobCompleteServiceCheck, metric, metrics.ServiceCheckOK, hostname, tags)
+}
+
+// jobFailedTransformer sends the job.complete service check based on kube_job_failed
+// It also sends an event when a job is newly failed
+func jobFailedTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	if strings.ToLower(metric.Labels["condition"]) != "true" {
+		return
+	}
+	tags = removeTag(tags, k.tagKey("condition"))
+	k.jobFailureEvent(s, name, metric, tags)
+	if metric.Val != 1.0 {
+		return
+	}
+	k.sendServiceCheck(s, jobCompleteServiceCheck, metric, metrics.ServiceCheckCritical, hostname, tags)
+}
+
+// jobStatusFailedTransformer submits the job.failed metric based on kube_job_status_failed
+// It also sends an event when new pods of a job failed since the last run
+func jobStatusFailedTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	s.Gauge(k.metricName("job.failed"), metric.Val, hostname, tags)
+	k.jobFailureEvent(s, name, metric, tags)
+}
+
+// defaultWaitingReasons contains the container waiting reasons reported by the check by default
+// They can be updated with the waiting_reasons config
+var defaultWaitingReasons = map[string]struct{}{
+	"errimagepull":      {},
+	"imagepullbackoff":  {},
+	"crashloopbackoff":  {},
+	"containercreating": {},
+}
+
+// containerWaitingReasonTransformer validates the container waiting reasons for metric kube_pod_container_status_waiting_reason
+func containerWaitingReasonTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	reason, found := metric.Labels["reason"]
+	if !found {
+		k.missingLabel(name, "reason")
+		return
+	}
+	// Filtering according to the reason here is paramount to limit cardinality
+	_, allowed := k.allowedWaitingReasons[strings.ToLower(reason)]
+	if !allowed && k.instance.ReportAllWaitingReasons {
+		if _, known := knownWaitingReasons[strings.ToLower(reason)]; !known {
+			reasonKey := k.tagKey("reason")
+			tags = append(removeTag(tags, reasonKey), reasonKey+":other")
+		}
+		allowed = true
+	}
+	if !allowed {
+		k.unprocessed(name, unprocessedFiltered, reason)
+		return
+	}
+	s.Gauge(k.metricName("container.status_report.count.waiting"), metric.Val, hostname, tags)
+}
+
+// knownWaitingReasons contains the container waiting reasons set by the kubelet
+// They're reported as is when report_all_waiting_reasons is enabled, other reasons are reported as "other"
+var knownWaitingReasons = map[string]struct{}{
+	"containercreating":          {},
+	"crashloopbackoff":           {},
+	"createcontainerconfigerror": {},
+	"createcontainererror":       {},
+	"errimageneverpull":          {},
+	"errimagepull":               {},
+	"imageinspecterror":          {},
+	"imagepullbackoff":           {},
+	"invalidimagename":           {},
+	"podinitializing":            {},
+	"poststarthookerror":         {},
+	"precreatehookerror":         {},
+	"prestarthookerror":          {},
+	"registryunavailable":        {},
+	"runcontainererror":          {},
+}
+
+// defaultTerminatedReasons contains the container terminated reasons reported by the check by default
+// They can be updated with the terminated_reasons config
+var defaultTerminatedReasons = map[string]struct{}{
+	"oomkilled":          {},
+	"containercannotrun": {},
+	"error":              {},
+}
+
+// containerTerminatedReasonTransformer validates the container terminated reasons for metric kube_pod_container_status_terminated_reason
+// It also sends an event when a container is OOMKilled
+func containerTerminatedReasonTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	reason, found := metric.Labels["reason"]
+	if !found {
+		k.missingLabel(name, "reason")
+		return
+	}
+	reason = strings.ToLower(reason)
+	// Filtering according to the reason here is paramount to limit cardinality
+	if _, allowed := k.allowedTerminatedReasons[reason]; !allowed {
+		k.unprocessed(name, unprocessedFiltered, reason)
+		return
+	}
+	s.Gauge(k.metricName("container.status_report.count.terminated"), metric.Val, hostname, tags)
+	if reason == "oomkilled" && metric.Val == 1.0 {
+		k.oomKilledEvent(s, metric, tags)
+	}
+}
+
+// containerLastTerminatedReasonTransformer validates the container reasons for metric kube_pod_container_status_last_terminated_reason
+// The last termination is kept after the container restarted, it tells why a running container died (e.g. OOMKilled or Error)
+// The reasons are filtered like the terminated ones, the OOMKilled events are only sent for the current termination
+func containerLastTerminatedReasonTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	reason, found := metric.Labels["reason"]
+	if !found {
+		k.missingLabel(name, "reason")
+		return
+	}
+	if _, allowed := k.allowedTerminatedReasons[strings.ToLower(reason)]; !allowed {
+		k.unprocessed(name, unprocessedFiltered, reason)
+		return
+	}
+	s.Gauge(k.metricName("container.last_terminated_reason"), metric.Val, hostname, tags)
+}
+
+// containerRestartsTransformer submits the container.restarts metric based on kube_pod_container_status_restarts_total
+// It also sends an event when the containers of a pod restart too often
+func containerRestartsTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	k.submitGuardedGauge(s, name, k.metricName("container.restarts"), metric.Val, metric.Timestamp, hostname, tags)
+	k.restartBurstEvent(s, metric, tags, time.Now())
+}
+
+// endpointTags adds the kube_service tag to the endpoint metrics tags
+// The endpoints of a service share its name and namespace
+func endpointTags(k *KSMCheck, name string, metric ksmstore.DDMetric, tags []string) ([]string, bool) {
+	endpoint, found := metric.Labels["endpoint"]
+	if !found {
+		k.missingLabel(name, "endpoint")
+		return nil, false
+	}
+	return append(tags, "kube_service:"+endpoint), true
+}
+
+// endpointAddressAvailableTransformer submits the endpoint.address_available metric based on kube_endpoint_address_available
+// It also submits the service.available metric equal to 0 when a service has no ready endpoint address, 1 otherwise
+func endpointAddressAvailableTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	tags, ok := endpointTags(k, name, metric, tags)
+	if !ok {
+		return
+	}
+	s.Gauge(k.metricName("endpoint.address_available"), metric.Val, hostname, tags)
+	available := 0.0
+	if metric.Val > 0 {
+		available = 1.0
+	}
+	s.Gauge(k.metricName("service.available"), available, hostname, tags)
+}
+
+// endpointAddressNotReadyTransformer submits the endpoint.address_not_ready metric based on kube_endpoint_address_not_ready
+func endpointAddressNotReadyTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	tags, ok := endpointTags(k, name, metric, tags)
+	if !ok {
+		return
+	}
+	s.Gauge(k.metricName("endpoint.address_not_ready"), metric.Val, hostname, tags)
+}
+
+// storageClassInfoTransformer counts the storage classes per provisioner and reclaim policy based on kube_storageclass_info
+// The count is aggregated by the aggregator for all the storage classes during the check run
+func storageClassInfoTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	provisioner, found := metric.Labels["provisioner"]
+	if !found {
+		k.missingLabel(name, "provisioner")
+		return
+	}
+	reclaimPolicy, found := metric.Labels["reclaim_policy"]
+	if !found {
+		k.missingLabel(name, "reclaim_policy")
+		return
+	}
+	s.Count(k.metricName("storageclass.count"), metric.Val, hostname, []string{"provisioner:" + provisioner, "reclaim_policy:" + reclaimPolicy})
+}
+
+// pvcPhaseServiceCheckStatuses contains the service check status per persistent volume claim phase
+var pvcPhaseServiceCheckStatuses = map[string]metrics.ServiceCheckStatus{
+	"bound":   metrics.ServiceCheckOK,
+	"pending": metrics.ServiceCheckWarning,
+	"lost":    metrics.ServiceCheckCritical,
+}
+
+// pvcPhaseLabel is the label the phase of the persistent volume claims is tagged as,
+// the phase label is mapped to the pod_phase tag
+const pvcPhaseLabel = "pvc_phase"
+
+// pvcStatusServiceCheck is sent by pvcStatusPhaseTransformer
+var pvcStatusServiceCheck = transformerServiceCheck{
+	name:         "persistentvolumeclaim.status",
+	statusLabels: []string{pvcPhaseLabel},
+	message:      "Persistent volume claim {namespace}/{persistentvolumeclaim} is {phase}",
+}
+
+// pvcStatusPhaseTransformer submits the persistentvolumeclaim.status metric based on kube_persistentvolumeclaim_status_phase
+// It also sends the persistentvolumeclaim.status service check for the active phase, claims Pending or Lost aren't OK
+// Each metric is tagged with its own phase, instead of the active phase joined to the other claim metrics
+func pvcStatusPhaseTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	phase, found := metric.Labels["phase"]
+	if !found {
+		k.missingLabel(name, "phase")
+		return
+	}
+	tags = removeTag(removeTag(tags, k.tagKey("phase")), k.tagKey(pvcPhaseLabel))
+	tags = append(tags, k.buildTag(pvcPhaseLabel, phase))
+	s.Gauge(k.metricName("persistentvolumeclaim.status"), metric.Val, hostname, tags)
+	if metric.Val != 1.0 {
+		// Only consider active metrics
+		return
+	}
+	status, found := pvcPhaseServiceCheckStatuses[strings.ToLower(phase)]
+	if !found {
+		status = metrics.ServiceCheckUnknown
+	}
+	k.sendServiceCheck(s, pvcStatusServiceCheck, metric, status, hostname, tags)
+}
+
+// configMapInfoTransformer counts the configmaps per namespace based on kube_configmap_info
+// The count is aggregated by the aggregator for all the configmaps of a given namespace during the check run
+func configMapInfoTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	if k.instance.DisableConfigMapSecretCounts {
+		return
+	}
+	namespace, found := metric.Labels["namespace"]
+	if !found {
+		k.missingLabel(name, "namespace")
+		return
+	}
+	s.Count(k.metricName("configmap.count"), metric.Val, hostname, []string{k.buildTag("namespace", namespace)})
+}
+
+// secretTypeTransformer submits the secret.type metric based on kube_secret_type
+// It also counts the secrets per namespace and type, the count is aggregated during the check run
+func secretTypeTransformer(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+	s.Gauge(k.metricName("secret.type"), metric.Val, hostname, tags)
+	if k.instance.DisableConfigMapSecretCounts {
+		return
+	}
+	namespace, found := metric.Labels["namespace"]
+	if !found {
+		k.missingLabel(name, "namespace")
+		return
+	}
+	secretType, found := metric.Labels["type"]
+	if !found {
+		k.missingLabel(name, "type")
+		return
+	}
+	s.Count(k.metricName("secret.count"), metric.Val, hostname, []string{k.buildTag("namespace", namespace), "secret_type:" + secretType})
+}
+
+// webhookConfigurationInfoTransformer returns a transformer counting the admission webhook configurations of the given type
+// in webhookconfiguration.count based on kube_mutatingwebhookconfiguration_info or kube_validatingwebhookconfiguration_info.
+// The webhook configurations are cluster-scoped, they're tagged by webhook type and configuration name
+func webhookConfigurationInfoTransformer(webhookType string) metricTransformerFunc {
+	label := webhookType + "webhookconfiguration"
+	return func(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+		configuration, found := metric.Labels[label]
+		if !found {
+			k.missingLabel(name, label)
+			return
+		}
+		s.Count(k.metricName("webhookconfiguration.count"), metric.Val, hostname, []string{"webhook_type:" + webhookType, "webhookconfiguration:" + configuration})
+	}
 }
@@ -9,9 +9,14 @@ package cluster
 
 import (
 	"testing"
+	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
 	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func Test_resourcequotaTransformer(t *testing.T) {
@@ -70,6 +75,26 @@ func Test_resourcequotaTransformer(t *testing.T) {
 				tags: []string{"resourcequota:gke-resource-quotas", "foo:bar"},
 			},
 		},
+		{
+			name: "object count quota",
+			args: args{
+				name: "kube_resourcequota",
+				metric: ksmstore.DDMetric{
+					Val: 12,
+					Labels: map[string]string{
+						"resource":      "count/secrets",
+						"type":          "used",
+						"resourcequota": "object-counts",
+					},
+				},
+				tags: []string{"resourcequota:object-counts", "resource:count/secrets"},
+			},
+			expected: &metricsExpected{
+				name: "kubernetes_state.resourcequota.count.secrets.used",
+				val:  12,
+				tags: []string{"resourcequota:object-counts", "resource:count/secrets"},
+			},
+		},
 		{
 			name: "no resource label",
 			args: args{
@@ -105,7 +130,173 @@ func Test_resourcequotaTransformer(t *testing.T) {
 		s := mocksender.NewMockSender("ksm")
 		s.SetupAcceptAll()
 		t.Run(tt.name, func(t *testing.T) {
-			resourcequotaTransformer(s, tt.args.name, tt.args.metric, tt.args.tags)
+			resourcequotaTransformer(newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{}), s, tt.args.name, tt.args.metric, "", tt.args.tags)
+			if tt.expected != nil {
+				s.AssertMetric(t, "Gauge", tt.expected.name, tt.expected.val, "", tt.expected.tags)
+				s.AssertNumberOfCalls(t, "Gauge", 1)
+			} else {
+				s.AssertNotCalled(t, "Gauge")
+			}
+		})
+	}
+}
+
+func Test_submitNodeResourceMetric(t *testing.T) {
+	type args struct {
+		name         string
+		metric       ksmstore.DDMetric
+		tags         []string
+		metricSuffix string
+	}
+	tests := []struct {
+		name     string
+		args     args
+		expected *metricsExpected
+	}{
+		{
+			name: "cpu allocatable",
+			args: args{
+				name: "kube_node_status_allocatable",
+				metric: ksmstore.DDMetric{
+					Val: 3.92,
+					Labels: map[string]string{
+						"node":     "foo",
+						"resource": "cpu",
+						"unit":     "core",
+					},
+				},
+				tags:         []string{"host:foo"},
+				metricSuffix: "allocatable",
+			},
+			expected: &metricsExpected{
+				name: "kubernetes_state.node.cpu_allocatable",
+				val:  3.92,
+				tags: []string{"host:foo"},
+			},
+		},
+		{
+			name: "memory capacity",
+			args: args{
+				name: "kube_node_status_capacity",
+				metric: ksmstore.DDMetric{
+					Val: 16801808384,
+					Labels: map[string]string{
+						"node":     "foo",
+						"resource": "memory",
+						"unit":     "byte",
+					},
+				},
+				tags:         []string{"host:foo"},
+				metricSuffix: "capacity",
+			},
+			expected: &metricsExpected{
+				name: "kubernetes_state.node.memory_capacity",
+				val:  16801808384,
+				tags: []string{"host:foo"},
+			},
+		},
+		{
+			name: "gpu capacity",
+			args: args{
+				name: "kube_node_status_capacity",
+				metric: ksmstore.DDMetric{
+					Val: 2,
+					Labels: map[string]string{
+						"node":     "foo",
+						"resource": "nvidia_com_gpu",
+						"unit":     "integer",
+					},
+				},
+				tags:         []string{"host:foo"},
+				metricSuffix: "capacity",
+			},
+			expected: &metricsExpected{
+				name: "kubernetes_state.node.gpu_capacity",
+				val:  2,
+				tags: []string{"host:foo", "resource:nvidia_com_gpu"},
+			},
+		},
+		{
+			name: "other vendor gpu allocatable",
+			args: args{
+				name: "kube_node_status_allocatable",
+				metric: ksmstore.DDMetric{
+					Val: 1,
+					Labels: map[string]string{
+						"node":     "foo",
+						"resource": "amd_com_gpu",
+						"unit":     "integer",
+					},
+				},
+				tags:         []string{"host:foo", "resource:amd_com_gpu", "unit:integer"},
+				metricSuffix: "allocatable",
+			},
+			expected: &metricsExpected{
+				name: "kubernetes_state.node.gpu_allocatable",
+				val:  1,
+				tags: []string{"host:foo", "unit:integer", "resource:amd_com_gpu"},
+			},
+		},
+		{
+			name: "device plugin resource",
+			args: args{
+				name: "kube_node_status_allocatable",
+				metric: ksmstore.DDMetric{
+					Val: 4,
+					Labels: map[string]string{
+						"node":     "foo",
+						"resource": "xilinx_com_FPGA",
+						"unit":     "integer",
+					},
+				},
+				tags:         []string{"host:foo"},
+				metricSuffix: "allocatable",
+			},
+			expected: &metricsExpected{
+				name: "kubernetes_state.node.fpga_allocatable",
+				val:  4,
+				tags: []string{"host:foo", "resource:xilinx_com_FPGA"},
+			},
+		},
+		{
+			name: "unsupported resource",
+			args: args{
+				name: "kube_node_status_capacity",
+				metric: ksmstore.DDMetric{
+					Val: 23,
+					Labels: map[string]string{
+						"node":     "foo",
+						"resource": "hugepages_2Mi",
+						"unit":     "byte",
+					},
+				},
+				tags:         []string{"host:foo"},
+				metricSuffix: "capacity",
+			},
+			expected: nil,
+		},
+		{
+			name: "no resource label",
+			args: args{
+				name: "kube_node_status_capacity",
+				metric: ksmstore.DDMetric{
+					Val: 23,
+					Labels: map[string]string{
+						"node": "foo",
+						"unit": "byte",
+					},
+				},
+				tags:         []string{"host:foo"},
+				metricSuffix: "capacity",
+			},
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			submitNodeResourceMetric(newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{}), s, tt.args.name, tt.args.metric, "", tt.args.tags, tt.args.metricSuffix)
 			if tt.expected != nil {
 				s.AssertMetric(t, "Gauge", tt.expected.name, tt.expected.val, "", tt.expected.tags)
 				s.AssertNumberOfCalls(t, "Gauge", 1)
@@ -115,3 +306,1613 @@ func Test_resourcequotaTransformer(t *testing.T) {
 		})
 	}
 }
+
+func Test_podReadyTransformer(t *testing.T) {
+	type args struct {
+		name   string
+		metric ksmstore.DDMetric
+		tags   []string
+	}
+	type serviceCheckExpected struct {
+		name    string
+		status  metrics.ServiceCheckStatus
+		tags    []string
+		message string
+	}
+	tests := []struct {
+		name                 string
+		args                 args
+		expectedMetric       *metricsExpected
+		expectedServiceCheck *serviceCheckExpected
+	}{
+		{
+			name: "ready",
+			args: args{
+				name: "kube_pod_status_ready",
+				metric: ksmstore.DDMetric{
+					Val:    1,
+					Labels: map[string]string{"pod": "redis-599d64fcb9-c654j", "namespace": "default", "condition": "true"},
+				},
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:true", "host:minikube"},
+			},
+			expectedMetric: &metricsExpected{
+				name: "kubernetes_state.pod.ready",
+				val:  1,
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:true", "host:minikube"},
+			},
+			expectedServiceCheck: &serviceCheckExpected{
+				name:   "kubernetes_state.pod.ready",
+				status: metrics.ServiceCheckOK,
+				tags:   []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "host:minikube"},
+			},
+		},
+		{
+			name: "not ready",
+			args: args{
+				name: "kube_pod_status_ready",
+				metric: ksmstore.DDMetric{
+					Val:    1,
+					Labels: map[string]string{"pod": "redis-599d64fcb9-c654j", "namespace": "default", "condition": "false"},
+				},
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:false", "host:minikube"},
+			},
+			expectedMetric: &metricsExpected{
+				name: "kubernetes_state.pod.ready",
+				val:  1,
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:false", "host:minikube"},
+			},
+			expectedServiceCheck: &serviceCheckExpected{
+				name:    "kubernetes_state.pod.ready",
+				status:  metrics.ServiceCheckCritical,
+				tags:    []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "host:minikube"},
+				message: "Pod default/redis-599d64fcb9-c654j readiness is false",
+			},
+		},
+		{
+			name: "inactive condition",
+			args: args{
+				name: "kube_pod_status_ready",
+				metric: ksmstore.DDMetric{
+					Val:    0,
+					Labels: map[string]string{"pod": "redis-599d64fcb9-c654j", "namespace": "default", "condition": "unknown"},
+				},
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:unknown", "host:minikube"},
+			},
+			expectedMetric: &metricsExpected{
+				name: "kubernetes_state.pod.ready",
+				val:  0,
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:unknown", "host:minikube"},
+			},
+			expectedServiceCheck: nil,
+		},
+		{
+			name: "no condition label",
+			args: args{
+				name: "kube_pod_status_ready",
+				metric: ksmstore.DDMetric{
+					Val:    1,
+					Labels: map[string]string{"pod": "redis-599d64fcb9-c654j", "namespace": "default"},
+				},
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default"},
+			},
+			expectedMetric:       nil,
+			expectedServiceCheck: nil,
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			podReadyTransformer(newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{}), s, tt.args.name, tt.args.metric, "", tt.args.tags)
+			if tt.expectedMetric != nil {
+				s.AssertMetric(t, "Gauge", tt.expectedMetric.name, tt.expectedMetric.val, "", tt.expectedMetric.tags)
+				s.AssertNumberOfCalls(t, "Gauge", 1)
+			} else {
+				s.AssertNotCalled(t, "Gauge")
+			}
+			if tt.expectedServiceCheck != nil {
+				s.AssertServiceCheck(t, tt.expectedServiceCheck.name, tt.expectedServiceCheck.status, "", tt.expectedServiceCheck.tags, tt.expectedServiceCheck.message)
+				s.AssertNumberOfCalls(t, "ServiceCheck", 1)
+			} else {
+				s.AssertNotCalled(t, "ServiceCheck")
+			}
+		})
+	}
+}
+
+func Test_conditionTransformersTimestamps(t *testing.T) {
+	honorTimestamps := &KSMConfig{HonorTimestamps: true}
+	transition := float64(time.Now().Add(-time.Minute).Unix())
+	readyTags := []string{"pod_name:foo", "kube_namespace:default", "condition:true"}
+	RunTransformerTests(t, podReadyTransformer, []TransformerTestCase{
+		{
+			Name:       "pod ready backdated",
+			Config:     honorTimestamps,
+			MetricName: "kube_pod_status_ready",
+			Metric:     ksmstore.DDMetric{Val: 1, Timestamp: transition, Labels: map[string]string{"pod": "foo", "namespace": "default", "condition": "true"}},
+			Tags:       readyTags,
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "GaugeWithTimestamp", Name: "kubernetes_state.pod.ready", Value: 1, Tags: readyTags, Timestamp: transition},
+			},
+			ExpectedServiceChecks: []ExpectedServiceCheck{
+				{Name: "kubernetes_state.pod.ready", Status: metrics.ServiceCheckOK, Tags: []string{"pod_name:foo", "kube_namespace:default"}},
+			},
+		},
+		{
+			Name:       "pod ready timestamps disabled",
+			MetricName: "kube_pod_status_ready",
+			Metric:     ksmstore.DDMetric{Val: 1, Timestamp: transition, Labels: map[string]string{"pod": "foo", "namespace": "default", "condition": "true"}},
+			Tags:       readyTags,
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.pod.ready", Value: 1, Tags: readyTags},
+			},
+			ExpectedServiceChecks: []ExpectedServiceCheck{
+				{Name: "kubernetes_state.pod.ready", Status: metrics.ServiceCheckOK, Tags: []string{"pod_name:foo", "kube_namespace:default"}},
+			},
+		},
+	})
+
+	scheduledTags := []string{"pod_name:foo", "kube_namespace:default", "condition:true"}
+	RunTransformerTests(t, podScheduledTransformer, []TransformerTestCase{
+		{
+			Name:       "pod scheduled backdated",
+			Config:     honorTimestamps,
+			MetricName: "kube_pod_status_scheduled",
+			Metric:     ksmstore.DDMetric{Val: 1, Timestamp: transition, Labels: map[string]string{"pod": "foo", "namespace": "default", "condition": "true"}},
+			Tags:       scheduledTags,
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "GaugeWithTimestamp", Name: "kubernetes_state.pod.scheduled", Value: 1, Tags: scheduledTags, Timestamp: transition},
+			},
+		},
+	})
+
+	nodeTags := []string{"host:foo", "condition:MemoryPressure", "status:false"}
+	RunTransformerTests(t, nodeConditionTransformer, []TransformerTestCase{
+		{
+			Name:       "node condition backdated",
+			Config:     honorTimestamps,
+			MetricName: "kube_node_status_condition",
+			Metric:     ksmstore.DDMetric{Val: 1, Timestamp: transition, Labels: map[string]string{"node": "foo", "condition": "MemoryPressure", "status": "false"}},
+			Hostname:   "foo",
+			Tags:       nodeTags,
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "GaugeWithTimestamp", Name: "kubernetes_state.node.by_condition", Value: 1, Hostname: "foo", Tags: nodeTags, Timestamp: transition},
+			},
+			ExpectedServiceChecks: []ExpectedServiceCheck{
+				{Name: "kubernetes_state.node.memory_pressure", Status: metrics.ServiceCheckOK, Hostname: "foo", Tags: []string{"host:foo", "condition:MemoryPressure"}},
+			},
+		},
+		{
+			Name:       "node condition without transition time",
+			Config:     honorTimestamps,
+			MetricName: "kube_node_status_condition",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"node": "foo", "condition": "MemoryPressure", "status": "false"}},
+			Hostname:   "foo",
+			Tags:       nodeTags,
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.node.by_condition", Value: 1, Hostname: "foo", Tags: nodeTags},
+			},
+			ExpectedServiceChecks: []ExpectedServiceCheck{
+				{Name: "kubernetes_state.node.memory_pressure", Status: metrics.ServiceCheckOK, Hostname: "foo", Tags: []string{"host:foo", "condition:MemoryPressure"}},
+			},
+		},
+	})
+}
+
+func Test_podScheduledTransformer(t *testing.T) {
+	type args struct {
+		name   string
+		metric ksmstore.DDMetric
+		tags   []string
+	}
+	tests := []struct {
+		name          string
+		args          args
+		expectedGauge *metricsExpected
+		expectedCount *metricsExpected
+	}{
+		{
+			name: "scheduled",
+			args: args{
+				name: "kube_pod_status_scheduled",
+				metric: ksmstore.DDMetric{
+					Val:    1,
+					Labels: map[string]string{"pod": "redis-599d64fcb9-c654j", "namespace": "default", "condition": "true"},
+				},
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:true"},
+			},
+			expectedGauge: &metricsExpected{
+				name: "kubernetes_state.pod.scheduled",
+				val:  1,
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:true"},
+			},
+			expectedCount: nil,
+		},
+		{
+			name: "pending scheduling",
+			args: args{
+				name: "kube_pod_status_scheduled",
+				metric: ksmstore.DDMetric{
+					Val:    1,
+					Labels: map[string]string{"pod": "redis-599d64fcb9-c654j", "namespace": "default", "condition": "false"},
+				},
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:false"},
+			},
+			expectedGauge: &metricsExpected{
+				name: "kubernetes_state.pod.scheduled",
+				val:  1,
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:false"},
+			},
+			expectedCount: &metricsExpected{
+				name: "kubernetes_state.pod.pending_scheduling",
+				val:  1,
+				tags: []string{"kube_namespace:default"},
+			},
+		},
+		{
+			name: "inactive condition",
+			args: args{
+				name: "kube_pod_status_scheduled",
+				metric: ksmstore.DDMetric{
+					Val:    0,
+					Labels: map[string]string{"pod": "redis-599d64fcb9-c654j", "namespace": "default", "condition": "false"},
+				},
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:false"},
+			},
+			expectedGauge: &metricsExpected{
+				name: "kubernetes_state.pod.scheduled",
+				val:  0,
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default", "condition:false"},
+			},
+			expectedCount: nil,
+		},
+		{
+			name: "no condition label",
+			args: args{
+				name: "kube_pod_status_scheduled",
+				metric: ksmstore.DDMetric{
+					Val:    1,
+					Labels: map[string]string{"pod": "redis-599d64fcb9-c654j", "namespace": "default"},
+				},
+				tags: []string{"pod_name:redis-599d64fcb9-c654j", "kube_namespace:default"},
+			},
+			expectedGauge: nil,
+			expectedCount: nil,
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			podScheduledTransformer(newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper}), s, tt.args.name, tt.args.metric, "", tt.args.tags)
+			if tt.expectedGauge != nil {
+				s.AssertMetric(t, "Gauge", tt.expectedGauge.name, tt.expectedGauge.val, "", tt.expectedGauge.tags)
+				s.AssertNumberOfCalls(t, "Gauge", 1)
+			} else {
+				s.AssertNotCalled(t, "Gauge")
+			}
+			if tt.expectedCount != nil {
+				s.AssertMetric(t, "Count", tt.expectedCount.name, tt.expectedCount.val, "", tt.expectedCount.tags)
+				s.AssertNumberOfCalls(t, "Count", 1)
+			} else {
+				s.AssertNotCalled(t, "Count")
+			}
+		})
+	}
+}
+
+func Test_nodeConditionTransformer(t *testing.T) {
+	type args struct {
+		name   string
+		metric ksmstore.DDMetric
+		tags   []string
+	}
+	type serviceCheckExpected struct {
+		name    string
+		status  metrics.ServiceCheckStatus
+		tags    []string
+		message string
+	}
+	tests := []struct {
+		name                 string
+		args                 args
+		expectedServiceCheck *serviceCheckExpected
+	}{
+		{
+			name: "ready",
+			args: args{
+				name: "kube_node_status_condition",
+				metric: ksmstore.DDMetric{
+					Val:    1,
+					Labels: map[string]string{"node": "foo", "condition": "Ready", "status": "true"},
+				},
+				tags: []string{"host:foo", "condition:Ready", "status:true"},
+			},
+			expectedServiceCheck: &serviceCheckExpected{
+				name:   "kubernetes_state.node.ready",
+				status: metrics.ServiceCheckOK,
+				tags:   []string{"host:foo", "condition:Ready"},
+			},
+		},
+		{
+			name: "not ready",
+			args: args{
+				name: "kube_node_status_condition",
+				metric: ksmstore.DDMetric{
+					Val:    1,
+					Labels: map[string]string{"node": "foo", "condition": "Ready", "status": "false"},
+				},
+				tags: []string{"host:foo", "condition:Ready", "status:false"},
+			},
+			expectedServiceCheck: &serviceCheckExpected{
+				name:    "kubernetes_state.node.ready",
+				status:  metrics.ServiceCheckCritical,
+				tags:    []string{"host:foo", "condition:Ready"},
+				message: "Node foo condition Ready is false",
+			},
+		},
+		{
+			name: "memory pressure",
+			args: args{
+				name: "kube_node_status_condition",
+				metric: ksmstore.DDMetric{
+					Val:    1,
+					Labels: map[string]string{"node": "foo", "condition": "MemoryPressure", "status": "true"},
+				},
+				tags: []string{"host:foo", "condition:MemoryPressure", "status:true"},
+			},
+			expectedServiceCheck: &serviceCheckExpected{
+				name:    "kubernetes_state.node.memory_pressure",
+				status:  metrics.ServiceCheckCritical,
+				tags:    []string{"host:foo", "condition:MemoryPressure"},
+				message: "Node foo condition MemoryPressure is true",
+			},
+		},
+		{
+			name: "inactive status",
+			args: args{
+				name: "kube_node_status_condition",
+				metric: ksmstore.DDMetric{
+					Val:    0,
+					Labels: map[string]string{"node": "foo", "condition": "Ready", "status": "unknown"},
+				},
+				tags: []string{"host:foo", "condition:Ready", "status:unknown"},
+			},
+			expectedServiceCheck: nil,
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+			nodeConditionTransformer(k, s, tt.args.name, tt.args.metric, "", tt.args.tags)
+			if tt.expectedServiceCheck != nil {
+				s.AssertServiceCheck(t, tt.expectedServiceCheck.name, tt.expectedServiceCheck.status, "", tt.expectedServiceCheck.tags, tt.expectedServiceCheck.message)
+				s.AssertMetric(t, "Gauge", "kubernetes_state.node.by_condition", 1, "", tt.args.tags)
+			} else {
+				s.AssertNotCalled(t, "ServiceCheck")
+				s.AssertNotCalled(t, "Gauge")
+			}
+			// No event is expected the first time a condition is seen
+			s.AssertNotCalled(t, "Event")
+		})
+	}
+}
+
+func Test_nodeConditionEvent(t *testing.T) {
+	conditionMetric := func(condition, status string) ksmstore.DDMetric {
+		return ksmstore.DDMetric{
+			Val:    1,
+			Labels: map[string]string{"node": "foo", "condition": condition, "status": status},
+		}
+	}
+	tests := []struct {
+		name          string
+		condition     string
+		statuses      []string
+		expectedEvent *metrics.Event
+	}{
+		{
+			name:      "ready to not ready",
+			condition: "Ready",
+			statuses:  []string{"true", "false"},
+			expectedEvent: &metrics.Event{
+				Title:          "Node foo is NotReady",
+				Host:           "foo",
+				AlertType:      metrics.EventAlertTypeError,
+				Priority:       metrics.EventPriorityNormal,
+				AggregationKey: "kubernetes_state-alpha:node:foo",
+				SourceTypeName: "kubernetes",
+				EventType:      kubeStateMetricsCheckName,
+			},
+		},
+		{
+			name:      "ready to unknown",
+			condition: "Ready",
+			statuses:  []string{"true", "unknown"},
+			expectedEvent: &metrics.Event{
+				Title:          "Node foo is NotReady",
+				Host:           "foo",
+				AlertType:      metrics.EventAlertTypeError,
+				Priority:       metrics.EventPriorityNormal,
+				AggregationKey: "kubernetes_state-alpha:node:foo",
+				SourceTypeName: "kubernetes",
+				EventType:      kubeStateMetricsCheckName,
+			},
+		},
+		{
+			name:          "not ready to ready",
+			condition:     "Ready",
+			statuses:      []string{"false", "true"},
+			expectedEvent: nil,
+		},
+		{
+			name:      "gain disk pressure",
+			condition: "DiskPressure",
+			statuses:  []string{"false", "true"},
+			expectedEvent: &metrics.Event{
+				Title:          "Node foo has DiskPressure",
+				Host:           "foo",
+				AlertType:      metrics.EventAlertTypeWarning,
+				Priority:       metrics.EventPriorityNormal,
+				AggregationKey: "kubernetes_state-alpha:node:foo",
+				SourceTypeName: "kubernetes",
+				EventType:      kubeStateMetricsCheckName,
+			},
+		},
+		{
+			name:          "lose disk pressure",
+			condition:     "DiskPressure",
+			statuses:      []string{"true", "false"},
+			expectedEvent: nil,
+		},
+		{
+			name:          "no transition",
+			condition:     "DiskPressure",
+			statuses:      []string{"true", "true"},
+			expectedEvent: nil,
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+			for _, status := range tt.statuses {
+				nodeConditionTransformer(k, s, "kube_node_status_condition", conditionMetric(tt.condition, status), "foo", []string{"host:foo", "condition:" + tt.condition, "status:" + status})
+				k.endRun()
+			}
+			if tt.expectedEvent != nil {
+				tt.expectedEvent.Ts = time.Now().Unix()
+				s.AssertEvent(t, *tt.expectedEvent, time.Minute)
+				s.AssertNumberOfCalls(t, "Event", 1)
+			} else {
+				s.AssertNotCalled(t, "Event")
+			}
+		})
+	}
+}
+
+func Test_nodeConditionEventDeletedNodes(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: map[string]string{"status": "condition_status"}})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	// The nodes not seen during a run are forgotten
+	k.nodeConditionEvent(s, "foo", "Ready", "true", "foo", []string{"host:foo", "condition:Ready", "condition_status:true"})
+	k.nodeConditionEvent(s, "bar", "Ready", "true", "bar", []string{"host:bar", "condition:Ready", "condition_status:true"})
+	k.endRun()
+	k.nodeConditionEvent(s, "foo", "Ready", "true", "foo", []string{"host:foo", "condition:Ready", "condition_status:true"})
+	k.endRun()
+	assert.Equal(t, map[string]map[string]string{"foo": {"Ready": "true"}}, k.nodeConditions)
+
+	// The remapped status tag is removed from the event
+	k.nodeConditionEvent(s, "foo", "Ready", "false", "foo", []string{"host:foo", "condition:Ready", "condition_status:false"})
+	s.AssertCalled(t, "Event", mock.MatchedBy(func(e metrics.Event) bool {
+		return e.Title == "Node foo is NotReady" && assert.ObjectsAreEqual([]string{"host:foo", "condition:Ready"}, e.Tags)
+	}))
+}
+
+func TestKSMCheck_processNodePressure(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	for _, m := range []struct{ node, condition, status string }{
+		{"foo", "MemoryPressure", "true"},
+		{"bar", "MemoryPressure", "true"},
+		{"baz", "MemoryPressure", "false"},
+		{"foo", "DiskPressure", "false"},
+		{"bar", "DiskPressure", "false"},
+		{"foo", "Ready", "true"},
+	} {
+		metric := ksmstore.DDMetric{Val: 1, Labels: map[string]string{"node": m.node, "condition": m.condition, "status": m.status}}
+		nodeConditionTransformer(k, s, "kube_node_status_condition", metric, m.node, []string{"host:" + m.node, "condition:" + m.condition, "status:" + m.status})
+	}
+	// Inactive status
+	metric := ksmstore.DDMetric{Val: 0, Labels: map[string]string{"node": "baz", "condition": "DiskPressure", "status": "true"}}
+	nodeConditionTransformer(k, s, "kube_node_status_condition", metric, "baz", []string{"host:baz", "condition:DiskPressure", "status:true"})
+
+	k.processNodePressure(s)
+
+	s.AssertMetric(t, "Gauge", "kubernetes_state.nodes.memory_pressure", 2, "", nil)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.nodes.disk_pressure", 0, "", nil)
+	s.AssertNotCalled(t, "Gauge", "kubernetes_state.nodes.pid_pressure", mock.Anything, mock.Anything, mock.Anything)
+	assert.Empty(t, k.nodesUnderPressure)
+}
+
+func Test_jobFailedTransformer(t *testing.T) {
+	tests := []struct {
+		name                 string
+		config               *KSMConfig
+		metric               ksmstore.DDMetric
+		tags                 []string
+		expectedServiceCheck bool
+	}{
+		{
+			name: "failed",
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"job_name": "foo", "namespace": "default", "condition": "true"},
+			},
+			tags:                 []string{"job_name:foo", "kube_namespace:default", "condition:true"},
+			expectedServiceCheck: true,
+		},
+		{
+			name:   "failed, remapped condition",
+			config: &KSMConfig{LabelsMapper: map[string]string{"condition": "job_condition"}},
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"job_name": "foo", "namespace": "default", "condition": "true"},
+			},
+			tags:                 []string{"job_name:foo", "kube_namespace:default", "job_condition:true"},
+			expectedServiceCheck: true,
+		},
+		{
+			name: "not failed",
+			metric: ksmstore.DDMetric{
+				Val:    0,
+				Labels: map[string]string{"job_name": "foo", "namespace": "default", "condition": "true"},
+			},
+			tags:                 []string{"job_name:foo", "kube_namespace:default", "condition:true"},
+			expectedServiceCheck: false,
+		},
+		{
+			name: "inactive condition",
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"job_name": "foo", "namespace": "default", "condition": "false"},
+			},
+			tags:                 []string{"job_name:foo", "kube_namespace:default", "condition:false"},
+			expectedServiceCheck: false,
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			if config == nil {
+				config = &KSMConfig{}
+			}
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), config)
+			jobFailedTransformer(k, s, "kube_job_failed", tt.metric, "", tt.tags)
+			if tt.expectedServiceCheck {
+				s.AssertServiceCheck(t, "kubernetes_state.job.complete", metrics.ServiceCheckCritical, "", []string{"job_name:foo", "kube_namespace:default"}, "Job default/foo failed")
+				s.AssertNotCalled(t, "ServiceCheck", "kubernetes_state.job.complete", metrics.ServiceCheckCritical, "", mocksender.MatchTagsContains([]string{"job_condition:true"}), "Job default/foo failed")
+				s.AssertNumberOfCalls(t, "ServiceCheck", 1)
+			} else {
+				s.AssertNotCalled(t, "ServiceCheck")
+			}
+			// No event during the first run
+			s.AssertNotCalled(t, "Event")
+		})
+	}
+}
+
+func Test_jobFailedTransformerRemappedCondition(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: map[string]string{"condition": "job_condition"}})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	// The remapped condition tag is removed from the event of a job failing after the first run
+	k.hasRun = true
+	metric := ksmstore.DDMetric{Val: 1, Labels: map[string]string{"job_name": "foo", "namespace": "default", "condition": "true"}}
+	jobFailedTransformer(k, s, "kube_job_failed", metric, "", []string{"job_name:foo", "kube_namespace:default", "job_condition:true"})
+	s.AssertCalled(t, "Event", mock.MatchedBy(func(e metrics.Event) bool {
+		return e.Title == "Job default/foo failed" && assert.ObjectsAreEqual([]string{"job_name:foo", "kube_namespace:default"}, e.Tags)
+	}))
+}
+
+func Test_jobFailureEvent(t *testing.T) {
+	failedMetric := func(val float64) ksmstore.DDMetric {
+		return ksmstore.DDMetric{
+			Val:    val,
+			Labels: map[string]string{"job_name": "foo", "namespace": "default", "condition": "true"},
+		}
+	}
+	statusFailedMetric := func(val float64) ksmstore.DDMetric {
+		return ksmstore.DDMetric{
+			Val:    val,
+			Labels: map[string]string{"job_name": "foo", "namespace": "default"},
+		}
+	}
+	tests := []struct {
+		name          string
+		transformer   metricTransformerFunc
+		metricName    string
+		metrics       []ksmstore.DDMetric
+		expectedTitle string
+	}{
+		{
+			name:          "job newly failed",
+			transformer:   jobFailedTransformer,
+			metricName:    "kube_job_failed",
+			metrics:       []ksmstore.DDMetric{failedMetric(0), failedMetric(1)},
+			expectedTitle: "Job default/foo failed",
+		},
+		{
+			name:          "job still failed",
+			transformer:   jobFailedTransformer,
+			metricName:    "kube_job_failed",
+			metrics:       []ksmstore.DDMetric{failedMetric(1), failedMetric(1)},
+			expectedTitle: "",
+		},
+		{
+			name:          "new job failed after the first run",
+			transformer:   jobFailedTransformer,
+			metricName:    "kube_job_failed",
+			metrics:       []ksmstore.DDMetric{{}, failedMetric(1)},
+			expectedTitle: "Job default/foo failed",
+		},
+		{
+			name:        "cronjob job newly failed",
+			transformer: jobFailedTransformer,
+			metricName:  "kube_job_failed",
+			metrics: []ksmstore.DDMetric{{}, {
+				Val:    1,
+				Labels: map[string]string{"job_name": "foo-1600000000", "namespace": "default", "condition": "true"},
+			}},
+			expectedTitle: "Job default/foo-1600000000 failed",
+		},
+		{
+			name:          "new failed pods",
+			transformer:   jobStatusFailedTransformer,
+			metricName:    "kube_job_status_failed",
+			metrics:       []ksmstore.DDMetric{statusFailedMetric(1), statusFailedMetric(3)},
+			expectedTitle: "Job default/foo has 2 new failed pod(s)",
+		},
+		{
+			name:          "no new failed pods",
+			transformer:   jobStatusFailedTransformer,
+			metricName:    "kube_job_status_failed",
+			metrics:       []ksmstore.DDMetric{statusFailedMetric(3), statusFailedMetric(3)},
+			expectedTitle: "",
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+			for _, metric := range tt.metrics {
+				if metric.Labels != nil {
+					tt.transformer(k, s, tt.metricName, metric, "", []string{"job_name:foo", "kube_namespace:default"})
+				}
+				k.endRun()
+			}
+			if tt.expectedTitle != "" {
+				s.AssertCalled(t, "Event", mock.MatchedBy(func(e metrics.Event) bool {
+					return e.Title == tt.expectedTitle && e.AggregationKey == "kubernetes_state-alpha:job:default/foo"
+				}))
+				s.AssertNumberOfCalls(t, "Event", 1)
+			} else {
+				s.AssertNotCalled(t, "Event")
+			}
+		})
+	}
+}
+
+func Test_jobFailureEventBothFamilies(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+	tags := []string{"job_name:foo", "kube_namespace:default"}
+	failed := func(val float64) ksmstore.DDMetric {
+		return ksmstore.DDMetric{Val: val, Labels: map[string]string{"job_name": "foo", "namespace": "default", "condition": "true"}}
+	}
+	statusFailed := func(val float64) ksmstore.DDMetric {
+		return ksmstore.DDMetric{Val: val, Labels: map[string]string{"job_name": "foo", "namespace": "default"}}
+	}
+
+	jobFailedTransformer(k, s, "kube_job_failed", failed(0), "", tags)
+	jobStatusFailedTransformer(k, s, "kube_job_status_failed", statusFailed(0), "", tags)
+	k.endRun()
+
+	// The failure is reported by both families, a single event is sent
+	jobFailedTransformer(k, s, "kube_job_failed", failed(1), "", tags)
+	jobStatusFailedTransformer(k, s, "kube_job_status_failed", statusFailed(1), "", tags)
+	s.AssertNumberOfCalls(t, "Event", 1)
+	assert.Equal(t, float64(1), k.droppedEvents[eventDroppedDuplicate])
+}
+
+func Test_containerTerminatedReasonTransformer(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *KSMConfig
+		metric   ksmstore.DDMetric
+		tags     []string
+		expected *metricsExpected
+	}{
+		{
+			name: "OOMKilled",
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default", "reason": "OOMKilled"},
+			},
+			tags: []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:OOMKilled"},
+			expected: &metricsExpected{
+				name: "kubernetes_state.container.status_report.count.terminated",
+				val:  1,
+				tags: []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:OOMKilled"},
+			},
+		},
+		{
+			name: "not allowed reason",
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default", "reason": "Completed"},
+			},
+			tags:     []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:Completed"},
+			expected: nil,
+		},
+		{
+			name:   "configured reason",
+			config: &KSMConfig{TerminatedReasons: ReasonsConfig{Add: []string{"Completed"}}},
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default", "reason": "Completed"},
+			},
+			tags: []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:Completed"},
+			expected: &metricsExpected{
+				name: "kubernetes_state.container.status_report.count.terminated",
+				val:  1,
+				tags: []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:Completed"},
+			},
+		},
+		{
+			name:   "removed reason",
+			config: &KSMConfig{TerminatedReasons: ReasonsConfig{Remove: []string{"Error"}}},
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default", "reason": "Error"},
+			},
+			tags:     []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:Error"},
+			expected: nil,
+		},
+		{
+			name: "no reason label",
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default"},
+			},
+			tags:     []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default"},
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			if config == nil {
+				config = &KSMConfig{}
+			}
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), config)
+			containerTerminatedReasonTransformer(k, s, "kube_pod_container_status_terminated_reason", tt.metric, "", tt.tags)
+			if tt.expected != nil {
+				s.AssertMetric(t, "Gauge", tt.expected.name, tt.expected.val, "", tt.expected.tags)
+				s.AssertNumberOfCalls(t, "Gauge", 1)
+			} else {
+				s.AssertNotCalled(t, "Gauge")
+			}
+		})
+	}
+}
+
+func Test_containerLastTerminatedReasonTransformer(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *KSMConfig
+		metric   ksmstore.DDMetric
+		tags     []string
+		expected *metricsExpected
+	}{
+		{
+			name: "OOMKilled",
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default", "reason": "OOMKilled"},
+			},
+			tags: []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:OOMKilled"},
+			expected: &metricsExpected{
+				name: "kubernetes_state.container.last_terminated_reason",
+				val:  1,
+				tags: []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:OOMKilled"},
+			},
+		},
+		{
+			name: "not allowed reason",
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default", "reason": "Completed"},
+			},
+			tags:     []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:Completed"},
+			expected: nil,
+		},
+		{
+			name:   "configured reason",
+			config: &KSMConfig{TerminatedReasons: ReasonsConfig{Add: []string{"Completed"}}},
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default", "reason": "Completed"},
+			},
+			tags: []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:Completed"},
+			expected: &metricsExpected{
+				name: "kubernetes_state.container.last_terminated_reason",
+				val:  1,
+				tags: []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:Completed"},
+			},
+		},
+		{
+			name: "no reason label",
+			metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default"},
+			},
+			tags:     []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default"},
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			if config == nil {
+				config = &KSMConfig{}
+			}
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), config)
+			k.hasRun = true
+			containerLastTerminatedReasonTransformer(k, s, "kube_pod_container_status_last_terminated_reason", tt.metric, "", tt.tags)
+			if tt.expected != nil {
+				s.AssertMetric(t, "Gauge", tt.expected.name, tt.expected.val, "", tt.expected.tags)
+				s.AssertNumberOfCalls(t, "Gauge", 1)
+			} else {
+				s.AssertNotCalled(t, "Gauge")
+			}
+			// The OOMKilled events are sent for the current termination only
+			s.AssertNotCalled(t, "Event")
+		})
+	}
+}
+
+func Test_oomKilledEvent(t *testing.T) {
+	oomKilled := ksmstore.DDMetric{
+		Val:    1,
+		Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default", "reason": "OOMKilled"},
+	}
+	tags := []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "host:minikube", "reason:OOMKilled"}
+
+	s := mocksender.NewMockSender("ksm")
+	s.SetupAcceptAll()
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+
+	// first run, the container was already OOMKilled
+	containerTerminatedReasonTransformer(k, s, "kube_pod_container_status_terminated_reason", oomKilled, "", tags)
+	k.endRun()
+	s.AssertNotCalled(t, "Event")
+
+	// the container is still OOMKilled
+	containerTerminatedReasonTransformer(k, s, "kube_pod_container_status_terminated_reason", oomKilled, "", tags)
+	k.endRun()
+	s.AssertNotCalled(t, "Event")
+
+	// the container restarted
+	k.endRun()
+
+	// the container was OOMKilled again, reported twice in the same run
+	containerTerminatedReasonTransformer(k, s, "kube_pod_container_status_terminated_reason", oomKilled, "", tags)
+	containerTerminatedReasonTransformer(k, s, "kube_pod_container_status_terminated_reason", oomKilled, "", tags)
+	k.endRun()
+	s.AssertEvent(t, metrics.Event{
+		Ts:             time.Now().Unix(),
+		Priority:       metrics.EventPriorityNormal,
+		Tags:           []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "host:minikube"},
+		AggregationKey: "kubernetes_state-alpha:container:default/bar/foo",
+		SourceTypeName: "kubernetes",
+		EventType:      kubeStateMetricsCheckName,
+	}, time.Minute)
+	s.AssertNumberOfCalls(t, "Event", 1)
+}
+
+func Test_endpointTransformers(t *testing.T) {
+	labels := map[string]string{"endpoint": "redis", "namespace": "default"}
+	tags := []string{"endpoint:redis", "kube_namespace:default"}
+	expectedTags := []string{"endpoint:redis", "kube_namespace:default", "kube_service:redis"}
+
+	RunTransformerTests(t, endpointAddressAvailableTransformer, []TransformerTestCase{
+		{
+			Name:       "available addresses",
+			MetricName: "kube_endpoint_address_available",
+			Metric:     ksmstore.DDMetric{Val: 3, Labels: labels},
+			Tags:       tags,
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.endpoint.address_available", Value: 3, Tags: expectedTags},
+				{Method: "Gauge", Name: "kubernetes_state.service.available", Value: 1, Tags: expectedTags},
+			},
+		},
+		{
+			Name:       "no available address",
+			MetricName: "kube_endpoint_address_available",
+			Metric:     ksmstore.DDMetric{Val: 0, Labels: labels},
+			Tags:       tags,
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.endpoint.address_available", Value: 0, Tags: expectedTags},
+				{Method: "Gauge", Name: "kubernetes_state.service.available", Value: 0, Tags: expectedTags},
+			},
+		},
+		{
+			Name:       "no endpoint label",
+			MetricName: "kube_endpoint_address_available",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"namespace": "default"}},
+			Tags:       []string{"kube_namespace:default"},
+		},
+	})
+
+	RunTransformerTests(t, endpointAddressNotReadyTransformer, []TransformerTestCase{
+		{
+			Name:       "not ready addresses",
+			MetricName: "kube_endpoint_address_not_ready",
+			Metric:     ksmstore.DDMetric{Val: 2, Labels: labels},
+			Tags:       tags,
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.endpoint.address_not_ready", Value: 2, Tags: expectedTags},
+			},
+		},
+	})
+}
+
+func Test_storageClassInfoTransformer(t *testing.T) {
+	RunTransformerTests(t, storageClassInfoTransformer, []TransformerTestCase{
+		{
+			Name:       "nominal case",
+			MetricName: "kube_storageclass_info",
+			Metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"storageclass": "standard", "provisioner": "kubernetes.io/gce-pd", "reclaim_policy": "Delete", "volume_binding_mode": "Immediate"},
+			},
+			Tags: []string{"storageclass:standard"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.storageclass.count", Value: 1, Tags: []string{"provisioner:kubernetes.io/gce-pd", "reclaim_policy:Delete"}},
+			},
+		},
+		{
+			Name:       "no reclaim_policy label",
+			MetricName: "kube_storageclass_info",
+			Metric: ksmstore.DDMetric{
+				Val:    1,
+				Labels: map[string]string{"storageclass": "standard", "provisioner": "kubernetes.io/gce-pd"},
+			},
+			Tags: []string{"storageclass:standard"},
+		},
+	})
+}
+
+func Test_pvcStatusPhaseTransformer(t *testing.T) {
+	type serviceCheckExpected struct {
+		status  metrics.ServiceCheckStatus
+		message string
+	}
+	tests := []struct {
+		name                 string
+		metric               ksmstore.DDMetric
+		expectedMetric       *metricsExpected
+		expectedServiceCheck *serviceCheckExpected
+	}{
+		{
+			name:                 "bound",
+			metric:               ksmstore.DDMetric{Val: 1, Labels: map[string]string{"persistentvolumeclaim": "data", "namespace": "default", "phase": "Bound"}},
+			expectedMetric:       &metricsExpected{name: "kubernetes_state.persistentvolumeclaim.status", val: 1, tags: []string{"persistentvolumeclaim:data", "pvc_phase:Bound"}},
+			expectedServiceCheck: &serviceCheckExpected{status: metrics.ServiceCheckOK},
+		},
+		{
+			name:                 "pending",
+			metric:               ksmstore.DDMetric{Val: 1, Labels: map[string]string{"persistentvolumeclaim": "data", "namespace": "default", "phase": "Pending"}},
+			expectedMetric:       &metricsExpected{name: "kubernetes_state.persistentvolumeclaim.status", val: 1, tags: []string{"persistentvolumeclaim:data", "pvc_phase:Pending"}},
+			expectedServiceCheck: &serviceCheckExpected{status: metrics.ServiceCheckWarning, message: "Persistent volume claim default/data is Pending"},
+		},
+		{
+			name:                 "lost",
+			metric:               ksmstore.DDMetric{Val: 1, Labels: map[string]string{"persistentvolumeclaim": "data", "namespace": "default", "phase": "Lost"}},
+			expectedMetric:       &metricsExpected{name: "kubernetes_state.persistentvolumeclaim.status", val: 1, tags: []string{"persistentvolumeclaim:data", "pvc_phase:Lost"}},
+			expectedServiceCheck: &serviceCheckExpected{status: metrics.ServiceCheckCritical, message: "Persistent volume claim default/data is Lost"},
+		},
+		{
+			name:                 "inactive phase",
+			metric:               ksmstore.DDMetric{Val: 0, Labels: map[string]string{"persistentvolumeclaim": "data", "namespace": "default", "phase": "Lost"}},
+			expectedMetric:       &metricsExpected{name: "kubernetes_state.persistentvolumeclaim.status", val: 0, tags: []string{"persistentvolumeclaim:data", "pvc_phase:Lost"}},
+			expectedServiceCheck: nil,
+		},
+		{
+			name:                 "no phase label",
+			metric:               ksmstore.DDMetric{Val: 1, Labels: map[string]string{"persistentvolumeclaim": "data", "namespace": "default"}},
+			expectedMetric:       nil,
+			expectedServiceCheck: nil,
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper})
+			// The phase label of the metric is mapped to pod_phase, the active phase of the claim is joined as pvc_phase
+			tags := []string{"persistentvolumeclaim:data", "kube_namespace:default", "pvc_phase:Bound"}
+			phase, found := tt.metric.Labels["phase"]
+			if found {
+				tags = append(tags, "pod_phase:"+phase)
+			}
+			pvcStatusPhaseTransformer(k, s, "kube_persistentvolumeclaim_status_phase", tt.metric, "", tags)
+			if tt.expectedMetric != nil {
+				s.AssertMetric(t, "Gauge", tt.expectedMetric.name, tt.expectedMetric.val, "", tt.expectedMetric.tags)
+				s.AssertNotCalled(t, "Gauge", tt.expectedMetric.name, tt.expectedMetric.val, "", mocksender.MatchTagsContains([]string{"pod_phase:" + phase}))
+				if phase != "Bound" {
+					s.AssertNotCalled(t, "Gauge", tt.expectedMetric.name, tt.expectedMetric.val, "", mocksender.MatchTagsContains([]string{"pvc_phase:Bound"}))
+				}
+				s.AssertNumberOfCalls(t, "Gauge", 1)
+			} else {
+				s.AssertNotCalled(t, "Gauge")
+			}
+			if tt.expectedServiceCheck != nil {
+				s.AssertServiceCheck(t, "kubernetes_state.persistentvolumeclaim.status", tt.expectedServiceCheck.status, "", []string{"persistentvolumeclaim:data", "kube_namespace:default"}, tt.expectedServiceCheck.message)
+				s.AssertNotCalled(t, "ServiceCheck", "kubernetes_state.persistentvolumeclaim.status", tt.expectedServiceCheck.status, "", mocksender.MatchTagsContains([]string{"pvc_phase:" + phase}), tt.expectedServiceCheck.message)
+				s.AssertNumberOfCalls(t, "ServiceCheck", 1)
+			} else {
+				s.AssertNotCalled(t, "ServiceCheck")
+			}
+		})
+	}
+}
+
+func Test_configMapSecretTransformers(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        *KSMConfig
+		transformer   metricTransformerFunc
+		metricName    string
+		metric        ksmstore.DDMetric
+		expectedGauge *metricsExpected
+		expectedCount *metricsExpected
+	}{
+		{
+			name:          "configmap",
+			config:        &KSMConfig{LabelsMapper: defaultLabelsMapper},
+			transformer:   configMapInfoTransformer,
+			metricName:    "kube_configmap_info",
+			metric:        ksmstore.DDMetric{Val: 1, Labels: map[string]string{"configmap": "foo", "namespace": "default"}},
+			expectedCount: &metricsExpected{name: "kubernetes_state.configmap.count", val: 1, tags: []string{"kube_namespace:default"}},
+		},
+		{
+			name:          "configmap, remapped namespace",
+			config:        &KSMConfig{LabelsMapper: map[string]string{"namespace": "ns"}},
+			transformer:   configMapInfoTransformer,
+			metricName:    "kube_configmap_info",
+			metric:        ksmstore.DDMetric{Val: 1, Labels: map[string]string{"configmap": "foo", "namespace": "default"}},
+			expectedCount: &metricsExpected{name: "kubernetes_state.configmap.count", val: 1, tags: []string{"ns:default"}},
+		},
+		{
+			name:        "configmap, counts disabled",
+			config:      &KSMConfig{DisableConfigMapSecretCounts: true},
+			transformer: configMapInfoTransformer,
+			metricName:  "kube_configmap_info",
+			metric:      ksmstore.DDMetric{Val: 1, Labels: map[string]string{"configmap": "foo", "namespace": "default"}},
+		},
+		{
+			name:          "secret",
+			config:        &KSMConfig{LabelsMapper: defaultLabelsMapper},
+			transformer:   secretTypeTransformer,
+			metricName:    "kube_secret_type",
+			metric:        ksmstore.DDMetric{Val: 1, Labels: map[string]string{"secret": "foo", "namespace": "default", "type": "Opaque"}},
+			expectedGauge: &metricsExpected{name: "kubernetes_state.secret.type", val: 1, tags: []string{"secret:foo", "kube_namespace:default"}},
+			expectedCount: &metricsExpected{name: "kubernetes_state.secret.count", val: 1, tags: []string{"kube_namespace:default", "secret_type:Opaque"}},
+		},
+		{
+			name:          "secret, counts disabled",
+			config:        &KSMConfig{DisableConfigMapSecretCounts: true},
+			transformer:   secretTypeTransformer,
+			metricName:    "kube_secret_type",
+			metric:        ksmstore.DDMetric{Val: 1, Labels: map[string]string{"secret": "foo", "namespace": "default", "type": "Opaque"}},
+			expectedGauge: &metricsExpected{name: "kubernetes_state.secret.type", val: 1, tags: []string{"secret:foo", "kube_namespace:default"}},
+		},
+	}
+	for _, tt := range tests {
+		s := mocksender.NewMockSender("ksm")
+		s.SetupAcceptAll()
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), tt.config)
+			tt.transformer(k, s, tt.metricName, tt.metric, "", []string{"secret:foo", "kube_namespace:default"})
+			if tt.expectedGauge != nil {
+				s.AssertMetric(t, "Gauge", tt.expectedGauge.name, tt.expectedGauge.val, "", tt.expectedGauge.tags)
+				s.AssertNumberOfCalls(t, "Gauge", 1)
+			} else {
+				s.AssertNotCalled(t, "Gauge")
+			}
+			if tt.expectedCount != nil {
+				s.AssertMetric(t, "Count", tt.expectedCount.name, tt.expectedCount.val, "", tt.expectedCount.tags)
+				s.AssertNumberOfCalls(t, "Count", 1)
+			} else {
+				s.AssertNotCalled(t, "Count")
+			}
+		})
+	}
+}
+
+func Test_containerWaitingReasonTransformer(t *testing.T) {
+	metric := func(reason string) ksmstore.DDMetric {
+		return ksmstore.DDMetric{
+			Val:    1,
+			Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default", "reason": reason},
+		}
+	}
+	tags := func(reason string) []string {
+		return []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default", "reason:" + reason}
+	}
+	RunTransformerTests(t, containerWaitingReasonTransformer, []TransformerTestCase{
+		{
+			Name:       "CrashLoopBackOff",
+			MetricName: "kube_pod_container_status_waiting_reason",
+			Metric:     metric("CrashLoopBackOff"),
+			Tags:       tags("CrashLoopBackOff"),
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.container.status_report.count.waiting", Value: 1, Tags: tags("CrashLoopBackOff")},
+			},
+		},
+		{
+			Name:       "not allowed reason",
+			MetricName: "kube_pod_container_status_waiting_reason",
+			Metric:     metric("CreateContainerConfigError"),
+			Tags:       tags("CreateContainerConfigError"),
+		},
+		{
+			Name:       "configured reason",
+			Config:     &KSMConfig{WaitingReasons: ReasonsConfig{Add: []string{"CreateContainerConfigError", "InvalidImageName"}}},
+			MetricName: "kube_pod_container_status_waiting_reason",
+			Metric:     metric("CreateContainerConfigError"),
+			Tags:       tags("CreateContainerConfigError"),
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.container.status_report.count.waiting", Value: 1, Tags: tags("CreateContainerConfigError")},
+			},
+		},
+		{
+			Name:       "removed reason",
+			Config:     &KSMConfig{WaitingReasons: ReasonsConfig{Remove: []string{"containercreating"}}},
+			MetricName: "kube_pod_container_status_waiting_reason",
+			Metric:     metric("ContainerCreating"),
+			Tags:       tags("ContainerCreating"),
+		},
+		{
+			Name:       "all reasons, known reason",
+			Config:     &KSMConfig{ReportAllWaitingReasons: true},
+			MetricName: "kube_pod_container_status_waiting_reason",
+			Metric:     metric("ImageInspectError"),
+			Tags:       tags("ImageInspectError"),
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.container.status_report.count.waiting", Value: 1, Tags: tags("ImageInspectError")},
+			},
+		},
+		{
+			Name:       "all reasons, unknown reason",
+			Config:     &KSMConfig{ReportAllWaitingReasons: true},
+			MetricName: "kube_pod_container_status_waiting_reason",
+			Metric:     metric("SomethingNew"),
+			Tags:       tags("SomethingNew"),
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.container.status_report.count.waiting", Value: 1, Tags: tags("other")},
+			},
+		},
+		{
+			Name:       "no reason label",
+			MetricName: "kube_pod_container_status_waiting_reason",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"container": "foo", "pod": "bar", "namespace": "default"}},
+			Tags:       []string{"kube_container_name:foo", "pod_name:bar", "kube_namespace:default"},
+		},
+	})
+}
+
+func Test_podInfoTransformer(t *testing.T) {
+	labels := map[string]string{"pod": "foo", "namespace": "default", "node": "bar", "created_by_kind": "DaemonSet", "created_by_name": "baz"}
+	config := &KSMConfig{LabelsMapper: defaultLabelsMapper}
+	RunTransformerTests(t, podInfoTransformer, []TransformerTestCase{
+		{
+			Name:       "running pod",
+			Config:     config,
+			MetricName: "kube_pod_info",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: labels},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default", "host:bar", "pod_phase:Running"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.node.pods_running", Value: 1, Hostname: "bar", Tags: []string{"host:bar"}},
+			},
+		},
+		{
+			Name:       "pending pod",
+			Config:     config,
+			MetricName: "kube_pod_info",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: labels},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default", "host:bar", "pod_phase:Pending"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.node.pods_pending", Value: 1, Hostname: "bar", Tags: []string{"host:bar"}},
+			},
+		},
+		{
+			Name:       "succeeded pod",
+			Config:     config,
+			MetricName: "kube_pod_info",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: labels},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default", "host:bar", "pod_phase:Succeeded"},
+		},
+		{
+			Name:       "no phase",
+			Config:     config,
+			MetricName: "kube_pod_info",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: labels},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default", "host:bar"},
+		},
+		{
+			Name:       "unscheduled pod",
+			Config:     config,
+			MetricName: "kube_pod_info",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"pod": "foo", "namespace": "default", "node": ""}},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default", "pod_phase:Pending"},
+		},
+		{
+			Name:       "priority class",
+			Config:     config,
+			MetricName: "kube_pod_info",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"pod": "foo", "namespace": "default", "node": "", "priority_class": "system-node-critical"}},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default", "pod_phase:Pending"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.pod.priority_class", Value: 1, Tags: []string{"kube_namespace:default", "priority_class:system-node-critical"}},
+			},
+		},
+	})
+}
+
+func Test_podQOSClassTransformer(t *testing.T) {
+	config := &KSMConfig{LabelsMapper: defaultLabelsMapper}
+	RunTransformerTests(t, podQOSClassTransformer, []TransformerTestCase{
+		{
+			Name:       "current class",
+			Config:     config,
+			MetricName: "kube_pod_status_qos_class",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"pod": "foo", "namespace": "default", "qos_class": "BestEffort"}},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default", "qos_class:BestEffort"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.pod.qos_class", Value: 1, Tags: []string{"kube_namespace:default", "qos_class:besteffort"}},
+			},
+		},
+		{
+			Name:       "other class",
+			Config:     config,
+			MetricName: "kube_pod_status_qos_class",
+			Metric:     ksmstore.DDMetric{Val: 0, Labels: map[string]string{"pod": "foo", "namespace": "default", "qos_class": "Guaranteed"}},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default", "qos_class:Guaranteed"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.pod.qos_class", Value: 0, Tags: []string{"kube_namespace:default", "qos_class:guaranteed"}},
+			},
+		},
+		{
+			Name:       "no qos_class label",
+			Config:     config,
+			MetricName: "kube_pod_status_qos_class",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"pod": "foo", "namespace": "default"}},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default"},
+		},
+		{
+			Name:       "remapped namespace",
+			Config:     &KSMConfig{LabelsMapper: map[string]string{"namespace": "ns"}},
+			MetricName: "kube_pod_status_qos_class",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"pod": "foo", "namespace": "default", "qos_class": "BestEffort"}},
+			Tags:       []string{"pod:foo", "ns:default", "qos_class:BestEffort"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.pod.qos_class", Value: 1, Tags: []string{"ns:default", "qos_class:besteffort"}},
+			},
+		},
+	})
+}
+
+func Test_resourceQuotaMetricResource(t *testing.T) {
+	tests := []struct {
+		resource string
+		expected string
+	}{
+		{resource: "pods", expected: "pods"},
+		{resource: "requests.cpu", expected: "requests.cpu"},
+		{resource: "services.loadbalancers", expected: "services.loadbalancers"},
+		{resource: "count/pods", expected: "count.pods"},
+		{resource: "count/deployments.apps", expected: "count.deployments_apps"},
+		{resource: "count/widgets.example.com", expected: "count.widgets_example_com"},
+		{resource: "requests.nvidia.com/gpu", expected: "requests.nvidia_com_gpu"},
+		{resource: "gold.storageclass.storage.k8s.io/requests.storage", expected: "gold.storageclass.storage.k8s.io_requests.storage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resource, func(t *testing.T) {
+			assert.Equal(t, tt.expected, resourceQuotaMetricResource(tt.resource))
+		})
+	}
+}
+
+func TestKSMCheck_processResourceQuotas(t *testing.T) {
+	tags := []string{"resourcequota:gke-resource-quotas", "kube_namespace:default", "resource:pods"}
+	tests := []struct {
+		name            string
+		config          *KSMConfig
+		used            float64
+		limit           float64
+		expectedStatus  metrics.ServiceCheckStatus
+		expectedMessage string
+	}{
+		{
+			name:           "under thresholds",
+			config:         &KSMConfig{},
+			used:           50,
+			limit:          100,
+			expectedStatus: metrics.ServiceCheckOK,
+		},
+		{
+			name:            "warning",
+			config:          &KSMConfig{},
+			used:            90,
+			limit:           100,
+			expectedStatus:  metrics.ServiceCheckWarning,
+			expectedMessage: "90% of the pods quota is used",
+		},
+		{
+			name:            "critical",
+			config:          &KSMConfig{},
+			used:            100,
+			limit:           100,
+			expectedStatus:  metrics.ServiceCheckCritical,
+			expectedMessage: "100% of the pods quota is used",
+		},
+		{
+			name:            "custom thresholds",
+			config:          &KSMConfig{ResourceQuotaWarningThreshold: 0.4, ResourceQuotaCriticalThreshold: 0.5},
+			used:            50,
+			limit:           100,
+			expectedStatus:  metrics.ServiceCheckCritical,
+			expectedMessage: "50% of the pods quota is used",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), tt.config)
+			s := mocksender.NewMockSender(k.ID())
+			s.SetupAcceptAll()
+
+			for quotaType, val := range map[string]float64{"used": tt.used, "hard": tt.limit} {
+				metric := ksmstore.DDMetric{
+					Val:    val,
+					Labels: map[string]string{"resource": "pods", "type": quotaType, "resourcequota": "gke-resource-quotas", "namespace": "default"},
+				}
+				resourcequotaTransformer(k, s, "kube_resourcequota", metric, "", append(tags, "type:"+quotaType))
+			}
+			k.processResourceQuotas(s)
+
+			s.AssertMetric(t, "Gauge", "kubernetes_state.resourcequota.pods.utilization", tt.used/tt.limit, "", tags)
+			s.AssertServiceCheck(t, "kubernetes_state.resourcequota.utilization", tt.expectedStatus, "", tags, tt.expectedMessage)
+			assert.Len(t, k.resourceQuotas, 0)
+		})
+	}
+}
+
+func TestKSMCheck_processResourceQuotas_noLimit(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	metric := ksmstore.DDMetric{Val: 7, Labels: map[string]string{"resource": "pods", "type": "used", "resourcequota": "gke-resource-quotas", "namespace": "default"}}
+	resourcequotaTransformer(k, s, "kube_resourcequota", metric, "", nil)
+	k.processResourceQuotas(s)
+
+	s.AssertNumberOfCalls(t, "Gauge", 1)
+	s.AssertNumberOfCalls(t, "ServiceCheck", 0)
+}
+
+func TestKSMCheck_processResourceQuotas_objectCount(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	tags := []string{"resourcequota:object-counts", "kube_namespace:default", "resource:count/pods"}
+	for quotaType, val := range map[string]float64{"used": 19, "hard": 20} {
+		metric := ksmstore.DDMetric{
+			Val:    val,
+			Labels: map[string]string{"resource": "count/pods", "type": quotaType, "resourcequota": "object-counts", "namespace": "default"},
+		}
+		resourcequotaTransformer(k, s, "kube_resourcequota", metric, "", append(tags, "type:"+quotaType))
+	}
+	k.processResourceQuotas(s)
+
+	// The metric names are sanitized, the message keeps the quota resource
+	s.AssertMetric(t, "Gauge", "kubernetes_state.resourcequota.count.pods.utilization", 0.95, "", tags)
+	s.AssertServiceCheck(t, "kubernetes_state.resourcequota.utilization", metrics.ServiceCheckCritical, "", tags, "95% of the count/pods quota is used")
+}
+
+func Test_webhookConfigurationInfoTransformer(t *testing.T) {
+	RunTransformerTests(t, webhookConfigurationInfoTransformer("mutating"), []TransformerTestCase{
+		{
+			Name:       "mutating webhook configuration",
+			MetricName: "kube_mutatingwebhookconfiguration_info",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"namespace": "", "mutatingwebhookconfiguration": "istio-sidecar-injector"}},
+			Tags:       []string{"kube_namespace:", "mutatingwebhookconfiguration:istio-sidecar-injector"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.webhookconfiguration.count", Value: 1, Tags: []string{"webhook_type:mutating", "webhookconfiguration:istio-sidecar-injector"}},
+			},
+		},
+		{
+			Name:       "validating webhook configuration label",
+			MetricName: "kube_mutatingwebhookconfiguration_info",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"namespace": "", "validatingwebhookconfiguration": "foo"}},
+			Tags:       []string{"kube_namespace:", "validatingwebhookconfiguration:foo"},
+		},
+	})
+	RunTransformerTests(t, webhookConfigurationInfoTransformer("validating"), []TransformerTestCase{
+		{
+			Name:       "validating webhook configuration",
+			MetricName: "kube_validatingwebhookconfiguration_info",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"namespace": "", "validatingwebhookconfiguration": "foo"}},
+			Tags:       []string{"kube_namespace:", "validatingwebhookconfiguration:foo"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.webhookconfiguration.count", Value: 1, Tags: []string{"webhook_type:validating", "webhookconfiguration:foo"}},
+			},
+		},
+	})
+}
+
+func Test_podOverheadTransformer(t *testing.T) {
+	tags := []string{"pod_name:foo", "kube_namespace:default", "runtime_class:kata"}
+	RunTransformerTests(t, podOverheadTransformer, []TransformerTestCase{
+		{
+			Name:       "cpu",
+			MetricName: "kube_pod_overhead",
+			Metric:     ksmstore.DDMetric{Val: 0.25, Labels: map[string]string{"pod": "foo", "namespace": "default", "resource": "cpu", "unit": "core"}},
+			Tags:       tags,
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.pod.cpu_overhead", Value: 0.25, Tags: tags},
+			},
+		},
+		{
+			Name:       "memory",
+			MetricName: "kube_pod_overhead",
+			Metric:     ksmstore.DDMetric{Val: 134217728, Labels: map[string]string{"pod": "foo", "namespace": "default", "resource": "memory", "unit": "byte"}},
+			Tags:       tags,
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.pod.memory_overhead", Value: 134217728, Tags: tags},
+			},
+		},
+		{
+			Name:       "unsupported resource",
+			MetricName: "kube_pod_overhead",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"pod": "foo", "namespace": "default", "resource": "pods", "unit": "integer"}},
+			Tags:       tags,
+		},
+	})
+}
+
+func Test_podStatusReasonTransformer(t *testing.T) {
+	config := &KSMConfig{LabelsMapper: defaultLabelsMapper}
+	RunTransformerTests(t, podStatusReasonTransformer, []TransformerTestCase{
+		{
+			Name:       "node lost",
+			Config:     config,
+			MetricName: "kube_pod_status_reason",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"pod": "foo", "namespace": "default", "reason": "NodeLost"}},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default", "reason:NodeLost"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.pod.status_reason", Value: 1, Tags: []string{"kube_namespace:default", "reason:nodelost"}},
+			},
+		},
+		{
+			Name:       "other reason",
+			Config:     config,
+			MetricName: "kube_pod_status_reason",
+			Metric:     ksmstore.DDMetric{Val: 0, Labels: map[string]string{"pod": "foo", "namespace": "default", "reason": "Evicted"}},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default", "reason:Evicted"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Count", Name: "kubernetes_state.pod.status_reason", Value: 0, Tags: []string{"kube_namespace:default", "reason:evicted"}},
+			},
+		},
+		{
+			Name:       "no reason label",
+			Config:     config,
+			MetricName: "kube_pod_status_reason",
+			Metric:     ksmstore.DDMetric{Val: 1, Labels: map[string]string{"pod": "foo", "namespace": "default"}},
+			Tags:       []string{"pod_name:foo", "kube_namespace:default"},
+		},
+	})
+}
+
+func Test_podEvictedEvent(t *testing.T) {
+	evicted := ksmstore.DDMetric{
+		Val:    1,
+		Labels: map[string]string{"pod": "bar", "namespace": "default", "reason": "Evicted"},
+	}
+	tags := []string{"pod_name:bar", "kube_namespace:default", "host:minikube", "reason:Evicted"}
+
+	s := mocksender.NewMockSender("ksm")
+	s.SetupAcceptAll()
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{LabelsMapper: defaultLabelsMapper})
+
+	// first run, the pod was already evicted
+	podStatusReasonTransformer(k, s, "kube_pod_status_reason", evicted, "", tags)
+	k.endRun()
+	s.AssertNotCalled(t, "Event")
+
+	// the pod is still evicted
+	podStatusReasonTransformer(k, s, "kube_pod_status_reason", evicted, "", tags)
+	k.endRun()
+	s.AssertNotCalled(t, "Event")
+
+	// a new pod is evicted
+	evicted.Labels = map[string]string{"pod": "baz", "namespace": "default", "reason": "Evicted"}
+	tags = []string{"pod_name:baz", "kube_namespace:default", "host:minikube", "reason:Evicted"}
+	podStatusReasonTransformer(k, s, "kube_pod_status_reason", evicted, "", tags)
+	k.endRun()
+	s.AssertEvent(t, metrics.Event{
+		Title:          "Pod default/baz was Evicted",
+		Text:           "Pod baz in namespace default was evicted from node minikube",
+		Ts:             time.Now().Unix(),
+		Priority:       metrics.EventPriorityNormal,
+		Host:           "minikube",
+		Tags:           []string{"pod_name:baz", "kube_namespace:default", "host:minikube"},
+		AlertType:      metrics.EventAlertTypeWarning,
+		AggregationKey: "kubernetes_state-alpha:pod:default/baz",
+		SourceTypeName: "kubernetes",
+		EventType:      kubeStateMetricsCheckName,
+	}, time.Minute)
+	s.AssertNumberOfCalls(t, "Event", 1)
+
+	// a pod without node is evicted, the event has no host
+	k.clusterName = "prod"
+	evicted.Labels = map[string]string{"pod": "qux", "namespace": "default", "reason": "Evicted"}
+	tags = []string{"pod_name:qux", "kube_namespace:default", "reason:Evicted"}
+	podStatusReasonTransformer(k, s, "kube_pod_status_reason", evicted, "", tags)
+	s.AssertEvent(t, metrics.Event{
+		Title:          "Pod default/qux was Evicted",
+		Text:           "Pod qux in namespace default was evicted",
+		Ts:             time.Now().Unix(),
+		Priority:       metrics.EventPriorityNormal,
+		Host:           "",
+		Tags:           []string{"pod_name:qux", "kube_namespace:default"},
+		AlertType:      metrics.EventAlertTypeWarning,
+		AggregationKey: "kubernetes_state-alpha:pod:default/qux",
+		SourceTypeName: "kubernetes",
+		EventType:      kubeStateMetricsCheckName,
+	}, time.Minute)
+	s.AssertNumberOfCalls(t, "Event", 2)
+}
+
+func Test_containerRestartsTransformer(t *testing.T) {
+	RunTransformerTests(t, containerRestartsTransformer, []TransformerTestCase{
+		{
+			Name:       "container restarts",
+			MetricName: "kube_pod_container_status_restarts_total",
+			Metric:     ksmstore.DDMetric{Val: 4, Labels: map[string]string{"namespace": "default", "pod": "foo", "container": "bar"}},
+			Tags:       []string{"kube_namespace:default", "pod_name:foo", "kube_container_name:bar"},
+			ExpectedMetrics: []ExpectedMetric{
+				{Method: "Gauge", Name: "kubernetes_state.container.restarts", Value: 4, Tags: []string{"kube_namespace:default", "pod_name:foo", "kube_container_name:bar"}},
+			},
+		},
+	})
+}
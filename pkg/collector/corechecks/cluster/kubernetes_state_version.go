@@ -0,0 +1,82 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metadata/inventories"
+)
+
+const (
+	// ksmModulePath is the module of the embedded kube-state-metrics library
+	ksmModulePath = "k8s.io/kube-state-metrics"
+	// ksmVersionUnknown is reported when the agent build info isn't available
+	ksmVersionUnknown = "unknown"
+	// ksmVersionExternal is reported when the metrics are scraped from the kube_state_url endpoint
+	ksmVersionExternal = "external"
+)
+
+// readBuildInfo is used to get the version of the embedded library, it can be replaced in the tests
+var readBuildInfo = debug.ReadBuildInfo
+
+// ksmVersion returns the version of the embedded kube-state-metrics library found in the agent build info
+// The version of the replacement module is used if any, the agent pins a fork of the library
+func ksmVersion() string {
+	info, ok := readBuildInfo()
+	if !ok {
+		return ksmVersionUnknown
+	}
+	for _, m := range info.Deps {
+		if m.Path != ksmModulePath {
+			continue
+		}
+		if m.Replace != nil {
+			return m.Replace.Version
+		}
+		return m.Version
+	}
+	return ksmVersionUnknown
+}
+
+// sendVersionMetadata submits the ksm.version metric tagged by library version and enabled collectors,
+// and sets the same information with the number of objects in the stores in the check metadata
+// The metadata values must be comparable, the collectors are joined
+// It helps to tell apart the discrepancies coming from the library version and from the configuration
+func (k *KSMCheck) sendVersionMetadata(s aggregator.Sender) {
+	version := ksmVersionExternal
+	if k.scraper == nil {
+		version = k.ksmVersion
+	}
+
+	collectors := make([]string, len(k.collectors))
+	copy(collectors, k.collectors)
+	sort.Strings(collectors)
+
+	tags := make([]string, 0, len(collectors)+1)
+	tags = append(tags, "ksm_version:"+version)
+	for _, collector := range collectors {
+		tags = append(tags, "collector:"+collector)
+	}
+	s.Gauge(k.metricName("ksm.version"), 1, "", tags)
+
+	objects := 0
+	for _, store := range k.store {
+		storeObjects, _ := store.(*ksmstore.MetricsStore).Size()
+		objects += storeObjects
+	}
+
+	checkID := string(k.ID())
+	inventories.SetCheckMetadata(checkID, "ksm_version", version)
+	inventories.SetCheckMetadata(checkID, "ksm_collectors", strings.Join(collectors, ","))
+	inventories.SetCheckMetadata(checkID, "ksm_store_objects", objects)
+}
@@ -0,0 +1,85 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	"github.com/DataDog/datadog-agent/pkg/kubestatemetrics/scraper"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ksmVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		info     *debug.BuildInfo
+		ok       bool
+		expected string
+	}{
+		{
+			name:     "no build info",
+			ok:       false,
+			expected: "unknown",
+		},
+		{
+			name:     "library not found",
+			info:     &debug.BuildInfo{Deps: []*debug.Module{{Path: "k8s.io/client-go", Version: "v0.18.2"}}},
+			ok:       true,
+			expected: "unknown",
+		},
+		{
+			name:     "library version",
+			info:     &debug.BuildInfo{Deps: []*debug.Module{{Path: "k8s.io/kube-state-metrics", Version: "v1.9.7"}}},
+			ok:       true,
+			expected: "v1.9.7",
+		},
+		{
+			name: "replaced library",
+			info: &debug.BuildInfo{Deps: []*debug.Module{{
+				Path:    "k8s.io/kube-state-metrics",
+				Version: "v1.8.1-0.20200108124505-369470d6ead8",
+				Replace: &debug.Module{Path: "k8s.io/kube-state-metrics", Version: "v1.9.6-0.20200413182837-dbbe062e36a4"},
+			}}},
+			ok:       true,
+			expected: "v1.9.6-0.20200413182837-dbbe062e36a4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(f func() (*debug.BuildInfo, bool)) { readBuildInfo = f }(readBuildInfo)
+			readBuildInfo = func() (*debug.BuildInfo, bool) { return tt.info, tt.ok }
+
+			assert.Equal(t, tt.expected, ksmVersion())
+		})
+	}
+}
+
+func TestKSMCheck_sendVersionMetadata(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	k.ksmVersion = "v1.9.6"
+	k.collectors = []string{"pods", "nodes"}
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	k.sendVersionMetadata(s)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.ksm.version", 1, "", []string{"ksm_version:v1.9.6", "collector:nodes", "collector:pods"})
+
+	// The version of the kube_state_url endpoint isn't known
+	k = newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	k.scraper = scraper.New("http://localhost:8080/metrics", time.Second)
+	s = mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	k.sendVersionMetadata(s)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.ksm.version", 1, "", []string{"ksm_version:external"})
+}
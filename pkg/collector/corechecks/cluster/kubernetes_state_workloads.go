@@ -0,0 +1,330 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+)
+
+// defaultRolloutStuckTimeout is the default duration after which a rollout that didn't progress is reported, in seconds
+// It's consistent with the default progressDeadlineSeconds of the deployments
+const defaultRolloutStuckTimeout = 600
+
+// defaultDaemonSetMisscheduledRuns is the default number of consecutive runs a daemonset can have misscheduled daemons
+// before being reported
+const defaultDaemonSetMisscheduledRuns = 3
+
+// defaultReplicaMismatchGracePeriod is the default duration during which a deployment can miss available replicas
+// before being reported, in seconds
+const defaultReplicaMismatchGracePeriod = 300
+
+// workloadState contains the replicas of a workload, collected from several KSM metrics during a check run
+// and the rollout progress tracking, kept between check runs
+type workloadState struct {
+	name string
+	tags []string
+
+	replicas map[string]float64
+	// revisions contains the current and update revisions of the statefulsets
+	revisions map[string]string
+
+	// seen is true when the workload was seen during the current run
+	seen bool
+
+	// rolloutStart is the time the current rollout was first seen, zero if no rollout is in progress
+	rolloutStart time.Time
+	// lastProgress is the last time the rollout progressed
+	lastProgress time.Time
+	// lastReplicas and lastRevisions contain the replicas and revisions of the previous run, used to detect rollout progress
+	lastReplicas  map[string]float64
+	lastRevisions map[string]string
+
+	// degradedSince is the time the deployment was first seen with less available replicas than desired, zero otherwise
+	degradedSince time.Time
+
+	// misscheduledRuns is the number of consecutive runs the daemonset had misscheduled daemons
+	misscheduledRuns int
+}
+
+// Replica fields of the workloads
+const (
+	replicasDesired   = "desired"
+	replicasUpdated   = "updated"
+	replicasAvailable = "available"
+	replicasReady     = "ready"
+	// misscheduled and unavailable are only used by the daemonsets
+	replicasMisscheduled = "misscheduled"
+	replicasUnavailable  = "unavailable"
+)
+
+// Revision fields of the statefulsets
+const (
+	revisionCurrent = "current"
+	revisionUpdate  = "update"
+)
+
+// workloadReplicasTransformer returns a transformer that submits the metric using the metric names mapper and max_contexts_per_metric
+// and keeps the value of the given replica field of the workloads of the given kind for the end of the run
+func workloadReplicasTransformer(kind, field string) metricTransformerFunc {
+	return func(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+		k.submitGuardedGauge(s, name, k.formatMetricName(name), metric.Val, 0, hostname, tags)
+
+		workload, found := metric.Labels[kind]
+		if !found {
+			k.missingLabel(name, kind)
+			return
+		}
+		state := k.workloadState(kind, metric.Labels["namespace"], workload)
+		state.tags = tags
+		state.replicas[field] = metric.Val
+	}
+}
+
+// statefulSetRevisionTransformer returns a transformer that keeps the given revision of the statefulsets for the end of the run
+// The revision metrics are only used to detect rollouts, they're not submitted
+func statefulSetRevisionTransformer(field string) metricTransformerFunc {
+	return func(k *KSMCheck, s aggregator.Sender, name string, metric ksmstore.DDMetric, hostname string, tags []string) {
+		statefulset, found := metric.Labels["statefulset"]
+		if !found {
+			k.missingLabel(name, "statefulset")
+			return
+		}
+		revision, found := metric.Labels["revision"]
+		if !found {
+			k.missingLabel(name, "revision")
+			return
+		}
+		state := k.workloadState("statefulset", metric.Labels["namespace"], statefulset)
+		state.revisions[field] = revision
+	}
+}
+
+// workloadState returns the state of a workload, it's created if needed and flagged as seen during the run
+func (k *KSMCheck) workloadState(kind, namespace, name string) *workloadState {
+	workloads, found := k.workloads[kind]
+	if !found {
+		workloads = make(map[string]*workloadState)
+		k.workloads[kind] = workloads
+	}
+	key := fmt.Sprintf("%s/%s", namespace, name)
+	state, found := workloads[key]
+	if !found {
+		state = &workloadState{name: name}
+		workloads[key] = state
+	}
+	if !state.seen {
+		state.seen = true
+		state.replicas = make(map[string]float64)
+		state.revisions = make(map[string]string)
+	}
+	return state
+}
+
+// workloadProcessors contains the functions computing the metrics and service checks of the workloads per kind
+var workloadProcessors = map[string]func(*KSMCheck, aggregator.Sender, *workloadState, time.Time){
+	"deployment": func(k *KSMCheck, s aggregator.Sender, state *workloadState, now time.Time) {
+		k.deploymentRollout(s, state, now)
+		k.deploymentAvailability(s, state, now)
+	},
+	"statefulset": (*KSMCheck).statefulSetRollout,
+	"daemonset": func(k *KSMCheck, s aggregator.Sender, state *workloadState, _ time.Time) {
+		k.daemonSetScheduling(s, state)
+		k.daemonSetMisscheduling(s, state)
+	},
+}
+
+// processWorkloads submits the metrics and service checks computed from several KSM metrics of a workload
+// It's called at the end of the run, once all the metrics have been processed
+func (k *KSMCheck) processWorkloads(s aggregator.Sender) {
+	now := time.Now()
+	for kind, workloads := range k.workloads {
+		for key, state := range workloads {
+			if !state.seen {
+				// The workload is deleted
+				delete(workloads, key)
+				continue
+			}
+			if process, found := workloadProcessors[kind]; found {
+				process(k, s, state, now)
+			}
+			state.lastReplicas = state.replicas
+			state.lastRevisions = state.revisions
+			state.seen = false
+		}
+	}
+}
+
+// trackRollout updates the rollout progress tracking of a workload and returns for how long the rollout didn't progress
+// The rollout progresses when one of the given replica fields or a revision changed since the previous run
+func (state *workloadState) trackRollout(inProgress bool, now time.Time, fields ...string) time.Duration {
+	if !inProgress {
+		state.rolloutStart = time.Time{}
+		return 0
+	}
+
+	if state.rolloutStart.IsZero() {
+		state.rolloutStart = now
+		state.lastProgress = now
+		return 0
+	}
+
+	for _, field := range fields {
+		if state.replicas[field] != state.lastReplicas[field] {
+			state.lastProgress = now
+			return 0
+		}
+	}
+	for field, revision := range state.revisions {
+		if revision != state.lastRevisions[field] {
+			state.lastProgress = now
+			return 0
+		}
+	}
+	return now.Sub(state.lastProgress)
+}
+
+// rolloutStuck returns whether a rollout that didn't progress for the given duration is stuck
+func (k *KSMCheck) rolloutStuck(noProgress time.Duration) bool {
+	return noProgress > time.Duration(k.instance.RolloutStuckTimeout)*time.Second
+}
+
+// deploymentRollout submits the deployment.rollout_in_progress metric and the deployment.rollout service check
+// A rollout is in progress while the updated replicas are lower than the desired replicas, the unavailable replicas
+// of a rolled out deployment are reported by the deployment.available service check instead.
+// The service check is WARNING when the rollout didn't progress for more than rollout_stuck_timeout
+func (k *KSMCheck) deploymentRollout(s aggregator.Sender, state *workloadState, now time.Time) {
+	desired, found := state.replicas[replicasDesired]
+	if !found {
+		return
+	}
+	updated := state.replicas[replicasUpdated]
+	available := state.replicas[replicasAvailable]
+
+	inProgress := updated < desired
+	noProgress := state.trackRollout(inProgress, now, replicasUpdated, replicasAvailable)
+	s.Gauge(k.metricName("deployment.rollout_in_progress"), boolToFloat(inProgress), "", state.tags)
+
+	if !k.rolloutStuck(noProgress) {
+		k.submitServiceCheck(s, "deployment.rollout", metrics.ServiceCheckOK, "", state.tags, "")
+		return
+	}
+	message := fmt.Sprintf("Rollout didn't progress for %s: %d/%d replicas updated, %d/%d replicas available",
+		noProgress.Round(time.Second), int(updated), int(desired), int(available), int(desired))
+	k.submitServiceCheck(s, "deployment.rollout", metrics.ServiceCheckWarning, "", state.tags, message)
+}
+
+// deploymentAvailability submits the deployment.available service check
+// It's WARNING when the deployment has less available replicas than desired for more than replica_mismatch_grace_period,
+// and CRITICAL if no replica is available
+func (k *KSMCheck) deploymentAvailability(s aggregator.Sender, state *workloadState, now time.Time) {
+	desired, found := state.replicas[replicasDesired]
+	if !found {
+		return
+	}
+	available := state.replicas[replicasAvailable]
+
+	if available >= desired {
+		state.degradedSince = time.Time{}
+		k.submitServiceCheck(s, "deployment.available", metrics.ServiceCheckOK, "", state.tags, "")
+		return
+	}
+
+	if state.degradedSince.IsZero() {
+		state.degradedSince = now
+	}
+	if now.Sub(state.degradedSince) <= time.Duration(k.instance.ReplicaMismatchGracePeriod)*time.Second {
+		k.submitServiceCheck(s, "deployment.available", metrics.ServiceCheckOK, "", state.tags, "")
+		return
+	}
+
+	status := metrics.ServiceCheckWarning
+	if available == 0 {
+		status = metrics.ServiceCheckCritical
+	}
+	message := fmt.Sprintf("%d/%d replicas available for %s", int(available), int(desired), now.Sub(state.degradedSince).Round(time.Second))
+	k.submitServiceCheck(s, "deployment.available", status, "", state.tags, message)
+}
+
+// statefulSetRollout submits the statefulset.rollout_in_progress and statefulset.rollout_stuck metrics
+// A rollout is in progress while the current revision differs from the update revision
+// or the updated or ready replicas are lower than the desired replicas,
+// it's stuck when it didn't progress for more than rollout_stuck_timeout
+func (k *KSMCheck) statefulSetRollout(s aggregator.Sender, state *workloadState, now time.Time) {
+	desired, found := state.replicas[replicasDesired]
+	if !found {
+		return
+	}
+
+	inProgress := state.revisions[revisionCurrent] != state.revisions[revisionUpdate] ||
+		state.replicas[replicasUpdated] < desired ||
+		state.replicas[replicasReady] < desired
+	noProgress := state.trackRollout(inProgress, now, replicasUpdated, replicasReady)
+
+	s.Gauge(k.metricName("statefulset.rollout_in_progress"), boolToFloat(inProgress), "", state.tags)
+	s.Gauge(k.metricName("statefulset.rollout_stuck"), boolToFloat(k.rolloutStuck(noProgress)), "", state.tags)
+}
+
+// daemonSetScheduling submits the daemonset.scheduling service check
+// It's CRITICAL when the unavailable daemons exceed daemonset_unavailable_threshold
+// and WARNING when daemons run on nodes they're not supposed to run on
+func (k *KSMCheck) daemonSetScheduling(s aggregator.Sender, state *workloadState) {
+	desired, found := state.replicas[replicasDesired]
+	if !found {
+		return
+	}
+	ready := state.replicas[replicasReady]
+	unavailable := state.replicas[replicasUnavailable]
+	misscheduled := state.replicas[replicasMisscheduled]
+
+	switch {
+	case unavailable > float64(k.instance.DaemonSetUnavailableThreshold):
+		message := fmt.Sprintf("%d daemons unavailable, %d/%d daemons ready", int(unavailable), int(ready), int(desired))
+		k.submitServiceCheck(s, "daemonset.scheduling", metrics.ServiceCheckCritical, "", state.tags, message)
+	case misscheduled > 0:
+		message := fmt.Sprintf("%d daemons misscheduled", int(misscheduled))
+		k.submitServiceCheck(s, "daemonset.scheduling", metrics.ServiceCheckWarning, "", state.tags, message)
+	default:
+		k.submitServiceCheck(s, "daemonset.scheduling", metrics.ServiceCheckOK, "", state.tags, "")
+	}
+}
+
+// daemonSetMisscheduling submits the daemonset.misscheduling service check
+// It's WARNING when daemons run on nodes they're not supposed to run on
+// for at least daemonset_misscheduled_runs consecutive runs
+func (k *KSMCheck) daemonSetMisscheduling(s aggregator.Sender, state *workloadState) {
+	misscheduled, found := state.replicas[replicasMisscheduled]
+	if !found {
+		return
+	}
+	if misscheduled <= 0 {
+		state.misscheduledRuns = 0
+		k.submitServiceCheck(s, "daemonset.misscheduling", metrics.ServiceCheckOK, "", state.tags, "")
+		return
+	}
+
+	state.misscheduledRuns++
+	if state.misscheduledRuns < k.instance.DaemonSetMisscheduledRuns {
+		k.submitServiceCheck(s, "daemonset.misscheduling", metrics.ServiceCheckOK, "", state.tags, "")
+		return
+	}
+	message := fmt.Sprintf("DaemonSet %s has %d daemons misscheduled for %d consecutive runs", state.name, int(misscheduled), state.misscheduledRuns)
+	k.submitServiceCheck(s, "daemonset.misscheduling", metrics.ServiceCheckWarning, "", state.tags, message)
+}
+
+// boolToFloat converts a boolean into a metric value
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
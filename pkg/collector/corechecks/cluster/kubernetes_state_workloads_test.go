@@ -0,0 +1,376 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/aggregator/mocksender"
+	core "github.com/DataDog/datadog-agent/pkg/collector/corechecks"
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+	"github.com/DataDog/datadog-agent/pkg/metrics"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_workloadReplicasTransformer(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+	tags := []string{"kube_deployment:foo", "kube_namespace:default"}
+
+	workloadReplicasTransformer("deployment", replicasDesired)(k, s, "kube_deployment_spec_replicas", ksmstore.DDMetric{Val: 3, Labels: map[string]string{"deployment": "foo", "namespace": "default"}}, "", tags)
+	workloadReplicasTransformer("deployment", replicasUpdated)(k, s, "kube_deployment_status_replicas_updated", ksmstore.DDMetric{Val: 2, Labels: map[string]string{"deployment": "foo", "namespace": "default"}}, "", tags)
+	workloadReplicasTransformer("deployment", replicasAvailable)(k, s, "kube_deployment_status_replicas_available", ksmstore.DDMetric{Val: 1, Labels: map[string]string{"namespace": "default"}}, "", tags)
+
+	s.AssertMetric(t, "Gauge", "kubernetes_state.deployment.replicas_desired", 3, "", tags)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.deployment.replicas_updated", 2, "", tags)
+	s.AssertMetric(t, "Gauge", "kubernetes_state.deployment.replicas_available", 1, "", tags)
+
+	state := k.workloads["deployment"]["default/foo"]
+	assert.NotNil(t, state)
+	assert.True(t, state.seen)
+	assert.Equal(t, tags, state.tags)
+	assert.Equal(t, map[string]float64{replicasDesired: 3, replicasUpdated: 2}, state.replicas)
+	assert.Equal(t, float64(1), k.unprocessedMetrics[unprocessedMetric{name: "kube_deployment_status_replicas_available", reason: unprocessedMissingLabel}])
+}
+
+func TestKSMCheck_deploymentRollout(t *testing.T) {
+	start := time.Now()
+	tags := []string{"kube_deployment:foo", "kube_namespace:default"}
+	type run struct {
+		now              time.Time
+		replicas         map[string]float64
+		expectedProgress float64
+		expectedStatus   metrics.ServiceCheckStatus
+		expectedMessage  string
+	}
+	tests := []struct {
+		name string
+		runs []run
+	}{
+		{
+			name: "rolled out",
+			runs: []run{
+				{now: start, replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 3, replicasAvailable: 3}, expectedProgress: 0, expectedStatus: metrics.ServiceCheckOK},
+			},
+		},
+		{
+			name: "rollout progressing",
+			runs: []run{
+				{now: start, replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 1, replicasAvailable: 2}, expectedProgress: 1, expectedStatus: metrics.ServiceCheckOK},
+				{now: start.Add(8 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 2, replicasAvailable: 2}, expectedProgress: 1, expectedStatus: metrics.ServiceCheckOK},
+				{now: start.Add(16 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 3, replicasAvailable: 2}, expectedProgress: 0, expectedStatus: metrics.ServiceCheckOK},
+				{now: start.Add(24 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 3, replicasAvailable: 3}, expectedProgress: 0, expectedStatus: metrics.ServiceCheckOK},
+			},
+		},
+		{
+			name: "rolled out with unavailable replicas",
+			runs: []run{
+				{now: start, replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 3, replicasAvailable: 2}, expectedProgress: 0, expectedStatus: metrics.ServiceCheckOK},
+				{now: start.Add(11 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 3, replicasAvailable: 2}, expectedProgress: 0, expectedStatus: metrics.ServiceCheckOK},
+			},
+		},
+		{
+			name: "rollout stuck",
+			runs: []run{
+				{now: start, replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 1, replicasAvailable: 2}, expectedProgress: 1, expectedStatus: metrics.ServiceCheckOK},
+				{now: start.Add(5 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 1, replicasAvailable: 2}, expectedProgress: 1, expectedStatus: metrics.ServiceCheckOK},
+				{now: start.Add(11 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 1, replicasAvailable: 2}, expectedProgress: 1, expectedStatus: metrics.ServiceCheckWarning, expectedMessage: "Rollout didn't progress for 11m0s: 1/3 replicas updated, 2/3 replicas available"},
+				{now: start.Add(12 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 2, replicasAvailable: 2}, expectedProgress: 1, expectedStatus: metrics.ServiceCheckOK},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+			state := &workloadState{tags: tags}
+			for _, r := range tt.runs {
+				s := mocksender.NewMockSender(k.ID())
+				s.SetupAcceptAll()
+				state.replicas = r.replicas
+
+				k.deploymentRollout(s, state, r.now)
+				state.lastReplicas = state.replicas
+
+				s.AssertMetric(t, "Gauge", "kubernetes_state.deployment.rollout_in_progress", r.expectedProgress, "", tags)
+				s.AssertServiceCheck(t, "kubernetes_state.deployment.rollout", r.expectedStatus, "", tags, r.expectedMessage)
+				k.endRun()
+			}
+		})
+	}
+}
+
+func TestKSMCheck_processWorkloads(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	for _, name := range []string{"foo", "bar"} {
+		state := k.workloadState("deployment", "default", name)
+		state.tags = []string{"kube_namespace:default", "kube_deployment:" + name}
+		state.replicas[replicasDesired] = 1
+	}
+	k.processWorkloads(s)
+	assert.Len(t, k.workloads["deployment"], 2)
+	// deployment.rollout and deployment.available per deployment
+	s.AssertNumberOfCalls(t, "ServiceCheck", 4)
+	k.endRun()
+
+	// bar is deleted
+	k.workloadState("deployment", "default", "foo").replicas[replicasDesired] = 1
+	k.processWorkloads(s)
+	assert.Len(t, k.workloads["deployment"], 1)
+	assert.Contains(t, k.workloads["deployment"], "default/foo")
+	assert.False(t, k.workloads["deployment"]["default/foo"].seen)
+	s.AssertNumberOfCalls(t, "ServiceCheck", 6)
+}
+
+func Test_statefulSetRevisionTransformer(t *testing.T) {
+	k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+	s := mocksender.NewMockSender(k.ID())
+	s.SetupAcceptAll()
+
+	statefulSetRevisionTransformer(revisionCurrent)(k, s, "kube_statefulset_status_current_revision", ksmstore.DDMetric{Val: 1, Labels: map[string]string{"statefulset": "foo", "namespace": "default", "revision": "foo-1"}}, "", nil)
+	statefulSetRevisionTransformer(revisionUpdate)(k, s, "kube_statefulset_status_update_revision", ksmstore.DDMetric{Val: 1, Labels: map[string]string{"statefulset": "foo", "namespace": "default", "revision": "foo-2"}}, "", nil)
+	statefulSetRevisionTransformer(revisionUpdate)(k, s, "kube_statefulset_status_update_revision", ksmstore.DDMetric{Val: 1, Labels: map[string]string{"statefulset": "bar", "namespace": "default"}}, "", nil)
+
+	s.AssertNumberOfCalls(t, "Gauge", 0)
+	assert.Equal(t, map[string]string{revisionCurrent: "foo-1", revisionUpdate: "foo-2"}, k.workloads["statefulset"]["default/foo"].revisions)
+	assert.NotContains(t, k.workloads["statefulset"], "default/bar")
+	assert.Equal(t, float64(1), k.unprocessedMetrics[unprocessedMetric{name: "kube_statefulset_status_update_revision", reason: unprocessedMissingLabel}])
+}
+
+func TestKSMCheck_statefulSetRollout(t *testing.T) {
+	start := time.Now()
+	tags := []string{"kube_stateful_set:foo", "kube_namespace:default"}
+	type run struct {
+		now              time.Time
+		replicas         map[string]float64
+		revisions        map[string]string
+		expectedProgress float64
+		expectedStuck    float64
+	}
+	tests := []struct {
+		name string
+		runs []run
+	}{
+		{
+			name: "rolled out",
+			runs: []run{
+				{now: start, replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 3, replicasReady: 3}, revisions: map[string]string{revisionCurrent: "foo-1", revisionUpdate: "foo-1"}, expectedProgress: 0, expectedStuck: 0},
+			},
+		},
+		{
+			name: "revision mismatch",
+			runs: []run{
+				{now: start, replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 3, replicasReady: 3}, revisions: map[string]string{revisionCurrent: "foo-1", revisionUpdate: "foo-2"}, expectedProgress: 1, expectedStuck: 0},
+			},
+		},
+		{
+			name: "rollout progressing",
+			runs: []run{
+				{now: start, replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 0, replicasReady: 3}, revisions: map[string]string{revisionCurrent: "foo-1", revisionUpdate: "foo-2"}, expectedProgress: 1, expectedStuck: 0},
+				{now: start.Add(8 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 1, replicasReady: 2}, revisions: map[string]string{revisionCurrent: "foo-1", revisionUpdate: "foo-2"}, expectedProgress: 1, expectedStuck: 0},
+				{now: start.Add(16 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 3, replicasReady: 3}, revisions: map[string]string{revisionCurrent: "foo-2", revisionUpdate: "foo-2"}, expectedProgress: 0, expectedStuck: 0},
+			},
+		},
+		{
+			name: "rollout stuck",
+			runs: []run{
+				{now: start, replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 1, replicasReady: 2}, revisions: map[string]string{revisionCurrent: "foo-1", revisionUpdate: "foo-2"}, expectedProgress: 1, expectedStuck: 0},
+				{now: start.Add(11 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 1, replicasReady: 2}, revisions: map[string]string{revisionCurrent: "foo-1", revisionUpdate: "foo-2"}, expectedProgress: 1, expectedStuck: 1},
+				{now: start.Add(12 * time.Minute), replicas: map[string]float64{replicasDesired: 3, replicasUpdated: 1, replicasReady: 2}, revisions: map[string]string{revisionCurrent: "foo-1", revisionUpdate: "foo-3"}, expectedProgress: 1, expectedStuck: 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), &KSMConfig{})
+			state := &workloadState{tags: tags}
+			for _, r := range tt.runs {
+				s := mocksender.NewMockSender(k.ID())
+				s.SetupAcceptAll()
+				state.replicas = r.replicas
+				state.revisions = r.revisions
+
+				k.statefulSetRollout(s, state, r.now)
+				state.lastReplicas = state.replicas
+				state.lastRevisions = state.revisions
+
+				s.AssertMetric(t, "Gauge", "kubernetes_state.statefulset.rollout_in_progress", r.expectedProgress, "", tags)
+				s.AssertMetric(t, "Gauge", "kubernetes_state.statefulset.rollout_stuck", r.expectedStuck, "", tags)
+			}
+		})
+	}
+}
+
+func TestKSMCheck_daemonSetScheduling(t *testing.T) {
+	tags := []string{"kube_daemon_set:foo", "kube_namespace:default"}
+	tests := []struct {
+		name            string
+		config          *KSMConfig
+		replicas        map[string]float64
+		expectedStatus  metrics.ServiceCheckStatus
+		expectedMessage string
+	}{
+		{
+			name:           "healthy",
+			config:         &KSMConfig{},
+			replicas:       map[string]float64{replicasDesired: 3, replicasReady: 3, replicasUnavailable: 0, replicasMisscheduled: 0},
+			expectedStatus: metrics.ServiceCheckOK,
+		},
+		{
+			name:            "unavailable",
+			config:          &KSMConfig{},
+			replicas:        map[string]float64{replicasDesired: 3, replicasReady: 2, replicasUnavailable: 1, replicasMisscheduled: 0},
+			expectedStatus:  metrics.ServiceCheckCritical,
+			expectedMessage: "1 daemons unavailable, 2/3 daemons ready",
+		},
+		{
+			name:           "unavailable under threshold",
+			config:         &KSMConfig{DaemonSetUnavailableThreshold: 1},
+			replicas:       map[string]float64{replicasDesired: 3, replicasReady: 2, replicasUnavailable: 1, replicasMisscheduled: 0},
+			expectedStatus: metrics.ServiceCheckOK,
+		},
+		{
+			name:            "misscheduled",
+			config:          &KSMConfig{},
+			replicas:        map[string]float64{replicasDesired: 3, replicasReady: 3, replicasUnavailable: 0, replicasMisscheduled: 1},
+			expectedStatus:  metrics.ServiceCheckWarning,
+			expectedMessage: "1 daemons misscheduled",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), tt.config)
+			s := mocksender.NewMockSender(k.ID())
+			s.SetupAcceptAll()
+
+			k.daemonSetScheduling(s, &workloadState{tags: tags, replicas: tt.replicas})
+			s.AssertServiceCheck(t, "kubernetes_state.daemonset.scheduling", tt.expectedStatus, "", tags, tt.expectedMessage)
+		})
+	}
+}
+
+func TestKSMCheck_daemonSetMisscheduling(t *testing.T) {
+	tags := []string{"kube_daemon_set:foo", "kube_namespace:default"}
+	type run struct {
+		misscheduled    float64
+		expectedStatus  metrics.ServiceCheckStatus
+		expectedMessage string
+	}
+	tests := []struct {
+		name   string
+		config *KSMConfig
+		runs   []run
+	}{
+		{
+			name:   "misscheduled for the default number of runs",
+			config: &KSMConfig{},
+			runs: []run{
+				{misscheduled: 0, expectedStatus: metrics.ServiceCheckOK},
+				{misscheduled: 2, expectedStatus: metrics.ServiceCheckOK},
+				{misscheduled: 2, expectedStatus: metrics.ServiceCheckOK},
+				{misscheduled: 1, expectedStatus: metrics.ServiceCheckWarning, expectedMessage: "DaemonSet foo has 1 daemons misscheduled for 3 consecutive runs"},
+				{misscheduled: 1, expectedStatus: metrics.ServiceCheckWarning, expectedMessage: "DaemonSet foo has 1 daemons misscheduled for 4 consecutive runs"},
+				{misscheduled: 0, expectedStatus: metrics.ServiceCheckOK},
+				{misscheduled: 1, expectedStatus: metrics.ServiceCheckOK},
+			},
+		},
+		{
+			name:   "configured number of runs",
+			config: &KSMConfig{DaemonSetMisscheduledRuns: 1},
+			runs: []run{
+				{misscheduled: 3, expectedStatus: metrics.ServiceCheckWarning, expectedMessage: "DaemonSet foo has 3 daemons misscheduled for 1 consecutive runs"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), tt.config)
+			state := &workloadState{name: "foo", tags: tags}
+			for _, r := range tt.runs {
+				s := mocksender.NewMockSender(k.ID())
+				s.SetupAcceptAll()
+
+				state.replicas = map[string]float64{replicasMisscheduled: r.misscheduled}
+				k.daemonSetMisscheduling(s, state)
+				s.AssertServiceCheck(t, "kubernetes_state.daemonset.misscheduling", r.expectedStatus, "", tags, r.expectedMessage)
+				k.endRun()
+			}
+		})
+	}
+}
+
+func TestKSMCheck_deploymentAvailability(t *testing.T) {
+	start := time.Now()
+	tags := []string{"kube_deployment:foo", "kube_namespace:default"}
+	type run struct {
+		now             time.Time
+		available       float64
+		expectedStatus  metrics.ServiceCheckStatus
+		expectedMessage string
+	}
+	tests := []struct {
+		name   string
+		config *KSMConfig
+		runs   []run
+	}{
+		{
+			name:   "available",
+			config: &KSMConfig{},
+			runs: []run{
+				{now: start, available: 3, expectedStatus: metrics.ServiceCheckOK},
+			},
+		},
+		{
+			name:   "recovered during the grace period",
+			config: &KSMConfig{},
+			runs: []run{
+				{now: start, available: 2, expectedStatus: metrics.ServiceCheckOK},
+				{now: start.Add(4 * time.Minute), available: 3, expectedStatus: metrics.ServiceCheckOK},
+				{now: start.Add(8 * time.Minute), available: 2, expectedStatus: metrics.ServiceCheckOK},
+			},
+		},
+		{
+			name:   "degraded",
+			config: &KSMConfig{},
+			runs: []run{
+				{now: start, available: 2, expectedStatus: metrics.ServiceCheckOK},
+				{now: start.Add(6 * time.Minute), available: 2, expectedStatus: metrics.ServiceCheckWarning, expectedMessage: "2/3 replicas available for 6m0s"},
+				{now: start.Add(7 * time.Minute), available: 0, expectedStatus: metrics.ServiceCheckCritical, expectedMessage: "0/3 replicas available for 7m0s"},
+				{now: start.Add(8 * time.Minute), available: 3, expectedStatus: metrics.ServiceCheckOK},
+			},
+		},
+		{
+			name:   "custom grace period",
+			config: &KSMConfig{ReplicaMismatchGracePeriod: 60},
+			runs: []run{
+				{now: start, available: 2, expectedStatus: metrics.ServiceCheckOK},
+				{now: start.Add(2 * time.Minute), available: 2, expectedStatus: metrics.ServiceCheckWarning, expectedMessage: "2/3 replicas available for 2m0s"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := newKSMCheck(core.NewCheckBase(kubeStateMetricsCheckName), tt.config)
+			state := &workloadState{tags: tags}
+			for _, r := range tt.runs {
+				s := mocksender.NewMockSender(k.ID())
+				s.SetupAcceptAll()
+				state.replicas = map[string]float64{replicasDesired: 3, replicasAvailable: r.available}
+
+				k.deploymentAvailability(s, state, r.now)
+				s.AssertServiceCheck(t, "kubernetes_state.deployment.available", r.expectedStatus, "", tags, r.expectedMessage)
+				k.endRun()
+			}
+		})
+	}
+}
@@ -15,7 +15,14 @@ import (
 	"github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
 
 	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8swatch "k8s.io/apimachinery/pkg/watch"
 	vpaclientset "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned"
+	"k8s.io/client-go/dynamic"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	ksmbuild "k8s.io/kube-state-metrics/pkg/builder"
@@ -32,6 +39,7 @@ type Builder struct {
 
 	kubeClient    clientset.Interface
 	vpaClient     vpaclientset.Interface
+	dynamicClient dynamic.Interface
 	namespaces    options.NamespaceList
 	ctx           context.Context
 	allowDenyList ksmtypes.AllowDenyLister
@@ -39,7 +47,20 @@ type Builder struct {
 	shard         int32
 	totalShards   int
 
-	resync time.Duration
+	resync            time.Duration
+	listPageSize      int64
+	deletedObjectsTTL time.Duration
+	familyFilter      store.FamilyNameAllow
+	// conditionTimestamps makes the stores set the condition last transition times as metric timestamps
+	conditionTimestamps bool
+
+	customResources []customResource
+}
+
+// customResource contains the metric families to generate for the objects of a custom resource
+type customResource struct {
+	resource       schema.GroupVersionResource
+	metricFamilies []generator.FamilyGenerator
 }
 
 // New returns new Builder instance
@@ -81,6 +102,17 @@ func (b *Builder) WithVPAClient(c vpaclientset.Interface) {
 	b.ksmBuilder.WithVPAClient(c)
 }
 
+// WithDynamicClient sets the dynamicClient property of a Builder so that custom resources can be watched.
+func (b *Builder) WithDynamicClient(c dynamic.Interface) {
+	b.dynamicClient = c
+}
+
+// WithCustomResource adds a custom resource to watch, metricFamilies are generated
+// for each of its objects, received as *unstructured.Unstructured.
+func (b *Builder) WithCustomResource(resource schema.GroupVersionResource, metricFamilies []generator.FamilyGenerator) {
+	b.customResources = append(b.customResources, customResource{resource: resource, metricFamilies: metricFamilies})
+}
+
 // WithMetrics sets the metrics property of a Builder.
 func (b *Builder) WithMetrics(r *prometheus.Registry) {
 	b.ksmBuilder.WithMetrics(r)
@@ -110,7 +142,11 @@ func (b *Builder) WithGenerateStoreFunc(f ksmtypes.BuildStoreFunc) {
 
 // Build initializes and registers all enabled stores.
 func (b *Builder) Build() []cache.Store {
-	return b.ksmBuilder.Build()
+	stores := b.ksmBuilder.Build()
+	for _, cr := range b.customResources {
+		stores = append(stores, b.generateCustomResourceStore(cr))
+	}
+	return stores
 }
 
 // WithResync is used if a resync period is configured
@@ -118,11 +154,78 @@ func (b *Builder) WithResync(r time.Duration) {
 	b.resync = r
 }
 
+// WithListPageSize is used if a page size is configured for the list calls of the reflectors
+func (b *Builder) WithListPageSize(size int64) {
+	b.listPageSize = size
+}
+
+// WithDeletedObjectsTTL is used if the metrics of the deleted objects must be kept for some time
+func (b *Builder) WithDeletedObjectsTTL(ttl time.Duration) {
+	b.deletedObjectsTTL = ttl
+}
+
+// WithFamilyFilter is used to only store the metric families allowed by the filter
+func (b *Builder) WithFamilyFilter(filter store.FamilyNameAllow) {
+	b.familyFilter = filter
+}
+
+// WithConditionTimestamps is used if the metrics of the conditions must be timestamped with their last transition time
+func (b *Builder) WithConditionTimestamps() {
+	b.conditionTimestamps = true
+}
+
+// newReflector creates a reflector using the resync period and the list page size of the builder
+// The list and watch errors of the reflector are recorded in the metrics stores, see MetricsStore.SetListWatchError
+func (b *Builder) newReflector(lw cache.ListerWatcher, expectedType interface{}, objectStore cache.Store, namespace string) *cache.Reflector {
+	reflector := cache.NewReflector(withErrorRecording(lw, objectStore, namespace), expectedType, objectStore, b.resync)
+	reflector.WatchListPageSize = b.listPageSize
+	return reflector
+}
+
+// withErrorRecording wraps the list watch of the reflectors feeding a metrics store with an errorRecordingListWatch
+func withErrorRecording(lw cache.ListerWatcher, objectStore cache.Store, source string) cache.ListerWatcher {
+	metricsStore, ok := objectStore.(*store.MetricsStore)
+	if !ok {
+		return lw
+	}
+	return &errorRecordingListWatch{ListerWatcher: lw, store: metricsStore, source: source}
+}
+
+// errorRecordingListWatch records the errors of the list and watch calls of a reflector in its metrics store,
+// so that the collectors failing after their first list (e.g. RBAC denials, apiserver timeouts) can be reported.
+// The error is cleared once a watch is established again.
+type errorRecordingListWatch struct {
+	cache.ListerWatcher
+	store  *store.MetricsStore
+	source string
+}
+
+// List implements the cache.ListerWatcher interface
+func (lw *errorRecordingListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	list, err := lw.ListerWatcher.List(options)
+	if err != nil {
+		lw.store.SetListWatchError(lw.source, err)
+	}
+	return list, err
+}
+
+// Watch implements the cache.ListerWatcher interface
+// The expired resource versions aren't errors: the reflector lists the objects again
+func (lw *errorRecordingListWatch) Watch(options metav1.ListOptions) (k8swatch.Interface, error) {
+	w, err := lw.ListerWatcher.Watch(options)
+	if err == nil || (!apierrors.IsResourceExpired(err) && !apierrors.IsGone(err)) {
+		lw.store.SetListWatchError(lw.source, err)
+	}
+	return w, err
+}
+
 // GenerateStore use to generate new Metrics Store for Metrics Families
+// The extra metric families of the object type are added to the KSM ones
 func (b *Builder) GenerateStore(metricFamilies []generator.FamilyGenerator,
 	expectedType interface{},
 	listWatchFunc func(kubeClient clientset.Interface, ns string) cache.ListerWatcher,
 ) cache.Store {
+	metricFamilies = withExtraMetricFamilies(metricFamilies, expectedType)
 	filteredMetricFamilies := generator.FilterMetricFamilies(b.allowDenyList, metricFamilies)
 	composedMetricGenFuncs := generator.ComposeMetricGenFuncs(filteredMetricFamilies)
 	store := store.NewMetricsStore(
@@ -130,6 +233,11 @@ func (b *Builder) GenerateStore(metricFamilies []generator.FamilyGenerator,
 		// Used later on to identify the Type of resource.
 		reflect.TypeOf(expectedType).String(),
 	)
+	store.WithDeletedObjectsTTL(b.deletedObjectsTTL)
+	store.WithFamilyFilter(b.familyFilter)
+	if b.conditionTimestamps {
+		store.WithConditionTimestamps()
+	}
 	b.reflectorPerNamespace(expectedType, store, listWatchFunc)
 	return store
 }
@@ -143,7 +251,31 @@ func (b *Builder) reflectorPerNamespace(
 ) {
 	for _, ns := range b.namespaces {
 		lw := listWatchFunc(b.kubeClient, ns) //instrumentedListWatch := watch.NewInstrumentedListerWatcher(lw, g.metrics, reflect.TypeOf(expectedType).String())
-		reflector := cache.NewReflector(lw, expectedType, store, b.resync*time.Second)
+		reflector := b.newReflector(lw, expectedType, store, ns)
 		go reflector.Run(b.ctx.Done())
 	}
 }
+
+// generateCustomResourceStore generates a Metrics Store for the metric families of a custom resource
+// The objects are watched using the dynamic client
+func (b *Builder) generateCustomResourceStore(cr customResource) cache.Store {
+	filteredMetricFamilies := generator.FilterMetricFamilies(b.allowDenyList, cr.metricFamilies)
+	composedMetricGenFuncs := generator.ComposeMetricGenFuncs(filteredMetricFamilies)
+	store := store.NewMetricsStore(composedMetricGenFuncs, cr.resource.String())
+	store.WithDeletedObjectsTTL(b.deletedObjectsTTL)
+	store.WithFamilyFilter(b.familyFilter)
+	for _, ns := range b.namespaces {
+		resourceClient := b.dynamicClient.Resource(cr.resource).Namespace(ns)
+		lw := &cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return resourceClient.List(opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (k8swatch.Interface, error) {
+				return resourceClient.Watch(opts)
+			},
+		}
+		reflector := b.newReflector(lw, &unstructured.Unstructured{}, store, ns)
+		go reflector.Run(b.ctx.Done())
+	}
+	return store
+}
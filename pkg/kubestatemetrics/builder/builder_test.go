@@ -0,0 +1,89 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package builder
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ksmstore "github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8swatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kube-state-metrics/pkg/metric"
+)
+
+func TestBuilder_newReflector(t *testing.T) {
+	b := New()
+	b.WithResync(30 * time.Second)
+	b.WithListPageSize(100)
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	reflector := b.newReflector(&cache.ListWatch{}, &v1.Pod{}, store, "")
+	assert.Equal(t, int64(100), reflector.WatchListPageSize)
+
+	b = New()
+	reflector = b.newReflector(&cache.ListWatch{}, &v1.Pod{}, store, "")
+	assert.Equal(t, int64(0), reflector.WatchListPageSize)
+}
+
+func TestErrorRecordingListWatch(t *testing.T) {
+	var listErr, watchErr error
+	lw := &cache.ListWatch{
+		ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+			return &v1.PodList{}, listErr
+		},
+		WatchFunc: func(metav1.ListOptions) (k8swatch.Interface, error) {
+			return k8swatch.NewFake(), watchErr
+		},
+	}
+	store := ksmstore.NewMetricsStore(func(interface{}) []metric.FamilyInterface { return nil }, "*v1.Pod")
+	recording := &errorRecordingListWatch{ListerWatcher: lw, store: store, source: "default"}
+
+	// The errors of the list calls are recorded
+	listErr = apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "", errors.New("denied"))
+	_, err := recording.List(metav1.ListOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, listErr, store.ListWatchError())
+
+	// A successful list doesn't clear the error until the watch is established
+	listErr = nil
+	_, err = recording.List(metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Error(t, store.ListWatchError())
+	_, err = recording.Watch(metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, store.ListWatchError())
+
+	// The expired resource versions aren't recorded
+	watchErr = apierrors.NewResourceExpired("too old resource version")
+	_, err = recording.Watch(metav1.ListOptions{})
+	assert.Error(t, err)
+	assert.NoError(t, store.ListWatchError())
+
+	watchErr = errors.New("timeout")
+	_, err = recording.Watch(metav1.ListOptions{})
+	assert.Error(t, err)
+	assert.Equal(t, watchErr, store.ListWatchError())
+}
+
+func Test_withErrorRecording(t *testing.T) {
+	store := ksmstore.NewMetricsStore(func(interface{}) []metric.FamilyInterface { return nil }, "*v1.Pod")
+
+	// The list watch of the metrics stores is wrapped, the other stores are left as is
+	lw := &cache.ListWatch{}
+	assert.IsType(t, &errorRecordingListWatch{}, withErrorRecording(lw, store, "default"))
+	assert.Equal(t, lw, withErrorRecording(lw, cache.NewStore(cache.MetaNamespaceKeyFunc), "default"))
+}
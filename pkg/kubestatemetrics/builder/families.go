@@ -0,0 +1,77 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package builder
+
+import (
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/kube-state-metrics/pkg/metric"
+	"k8s.io/kube-state-metrics/pkg/metric_generator"
+)
+
+// extraMetricFamilies contains the metric families generated in addition to the KSM ones, per object type
+// They're filtered by the allow/deny list like the KSM metric families
+var extraMetricFamilies = map[string][]generator.FamilyGenerator{
+	reflect.TypeOf(&v1.Pod{}).String(): {
+		{
+			Name: "kube_pod_status_qos_class",
+			Type: metric.Gauge,
+			Help: "The pods current qosClass.",
+			GenerateFunc: func(obj interface{}) *metric.Family {
+				pod := obj.(*v1.Pod)
+				classes := []v1.PodQOSClass{v1.PodQOSBestEffort, v1.PodQOSBurstable, v1.PodQOSGuaranteed}
+				ms := make([]*metric.Metric, 0, len(classes))
+				if pod.Status.QOSClass == "" {
+					return &metric.Family{Metrics: ms}
+				}
+				for _, class := range classes {
+					value := 0.0
+					if pod.Status.QOSClass == class {
+						value = 1
+					}
+					ms = append(ms, &metric.Metric{
+						LabelKeys:   []string{"namespace", "pod", "qos_class"},
+						LabelValues: []string{pod.Namespace, pod.Name, string(class)},
+						Value:       value,
+					})
+				}
+				return &metric.Family{Metrics: ms}
+			},
+		},
+		{
+			Name: "kube_pod_runtimeclass_name_info",
+			Type: metric.Gauge,
+			Help: "The runtimeclass associated with the pod.",
+			GenerateFunc: func(obj interface{}) *metric.Family {
+				pod := obj.(*v1.Pod)
+				ms := []*metric.Metric{}
+				if pod.Spec.RuntimeClassName == nil {
+					return &metric.Family{Metrics: ms}
+				}
+				ms = append(ms, &metric.Metric{
+					LabelKeys:   []string{"namespace", "pod", "runtimeclass_name"},
+					LabelValues: []string{pod.Namespace, pod.Name, *pod.Spec.RuntimeClassName},
+					Value:       1,
+				})
+				return &metric.Family{Metrics: ms}
+			},
+		},
+	},
+}
+
+// withExtraMetricFamilies returns the given KSM metric families and the extra metric families of the object type
+func withExtraMetricFamilies(metricFamilies []generator.FamilyGenerator, expectedType interface{}) []generator.FamilyGenerator {
+	extra, found := extraMetricFamilies[reflect.TypeOf(expectedType).String()]
+	if !found {
+		return metricFamilies
+	}
+	families := make([]generator.FamilyGenerator, 0, len(metricFamilies)+len(extra))
+	families = append(families, metricFamilies...)
+	return append(families, extra...)
+}
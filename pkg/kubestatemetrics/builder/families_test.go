@@ -0,0 +1,67 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kube-state-metrics/pkg/metric"
+	"k8s.io/kube-state-metrics/pkg/metric_generator"
+)
+
+func Test_withExtraMetricFamilies(t *testing.T) {
+	ksmFamilies := []generator.FamilyGenerator{{Name: "kube_pod_info"}}
+
+	families := withExtraMetricFamilies(ksmFamilies, &v1.Pod{})
+	assert.Len(t, families, 3)
+	assert.Equal(t, "kube_pod_info", families[0].Name)
+	assert.Equal(t, "kube_pod_status_qos_class", families[1].Name)
+	assert.Equal(t, "kube_pod_runtimeclass_name_info", families[2].Name)
+	assert.Len(t, ksmFamilies, 1)
+
+	assert.Equal(t, ksmFamilies, withExtraMetricFamilies(ksmFamilies, &v1.Node{}))
+}
+
+func Test_podQOSClassFamily(t *testing.T) {
+	family := extraMetricFamilies["*v1.Pod"][0]
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo"},
+		Status:     v1.PodStatus{QOSClass: v1.PodQOSBurstable},
+	}
+	got := family.Generate(pod)
+	assert.Equal(t, "kube_pod_status_qos_class", got.Name)
+	assert.Equal(t, []*metric.Metric{
+		{LabelKeys: []string{"namespace", "pod", "qos_class"}, LabelValues: []string{"default", "foo", "BestEffort"}, Value: 0},
+		{LabelKeys: []string{"namespace", "pod", "qos_class"}, LabelValues: []string{"default", "foo", "Burstable"}, Value: 1},
+		{LabelKeys: []string{"namespace", "pod", "qos_class"}, LabelValues: []string{"default", "foo", "Guaranteed"}, Value: 0},
+	}, got.Metrics)
+
+	// The QoS class isn't set until the pod is admitted
+	assert.Empty(t, family.Generate(&v1.Pod{}).Metrics)
+}
+
+func Test_podRuntimeClassFamily(t *testing.T) {
+	family := extraMetricFamilies["*v1.Pod"][1]
+
+	runtimeClass := "gvisor"
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "foo"},
+		Spec:       v1.PodSpec{RuntimeClassName: &runtimeClass},
+	}
+	got := family.Generate(pod)
+	assert.Equal(t, "kube_pod_runtimeclass_name_info", got.Name)
+	assert.Equal(t, []*metric.Metric{
+		{LabelKeys: []string{"namespace", "pod", "runtimeclass_name"}, LabelValues: []string{"default", "foo", "gvisor"}, Value: 1},
+	}, got.Metrics)
+
+	assert.Empty(t, family.Generate(&v1.Pod{}).Metrics)
+}
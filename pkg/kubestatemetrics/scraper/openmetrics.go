@@ -0,0 +1,105 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+)
+
+// openMetricsEOF is the line ending an OpenMetrics exposition
+const openMetricsEOF = "# EOF"
+
+// createdSampleTypes contains the OpenMetrics family types exposing a _created sample,
+// which holds the creation time of the series rather than a value
+var createdSampleTypes = map[string]struct{}{
+	"counter":   {},
+	"histogram": {},
+	"summary":   {},
+}
+
+// ParseOpenMetrics parses metrics in the OpenMetrics exposition format into metric families
+// The samples are grouped by sample name, not by OpenMetrics family name (e.g. kube_pod_container_status_restarts_total
+// instead of kube_pod_container_status_restarts), so that they're named as in the Prometheus text format.
+// The families rejected by the filter are skipped, the _created samples and the exemplars are dropped.
+func ParseOpenMetrics(r io.Reader, filter store.FamilyNameAllow) ([]store.DDMetricsFam, error) {
+	families := newFamiliesBuilder()
+	types := make(map[string]string)
+	eof := false
+
+	scanner := newLineScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if eof {
+			return nil, fmt.Errorf("line %d: unexpected content after %s", lineNumber, openMetricsEOF)
+		}
+		if line == openMetricsEOF {
+			eof = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			if fields := strings.Fields(line); len(fields) == 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		name, rest := parseMetricName(line)
+		if name == "" {
+			return nil, fmt.Errorf("line %d: missing metric name", lineNumber)
+		}
+		if isCreatedSample(name, types) || (filter != nil && !filter(name)) {
+			continue
+		}
+
+		metric, fields, err := parseSample(rest)
+		if err == nil {
+			err = parseValueAndTimestamp(&metric, withoutExemplar(fields), openMetricsTimestamp)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		families.add(name, metric)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !eof {
+		return nil, errors.New("missing " + openMetricsEOF)
+	}
+	return families.families, nil
+}
+
+// isCreatedSample returns whether a sample is the _created sample of a family
+func isCreatedSample(name string, types map[string]string) bool {
+	if !strings.HasSuffix(name, "_created") {
+		return false
+	}
+	_, found := createdSampleTypes[types[strings.TrimSuffix(name, "_created")]]
+	return found
+}
+
+// withoutExemplar removes the exemplar, following a #, from the fields of a sample
+func withoutExemplar(fields []string) []string {
+	for i, field := range fields {
+		if strings.HasPrefix(field, "#") {
+			return fields[:i]
+		}
+	}
+	return fields
+}
+
+// openMetricsTimestamp parses a timestamp of the OpenMetrics format, in seconds
+func openMetricsTimestamp(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,108 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package scraper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+)
+
+func TestParseOpenMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		filter   store.FamilyNameAllow
+		expected []store.DDMetricsFam
+		err      bool
+	}{
+		{
+			name: "families",
+			text: `# HELP kube_pod_container_status_restarts The number of container restarts per container.
+# TYPE kube_pod_container_status_restarts counter
+kube_pod_container_status_restarts_total{namespace="default",pod="foo",container="bar"} 3 1600000000.5
+kube_pod_container_status_restarts_created{namespace="default",pod="foo",container="bar"} 1500000000
+# TYPE kube_pod info
+kube_pod_info{namespace="default",pod="foo",node="baz"} 1
+# TYPE kube_node_created gauge
+kube_node_created{node="baz"} 1500000000
+# EOF
+`,
+			expected: []store.DDMetricsFam{
+				{
+					Name: "kube_pod_container_status_restarts_total",
+					ListMetrics: []store.DDMetric{
+						{Labels: map[string]string{"namespace": "default", "pod": "foo", "container": "bar"}, Val: 3, Timestamp: 1600000000.5},
+					},
+				},
+				{
+					Name: "kube_pod_info",
+					ListMetrics: []store.DDMetric{
+						{Labels: map[string]string{"namespace": "default", "pod": "foo", "node": "baz"}, Val: 1},
+					},
+				},
+				{
+					// Only the _created samples of the counters, histograms and summaries are dropped
+					Name: "kube_node_created",
+					ListMetrics: []store.DDMetric{
+						{Labels: map[string]string{"node": "baz"}, Val: 1500000000},
+					},
+				},
+			},
+		},
+		{
+			name: "exemplar and filter",
+			text: `# TYPE kube_foo counter
+kube_foo_total{pod="foo"} 2 # {trace_id="abc"} 1 1600000000
+kube_pod_labels{pod="foo"} 1
+# EOF
+`,
+			filter: func(name string) bool { return name != "kube_pod_labels" },
+			expected: []store.DDMetricsFam{
+				{
+					Name:        "kube_foo_total",
+					ListMetrics: []store.DDMetric{{Labels: map[string]string{"pod": "foo"}, Val: 2}},
+				},
+			},
+		},
+		{
+			name: "missing EOF",
+			text: `kube_foo{pod="foo"} 1
+`,
+			err: true,
+		},
+		{
+			name: "content after EOF",
+			text: `# EOF
+kube_foo{pod="foo"} 1
+`,
+			err: true,
+		},
+		{
+			name: "invalid sample",
+			text: `kube_foo{pod="foo"} 1 2 3
+# EOF
+`,
+			err: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families, err := ParseOpenMetrics(strings.NewReader(tt.text), tt.filter)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, families)
+		})
+	}
+}
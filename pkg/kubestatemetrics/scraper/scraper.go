@@ -0,0 +1,81 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package scraper
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+)
+
+// acceptHeader prefers the OpenMetrics format, kube-state-metrics versions not supporting it reply in the text format
+const acceptHeader = "application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5"
+
+// openMetricsContentType is the content type of the OpenMetrics format
+const openMetricsContentType = "application/openmetrics-text"
+
+// Scraper collects the metrics exposed by an existing kube-state-metrics deployment
+// It's used instead of the metric stores when the agent can't list and watch the resources itself
+type Scraper struct {
+	url    string
+	client *http.Client
+	// familyFilter is used to drop the metric families unused by the scraper clients
+	familyFilter store.FamilyNameAllow
+}
+
+// New returns a scraper of the given kube-state-metrics metrics endpoint
+func New(url string, timeout time.Duration) *Scraper {
+	return &Scraper{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// WithFamilyFilter configures the scraper to only return the metric families allowed by the given filter
+func (s *Scraper) WithFamilyFilter(filter store.FamilyNameAllow) {
+	s.familyFilter = filter
+}
+
+// Scrape queries the metrics endpoint and returns the metric families indexed by name,
+// in the format returned by the Push method of the metric stores
+// The OpenMetrics format is requested, the parser is chosen according to the content type of the response.
+func (s *Scraper) Scrape() (map[string][]store.DDMetricsFam, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptHeader)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from the kube-state-metrics endpoint %s", resp.StatusCode, s.url)
+	}
+
+	parse := ParseText
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), openMetricsContentType) {
+		parse = ParseOpenMetrics
+	}
+	families, err := parse(resp.Body, s.familyFilter)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse the metrics of the kube-state-metrics endpoint %s: %v", s.url, err)
+	}
+
+	metrics := make(map[string][]store.DDMetricsFam, len(families))
+	for _, f := range families {
+		metrics[f.Name] = append(metrics[f.Name], f)
+	}
+	return metrics, nil
+}
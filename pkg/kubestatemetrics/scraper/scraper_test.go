@@ -0,0 +1,53 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package scraper
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrape(t *testing.T) {
+	status := http.StatusOK
+	openMetrics := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, acceptHeader, r.Header.Get("Accept"))
+		if openMetrics {
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=0.0.1; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		}
+		w.WriteHeader(status)
+		fmt.Fprintln(w, `kube_pod_status_ready{pod="foo",condition="true"} 1`)
+		fmt.Fprintln(w, `kube_pod_labels{pod="foo"} 1`)
+		if openMetrics {
+			fmt.Fprintln(w, "# EOF")
+		}
+	}))
+	defer server.Close()
+
+	s := New(server.URL, time.Second)
+	s.WithFamilyFilter(func(name string) bool { return name != "kube_pod_labels" })
+
+	for _, openMetrics = range []bool{false, true} {
+		metrics, err := s.Scrape()
+		assert.NoError(t, err)
+		assert.Len(t, metrics, 1)
+		assert.Len(t, metrics["kube_pod_status_ready"], 1)
+		assert.Equal(t, map[string]string{"pod": "foo", "condition": "true"}, metrics["kube_pod_status_ready"][0].ListMetrics[0].Labels)
+	}
+
+	status = http.StatusForbidden
+	_, err := s.Scrape()
+	assert.Error(t, err)
+}
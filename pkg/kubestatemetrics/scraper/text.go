@@ -0,0 +1,207 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package scraper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+)
+
+// maxLineSize is the maximum size of a line of the exposition format
+const maxLineSize = 1024 * 1024
+
+// ParseText parses metrics in the Prometheus text exposition format into metric families
+// The samples are grouped by metric name, the families rejected by the filter are skipped.
+// The comments, including the HELP and TYPE metadata, are ignored as the kube-state-metrics families are gauges.
+// The timestamps of the samples are kept, converted into seconds.
+func ParseText(r io.Reader, filter store.FamilyNameAllow) ([]store.DDMetricsFam, error) {
+	families := newFamiliesBuilder()
+
+	scanner := newLineScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, rest := parseMetricName(line)
+		if name == "" {
+			return nil, fmt.Errorf("line %d: missing metric name", lineNumber)
+		}
+		if filter != nil && !filter(name) {
+			continue
+		}
+
+		metric, fields, err := parseSample(rest)
+		if err == nil {
+			err = parseValueAndTimestamp(&metric, fields, textTimestamp)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		families.add(name, metric)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return families.families, nil
+}
+
+// textTimestamp converts a timestamp of the text format, in milliseconds, into seconds
+func textTimestamp(s string) (float64, error) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return float64(ms) / 1000, nil
+}
+
+// newLineScanner returns a scanner of the lines of an exposition format, accepting long lines
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	return scanner
+}
+
+// familiesBuilder groups the samples into families by metric name, in the order the metrics are seen
+type familiesBuilder struct {
+	families []store.DDMetricsFam
+	index    map[string]int
+}
+
+func newFamiliesBuilder() *familiesBuilder {
+	return &familiesBuilder{
+		families: []store.DDMetricsFam{},
+		index:    make(map[string]int),
+	}
+}
+
+// add adds a sample to the family of the given metric name
+func (b *familiesBuilder) add(name string, metric store.DDMetric) {
+	i, found := b.index[name]
+	if !found {
+		i = len(b.families)
+		b.index[name] = i
+		b.families = append(b.families, store.DDMetricsFam{Name: name})
+	}
+	b.families[i].ListMetrics = append(b.families[i].ListMetrics, metric)
+}
+
+// parseMetricName returns the metric name at the beginning of a sample line and the rest of the line
+func parseMetricName(line string) (string, string) {
+	end := strings.IndexAny(line, "{ \t")
+	if end < 0 {
+		return line, ""
+	}
+	return line[:end], line[end:]
+}
+
+// parseSample parses the labels following the metric name of a sample
+// It returns the whitespace separated fields following the labels: the value, the optional timestamp and exemplar
+func parseSample(s string) (store.DDMetric, []string, error) {
+	metric := store.DDMetric{Labels: map[string]string{}}
+
+	if strings.HasPrefix(s, "{") {
+		rest, err := parseLabels(s[1:], metric.Labels)
+		if err != nil {
+			return metric, nil, err
+		}
+		s = rest
+	}
+	return metric, strings.Fields(s), nil
+}
+
+// parseValueAndTimestamp parses the value and the optional timestamp of a sample
+// The timestamp is converted into seconds by the given function of the exposition format
+func parseValueAndTimestamp(metric *store.DDMetric, fields []string, parseTimestamp func(string) (float64, error)) error {
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("invalid sample %q", strings.Join(fields, " "))
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %q", fields[0])
+	}
+	metric.Val = value
+
+	if len(fields) == 2 {
+		timestamp, err := parseTimestamp(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+		metric.Timestamp = timestamp
+	}
+	return nil
+}
+
+// parseLabels parses the labels of a sample into the given map, from the first label to the closing brace
+// It returns the rest of the line, after the closing brace
+func parseLabels(s string, labels map[string]string) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return "", errors.New("invalid label")
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t")
+
+		value, rest, err := parseLabelValue(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid value of label %s: %v", key, err)
+		}
+		labels[key] = value
+
+		s = strings.TrimLeft(rest, " \t")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "}") {
+			return "", errors.New("missing closing brace")
+		}
+	}
+}
+
+// parseLabelValue parses a quoted label value and returns the unescaped value and the rest of the line
+func parseLabelValue(s string) (string, string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", errors.New("missing opening quote")
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return value.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", errors.New("invalid escape sequence")
+			}
+			switch s[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case '\\', '"':
+				value.WriteByte(s[i])
+			default:
+				return "", "", fmt.Errorf("invalid escape sequence \\%c", s[i])
+			}
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return "", "", errors.New("missing closing quote")
+}
@@ -0,0 +1,128 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package scraper
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/kubestatemetrics/store"
+)
+
+func TestParseText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		filter   store.FamilyNameAllow
+		expected []store.DDMetricsFam
+		err      bool
+	}{
+		{
+			name: "families",
+			text: `# HELP kube_pod_status_ready Describes whether the pod is ready to serve requests.
+# TYPE kube_pod_status_ready gauge
+kube_pod_status_ready{namespace="default",pod="foo",condition="true"} 1
+kube_pod_status_ready{namespace="default",pod="foo",condition="false"} 0
+
+# HELP kube_node_spec_unschedulable Whether a node can schedule new pods.
+# TYPE kube_node_spec_unschedulable gauge
+kube_node_spec_unschedulable{node="bar"} 0
+`,
+			expected: []store.DDMetricsFam{
+				{
+					Name: "kube_pod_status_ready",
+					ListMetrics: []store.DDMetric{
+						{Labels: map[string]string{"namespace": "default", "pod": "foo", "condition": "true"}, Val: 1},
+						{Labels: map[string]string{"namespace": "default", "pod": "foo", "condition": "false"}, Val: 0},
+					},
+				},
+				{
+					Name: "kube_node_spec_unschedulable",
+					ListMetrics: []store.DDMetric{
+						{Labels: map[string]string{"node": "bar"}, Val: 0},
+					},
+				},
+			},
+		},
+		{
+			name: "labels formatting",
+			text: `kube_pod_labels{ namespace = "default" , label_app="a \"quoted\" \\ value\nwith a new line",} 1 1600000000000
+kube_foo 3.5
+kube_bar{} +Inf
+`,
+			expected: []store.DDMetricsFam{
+				{
+					Name: "kube_pod_labels",
+					ListMetrics: []store.DDMetric{
+						{Labels: map[string]string{"namespace": "default", "label_app": "a \"quoted\" \\ value\nwith a new line"}, Val: 1, Timestamp: 1600000000},
+					},
+				},
+				{
+					Name:        "kube_foo",
+					ListMetrics: []store.DDMetric{{Labels: map[string]string{}, Val: 3.5}},
+				},
+				{
+					Name:        "kube_bar",
+					ListMetrics: []store.DDMetric{{Labels: map[string]string{}, Val: math.Inf(1)}},
+				},
+			},
+		},
+		{
+			name: "filter",
+			text: `kube_pod_labels{pod="foo"} 1
+kube_pod_info{pod="foo",node="bar"} 1
+`,
+			filter: func(name string) bool { return name != "kube_pod_labels" },
+			expected: []store.DDMetricsFam{
+				{
+					Name:        "kube_pod_info",
+					ListMetrics: []store.DDMetric{{Labels: map[string]string{"pod": "foo", "node": "bar"}, Val: 1}},
+				},
+			},
+		},
+		{
+			name: "invalid value",
+			text: `kube_foo{pod="foo"} one`,
+			err:  true,
+		},
+		{
+			name: "unterminated label value",
+			text: `kube_foo{pod="foo} 1`,
+			err:  true,
+		},
+		{
+			name: "missing closing brace",
+			text: `kube_foo{pod="foo" 1`,
+			err:  true,
+		},
+		{
+			name: "invalid timestamp",
+			text: `kube_foo{pod="foo"} 1 1600000000.5`,
+			err:  true,
+		},
+		{
+			name: "invalid escape sequence",
+			text: `kube_foo{pod="\t"} 1`,
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			families, err := ParseText(strings.NewReader(tt.text), tt.filter)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, families)
+		})
+	}
+}
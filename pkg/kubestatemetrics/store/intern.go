@@ -0,0 +1,114 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package store
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// labelsInterner is shared by the stores, the same label keys and values are used by most resources
+var labelsInterner = newInterner()
+
+// internedLabelValues contains the labels whose values are interned.
+// Only the labels whose values are shared by many objects must be added here, the interned strings
+// are kept until the metrics of the last object using them are removed from the stores.
+var internedLabelValues = map[string]struct{}{
+	"namespace":           {},
+	"node":                {},
+	"phase":               {},
+	"condition":           {},
+	"status":              {},
+	"reason":              {},
+	"resource":            {},
+	"unit":                {},
+	"type":                {},
+	"container":           {},
+	"created_by_kind":     {},
+	"owner_kind":          {},
+	"owner_is_controller": {},
+	"storageclass":        {},
+	"service_type":        {},
+}
+
+// interner deduplicates strings so that the repeated ones share the same memory
+// It's shared by the stores of all the resource kinds, the strings are almost always known already
+// so the lookups only take a read lock and don't serialize the informer callbacks.
+// The strings are reference counted: each intern must be matched by a release once the string isn't used anymore,
+// e.g. the names of the nodes removed by an autoscaler are released with the metrics of their last object.
+type interner struct {
+	mu sync.RWMutex
+	// strings contains the stored strings
+	strings map[string]*internedString
+}
+
+// internedString is a stored string and the number of times it's used
+type internedString struct {
+	s    string
+	refs int64
+}
+
+func newInterner() *interner {
+	return &interner{strings: make(map[string]*internedString)}
+}
+
+// intern returns the stored copy of a string, the string is stored if it's new
+// A nil interner returns the string as is
+func (i *interner) intern(s string) string {
+	if i == nil {
+		return s
+	}
+
+	i.mu.RLock()
+	interned, found := i.strings[s]
+	if found {
+		atomic.AddInt64(&interned.refs, 1)
+	}
+	i.mu.RUnlock()
+	if found {
+		return interned.s
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// The string may have been stored since the read lock was released
+	if interned, found := i.strings[s]; found {
+		atomic.AddInt64(&interned.refs, 1)
+		return interned.s
+	}
+	i.strings[s] = &internedString{s: s, refs: 1}
+	return s
+}
+
+// release drops a reference to a string returned by intern, the string is forgotten when it isn't used anymore
+// A nil interner does nothing
+func (i *interner) release(s string) {
+	if i == nil {
+		return
+	}
+
+	i.mu.RLock()
+	interned, found := i.strings[s]
+	var refs int64
+	if found {
+		refs = atomic.AddInt64(&interned.refs, -1)
+	}
+	i.mu.RUnlock()
+	if !found || refs > 0 {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// The string may have been used again since the read lock was released
+	if interned, found := i.strings[s]; found && atomic.LoadInt64(&interned.refs) <= 0 {
+		delete(i.strings, s)
+	}
+}
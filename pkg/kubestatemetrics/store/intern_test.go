@@ -0,0 +1,169 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kube-state-metrics/pkg/metric"
+)
+
+// stringData returns the address of the bytes of a string
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
+
+func TestInterner(t *testing.T) {
+	i := newInterner()
+	a := i.intern(fmt.Sprintf("%s-%d", "foo", 1))
+	b := i.intern(fmt.Sprintf("%s-%d", "foo", 1))
+	assert.Equal(t, "foo-1", b)
+	assert.Equal(t, stringData(a), stringData(b))
+
+	var nilInterner *interner
+	assert.Equal(t, "bar", nilInterner.intern("bar"))
+}
+
+func TestInternerReferences(t *testing.T) {
+	i := newInterner()
+	node := i.intern(fmt.Sprint("node-1"))
+	i.intern(fmt.Sprint("node-1"))
+	i.intern(fmt.Sprint("node-2"))
+
+	// node-1 is still used once, it keeps the same copy
+	i.release("node-1")
+	assert.Equal(t, stringData(node), stringData(i.intern(fmt.Sprint("node-1"))))
+	i.release("node-1")
+
+	// node-2 isn't used anymore, it's released
+	i.release("node-2")
+	assert.Len(t, i.strings, 1)
+	assert.Contains(t, i.strings, "node-1")
+
+	// Releasing an unknown string does nothing
+	i.release("node-3")
+	assert.Len(t, i.strings, 1)
+
+	var nilInterner *interner
+	nilInterner.release("node-1")
+}
+
+func TestStoreReleasesInternedStrings(t *testing.T) {
+	pod := func(uid, name, namespace, resourceVersion string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), Name: name, Namespace: namespace, ResourceVersion: resourceVersion}}
+	}
+	ms := NewMetricsStore(podMetrics, "*v1.Pod")
+	ms.interner = newInterner()
+	interned := func() []string {
+		var strings []string
+		for s := range ms.interner.strings {
+			strings = append(strings, s)
+		}
+		return strings
+	}
+
+	assert.NoError(t, ms.Add(pod("123", "foo", "default", "1")))
+	assert.NoError(t, ms.Add(pod("456", "bar", "default", "1")))
+	// The objects that didn't change keep their strings
+	assert.NoError(t, ms.Update(pod("123", "foo", "default", "1")))
+	assert.ElementsMatch(t, []string{"namespace", "pod", "node", "created_by_kind", "phase", "default", "node-3", "ReplicaSet", "Running"}, interned())
+
+	// The strings of the previous metrics of an object are released
+	assert.NoError(t, ms.Update(pod("123", "foo", "kube-system", "2")))
+	assert.Contains(t, interned(), "default")
+	assert.NoError(t, ms.Update(pod("456", "bar", "kube-system", "2")))
+	assert.NotContains(t, interned(), "default")
+	assert.Contains(t, interned(), "kube-system")
+
+	// The strings of the deleted objects are released
+	assert.NoError(t, ms.Delete(pod("123", "foo", "kube-system", "2")))
+	assert.Contains(t, interned(), "kube-system")
+	assert.NoError(t, ms.Replace(nil, ""))
+	assert.Empty(t, interned())
+}
+
+func TestBuildTagsInterning(t *testing.T) {
+	i := newInterner()
+	newMetric := func() *metric.Metric {
+		return &metric.Metric{
+			LabelKeys:   []string{fmt.Sprint("namespace"), fmt.Sprint("pod")},
+			LabelValues: []string{fmt.Sprint("default"), fmt.Sprint("foo")},
+		}
+	}
+
+	first, err := buildTags(newMetric(), i)
+	assert.NoError(t, err)
+	second, err := buildTags(newMetric(), i)
+	assert.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, stringData(first["namespace"]), stringData(second["namespace"]))
+	// High cardinality values aren't interned
+	assert.NotEqual(t, stringData(first["pod"]), stringData(second["pod"]))
+	assert.Len(t, i.strings, 3)
+}
+
+// podMetrics generates metrics similar to kube_pod_info, allocating new label strings for each object like KSM does
+func podMetrics(obj interface{}) []metric.FamilyInterface {
+	o, _ := meta.Accessor(obj)
+	node := fmt.Sprintf("node-%d", len(o.GetName())%10)
+	return []metric.FamilyInterface{&metric.Family{
+		Name: "kube_pod_info",
+		Metrics: []*metric.Metric{{
+			LabelKeys:   []string{fmt.Sprint("namespace"), fmt.Sprint("pod"), fmt.Sprint("node"), fmt.Sprint("created_by_kind"), fmt.Sprint("phase")},
+			LabelValues: []string{fmt.Sprint(o.GetNamespace()), o.GetName(), node, fmt.Sprint("ReplicaSet"), fmt.Sprint("Running")},
+			Value:       1,
+		}},
+	}}
+}
+
+// BenchmarkAdd compares the memory retained by the store with and without interning
+func BenchmarkAdd(b *testing.B) {
+	const pods = 10000
+	objects := make([]*v1.Pod, pods)
+	for i := range objects {
+		objects[i] = &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+			UID:       types.UID(fmt.Sprintf("uid-%d", i)),
+			Name:      fmt.Sprintf("pod-%d", i),
+			Namespace: fmt.Sprintf("namespace-%d", i%10),
+		}}
+	}
+
+	for name, i := range map[string]*interner{"interned": newInterner(), "not interned": nil} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			var retained uint64
+			for n := 0; n < b.N; n++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				ms := NewMetricsStore(podMetrics, "*v1.Pod")
+				ms.interner = i
+				for _, pod := range objects {
+					_ = ms.Add(pod)
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				retained += after.HeapAlloc - before.HeapAlloc
+				runtime.KeepAlive(ms)
+			}
+			b.ReportMetric(float64(retained)/float64(b.N)/pods, "retained-B/object")
+		})
+	}
+}
@@ -9,23 +9,63 @@ package store
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/DataDog/datadog-agent/pkg/util/log"
 
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/kube-state-metrics/pkg/metric"
 )
 
-// MetricsStore implements the k8s.io/client-go/tools/cache.Store
-// interface. Instead of storing entire Kubernetes objects, it stores metrics
-// generated based on those objects.
-type MetricsStore struct {
+// storeShards is the number of shards of a store
+// The objects are spread over the shards by UID, so the informer callbacks and the snapshots
+// only contend on the lock of a shard instead of the whole store during resyncs of large clusters.
+const storeShards = 16
+
+// storeShard contains the metrics of the objects of a shard of a store
+type storeShard struct {
 	mutex sync.RWMutex
 	// metrics is a map indexed by Kubernetes object id, containing a slice of
 	// metric families, containing a slice of metrics.
 	metrics map[types.UID][]DDMetricsFam
+	// resourceVersions contains the resource version of the objects the metrics were generated from,
+	// it's used to avoid generating the metrics again when an object didn't change (e.g. on resyncs).
+	resourceVersions map[types.UID]string
+	// deletedAt contains the deletion time of the objects whose metrics are kept for deletedObjectsTTL,
+	// so that the objects deleted between two check runs are reported at least once.
+	deletedAt map[types.UID]time.Time
+}
+
+// MetricsStore implements the k8s.io/client-go/tools/cache.Store
+// interface. Instead of storing entire Kubernetes objects, it stores metrics
+// generated based on those objects.
+// The With* options must be set before the store is used by an informer.
+type MetricsStore struct {
+	// generation is incremented each time the metrics change, it allows clients
+	// to reuse the metrics they pushed if the store didn't change since.
+	// It's accessed atomically, it's the first field to be 64-bit aligned on 32-bit platforms.
+	generation uint64
+	// synced is set once the store received the initial list of its objects, it's accessed atomically
+	synced uint32
+	// listWatchErrors contains the error of the last list or watch call per reflector feeding the store, see SetListWatchError
+	listWatchErrors      map[string]error
+	listWatchErrorsMutex sync.Mutex
+	// shards contain the metrics of the objects, see storeShards
+	shards            [storeShards]storeShard
+	deletedObjectsTTL time.Duration
+	// interner is used to deduplicate the label keys and values of the metrics
+	interner *interner
+	// familyFilter is used to drop the metric families unused by the store clients before they're stored
+	familyFilter FamilyNameAllow
+	// conditionTimestamps is true if the metrics of the conditions are timestamped with their last transition time
+	conditionTimestamps bool
+	// now returns the current time, it can be overridden in tests
+	now func() time.Time
 	// generateMetricsFunc generates metrics based on a given Kubernetes object
 	// and returns them grouped by metric family.
 	generateMetricsFunc func(interface{}) []metric.FamilyInterface
@@ -37,6 +77,8 @@ type MetricsStore struct {
 type DDMetric struct {
 	Labels map[string]string
 	Val    float64
+	// Timestamp is the Unix time in seconds the value was observed at, zero if unknown
+	Timestamp float64
 }
 
 // DDMetricsFam is the representation of a metric family.
@@ -46,28 +88,66 @@ type DDMetricsFam struct {
 	ListMetrics []DDMetric
 }
 
+// WithDeletedObjectsTTL keeps the metrics of the deleted objects for the given duration.
+// By default the metrics are dropped as soon as the objects are deleted.
+func (s *MetricsStore) WithDeletedObjectsTTL(ttl time.Duration) {
+	s.deletedObjectsTTL = ttl
+}
+
+// WithFamilyFilter configures the store to only keep the metric families allowed by the given filter.
+// The other families are dropped when the objects are added, before their metrics are converted.
+func (s *MetricsStore) WithFamilyFilter(filter FamilyNameAllow) {
+	s.familyFilter = filter
+}
+
+// WithConditionTimestamps configures the store to set the last transition time of the conditions
+// reported by the metrics (e.g. kube_node_status_condition) as their timestamp.
+func (s *MetricsStore) WithConditionTimestamps() {
+	s.conditionTimestamps = true
+}
+
 // NewMetricsStore returns a new MetricsStore.
 func NewMetricsStore(generateFunc func(interface{}) []metric.FamilyInterface, mt string) *MetricsStore {
-	return &MetricsStore{
+	s := &MetricsStore{
 		MetricsType:         mt,
 		generateMetricsFunc: generateFunc,
-		metrics:             map[types.UID][]DDMetricsFam{},
+		interner:            labelsInterner,
+		now:                 time.Now,
+		listWatchErrors:     map[string]error{},
+	}
+	for i := range s.shards {
+		s.shards[i].metrics = map[types.UID][]DDMetricsFam{}
+		s.shards[i].resourceVersions = map[types.UID]string{}
+		s.shards[i].deletedAt = map[types.UID]time.Time{}
 	}
+	return s
 }
 
-func (d *DDMetricsFam) extract(f metric.Family) {
+// shard returns the shard of an object, the UIDs are hashed with FNV-1a
+func (s *MetricsStore) shard(uid types.UID) *storeShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(uid); i++ {
+		h ^= uint32(uid[i])
+		h *= 16777619
+	}
+	return &s.shards[h%storeShards]
+}
+
+func (d *DDMetricsFam) extract(f metric.Family, uid types.UID, i *interner) {
 	// f.Type is not extracted (value of gauge, counter etc) as we only support gauges.
 	d.Name = f.Name
 	for _, m := range f.Metrics {
 		var err error
 		s := DDMetric{}
 		s.Val = m.Value
-		s.Labels, err = buildTags(m)
+		s.Labels, err = buildTags(m, i)
 		if err != nil {
 			// TODO test how verbose that could be.
 			log.Errorf("Could not retrieve the labels for %s: %v", f.Name, err)
 			continue
 		}
+		// The uid label is used to identify the object of the metrics
+		s.Labels["uid"] = string(uid)
 		d.ListMetrics = append(d.ListMetrics, s)
 	}
 }
@@ -81,56 +161,202 @@ func (s *MetricsStore) Add(obj interface{}) error {
 		return err
 	}
 
+	resourceVersion := o.GetResourceVersion()
+	shard := s.shard(o.GetUID())
+	shard.mutex.Lock()
+	delete(shard.deletedAt, o.GetUID())
+	knownVersion, found := shard.resourceVersions[o.GetUID()]
+	shard.mutex.Unlock()
+	if found && resourceVersion != "" && resourceVersion == knownVersion {
+		// The object didn't change, its metrics are up to date
+		return nil
+	}
+
+	var transitionTimes map[string]float64
+	if s.conditionTimestamps {
+		transitionTimes = conditionTransitionTimes(obj)
+	}
+
 	metricsForUID := s.generateMetricsFunc(obj)
-	convertedMetricsForUID := make([]DDMetricsFam, len(metricsForUID))
-	for i, f := range metricsForUID {
+	convertedMetricsForUID := make([]DDMetricsFam, 0, len(metricsForUID))
+	for _, f := range metricsForUID {
+		allowed := true
 		metricConvertedList := DDMetricsFam{
 			// Used to build a map to easily identify the Object associated with the metrics
 			Type: s.MetricsType,
 		}
-		f.Inspect(metricConvertedList.extract)
-		convertedMetricsForUID[i] = metricConvertedList
+		f.Inspect(func(f metric.Family) {
+			if s.familyFilter != nil && !s.familyFilter(f.Name) {
+				allowed = false
+				return
+			}
+			metricConvertedList.extract(f, o.GetUID(), s.interner)
+			if transitionTimes != nil {
+				metricConvertedList.setConditionTimestamps(transitionTimes)
+			}
+		})
+		if allowed {
+			convertedMetricsForUID = append(convertedMetricsForUID, metricConvertedList)
+		}
 	}
 	// We need to keep the store with UID as a key to handle the lifecycle of the objects and the metrics attached.
-	s.mutex.Lock()
-	s.metrics[o.GetUID()] = convertedMetricsForUID
-	s.mutex.Unlock()
+	// The generation is incremented after the metrics are written, see Snapshot
+	// The strings of the previous metrics are released after the new ones are interned, so the shared ones are kept
+	shard.mutex.Lock()
+	previous := shard.metrics[o.GetUID()]
+	shard.metrics[o.GetUID()] = convertedMetricsForUID
+	shard.resourceVersions[o.GetUID()] = resourceVersion
+	atomic.AddUint64(&s.generation, 1)
+	shard.mutex.Unlock()
+	releaseTags(previous, s.interner)
 
 	return nil
 }
 
-func buildTags(metrics *metric.Metric) (map[string]string, error) {
+// buildTags converts the labels of a metric into a map
+// The label keys and the values of the low cardinality labels are interned if an interner is given,
+// they're released by releaseTags. The uid label is set by extract, its key isn't interned.
+func buildTags(metrics *metric.Metric, i *interner) (map[string]string, error) {
 	if len(metrics.LabelKeys) != len(metrics.LabelValues) {
 		return nil, fmt.Errorf("LabelKeys and LabelValues not same size")
 	}
 	tags := make(map[string]string, len(metrics.LabelValues))
-	for i, key := range metrics.LabelKeys {
-		tags[key] = metrics.LabelValues[i]
+	for idx, key := range metrics.LabelKeys {
+		value := metrics.LabelValues[idx]
+		if _, found := internedLabelValues[key]; found {
+			value = i.intern(value)
+		}
+		if key != "uid" {
+			key = i.intern(key)
+		}
+		tags[key] = value
 	}
 	return tags, nil
 }
 
+// releaseTags releases the strings interned by buildTags for the metrics of an object
+// It must be called once the metrics are removed from the store
+func releaseTags(metricFamList []DDMetricsFam, i *interner) {
+	if i == nil {
+		return
+	}
+	for _, metricFam := range metricFamList {
+		for _, m := range metricFam.ListMetrics {
+			for key, value := range m.Labels {
+				if key == "uid" {
+					continue
+				}
+				if _, found := internedLabelValues[key]; found {
+					i.release(value)
+				}
+				i.release(key)
+			}
+		}
+	}
+}
+
 // Update updates the existing entry in the MetricsStore by overriding it.
+// The metrics aren't generated again if the resource version of the object didn't change.
 func (s *MetricsStore) Update(obj interface{}) error {
-	// TODO: For now, just call Add, in the future one could check if the resource version changed?
 	return s.Add(obj)
 }
 
 // Delete deletes an existing entry in the MetricsStore.
+// The metrics are kept until the deleted objects TTL expires if one is configured.
 func (s *MetricsStore) Delete(obj interface{}) error {
+	// The objects deleted while the watch was disconnected are wrapped into tombstones
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
 	o, err := meta.Accessor(obj)
 	if err != nil {
 		return err
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	shard := s.shard(o.GetUID())
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 
-	delete(s.metrics, o.GetUID())
+	s.remove(shard, o.GetUID())
 
 	return nil
 }
 
+// remove deletes the metrics of an object, or flags them for deletion if a deleted objects TTL is configured
+// The mutex of the shard of the object must be locked by the caller
+func (s *MetricsStore) remove(shard *storeShard, uid types.UID) {
+	if s.deletedObjectsTTL > 0 {
+		if _, found := shard.deletedAt[uid]; !found {
+			shard.deletedAt[uid] = s.now()
+		}
+		return
+	}
+
+	releaseTags(shard.metrics[uid], s.interner)
+	delete(shard.metrics, uid)
+	delete(shard.resourceVersions, uid)
+	atomic.AddUint64(&s.generation, 1)
+}
+
+// evictDeleted deletes the metrics of the objects deleted for more than the deleted objects TTL
+func (s *MetricsStore) evictDeleted() {
+	if s.deletedObjectsTTL == 0 {
+		return
+	}
+
+	now := s.now()
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mutex.Lock()
+		for uid, deletedAt := range shard.deletedAt {
+			if now.Sub(deletedAt) < s.deletedObjectsTTL {
+				continue
+			}
+			releaseTags(shard.metrics[uid], s.interner)
+			delete(shard.metrics, uid)
+			delete(shard.resourceVersions, uid)
+			delete(shard.deletedAt, uid)
+			atomic.AddUint64(&s.generation, 1)
+		}
+		shard.mutex.Unlock()
+	}
+}
+
+// Size returns the number of objects and the number of metrics in the store
+func (s *MetricsStore) Size() (int, int) {
+	objects, metrics := 0, 0
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mutex.RLock()
+		objects += len(shard.metrics)
+		for _, metricFamList := range shard.metrics {
+			for _, metricFam := range metricFamList {
+				metrics += len(metricFam.ListMetrics)
+			}
+		}
+		shard.mutex.RUnlock()
+	}
+	return objects, metrics
+}
+
+// Dump returns all the metrics of the store grouped by metric family, the families are sorted by name.
+// It's meant for debugging: the metrics of all the objects are merged into a single family.
+func (s *MetricsStore) Dump() []DDMetricsFam {
+	pushed := s.Push(GetAllFamilies, GetAllMetrics)
+
+	families := make([]DDMetricsFam, 0, len(pushed))
+	for name, objectFamilies := range pushed {
+		family := DDMetricsFam{Name: name}
+		for _, f := range objectFamilies {
+			family.Type = f.Type
+			family.ListMetrics = append(family.ListMetrics, f.ListMetrics...)
+		}
+		families = append(families, family)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families
+}
+
 // List implements the List method of the store interface.
 func (s *MetricsStore) List() []interface{} {
 	return nil
@@ -152,18 +378,79 @@ func (s *MetricsStore) GetByKey(key string) (item interface{}, exists bool, err
 }
 
 // Replace will delete the contents of the store, using instead the
-// given list.
+// given list. The metrics of the objects that didn't change are kept as is.
 func (s *MetricsStore) Replace(list []interface{}, _ string) error {
+	uids := make(map[types.UID]struct{}, len(list))
 	for _, o := range list {
 		err := s.Add(o)
 		if err != nil {
 			return err
 		}
+		if accessor, err := meta.Accessor(o); err == nil {
+			uids[accessor.GetUID()] = struct{}{}
+		}
+	}
+
+	// Delete the objects that aren't in the list anymore
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mutex.Lock()
+		for uid := range shard.metrics {
+			if _, found := uids[uid]; !found {
+				s.remove(shard, uid)
+			}
+		}
+		shard.mutex.Unlock()
 	}
 
+	atomic.StoreUint32(&s.synced, 1)
 	return nil
 }
 
+// Synced returns whether the store received the list of its objects at least once.
+// It stays false while the objects can't be listed, e.g. when the list is forbidden.
+func (s *MetricsStore) Synced() bool {
+	return atomic.LoadUint32(&s.synced) == 1
+}
+
+// SetListWatchError records the result of the last list or watch call of a reflector feeding the store,
+// identified by the given source (e.g. its namespace). A nil error clears the error of the source.
+// The reflectors retry the failed calls, the store keeps the metrics of the last list meanwhile.
+func (s *MetricsStore) SetListWatchError(source string, err error) {
+	s.listWatchErrorsMutex.Lock()
+	defer s.listWatchErrorsMutex.Unlock()
+
+	if err == nil {
+		delete(s.listWatchErrors, source)
+		return
+	}
+	s.listWatchErrors[source] = err
+}
+
+// ListWatchError returns an error if the last list or watch call of a reflector feeding the store failed, nil otherwise.
+func (s *MetricsStore) ListWatchError() error {
+	s.listWatchErrorsMutex.Lock()
+	defer s.listWatchErrorsMutex.Unlock()
+
+	sources := make([]string, 0, len(s.listWatchErrors))
+	for source := range s.listWatchErrors {
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return nil
+	}
+	sort.Strings(sources)
+	return s.listWatchErrors[sources[0]]
+}
+
+// Generation returns a number incremented each time the metrics of the store change.
+// The results of Push can be reused as long as the generation didn't change.
+func (s *MetricsStore) Generation() uint64 {
+	s.evictDeleted()
+
+	return atomic.LoadUint64(&s.generation)
+}
+
 // Resync implements the Resync method of the store interface.
 func (s *MetricsStore) Resync() error {
 	return nil
@@ -175,23 +462,69 @@ type FamilyAllow func(DDMetricsFam) bool
 // GetAllFamilies is family metric filter that allows all metric families
 var GetAllFamilies FamilyAllow = func(DDMetricsFam) bool { return true }
 
+// FamilyNameAllow is a metric-family-name-based filtering function used when storing the metrics
+type FamilyNameAllow func(string) bool
+
 // MetricAllow is a metric-based filtering function provided by the store clients
 type MetricAllow func(DDMetric) bool
 
 // GetAllMetrics is a metric filter that allows all metrics
 var GetAllMetrics MetricAllow = func(DDMetric) bool { return true }
 
+// Snapshot is a point-in-time view of the metrics of a store.
+// It isn't affected by the writes to the store happening after its creation,
+// so that related metric families are read consistently.
+type Snapshot struct {
+	generation uint64
+	metrics    map[types.UID][]DDMetricsFam
+}
+
+// Snapshot returns a point-in-time view of the metrics of the store
+// The metrics of an object are replaced as a whole when it changes, and never modified in place,
+// so only the maps indexing them need to be copied.
+// The read locks of all the shards are held during the copy, so the snapshot contains exactly the writes of its generation.
+// The writers only lock the shard of their object, and the shards are always locked in the same order.
+func (s *MetricsStore) Snapshot() *Snapshot {
+	s.evictDeleted()
+
+	for i := range s.shards {
+		s.shards[i].mutex.RLock()
+	}
+	generation := atomic.LoadUint64(&s.generation)
+	metrics := make(map[types.UID][]DDMetricsFam)
+	for i := range s.shards {
+		for uid, metricFamList := range s.shards[i].metrics {
+			metrics[uid] = metricFamList
+		}
+	}
+	for i := range s.shards {
+		s.shards[i].mutex.RUnlock()
+	}
+	return &Snapshot{generation: generation, metrics: metrics}
+}
+
+// Generation returns the generation of the store the snapshot was taken at
+func (s *Snapshot) Generation() uint64 {
+	return s.generation
+}
+
 // Push is used to take all the metrics from the store and push them to the check for
 // further processing.
 // FamilyAllow and MetricAllow filtering functions can be used
 // to get a subset of metrics from the store.
+// Successive calls can read inconsistent data if the store changes, a Snapshot should be used instead.
 func (s *MetricsStore) Push(familyFilter FamilyAllow, metricFilter MetricAllow) map[string][]DDMetricsFam {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	return s.Snapshot().Push(familyFilter, metricFilter)
+}
 
+// Push is used to take all the metrics from the snapshot and push them to the check for
+// further processing.
+// FamilyAllow and MetricAllow filtering functions can be used
+// to get a subset of metrics from the snapshot.
+func (s *Snapshot) Push(familyFilter FamilyAllow, metricFilter MetricAllow) map[string][]DDMetricsFam {
 	mRes := make(map[string][]DDMetricsFam)
 
-	for u, metricFamList := range s.metrics {
+	for _, metricFamList := range s.metrics {
 		for _, metricFam := range metricFamList {
 			if !familyFilter(metricFam) {
 				continue
@@ -201,12 +534,7 @@ func (s *MetricsStore) Push(familyFilter FamilyAllow, metricFilter MetricAllow)
 				if !metricFilter(metric) {
 					continue
 				}
-				tags := metric.Labels
-				tags["uid"] = string(u)
-				resMetric = append(resMetric, DDMetric{
-					Val:    metric.Val,
-					Labels: tags,
-				})
+				resMetric = append(resMetric, metric)
 			}
 			mRes[metricFam.Name] = append(mRes[metricFam.Name], DDMetricsFam{
 				ListMetrics: resMetric,
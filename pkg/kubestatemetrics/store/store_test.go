@@ -8,14 +8,18 @@
 package store
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/kube-state-metrics/pkg/metric"
 )
 
@@ -58,11 +62,7 @@ func TestExtract(t *testing.T) {
 			t.Fatal(err)
 		}
 	}
-	ms.mutex.RLock()
-	metrics := ms.metrics
-	ms.mutex.RUnlock()
-
-	for uid, ddMetrics := range metrics {
+	for uid, ddMetrics := range ms.Snapshot().metrics {
 		for _, metricFam := range ddMetrics {
 			assert.Equal(t, metricName, metricFam.Name)
 			assert.Equal(t, storeName, metricFam.Type)
@@ -106,7 +106,7 @@ func TestBuildTags(t *testing.T) {
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			m, err := buildTags(test.in)
+			m, err := buildTags(test.in, newInterner())
 			if err != nil {
 				assert.Error(t, err, test.err)
 			}
@@ -406,10 +406,307 @@ func TestPush(t *testing.T) {
 	}
 }
 
+func TestUpdateResourceVersion(t *testing.T) {
+	generated := 0
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		generated++
+		o, _ := meta.Accessor(obj)
+		return []metric.FamilyInterface{&metric.Family{
+			Name:    "kube_node_info",
+			Metrics: []*metric.Metric{{LabelKeys: []string{"node"}, LabelValues: []string{o.GetName()}, Value: 1}},
+		}}
+	}
+	node := func(uid, name, resourceVersion string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), Name: name, ResourceVersion: resourceVersion}}
+	}
+
+	ms := NewMetricsStore(genFunc, "*v1.Node")
+	assert.NoError(t, ms.Add(node("123", "foo", "1")))
+	assert.Equal(t, 1, generated)
+	assert.Equal(t, uint64(1), ms.Generation())
+
+	// Same resource version, e.g. resync
+	assert.NoError(t, ms.Update(node("123", "foo", "1")))
+	assert.Equal(t, 1, generated)
+	assert.Equal(t, uint64(1), ms.Generation())
+
+	// New resource version
+	assert.NoError(t, ms.Update(node("123", "bar", "2")))
+	assert.Equal(t, 2, generated)
+	assert.Equal(t, uint64(2), ms.Generation())
+	assert.Equal(t, "bar", ms.shard("123").metrics["123"][0].ListMetrics[0].Labels["node"])
+
+	// No resource version
+	assert.NoError(t, ms.Add(node("456", "baz", "")))
+	assert.NoError(t, ms.Update(node("456", "baz", "")))
+	assert.Equal(t, 4, generated)
+	assert.Equal(t, uint64(4), ms.Generation())
+
+	assert.NoError(t, ms.Delete(node("456", "baz", "")))
+	assert.Equal(t, uint64(5), ms.Generation())
+	assert.NotContains(t, ms.shard("456").metrics, types.UID("456"))
+	assert.NotContains(t, ms.shard("456").resourceVersions, types.UID("456"))
+}
+
+func TestReplace(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		return []metric.FamilyInterface{&metric.Family{Name: "kube_node_info", Metrics: []*metric.Metric{{Value: 1}}}}
+	}
+	node := func(uid, resourceVersion string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), ResourceVersion: resourceVersion}}
+	}
+
+	ms := NewMetricsStore(genFunc, "*v1.Node")
+	assert.False(t, ms.Synced())
+	assert.NoError(t, ms.Replace([]interface{}{node("123", "1"), node("456", "1")}, ""))
+	assert.Len(t, ms.Snapshot().metrics, 2)
+	assert.Equal(t, uint64(2), ms.Generation())
+	assert.True(t, ms.Synced())
+
+	// 456 is deleted, 123 didn't change
+	assert.NoError(t, ms.Replace([]interface{}{node("123", "1")}, ""))
+	assert.Len(t, ms.Snapshot().metrics, 1)
+	assert.Contains(t, ms.Snapshot().metrics, types.UID("123"))
+	assert.Equal(t, uint64(3), ms.Generation())
+
+	// Nothing changed
+	assert.NoError(t, ms.Replace([]interface{}{node("123", "1")}, ""))
+	assert.Equal(t, uint64(3), ms.Generation())
+}
+
+func TestDelete(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		return []metric.FamilyInterface{&metric.Family{Name: "kube_pod_info", Metrics: []*metric.Metric{{Value: 1}, {Value: 2}}}}
+	}
+	pod := func(uid string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), ResourceVersion: "1"}}
+	}
+
+	t.Run("no ttl", func(t *testing.T) {
+		ms := NewMetricsStore(genFunc, "*v1.Pod")
+		assert.NoError(t, ms.Add(pod("123")))
+		assert.NoError(t, ms.Add(pod("456")))
+
+		assert.NoError(t, ms.Delete(pod("123")))
+		assert.NoError(t, ms.Delete(cache.DeletedFinalStateUnknown{Key: "default/foo", Obj: pod("456")}))
+		objects, metrics := ms.Size()
+		assert.Equal(t, 0, objects)
+		assert.Equal(t, 0, metrics)
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		now := time.Now()
+		ms := NewMetricsStore(genFunc, "*v1.Pod")
+		ms.WithDeletedObjectsTTL(time.Minute)
+		ms.now = func() time.Time { return now }
+		assert.NoError(t, ms.Add(pod("123")))
+		assert.NoError(t, ms.Add(pod("456")))
+		objects, metrics := ms.Size()
+		assert.Equal(t, 2, objects)
+		assert.Equal(t, 4, metrics)
+
+		assert.NoError(t, ms.Delete(pod("123")))
+		generation := ms.Generation()
+		assert.Len(t, ms.Push(GetAllFamilies, GetAllMetrics)["kube_pod_info"], 2)
+
+		now = now.Add(30 * time.Second)
+		// A second deletion doesn't extend the TTL
+		assert.NoError(t, ms.Replace([]interface{}{pod("456")}, ""))
+		assert.Equal(t, generation, ms.Generation())
+
+		now = now.Add(30 * time.Second)
+		assert.Equal(t, generation+1, ms.Generation())
+		assert.Len(t, ms.Push(GetAllFamilies, GetAllMetrics)["kube_pod_info"], 1)
+		objects, metrics = ms.Size()
+		assert.Equal(t, 1, objects)
+		assert.Equal(t, 2, metrics)
+		assert.Len(t, ms.shard("123").deletedAt, 0)
+	})
+}
+
 func (ms *MetricsStore) addMetrics(toAdd map[types.UID][]DDMetricsFam) {
-	ms.mutex.Lock()
 	for uid := range toAdd {
-		ms.metrics[uid] = append(ms.metrics[uid], toAdd[uid]...)
+		// The uid label is set when adding objects
+		for _, metricFam := range toAdd[uid] {
+			for _, metric := range metricFam.ListMetrics {
+				metric.Labels["uid"] = string(uid)
+			}
+		}
+		shard := ms.shard(uid)
+		shard.mutex.Lock()
+		shard.metrics[uid] = append(shard.metrics[uid], toAdd[uid]...)
+		shard.mutex.Unlock()
+	}
+}
+
+func TestFamilyFilter(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		return []metric.FamilyInterface{
+			&metric.Family{Name: "kube_pod_info", Metrics: []*metric.Metric{{Value: 1}}},
+			&metric.Family{Name: "kube_pod_labels", Metrics: []*metric.Metric{{Value: 1}}},
+		}
+	}
+
+	ms := NewMetricsStore(genFunc, "*v1.Pod")
+	ms.WithFamilyFilter(func(name string) bool { return name != "kube_pod_labels" })
+	assert.NoError(t, ms.Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "123"}}))
+
+	res := ms.Push(GetAllFamilies, GetAllMetrics)
+	assert.Len(t, res, 1)
+	assert.Contains(t, res, "kube_pod_info")
+}
+
+func TestSnapshot(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		o, _ := meta.Accessor(obj)
+		return []metric.FamilyInterface{&metric.Family{
+			Name:    "kube_pod_info",
+			Metrics: []*metric.Metric{{LabelKeys: []string{"pod"}, LabelValues: []string{o.GetName()}, Value: 1}},
+		}}
+	}
+	pod := func(uid, name, resourceVersion string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid), Name: name, ResourceVersion: resourceVersion}}
+	}
+
+	ms := NewMetricsStore(genFunc, "*v1.Pod")
+	assert.NoError(t, ms.Add(pod("123", "foo", "1")))
+	assert.NoError(t, ms.Add(pod("456", "bar", "1")))
+	snapshot := ms.Snapshot()
+	assert.Equal(t, ms.Generation(), snapshot.Generation())
+
+	// The store changes after the snapshot
+	assert.NoError(t, ms.Update(pod("123", "baz", "2")))
+	assert.NoError(t, ms.Delete(pod("456", "bar", "1")))
+	assert.NotEqual(t, ms.Generation(), snapshot.Generation())
+
+	res := snapshot.Push(GetAllFamilies, GetAllMetrics)
+	assert.Len(t, res["kube_pod_info"], 2)
+	pods := map[string]string{}
+	for _, metricFam := range res["kube_pod_info"] {
+		for _, m := range metricFam.ListMetrics {
+			pods[m.Labels["uid"]] = m.Labels["pod"]
+		}
+	}
+	assert.Equal(t, map[string]string{"123": "foo", "456": "bar"}, pods)
+
+	res = ms.Push(GetAllFamilies, GetAllMetrics)
+	assert.Len(t, res["kube_pod_info"], 1)
+	assert.Equal(t, "baz", res["kube_pod_info"][0].ListMetrics[0].Labels["pod"])
+}
+
+func TestSnapshotConcurrentWrites(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		return []metric.FamilyInterface{&metric.Family{Name: "kube_pod_info", Metrics: []*metric.Metric{{Value: 1}}}}
+	}
+	pod := func(i int) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: "1"}}
+	}
+
+	// Each write adds an object, so a snapshot must contain as many objects as its generation
+	const pods = 1000
+	ms := NewMetricsStore(genFunc, "*v1.Pod")
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < pods; i += 4 {
+				assert.NoError(t, ms.Add(pod(i)))
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for {
+		snapshot := ms.Snapshot()
+		assert.Len(t, snapshot.metrics, int(snapshot.Generation()))
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		o, _ := meta.Accessor(obj)
+		return []metric.FamilyInterface{
+			&metric.Family{Name: "kube_pod_status_ready", Metrics: []*metric.Metric{{LabelKeys: []string{"condition"}, LabelValues: []string{"true"}, Value: 1}}},
+			&metric.Family{Name: "kube_pod_info", Metrics: []*metric.Metric{{LabelKeys: []string{"pod"}, LabelValues: []string{o.GetName()}, Value: 1}}},
+		}
+	}
+
+	ms := NewMetricsStore(genFunc, "*v1.Pod")
+	assert.NoError(t, ms.Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "123", Name: "foo"}}))
+	assert.NoError(t, ms.Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "456", Name: "bar"}}))
+
+	res := ms.Dump()
+	assert.Len(t, res, 2)
+	assert.Equal(t, "kube_pod_info", res[0].Name)
+	assert.Equal(t, "kube_pod_status_ready", res[1].Name)
+	assert.Len(t, res[0].ListMetrics, 2)
+	assert.Len(t, res[1].ListMetrics, 2)
+	pods := map[string]string{}
+	for _, m := range res[0].ListMetrics {
+		pods[m.Labels["uid"]] = m.Labels["pod"]
+	}
+	assert.Equal(t, map[string]string{"123": "foo", "456": "bar"}, pods)
+}
+
+func TestShards(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		return []metric.FamilyInterface{&metric.Family{Name: "kube_pod_info", Metrics: []*metric.Metric{{Value: 1}}}}
+	}
+	pod := func(i int) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(fmt.Sprintf("uid-%d", i)), ResourceVersion: "1"}}
 	}
-	ms.mutex.Unlock()
+
+	// The objects are added and deleted concurrently like by the informers of large clusters
+	const pods = 1000
+	ms := NewMetricsStore(genFunc, "*v1.Pod")
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < pods; i += 4 {
+				assert.NoError(t, ms.Add(pod(i)))
+				if i%2 == 1 {
+					assert.NoError(t, ms.Delete(pod(i)))
+				}
+				ms.Snapshot()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	objects, metrics := ms.Size()
+	assert.Equal(t, pods/2, objects)
+	assert.Equal(t, pods/2, metrics)
+	assert.Equal(t, uint64(pods+pods/2), ms.Generation())
+	assert.Len(t, ms.Push(GetAllFamilies, GetAllMetrics)["kube_pod_info"], pods/2)
+
+	// The objects are spread over all the shards
+	for i := range ms.shards {
+		assert.NotEmpty(t, ms.shards[i].metrics)
+	}
+}
+
+func TestMetricsStore_ListWatchError(t *testing.T) {
+	ms := NewMetricsStore(func(interface{}) []metric.FamilyInterface { return nil }, "*v1.Pod")
+	assert.NoError(t, ms.ListWatchError())
+
+	ms.SetListWatchError("foo", errors.New("forbidden"))
+	ms.SetListWatchError("bar", errors.New("timeout"))
+	assert.EqualError(t, ms.ListWatchError(), "timeout")
+
+	// The error of a source is cleared independently of the others
+	ms.SetListWatchError("bar", nil)
+	assert.EqualError(t, ms.ListWatchError(), "forbidden")
+	ms.SetListWatchError("foo", nil)
+	assert.NoError(t, ms.ListWatchError())
 }
@@ -0,0 +1,79 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package store
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	autoscaling "k8s.io/api/autoscaling/v2beta1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// conditionFamilies maps the metric families reporting the conditions of the objects to the condition they report
+// An empty condition means the condition is given by the condition label of the metrics
+var conditionFamilies = map[string]string{
+	"kube_node_status_condition":                    "",
+	"kube_deployment_status_condition":              "",
+	"kube_horizontalpodautoscaler_status_condition": "",
+	"kube_pod_status_ready":                         string(v1.PodReady),
+	"kube_pod_status_scheduled":                     string(v1.PodScheduled),
+	"kube_job_complete":                             string(batchv1.JobComplete),
+	"kube_job_failed":                               string(batchv1.JobFailed),
+}
+
+// conditionTransitionTimes returns the last transition time of the conditions of an object per condition type,
+// as Unix times in seconds
+func conditionTransitionTimes(obj interface{}) map[string]float64 {
+	times := make(map[string]float64)
+	add := func(condition string, t metav1.Time) {
+		if !t.IsZero() {
+			times[condition] = float64(t.Unix())
+		}
+	}
+
+	switch o := obj.(type) {
+	case *v1.Node:
+		for _, c := range o.Status.Conditions {
+			add(string(c.Type), c.LastTransitionTime)
+		}
+	case *v1.Pod:
+		for _, c := range o.Status.Conditions {
+			add(string(c.Type), c.LastTransitionTime)
+		}
+	case *appsv1.Deployment:
+		for _, c := range o.Status.Conditions {
+			add(string(c.Type), c.LastTransitionTime)
+		}
+	case *batchv1.Job:
+		for _, c := range o.Status.Conditions {
+			add(string(c.Type), c.LastTransitionTime)
+		}
+	case *autoscaling.HorizontalPodAutoscaler:
+		for _, c := range o.Status.Conditions {
+			add(string(c.Type), c.LastTransitionTime)
+		}
+	}
+	return times
+}
+
+// setConditionTimestamps sets the last transition time of the condition reported by the metrics as their timestamp
+// It's a no-op if the family doesn't report conditions
+func (d *DDMetricsFam) setConditionTimestamps(transitionTimes map[string]float64) {
+	condition, found := conditionFamilies[d.Name]
+	if !found {
+		return
+	}
+	for i := range d.ListMetrics {
+		metricCondition := condition
+		if metricCondition == "" {
+			metricCondition = d.ListMetrics[i].Labels["condition"]
+		}
+		d.ListMetrics[i].Timestamp = transitionTimes[metricCondition]
+	}
+}
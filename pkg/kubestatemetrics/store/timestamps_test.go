@@ -0,0 +1,86 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-2020 Datadog, Inc.
+
+// +build kubeapiserver
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kube-state-metrics/pkg/metric"
+)
+
+func TestConditionTransitionTimes(t *testing.T) {
+	transition := metav1.NewTime(time.Unix(1600000000, 0))
+	tests := []struct {
+		name string
+		obj  interface{}
+		want map[string]float64
+	}{
+		{
+			name: "node",
+			obj: &v1.Node{Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, LastTransitionTime: transition},
+				{Type: v1.NodeMemoryPressure},
+			}}},
+			want: map[string]float64{"Ready": 1600000000},
+		},
+		{
+			name: "job",
+			obj: &batchv1.Job{Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{
+				{Type: batchv1.JobFailed, LastTransitionTime: transition},
+			}}},
+			want: map[string]float64{"Failed": 1600000000},
+		},
+		{
+			name: "object without conditions",
+			obj:  &v1.Service{},
+			want: map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, conditionTransitionTimes(tt.obj))
+		})
+	}
+}
+
+func TestConditionTimestamps(t *testing.T) {
+	genFunc := func(obj interface{}) []metric.FamilyInterface {
+		return []metric.FamilyInterface{
+			&metric.Family{Name: "kube_pod_status_ready", Metrics: []*metric.Metric{
+				{LabelKeys: []string{"condition"}, LabelValues: []string{"true"}, Value: 1},
+			}},
+			&metric.Family{Name: "kube_pod_status_phase", Metrics: []*metric.Metric{
+				{LabelKeys: []string{"phase"}, LabelValues: []string{"Running"}, Value: 1},
+			}},
+		}
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{UID: "123"},
+		Status: v1.PodStatus{Conditions: []v1.PodCondition{
+			{Type: v1.PodReady, LastTransitionTime: metav1.NewTime(time.Unix(1600000000, 0))},
+		}},
+	}
+
+	ms := NewMetricsStore(genFunc, "*v1.Pod")
+	assert.NoError(t, ms.Add(pod))
+	res := ms.Push(GetAllFamilies, GetAllMetrics)
+	assert.Equal(t, 0.0, res["kube_pod_status_ready"][0].ListMetrics[0].Timestamp)
+
+	ms = NewMetricsStore(genFunc, "*v1.Pod")
+	ms.WithConditionTimestamps()
+	assert.NoError(t, ms.Add(pod))
+	res = ms.Push(GetAllFamilies, GetAllMetrics)
+	// The ready condition label is the status of the condition, the condition comes from the family
+	assert.Equal(t, 1600000000.0, res["kube_pod_status_ready"][0].ListMetrics[0].Timestamp)
+	assert.Equal(t, 0.0, res["kube_pod_status_phase"][0].ListMetrics[0].Timestamp)
+}
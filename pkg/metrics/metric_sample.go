@@ -20,6 +20,8 @@ const (
 	SetType
 	// NOTE: DistributionType is in development and is NOT supported
 	DistributionType
+	// GaugeWithTimestampType is a gauge submitted with its own timestamp, it's not aggregated
+	GaugeWithTimestampType
 )
 
 // DistributionMetricTypes contains the MetricTypes that are used for percentiles
@@ -48,6 +50,8 @@ func (m MetricType) String() string {
 		return "Set"
 	case DistributionType:
 		return "Distribution"
+	case GaugeWithTimestampType:
+		return "GaugeWithTimestamp"
 	default:
 		return ""
 	}